@@ -1,36 +1,198 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Logger is the global logger instance.
 var Logger *slog.Logger
 
-// Init initializes the logger with a file output.
-func Init() {
-	// TODO: Add option to take log file path from user
+// File Logger writes to, nil if it writes to stderr or nowhere
+var current atomic.Pointer[logFile]
 
-	// os.TempDir gives temporary directory of any platform
-	faustTempDir := filepath.Join(os.TempDir(), "faustlsp")
-	os.Mkdir(faustTempDir, 0750)
+// Level is the minimum level of the records Logger writes, info by default.
+var Level slog.LevelVar
 
-	currTime := time.Now().Format("15-04-05")
-	logFile := "log-" + currTime + ".json"
-	logFilePath := filepath.Join(faustTempDir, logFile)
+// MaxSize is the size in bytes beyond which a log file in the temporary directory is left for a new one, 0 to never start a new one.
+var MaxSize int64 = 10 << 20
 
-	f, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_RDWR, 0755)
-	if err != nil {
+// Retention is the number of log files of this process kept in the temporary directory, older ones being removed when a new one is created.
+var Retention = 10
+
+// Prefix of the names of the log files of this process. The temporary directory is shared by every running server,
+// which only remove their own files.
+var logPrefix = fmt.Sprintf("log-%d-", os.Getpid())
+
+// Outputs other than files SetOutput takes.
+const (
+	Stderr  = "stderr"
+	Discard = "discard"
+)
+
+// SetLevel sets the minimum level of the records Logger writes from its name: debug, info, warn or error.
+func SetLevel(name string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return err
+	}
+	Level.Set(level)
+	return nil
+}
+
+// Path returns the path of the file Logger writes to, empty if it writes to stderr or nowhere.
+func Path() string {
+	f := current.Load()
+	if f == nil {
+		return ""
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.path
+}
+
+// Init initializes the logger with a file output in the temporary directory.
+func Init() {
+	if err := SetOutput(""); err != nil {
 		panic(err)
 	}
+}
+
+// SetOutput makes Logger write to output: stderr, discard, the path of a file to append to, or a new file in the temporary directory if empty.
+func SetOutput(output string) error {
+	var w io.Writer
+	var f *logFile
+	switch output {
+	case Stderr:
+		w = os.Stderr
+	case Discard:
+		w = io.Discard
+	case "":
+		// os.TempDir gives temporary directory of any platform
+		f = &logFile{dir: filepath.Join(os.TempDir(), "faustlsp")}
+		if err := f.rotate(); err != nil {
+			return err
+		}
+		w = f
+	default:
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		f = &logFile{file: file, path: output}
+		w = f
+	}
 
-	// Initialize the logger to write to the file, without flags or prefixes.
-	//	Logger = log.New(f, "faust-lsp: ", log.Ltime)
-	Logger = slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{
+	// Records are written as JSON, with the source of the call
+	Logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
 		AddSource: true,
+		Level:     &Level,
 	}))
+	if previous := current.Swap(f); previous != nil {
+		previous.close()
+	}
+	return nil
+}
+
+// File logs are written to. Files in dir, the temporary directory, are rotated once they grow beyond MaxSize.
+type logFile struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+	// Directory new files are created in, empty for a file given by the user, which is never rotated
+	dir string
+	// Bytes written to file
+	size int64
+}
 
+func (f *logFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.dir != "" && MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > MaxSize {
+		// Keeps writing to the full file rather than losing the record
+		f.rotate()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Starts a new file in dir, closing the previous one and removing the files beyond Retention
+func (f *logFile) rotate() error {
+	if err := os.MkdirAll(f.dir, 0750); err != nil {
+		return err
+	}
+	name := logPrefix + time.Now().Format("2006-01-02-15-04-05")
+	path := filepath.Join(f.dir, name+".json")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	// Files created within the same second are numbered
+	for i := 1; errors.Is(err, os.ErrExist); i++ {
+		path = filepath.Join(f.dir, fmt.Sprintf("%s-%d.json", name, i))
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.path, f.size = file, path, 0
+	removeOldLogs(f.dir, path)
+	return nil
+}
+
+func (f *logFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// Removes the oldest log files of this process in dir beyond Retention, other than the one at keep
+func removeOldLogs(dir string, keep string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type log struct {
+		path    string
+		modTime time.Time
+	}
+	logs := []log{}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if entry.IsDir() || !strings.HasPrefix(name, logPrefix) || filepath.Ext(name) != ".json" || path == keep {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, log{path, info.ModTime()})
+	}
+	// Newest first
+	slices.SortFunc(logs, func(a, b log) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	for i, l := range logs {
+		// The file at keep counts as one of the kept files
+		if i+1 >= Retention {
+			os.Remove(l.path)
+		}
+	}
 }
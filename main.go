@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"syscall"
 
 	"github.com/carn181/faustlsp/logging"
@@ -13,18 +18,46 @@ import (
 )
 
 func main() {
-	logging.Init()
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		logging.Init()
+		os.Exit(report(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "parse" {
+		logging.Init()
+		os.Exit(parse(os.Args[2:]))
+	}
 
-	logging.Logger.Info("Initialized")
+	tcp := flag.Bool("tcp", false, "Listen for clients on a TCP socket instead of talking through stdin and stdout")
+	port := flag.Int("port", transport.DefaultPort, "Port to listen on, implies -tcp")
+	logLevel := flag.String("log-level", "info", "Minimum level of the records logged: debug, info, warn or error")
+	logOutput := flag.String("log", "", "File to append logs to, stderr, or discard. A new file in the temporary directory by default")
+	logMaxSize := flag.Int64("log-max-size", logging.MaxSize>>20, "Size in MiB beyond which a new log file is started in the temporary directory, 0 to keep a single file")
+	logRetention := flag.Int("log-retention", logging.Retention, "Number of log files of the server kept in the temporary directory")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: faustlsp [-tcp] [-port port] [-log-level level] [-log output] [-log-max-size MiB] [-log-retention count]\n       faustlsp report [flags] [dir]\n       faustlsp parse [flags] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" {
+			*tcp = true
+		}
+	})
+	logging.MaxSize = *logMaxSize << 20
+	logging.Retention = *logRetention
+	if err := logging.SetOutput(*logOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't log to %s: %s\n", *logOutput, err)
+		os.Exit(2)
+	}
+	if err := logging.SetLevel(*logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log level %s: %s\n", *logLevel, err)
+		os.Exit(2)
+	}
+	logging.Logger.Info("Initialized", "log_level", logging.Level.Level().String(), "log", logging.Path())
 
 	// Background Context for cancelling
 	ctx, cancel := context.WithCancel(context.Background())
 
-	var s server.Server
-
-	// Default Transport method is stdin
-	s.Init(transport.Stdin)
-
 	// Handle Signals
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -33,10 +66,22 @@ func main() {
 		// Cancel when a signal is received
 		<-sigs
 		cancel()
-		fmt.Println("Got Interrupt ")
+		fmt.Fprintln(os.Stderr, "Got Interrupt")
 		logging.Logger.Info("Got Interrupt")
 	}()
 
+	if *tcp {
+		os.Exit(serve(ctx, *port))
+	}
+
+	var s server.Server
+
+	// Default Transport method is stdin
+	if err := s.Init(transport.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't start server:", err)
+		os.Exit(1)
+	}
+
 	// Start running server
 	err := s.Run(ctx)
 	logging.Logger.Info("Ended")
@@ -47,3 +92,104 @@ func main() {
 		os.Exit(0)
 	}
 }
+
+// Serves clients connecting on port of localhost one after another until interrupted
+func serve(ctx context.Context, port int) int {
+	ln, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", ln.Addr())
+	if err := server.Serve(ctx, ln); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		logging.Logger.Error("Couldn't accept client", "error", err)
+		return 1
+	}
+	logging.Logger.Info("Ended")
+	return 0
+}
+
+// Writes the report of a project for CI, returning 1 if the project has errors
+func report(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", "json", "Format of the report: json, markdown, sarif or github (workflow command annotations)")
+	output := flags.String("o", "", "File to write the report to instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: faustlsp report [-format json|markdown|sarif|github] [-o file] [dir]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if !slices.Contains([]string{"json", "markdown", "sarif", "github"}, *format) {
+		fmt.Fprintf(os.Stderr, "Unknown report format %s\n", *format)
+		return 2
+	}
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	r, err := server.ProjectReport(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't build report: %s\n", err)
+		return 2
+	}
+	var content []byte
+	switch *format {
+	case "markdown":
+		content = []byte(r.Markdown())
+	case "github":
+		content = []byte(r.GitHubAnnotations())
+	case "sarif":
+		content, err = r.SARIF()
+	default:
+		content, err = json.MarshalIndent(r, "", "  ")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't encode report: %s\n", err)
+		return 2
+	}
+
+	if *output == "" {
+		fmt.Println(string(content))
+	} else if err := os.WriteFile(*output, content, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't write report: %s\n", err)
+		return 2
+	}
+	if r.Summary.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Prints the syntax tree, symbols and syntax errors the server finds in a file, for bug reports
+func parse(args []string) int {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: faustlsp parse [-json] file")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	r, err := server.ParseFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't parse file: %s\n", err)
+		return 2
+	}
+	if !*asJSON {
+		fmt.Print(r.Text())
+		return 0
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't encode result: %s\n", err)
+		return 2
+	}
+	fmt.Println(string(content))
+	return 0
+}
@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Nodes delimited by braces that get their own fold
+var foldableBlocks = map[string]struct{}{
+	"environment":     {},
+	"rec_environment": {},
+	"pattern":         {},
+}
+
+// FoldingRanges returns folds for multi-line definitions, blocks, comments and groups of imports,
+// or ctx's error if it is done before they are all found
+func FoldingRanges(ctx context.Context, tree *Tree, content []byte) ([]FoldingRange, error) {
+	folds := foldingRanges{ctx: ctx, byStart: make(map[uint32]int)}
+	root := tree.RootNode()
+	if err := folds.walk(root, content); err != nil {
+		return nil, err
+	}
+	folds.commentsAndImports(root, content)
+	return folds.ranges, nil
+}
+
+type foldingRanges struct {
+	ctx    context.Context
+	ranges []FoldingRange
+	// Index of the fold starting on each line. Clients only show one fold per line, so only the largest one is kept.
+	byStart map[uint32]int
+}
+
+func (f *foldingRanges) add(start, end uint32, kind FoldingRangeKind) {
+	if end <= start {
+		return
+	}
+	if i, ok := f.byStart[start]; ok {
+		if *f.ranges[i].EndLine < end {
+			f.ranges[i] = FoldingRange{StartLine: &start, EndLine: &end, Kind: string(kind)}
+		}
+		return
+	}
+	f.byStart[start] = len(f.ranges)
+	f.ranges = append(f.ranges, FoldingRange{StartLine: &start, EndLine: &end, Kind: string(kind)})
+}
+
+// Adds the folds of node and its children, stopping once the context is done, which is checked at each inner node
+func (f *foldingRanges) walk(node *tree_sitter.Node, content []byte) error {
+	start, end := uint32(node.StartPosition().Row), uint32(node.EndPosition().Row)
+	switch node.Kind() {
+	case "definition", "function_definition":
+		f.add(start, end, Region)
+	case "comment":
+		if strings.HasPrefix(node.Utf8Text(content), "/*") {
+			f.add(start, end, Comment)
+		}
+	default:
+		// Keep the closing brace visible
+		if _, ok := foldableBlocks[node.Kind()]; ok && node.IsNamed() && end > start {
+			f.add(start, end-1, Region)
+		}
+	}
+	if node.NamedChildCount() == 0 {
+		return nil
+	}
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if err := f.walk(node.NamedChild(i), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Folds runs of line comments and runs of imports at the top level
+func (f *foldingRanges) commentsAndImports(root *tree_sitter.Node, content []byte) {
+	var runKind FoldingRangeKind
+	var runStart, runEnd, prevEnd uint32
+	flush := func() {
+		if runKind != "" {
+			f.add(runStart, runEnd, runKind)
+		}
+		runKind = ""
+	}
+
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		child := root.NamedChild(i)
+		start, end := uint32(child.StartPosition().Row), uint32(child.EndPosition().Row)
+		isComment := child.Kind() == "comment"
+
+		// Comments trailing a statement don't break its run
+		if isComment && i > 0 && start == prevEnd {
+			continue
+		}
+		prevEnd = end
+
+		var kind FoldingRangeKind
+		switch {
+		case child.Kind() == "file_import":
+			kind = Imports
+		case isComment && strings.HasPrefix(child.Utf8Text(content), "//"):
+			kind = Comment
+		}
+
+		// Runs continue only on consecutive lines
+		if kind == runKind && kind != "" && start <= runEnd+1 {
+			runEnd = end
+			continue
+		}
+		flush()
+		if kind != "" {
+			runKind, runStart, runEnd = kind, start, end
+		}
+	}
+	flush()
+}
@@ -0,0 +1,399 @@
+package parser
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Nodes whose text is emitted verbatim by the formatter
+var atomicNodes = map[string]struct{}{
+	"identifier":    {},
+	"string":        {},
+	"fstring":       {},
+	"comment":       {},
+	"documentation": {},
+}
+
+// Nodes whose '(' directly follows the callee or keyword without a space
+var callNodes = map[string]struct{}{
+	"function_definition": {}, "function_call": {}, "partial": {}, "prefix": {},
+	"prim1": {}, "prim2": {}, "prim3": {}, "prim4": {}, "prim5": {},
+	"iteration": {}, "route": {}, "button": {}, "checkbox": {}, "numeric_widget": {},
+	"bargraph": {}, "group": {}, "soundfile": {}, "component": {}, "library": {},
+	"file_import": {}, "inputs": {}, "outputs": {}, "ffunction": {}, "fconst": {},
+	"fvariable": {}, "lambda": {}, "signature": {},
+}
+
+// Nodes whose braces delimit a block of statements or rules, laid out one per line
+var blockNodes = map[string]struct{}{
+	"environment":     {},
+	"rec_environment": {},
+	"pattern":         {},
+}
+
+// Nodes whose ';' terminates a statement
+var statementEndNodes = map[string]struct{}{
+	"program":         {},
+	"environment":     {},
+	"rec_environment": {},
+	"rule":            {},
+	"recinition":      {},
+	"file_import":     {},
+}
+
+type fmtToken struct {
+	Node   *tree_sitter.Node
+	Text   string
+	Parent string
+	// Kind of the parent's parent, needed for operators like + whose parent is the (add) node
+	GrandParent string
+}
+
+func (t fmtToken) isBlockOpen() bool {
+	_, ok := blockNodes[t.Parent]
+	return ok && t.Text == "{"
+}
+
+func (t fmtToken) isBlockClose() bool {
+	_, ok := blockNodes[t.Parent]
+	return ok && t.Text == "}"
+}
+
+func (t fmtToken) isStatementEnd() bool {
+	_, ok := statementEndNodes[t.Parent]
+	return ok && t.Text == ";"
+}
+
+func (t fmtToken) isComment() bool {
+	return t.Node.Kind() == "comment"
+}
+
+func (t fmtToken) isLineComment() bool {
+	return t.isComment() && strings.HasPrefix(t.Text, "//")
+}
+
+// Format pretty-prints Faust code. Only whitespace is changed: the tokens and comments of the output are checked to be the same as the input's.
+// Code with syntax errors is not formatted.
+func Format(code []byte, indent string) ([]byte, error) {
+	tree := ParseTree(code)
+	defer tree.Close()
+	root := tree.RootNode()
+	if root.HasError() {
+		return []byte{}, errors.New("can't format code with syntax errors")
+	}
+
+	output := formatTree(root, code, indent)
+
+	// Ensure formatting preserved every token
+	formattedTree := ParseTree(output)
+	defer formattedTree.Close()
+	if formattedTree.RootNode().HasError() || !slices.Equal(tokenTexts(root, code), tokenTexts(formattedTree.RootNode(), output)) {
+		return []byte{}, errors.New("formatting would change the meaning of the code")
+	}
+	return output, nil
+}
+
+func tokenTexts(root *tree_sitter.Node, code []byte) []string {
+	tokens := collectTokens(root, code, []fmtToken{})
+	texts := make([]string, len(tokens))
+	for i, token := range tokens {
+		texts[i] = token.Text
+	}
+	return texts
+}
+
+func collectTokens(node *tree_sitter.Node, code []byte, tokens []fmtToken) []fmtToken {
+	_, atomic := atomicNodes[node.Kind()]
+	if atomic || node.ChildCount() == 0 {
+		token := fmtToken{Node: node, Text: node.Utf8Text(code)}
+		if node.Kind() == "comment" {
+			token.Text = strings.TrimRight(token.Text, " \t\r")
+		}
+		if parent := node.Parent(); parent != nil {
+			token.Parent = parent.Kind()
+			if grandParent := parent.Parent(); grandParent != nil {
+				token.GrandParent = grandParent.Kind()
+			}
+		}
+		return append(tokens, token)
+	}
+	for i := uint(0); i < node.ChildCount(); i++ {
+		tokens = collectTokens(node.Child(i), code, tokens)
+	}
+	return tokens
+}
+
+type formatter struct {
+	tokens []fmtToken
+	indent string
+	out    strings.Builder
+	column int
+
+	level int
+	// Whether the next token starts a statement at the current block level
+	atStatement bool
+	// Whether the last comment was written at the end of a line of code
+	trailingComment bool
+
+	// Definitions to align on '=', mapped from their start byte to their group
+	alignGroups map[uint]int
+	alignments  []alignment
+}
+
+type alignment struct {
+	group  int
+	offset int
+	column int
+}
+
+func formatTree(root *tree_sitter.Node, code []byte, indent string) []byte {
+	f := formatter{
+		tokens:      collectTokens(root, code, []fmtToken{}),
+		indent:      indent,
+		atStatement: true,
+		alignGroups: make(map[uint]int),
+	}
+	f.findAlignGroups(root, new(int))
+
+	for i, token := range f.tokens {
+		if i > 0 {
+			f.separate(f.tokens[i-1], token)
+		}
+		if token.Text == "=" && (token.Parent == "definition" || token.Parent == "function_definition") {
+			if group, ok := f.alignGroups[token.Node.Parent().StartByte()]; ok {
+				f.alignments = append(f.alignments, alignment{group: group, offset: f.out.Len(), column: f.column})
+			}
+		}
+		f.write(token.Text)
+
+		switch {
+		case token.isBlockOpen():
+			f.level++
+			f.atStatement = true
+		case token.isStatementEnd():
+			f.atStatement = true
+		case token.isComment():
+		default:
+			f.atStatement = false
+		}
+	}
+	if f.out.Len() > 0 {
+		f.out.WriteString("\n")
+	}
+	return f.align()
+}
+
+// Writes the whitespace between two consecutive tokens
+func (f *formatter) separate(prev, next fmtToken) {
+	rowDiff := int(next.Node.StartPosition().Row) - int(prev.Node.EndPosition().Row)
+
+	// Comments trailing code stay on their line
+	if next.isComment() && rowDiff == 0 && !prev.isLineComment() {
+		f.write(" ")
+		f.trailingComment = true
+		return
+	}
+	if next.isComment() {
+		f.trailingComment = false
+	}
+
+	if next.isBlockClose() {
+		f.level--
+		if prev.isBlockOpen() {
+			return
+		}
+		f.newline(1, f.level)
+		return
+	}
+
+	endsLine := prev.isLineComment() || prev.isBlockOpen() || prev.isStatementEnd() || (prev.isComment() && f.trailingComment && f.atStatement)
+	if endsLine || (rowDiff > 0 && (f.atStatement || next.isComment())) {
+		lines := 1
+		if rowDiff > 1 && (f.atStatement || prev.isBlockOpen() || prev.isStatementEnd()) {
+			lines = 2
+		}
+		level := f.level
+		if !(f.atStatement || prev.isBlockOpen() || prev.isStatementEnd()) {
+			level++
+		}
+		f.newline(lines, level)
+		return
+	}
+
+	// Expressions spanning multiple lines keep their line breaks, indented once more than their statement
+	if rowDiff > 0 {
+		f.newline(1, f.level+1)
+		return
+	}
+
+	if needsSpace(prev, next) {
+		f.write(" ")
+	}
+}
+
+func needsSpace(prev, next fmtToken) bool {
+	switch next.Text {
+	case ",", ";", ")", "]":
+		return false
+	case ".":
+		return next.Parent != "access" && next.Parent != "lambda"
+	case "'":
+		return next.Parent != "one_sample_delay"
+	case "(":
+		_, call := callNodes[next.Parent]
+		return !call
+	case "[":
+		return next.Parent != "substitutions"
+	case "{":
+		return next.Parent != "waveform"
+	case "}":
+		return next.Parent != "waveform"
+	}
+
+	switch prev.Text {
+	case "(", "[", "\\":
+		return false
+	case ".":
+		return prev.Parent != "access" && prev.Parent != "lambda"
+	case "'":
+		return prev.Parent != "recinition"
+	case "{":
+		return prev.Parent != "waveform"
+	case "-", "+":
+		return prev.Parent != "negate_id" && prev.Parent != "unary_number"
+	}
+	return true
+}
+
+func (f *formatter) newline(lines int, level int) {
+	f.out.WriteString(strings.Repeat("\n", lines))
+	f.column = 0
+	f.write(strings.Repeat(f.indent, max(level, 0)))
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		f.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		f.column += utf8.RuneCountInString(s)
+	}
+}
+
+// Groups consecutive single-line definitions in the same block that have no blank lines or comments between them
+func (f *formatter) findAlignGroups(node *tree_sitter.Node, groupCtr *int) {
+	if node.Kind() == "program" || node.Kind() == "environment" || node.Kind() == "rec_environment" {
+		var group []*tree_sitter.Node
+		flush := func() {
+			if len(group) > 1 {
+				for _, def := range group {
+					f.alignGroups[def.StartByte()] = *groupCtr
+				}
+				*groupCtr++
+			}
+			group = nil
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			if !isAlignable(child) {
+				flush()
+				continue
+			}
+			if len(group) > 0 && child.StartPosition().Row-group[len(group)-1].EndPosition().Row > 1 {
+				flush()
+			}
+			group = append(group, child)
+		}
+		flush()
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		f.findAlignGroups(node.NamedChild(i), groupCtr)
+	}
+}
+
+// Definitions stay on a single line after formatting only if they don't contain blocks
+func isAlignable(node *tree_sitter.Node) bool {
+	if node.Kind() != "definition" && node.Kind() != "function_definition" {
+		return false
+	}
+	if node.StartPosition().Row != node.EndPosition().Row {
+		return false
+	}
+	return !containsBlock(node)
+}
+
+func containsBlock(node *tree_sitter.Node) bool {
+	if _, ok := blockNodes[node.Kind()]; ok {
+		return true
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if containsBlock(node.NamedChild(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pads definitions of each group so that their '=' line up
+func (f *formatter) align() []byte {
+	output := f.out.String()
+	widths := make(map[int]int)
+	for _, a := range f.alignments {
+		widths[a.group] = max(widths[a.group], a.column)
+	}
+	for i := len(f.alignments) - 1; i >= 0; i-- {
+		a := f.alignments[i]
+		padding := strings.Repeat(" ", widths[a.group]-a.column)
+		output = output[:a.offset] + padding + output[a.offset:]
+	}
+	return []byte(output)
+}
+
+// FormatRange formats the top-level statements overlapping the byte range [start, end] of code.
+// Returns the formatted statements along with the byte range of code they replace.
+// Statements are formatted on their own, so syntax errors elsewhere in the code don't prevent formatting them.
+func FormatRange(code []byte, indent string, start, end uint) ([]byte, uint, uint, error) {
+	tree := ParseTree(code)
+	defer tree.Close()
+	root := tree.RootNode()
+
+	found := false
+	var replaceStart, replaceEnd uint
+	for i := uint(0); i < root.ChildCount(); i++ {
+		child := root.Child(i)
+		if !child.IsNamed() || child.Kind() == "comment" {
+			continue
+		}
+
+		// Include the ';' terminating definitions
+		stmtStart, stmtEnd := child.StartByte(), child.EndByte()
+		if next := child.NextSibling(); next != nil && next.Kind() == ";" {
+			stmtEnd = next.EndByte()
+		}
+		if stmtStart > end || stmtEnd < start {
+			continue
+		}
+		if child.HasError() {
+			return []byte{}, 0, 0, errors.New("can't format statement with syntax errors")
+		}
+
+		if !found {
+			replaceStart = stmtStart
+			found = true
+		}
+		replaceEnd = stmtEnd
+	}
+	if !found {
+		return []byte{}, 0, 0, errors.New("no statements in range")
+	}
+
+	output, err := Format(code[replaceStart:replaceEnd], indent)
+	if err != nil {
+		return []byte{}, 0, 0, err
+	}
+	return []byte(strings.TrimSuffix(string(output), "\n")), replaceStart, replaceEnd, nil
+}
@@ -15,10 +15,9 @@ import (
 // TODO: Handle Incremental Changes to Trees
 
 type TSParser struct {
-	language     *tree_sitter.Language
-	parser       *tree_sitter.Parser
-	treesToClose []*tree_sitter.Tree
-	mu           sync.Mutex
+	language *tree_sitter.Language
+	parser   *tree_sitter.Parser
+	mu       sync.Mutex
 }
 
 var tsParser TSParser
@@ -34,18 +33,17 @@ type TSQueryResult struct {
 	Results map[string][]tree_sitter.Node
 }
 
-func ParseTree(code []byte) *tree_sitter.Tree {
-	//	tsParser.parser = tree_sitter.NewParser()
-	//	tsParser.parser.SetLanguage(tsParser.language)
-	tsParser.mu.Lock()
-	tree := tsParser.parser.Parse(code, nil)
-	//	tsParser.parser.Close()
-	tsParser.parser.Reset()
-	tsParser.mu.Unlock()
-	return tree
+// ParseTree parses code into a tree of the Trees pool, which has to be closed after use
+func ParseTree(code []byte) *Tree {
+	return Trees.Parse(code)
+}
+
+// Reparse parses code reusing the unchanged parts of oldTree, which must have been edited to match code
+func Reparse(code []byte, oldTree *Tree) *Tree {
+	return Trees.Reparse(code, oldTree)
 }
 
-func TSDiagnostics(code []byte, tree *tree_sitter.Tree) []Diagnostic {
+func TSDiagnostics(code []byte, tree *Tree) []Diagnostic {
 	errorQuery := "(ERROR) @error\n(MISSING) @missing"
 	rslts := GetQueryMatches(errorQuery, code, tree)
 
@@ -96,7 +94,7 @@ func TSDiagnostics(code []byte, tree *tree_sitter.Tree) []Diagnostic {
 	return diagnostics
 }
 
-func DocumentSymbols(tree *tree_sitter.Tree, content []byte) []DocumentSymbol {
+func DocumentSymbols(tree *Tree, content []byte) []DocumentSymbol {
 	cursor := tree.Walk()
 	defer cursor.Close()
 
@@ -109,25 +107,15 @@ func DocumentSymbolsRecursiveNoEnvironment(node *tree_sitter.Node, content []byt
 	name := node.GrammarName()
 	var s DocumentSymbol
 	if name == "definition" || name == "function_definition" {
-		ident := node.Child(0)
+		ident := definitionName(node)
 		s.Name = ident.Utf8Text(content)
-		//		istart := ident.StartPosition()
-		//		iend := ident.EndPosition()
-		start := node.StartPosition()
-		end := node.EndPosition()
 		if name == "function_definition" {
 			s.Kind = Function
 		} else if name == "definition" {
 			s.Kind = Variable
 		}
-		s.SelectionRange = Range{
-			Start: Position{Line: uint32(start.Row), Character: uint32(start.Column)},
-			End:   Position{Line: uint32(end.Row), Character: uint32(end.Column)},
-		}
-		s.Range = Range{
-			Start: Position{Line: uint32(start.Row), Character: uint32(start.Column)},
-			End:   Position{Line: uint32(end.Row), Character: uint32(end.Column)},
-		}
+		s.SelectionRange = nodeRange(ident.StartPosition(), ident.EndPosition())
+		s.Range = definitionRange(node)
 	}
 
 	if name == "definition" || name == "function_definition" || name == "environment" || name == "program" {
@@ -149,7 +137,7 @@ func DocumentSymbolsRecursive(node *tree_sitter.Node, content []byte) DocumentSy
 	name := node.GrammarName()
 	var s DocumentSymbol
 	if name == "definition" || name == "function_definition" {
-		ident := node.Child(0)
+		ident := definitionName(node)
 		s.Name = ident.Utf8Text(content)
 		if name == "function_definition" {
 			s.Kind = Function
@@ -157,18 +145,9 @@ func DocumentSymbolsRecursive(node *tree_sitter.Node, content []byte) DocumentSy
 			// Every definition is essentially a function in Faust than a variable
 			s.Kind = Function
 		}
-		//		istart := ident.StartPosition()
-		//		iend := ident.EndPosition()
-		start := node.StartPosition()
-		end := node.EndPosition()
-		s.SelectionRange = Range{
-			Start: Position{Line: uint32(start.Row), Character: uint32(start.Column)},
-			End:   Position{Line: uint32(end.Row), Character: uint32(end.Column)},
-		}
-		s.Range = Range{
-			Start: Position{Line: uint32(start.Row), Character: uint32(start.Column)},
-			End:   Position{Line: uint32(end.Row), Character: uint32(end.Column)},
-		}
+		// Editors use the range for breadcrumbs and sticky scroll, and the selection range to highlight the name
+		s.SelectionRange = nodeRange(ident.StartPosition(), ident.EndPosition())
+		s.Range = definitionRange(node)
 	}
 
 	if name == "definition" || name == "function_definition" || name == "program" {
@@ -208,7 +187,34 @@ func DocumentSymbolsRecursive(node *tree_sitter.Node, content []byte) DocumentSy
 
 }
 
-func GetImports(code []byte, tree *tree_sitter.Tree) []util.Path {
+// Identifier a definition or function definition defines, skipping precision variants before it
+func definitionName(node *tree_sitter.Node) *tree_sitter.Node {
+	if ident := node.ChildByFieldName("variable"); ident != nil {
+		return ident
+	}
+	if ident := node.ChildByFieldName("name"); ident != nil {
+		return ident
+	}
+	return node.Child(0)
+}
+
+// Range of a definition including its with/letrec block and terminating semicolon
+func definitionRange(node *tree_sitter.Node) Range {
+	end := node.EndPosition()
+	if next := node.NextSibling(); next != nil && next.Kind() == ";" {
+		end = next.EndPosition()
+	}
+	return nodeRange(node.StartPosition(), end)
+}
+
+func nodeRange(start, end tree_sitter.Point) Range {
+	return Range{
+		Start: Position{Line: uint32(start.Row), Character: uint32(start.Column)},
+		End:   Position{Line: uint32(end.Row), Character: uint32(end.Column)},
+	}
+}
+
+func GetImports(code []byte, tree *Tree) []util.Path {
 	importQuery := `
 (file_import filename: (string) @import)
 (definition (identifier) (library filename: (string) @import))
@@ -225,7 +231,7 @@ func GetImports(code []byte, tree *tree_sitter.Tree) []util.Path {
 	return paths
 }
 
-func GetQueryMatches(queryStr string, code []byte, tree *tree_sitter.Tree) TSQueryResult {
+func GetQueryMatches(queryStr string, code []byte, tree *Tree) TSQueryResult {
 	query, _ := tree_sitter.NewQuery(tsParser.language, queryStr)
 	defer query.Close()
 
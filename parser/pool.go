@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"sync"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Tree is a tree-sitter tree created by a Pool.
+// Nodes taken from a tree are only valid until it is closed, so it must only be closed once they aren't used anymore.
+type Tree struct {
+	*tree_sitter.Tree
+	pool *Pool
+	once sync.Once
+}
+
+// Close frees the tree. Closing a tree more than once does nothing.
+func (t *Tree) Close() {
+	t.once.Do(func() {
+		t.Tree.Close()
+		t.pool.mu.Lock()
+		t.pool.live--
+		t.pool.mu.Unlock()
+	})
+}
+
+// Copy returns a copy of the tree that can be used and closed on its own, e.g. by another goroutine while the tree is edited.
+// Copies are cheap as they share the nodes of the tree.
+func (t *Tree) Copy() *Tree {
+	return t.pool.track(t.Tree.Clone())
+}
+
+// Pool creates trees and accounts for the ones not closed yet, which tells whether trees leak
+type Pool struct {
+	mu   sync.Mutex
+	live int
+}
+
+// Pool of the trees made by ParseTree and Reparse
+var Trees Pool
+
+func (p *Pool) Parse(code []byte) *Tree {
+	tsParser.mu.Lock()
+	tree := tsParser.parser.Parse(code, nil)
+	tsParser.parser.Reset()
+	tsParser.mu.Unlock()
+	return p.track(tree)
+}
+
+// Reparse parses code reusing the unchanged parts of oldTree, which must have been edited to match code
+func (p *Pool) Reparse(code []byte, oldTree *Tree) *Tree {
+	tsParser.mu.Lock()
+	tree := tsParser.parser.Parse(code, oldTree.Tree)
+	tsParser.parser.Reset()
+	tsParser.mu.Unlock()
+	return p.track(tree)
+}
+
+func (p *Pool) track(tree *tree_sitter.Tree) *Tree {
+	p.mu.Lock()
+	p.live++
+	p.mu.Unlock()
+	return &Tree{Tree: tree, pool: p}
+}
+
+// Live returns the number of trees of the pool that aren't closed yet
+func (p *Pool) Live() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.live
+}
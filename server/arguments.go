@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Diagnostic codes of case rules with a different number of patterns and calls with too many arguments
+const (
+	caseArityCode        = "case-arity"
+	tooManyArgumentsCode = "too-many-arguments"
+)
+
+// ArgumentCountDiagnostics reports case expressions whose rules don't all take the same number of patterns,
+// and calls passing more arguments than the function they call takes when its definition is known and its result provably has no inputs.
+// Extra arguments are otherwise applied to the result, and calls with fewer arguments are partial applications, so neither is reported.
+func ArgumentCountDiagnostics(root *tree_sitter.Node, content []byte, precision string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	if root.HasError() {
+		return diagnostics
+	}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		switch node.Kind() {
+		case "pattern":
+			diagnostics = append(diagnostics, caseArityDiagnostics(node)...)
+		case "function_call":
+			if d, ok := callArgumentsDiagnostic(node, content, precision); ok {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return diagnostics
+}
+
+func caseArityDiagnostics(pattern *tree_sitter.Node) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	rules := caseRules(pattern)
+	if len(rules) == 0 {
+		return diagnostics
+	}
+	expected := ruleArgumentCount(rules[0])
+	for _, rule := range rules[1:] {
+		if n := ruleArgumentCount(rule); n != expected {
+			diagnostics = append(diagnostics, transport.Diagnostic{
+				Range:    ToRange(rule),
+				Severity: transport.DiagnosticSeverity(transport.Error),
+				Code:     caseArityCode,
+				Source:   "faustlsp",
+				Message:  fmt.Sprintf("Rule takes %d pattern(s) but the first rule of this case takes %d", n, expected),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func caseRules(pattern *tree_sitter.Node) []*tree_sitter.Node {
+	rules := []*tree_sitter.Node{}
+	for i := uint(0); i < pattern.NamedChildCount(); i++ {
+		if child := pattern.NamedChild(i); child.Kind() == "rules" {
+			for j := uint(0); j < child.NamedChildCount(); j++ {
+				if rule := child.NamedChild(j); rule.Kind() == "rule" {
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	return rules
+}
+
+func ruleArgumentCount(rule *tree_sitter.Node) int {
+	return argumentCount(childOfKind(rule, "arguments"))
+}
+
+func argumentCount(arguments *tree_sitter.Node) int {
+	if arguments == nil {
+		return 0
+	}
+	count := 0
+	for i := uint(0); i < arguments.NamedChildCount(); i++ {
+		if arguments.NamedChild(i).Kind() != "comment" {
+			count++
+		}
+	}
+	return count
+}
+
+func childOfKind(node *tree_sitter.Node, kind string) *tree_sitter.Node {
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if child := node.NamedChild(i); child.Kind() == kind {
+			return child
+		}
+	}
+	return nil
+}
+
+// Checks the outermost call of a chain like f(1)(2, 3), counting the arguments of the whole chain
+func callArgumentsDiagnostic(call *tree_sitter.Node, content []byte, precision string) (transport.Diagnostic, bool) {
+	if parent := call.Parent(); parent != nil && parent.Kind() == "function_call" && isField(parent, "callee", call) {
+		return transport.Diagnostic{}, false
+	}
+	given := 0
+	callee := call
+	for callee.Kind() == "function_call" {
+		given += argumentCount(childOfKind(callee, "arguments"))
+		callee = callee.ChildByFieldName("callee")
+		if callee == nil {
+			return transport.Diagnostic{}, false
+		}
+	}
+	if callee.Kind() != "identifier" {
+		return transport.Diagnostic{}, false
+	}
+	expected, body, ok := parameterCount(callee, content, precision)
+	if !ok || given <= expected || !hasNoInputs(body, content, precision) {
+		return transport.Diagnostic{}, false
+	}
+	name := callee.Utf8Text(content)
+	return transport.Diagnostic{
+		Range:    ToRange(call),
+		Severity: transport.DiagnosticSeverity(transport.Warning),
+		Code:     tooManyArgumentsCode,
+		Source:   "faustlsp",
+		Message:  fmt.Sprintf("%s takes %d argument(s) and its result has no inputs, but is applied to %d", name, expected, given),
+	}, true
+}
+
+// Whether the body of a function provably has no inputs, which the arguments beyond its parameters would be applied to.
+// Bodies using parameters depend on the arguments, so they aren't known to.
+func hasNoInputs(body *tree_sitter.Node, content []byte, precision string) bool {
+	if body == nil || usesParameters(body, content) {
+		return false
+	}
+	arity, ok := ExpressionArity(body, content, precision)
+	return ok && arity.Inputs == 0
+}
+
+// Whether body uses one of the parameters of the function or lambda it is the body of
+func usesParameters(body *tree_sitter.Node, content []byte) bool {
+	definition := body.Parent()
+	parameters := childOfKind(definition, "arguments")
+	if definition.Kind() == "lambda" {
+		parameters = childOfKind(definition, "parameters")
+	}
+	var uses func(node *tree_sitter.Node) bool
+	uses = func(node *tree_sitter.Node) bool {
+		if node.Kind() == "identifier" && bindsName(parameters, node.Utf8Text(content), content) {
+			return true
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			if uses(node.NamedChild(i)) {
+				return true
+			}
+		}
+		return false
+	}
+	return uses(body)
+}
+
+// Number of parameters of the function ident refers to, found in enclosing with environments or at the top-level of the file,
+// along with its body if it has a single definition that is a function or lambda.
+// Returns false if ident is bound by a parameter or its definitions don't agree on a number.
+func parameterCount(ident *tree_sitter.Node, content []byte, precision string) (int, *tree_sitter.Node, bool) {
+	name := ident.Utf8Text(content)
+	for curr := ident.Parent(); curr != nil; curr = curr.Parent() {
+		switch curr.Kind() {
+		case "function_definition", "rule":
+			if bindsName(childOfKind(curr, "arguments"), name, content) {
+				return 0, nil, false
+			}
+		case "lambda":
+			if bindsName(childOfKind(curr, "parameters"), name, content) {
+				return 0, nil, false
+			}
+		case "iteration":
+			if iter := curr.ChildByFieldName("current_iter"); iter != nil && iter.Utf8Text(content) == name {
+				return 0, nil, false
+			}
+		case "with_environment", "letrec_environment":
+			if count, body, found, ok := definedParameterCount(curr.ChildByFieldName("local_environment"), name, content, precision); found {
+				return count, body, ok
+			}
+		case "program":
+			count, body, found, ok := definedParameterCount(curr, name, content, precision)
+			return count, body, found && ok
+		}
+	}
+	return 0, nil, false
+}
+
+func bindsName(arguments *tree_sitter.Node, name string, content []byte) bool {
+	if arguments == nil {
+		return false
+	}
+	for i := uint(0); i < arguments.NamedChildCount(); i++ {
+		if arg := arguments.NamedChild(i); arg.Kind() == "identifier" && arg.Utf8Text(content) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of parameters of the definitions of name directly inside node, which can be function definitions, lambdas or cases,
+// and the body of the definition if there is only one. found is whether name is defined there, ok whether the number is known.
+func definedParameterCount(node *tree_sitter.Node, name string, content []byte, precision string) (count int, body *tree_sitter.Node, found bool, ok bool) {
+	if node == nil {
+		return 0, nil, false, false
+	}
+	ok = true
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		child := node.NamedChild(i)
+		ident := definitionIdentifier(child)
+		if ident == nil || ident.Utf8Text(content) != name || !variantsMatch(DefinitionVariants(child), precision) {
+			continue
+		}
+		n, b, known := definitionParameterCount(child)
+		if found && (!known || n != count) {
+			ok = false
+		}
+		if !found {
+			count, body, ok = n, b, known
+		} else {
+			body = nil
+		}
+		found = true
+	}
+	return count, body, found, ok
+}
+
+// Number of parameters of a definition, and its body if it is a function or lambda
+func definitionParameterCount(definition *tree_sitter.Node) (int, *tree_sitter.Node, bool) {
+	value := definition.ChildByFieldName("value")
+	if definition.Kind() == "function_definition" {
+		return argumentCount(childOfKind(definition, "arguments")), value, true
+	}
+	if value == nil {
+		return 0, nil, false
+	}
+	switch value.Kind() {
+	case "lambda":
+		return argumentCount(childOfKind(value, "parameters")), value.ChildByFieldName("value"), true
+	case "pattern":
+		rules := caseRules(value)
+		if len(rules) == 0 {
+			return 0, nil, false
+		}
+		n := ruleArgumentCount(rules[0])
+		for _, rule := range rules[1:] {
+			if ruleArgumentCount(rule) != n {
+				return 0, nil, false
+			}
+		}
+		return n, nil, true
+	}
+	return 0, nil, false
+}
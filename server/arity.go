@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Arity is the number of input and output signals of a block diagram
+type Arity struct {
+	Inputs  int
+	Outputs int
+}
+
+func (a Arity) String() string {
+	return fmt.Sprintf("%d input(s), %d output(s)", a.Inputs, a.Outputs)
+}
+
+// Number of inputs of primitives that take their inputs as signals (1 output each)
+var primitiveInputs = map[string]int{
+	// Infix primitives
+	"add": 2, "sub": 2, "mult": 2, "div": 2, "mod": 2, "pow": 2,
+	"or": 2, "and": 2, "xor": 2, "lshift": 2, "rshift": 2,
+	"lt": 2, "le": 2, "gt": 2, "ge": 2, "eq": 2, "neq": 2, "delay": 2,
+
+	// Unary primitives
+	"exp": 1, "log": 1, "log10": 1, "sqrt": 1, "abs": 1, "floor": 1, "ceil": 1,
+	"rint": 1, "round": 1, "cos": 1, "sin": 1, "tan": 1, "acos": 1, "asin": 1,
+	"atan": 1, "int": 1, "float": 1, "lowest": 1, "highest": 1,
+
+	// Binary primitives
+	"min": 2, "max": 2, "fmod": 2, "remainder": 2, "atan2": 2,
+	"prefix": 2, "attach": 2, "enable": 2, "control": 2,
+
+	// Ternary and above
+	"rdtable": 3, "select2": 3, "assertbounds": 3, "select3": 4, "rwtable": 5,
+
+	"mem": 1,
+}
+
+// ExpressionArity statically computes the arity of an expression node.
+// Returns false when the arity can't be known without evaluating the program (e.g. function application, library access).
+// Definitions with precision variants are picked for the given precision.
+func ExpressionArity(node *tree_sitter.Node, content []byte, precision string) (Arity, bool) {
+	e := arityEvaluator{content: content, precision: precision, resolving: make(map[uint]struct{})}
+	return e.arity(node)
+}
+
+type arityEvaluator struct {
+	content   []byte
+	precision string
+	// Start bytes of definitions being resolved, to avoid infinite recursion on recursive definitions
+	resolving map[uint]struct{}
+}
+
+func (e *arityEvaluator) arity(node *tree_sitter.Node) (Arity, bool) {
+	if node == nil {
+		return Arity{}, false
+	}
+
+	switch node.Kind() {
+	case "int", "real", "unary_number", "button", "checkbox", "numeric_widget", "inputs", "outputs", "fconst", "fvariable":
+		return Arity{0, 1}, true
+	case "wire":
+		return Arity{1, 1}, true
+	case "cut":
+		return Arity{1, 0}, true
+	case "bargraph":
+		return Arity{1, 1}, true
+	case "waveform":
+		return Arity{0, 2}, true
+	case "group":
+		return e.arity(node.ChildByFieldName("expression"))
+	case "modifier":
+		return e.arity(node.ChildByFieldName("operand"))
+	case "negate_id":
+		return e.arity(node.NamedChild(0))
+	case "with_environment", "letrec_environment":
+		return e.arity(node.ChildByFieldName("expression"))
+	case "identifier":
+		return e.identifierArity(node)
+	case "sequential", "parallel", "split", "merge", "recursive":
+		left, lok := e.arity(node.ChildByFieldName("left"))
+		right, rok := e.arity(node.ChildByFieldName("right"))
+		if !lok || !rok {
+			return Arity{}, false
+		}
+		return CompositionArity(node.Kind(), left, right)
+	case "infix":
+		left, lok := e.arity(node.ChildByFieldName("left"))
+		right, rok := e.arity(node.ChildByFieldName("right"))
+		if !lok || !rok || left.Outputs != 1 || right.Outputs != 1 {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs + right.Inputs, 1}, true
+	case "prefix":
+		left, lok := e.arity(node.ChildByFieldName("left"))
+		right, rok := e.arity(node.ChildByFieldName("right"))
+		if !lok || !rok {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs + right.Inputs, 1}, true
+	case "partial":
+		operand, ok := e.arity(node.ChildByFieldName("operand"))
+		if !ok {
+			return Arity{}, false
+		}
+		return Arity{operand.Inputs + 1, 1}, true
+	case "prim1":
+		arg, ok := e.arity(node.ChildByFieldName("argument"))
+		if !ok {
+			return Arity{}, false
+		}
+		return Arity{arg.Inputs, 1}, true
+	case "prim2", "prim3", "prim4", "prim5":
+		primitive := node.ChildByFieldName("primitive")
+		var args *tree_sitter.Node
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			if node.NamedChild(i).Kind() == "arguments" {
+				args = node.NamedChild(i)
+			}
+		}
+		if primitive == nil || args == nil {
+			return Arity{}, false
+		}
+		inputs, ok := primitiveInputs[primitive.Kind()]
+		if !ok || args.NamedChildCount() > uint(inputs) {
+			return Arity{}, false
+		}
+		inputs -= int(args.NamedChildCount())
+		for i := uint(0); i < args.NamedChildCount(); i++ {
+			arg, ok := e.arity(args.NamedChild(i))
+			if !ok {
+				return Arity{}, false
+			}
+			inputs += arg.Inputs
+		}
+		return Arity{inputs, 1}, true
+	case "route":
+		ins, iok := e.constant(node.ChildByFieldName("num_inputs"))
+		outs, ook := e.constant(node.ChildByFieldName("num_outputs"))
+		if !iok || !ook {
+			return Arity{}, false
+		}
+		return Arity{ins, outs}, true
+	case "iteration":
+		n, ok := e.constant(node.ChildByFieldName("num_iters"))
+		if !ok {
+			return Arity{}, false
+		}
+		body, ok := e.arity(node.ChildByFieldName("expression"))
+		if !ok {
+			return Arity{}, false
+		}
+		switch node.ChildByFieldName("type").Kind() {
+		case "par":
+			return Arity{n * body.Inputs, n * body.Outputs}, true
+		case "seq":
+			return body, true
+		case "sum", "prod":
+			return Arity{n * body.Inputs, body.Outputs}, true
+		}
+		return Arity{}, false
+	}
+
+	// Anonymous primitive keywords like sin, pow, rdtable used without arguments
+	if inputs, ok := primitiveInputs[node.Kind()]; ok {
+		return Arity{inputs, 1}, true
+	}
+	return Arity{}, false
+}
+
+// Arity of an identifier by finding its definition in enclosing with environments or at the top-level of the file
+func (e *arityEvaluator) identifierArity(ident *tree_sitter.Node) (Arity, bool) {
+	value, iterVar, ok := e.lookup(ident)
+	if !ok {
+		return Arity{}, false
+	}
+	// Iteration variables are constant numbers
+	if iterVar {
+		return Arity{0, 1}, true
+	}
+	if !e.enter(value) {
+		return Arity{}, false
+	}
+	defer e.leave(value)
+	return e.arity(value)
+}
+
+// Finds the value of the definition an identifier refers to, or whether it is an iteration variable
+func (e *arityEvaluator) lookup(ident *tree_sitter.Node) (value *tree_sitter.Node, iterVar bool, ok bool) {
+	name := ident.Utf8Text(e.content)
+	for curr := ident.Parent(); curr != nil; curr = curr.Parent() {
+		switch curr.Kind() {
+		case "iteration":
+			iter := curr.ChildByFieldName("current_iter")
+			if iter != nil && iter.Utf8Text(e.content) == name {
+				return nil, true, true
+			}
+		case "with_environment", "letrec_environment":
+			if value, ok := findDefinitionValue(curr.ChildByFieldName("local_environment"), name, e.content, e.precision); ok {
+				return value, false, true
+			}
+		case "program":
+			if value, ok := findDefinitionValue(curr, name, e.content, e.precision); ok {
+				return value, false, true
+			}
+		}
+	}
+	return nil, false, false
+}
+
+// Marks a definition as being resolved. Returns false if it already is, i.e. the definition is recursive.
+func (e *arityEvaluator) enter(value *tree_sitter.Node) bool {
+	if _, ok := e.resolving[value.StartByte()]; ok {
+		return false
+	}
+	e.resolving[value.StartByte()] = struct{}{}
+	return true
+}
+
+func (e *arityEvaluator) leave(value *tree_sitter.Node) {
+	delete(e.resolving, value.StartByte())
+}
+
+// Evaluates an expression as an integer constant. Handles literals and identifiers defined as constants.
+func (e *arityEvaluator) constant(node *tree_sitter.Node) (int, bool) {
+	if node == nil {
+		return 0, false
+	}
+	switch node.Kind() {
+	case "int":
+		n, err := strconv.Atoi(node.Utf8Text(e.content))
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	case "group":
+		return e.constant(node.ChildByFieldName("expression"))
+	case "identifier":
+		value, iterVar, ok := e.lookup(node)
+		if !ok || iterVar || !e.enter(value) {
+			return 0, false
+		}
+		defer e.leave(value)
+		return e.constant(value)
+	}
+	return 0, false
+}
+
+// Finds the value of a simple definition (not a function definition) named ident directly inside node.
+// Definitions restricted to other precisions than the given one are skipped.
+func findDefinitionValue(node *tree_sitter.Node, ident string, content []byte, precision string) (*tree_sitter.Node, bool) {
+	if node == nil {
+		return nil, false
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		child := node.NamedChild(i)
+		if child.Kind() != "definition" || !variantsMatch(DefinitionVariants(child), precision) {
+			continue
+		}
+		variable := child.ChildByFieldName("variable")
+		if variable != nil && variable.Utf8Text(content) == ident {
+			value := child.ChildByFieldName("value")
+			return value, value != nil
+		}
+	}
+	return nil, false
+}
+
+// CompositionArity computes the arity of composing left and right with the given composition (tree-sitter node kind).
+// Returns false if the composition is invalid for these arities.
+func CompositionArity(kind string, left, right Arity) (Arity, bool) {
+	switch kind {
+	case "sequential":
+		if left.Outputs != right.Inputs {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs, right.Outputs}, true
+	case "parallel":
+		return Arity{left.Inputs + right.Inputs, left.Outputs + right.Outputs}, true
+	case "split":
+		if left.Outputs == 0 || right.Inputs%left.Outputs != 0 {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs, right.Outputs}, true
+	case "merge":
+		if right.Inputs == 0 || left.Outputs%right.Inputs != 0 {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs, right.Outputs}, true
+	case "recursive":
+		if right.Inputs > left.Outputs || right.Outputs > left.Inputs {
+			return Arity{}, false
+		}
+		return Arity{left.Inputs - right.Outputs, left.Outputs}, true
+	}
+	return Arity{}, false
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Minimum number of elements a ',' chain needs to be treated as a bank
+const minBankSize = 8
+
+// Maximum length of an element's text shown in summaries
+const maxBankLabel = 30
+
+// Bank is a group of parallel channels, either a par iteration or a long chain of ',' compositions like a mixer bank
+type Bank struct {
+	Range transport.Range
+	// Number of elements of the bank
+	Count int
+	// Number of output channels of the bank, 0 when it can't be known statically
+	Channels int
+	// Text of the bank's element for par iterations, or of its first element for ',' chains
+	Label string
+	// Runs of consecutive identical elements of ',' chains. Empty for par iterations
+	Runs []BankRun
+}
+
+type BankRun struct {
+	Range transport.Range
+	Count int
+	Text  string
+}
+
+// Summary shows the number of elements of the bank, along with its channels when they aren't one per element
+func (b Bank) Summary() string {
+	switch b.Channels {
+	case b.Count:
+		return fmt.Sprintf("×%d channels", b.Count)
+	case 0:
+		return fmt.Sprintf("×%d", b.Count)
+	}
+	return fmt.Sprintf("×%d, %d channels", b.Count, b.Channels)
+}
+
+// FindBanks finds par iterations with constant sizes and long ',' chains in a tree, picking definitions for the given precision
+func FindBanks(root *tree_sitter.Node, content []byte, precision string) []Bank {
+	e := arityEvaluator{content: content, precision: precision, resolving: make(map[uint]struct{})}
+	banks := []Bank{}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		switch node.Kind() {
+		case "iteration":
+			iterType := node.ChildByFieldName("type")
+			if iterType != nil && iterType.Kind() == "par" {
+				// Iterations of a single element aren't banks
+				if n, ok := e.constant(node.ChildByFieldName("num_iters")); ok && n > 1 {
+					banks = append(banks, Bank{
+						Range:    ToRange(node),
+						Count:    n,
+						Channels: bankChannels(&e, node),
+						Label:    bankLabel(node.ChildByFieldName("expression"), content),
+					})
+				}
+			}
+		case "parallel":
+			elements := parallelElements(node, []*tree_sitter.Node{})
+			if len(elements) >= minBankSize {
+				banks = append(banks, Bank{
+					Range:    ToRange(node),
+					Count:    len(elements),
+					Channels: bankChannels(&e, node),
+					Label:    bankLabel(elements[0], content),
+					Runs:     bankRuns(elements, content),
+				})
+			}
+			for _, element := range elements {
+				walk(element)
+			}
+			return
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return banks
+}
+
+// Outputs of a bank, N times those of the element of par(i, N, …), 0 if they can't be known statically
+func bankChannels(e *arityEvaluator, node *tree_sitter.Node) int {
+	arity, ok := e.arity(node)
+	if !ok {
+		return 0
+	}
+	return arity.Outputs
+}
+
+// Flattens a chain of ',' compositions into its elements
+func parallelElements(node *tree_sitter.Node, elements []*tree_sitter.Node) []*tree_sitter.Node {
+	if node.Kind() != "parallel" {
+		return append(elements, node)
+	}
+	for _, field := range []string{"left", "right"} {
+		if child := node.ChildByFieldName(field); child != nil {
+			elements = parallelElements(child, elements)
+		}
+	}
+	return elements
+}
+
+// Groups consecutive elements with the same code, ignoring whitespace
+func bankRuns(elements []*tree_sitter.Node, content []byte) []BankRun {
+	runs := []BankRun{}
+	for i := 0; i < len(elements); {
+		text := normalizedText(elements[i], content)
+		j := i + 1
+		for j < len(elements) && normalizedText(elements[j], content) == text {
+			j++
+		}
+		if j-i > 1 {
+			runs = append(runs, BankRun{
+				Range: transport.Range{Start: ToRange(elements[i]).Start, End: ToRange(elements[j-1]).End},
+				Count: j - i,
+				Text:  bankLabel(elements[i], content),
+			})
+		}
+		i = j
+	}
+	return runs
+}
+
+func normalizedText(node *tree_sitter.Node, content []byte) string {
+	return strings.Join(strings.Fields(node.Utf8Text(content)), " ")
+}
+
+func bankLabel(node *tree_sitter.Node, content []byte) string {
+	if node == nil {
+		return ""
+	}
+	label := normalizedText(node, content)
+	if len([]rune(label)) > maxBankLabel {
+		label = string([]rune(label)[:maxBankLabel-1]) + "…"
+	}
+	return label
+}
+
+func (f *File) Banks(precision string) []Bank {
+	t, content := f.Tree()
+	defer t.Close()
+	return FindBanks(t.RootNode(), content, precision)
+}
+
+// BankFoldingRanges adds to folds the multi-line banks as well as multi-line runs of identical elements inside them.
+// Editors keep a single fold per line, so banks and runs starting on a line folds already start on are left out.
+func BankFoldingRanges(banks []Bank, folds []transport.FoldingRange) []transport.FoldingRange {
+	folded := make(map[uint32]struct{})
+	for _, fold := range folds {
+		if fold.StartLine != nil {
+			folded[*fold.StartLine] = struct{}{}
+		}
+	}
+	add := func(r transport.Range, collapsed string) {
+		if r.End.Line <= r.Start.Line {
+			return
+		}
+		if _, ok := folded[r.Start.Line]; ok {
+			return
+		}
+		folded[r.Start.Line] = struct{}{}
+		start, end := r.Start.Line, r.End.Line
+		folds = append(folds, transport.FoldingRange{
+			StartLine:     &start,
+			EndLine:       &end,
+			Kind:          string(transport.Region),
+			CollapsedText: collapsed,
+		})
+	}
+	for _, bank := range banks {
+		add(bank.Range, fmt.Sprintf("%s %s", bank.Label, bank.Summary()))
+		for _, run := range bank.Runs {
+			add(run.Range, fmt.Sprintf("%s ×%d", run.Text, run.Count))
+		}
+	}
+	return folds
+}
+
+// BankInlayHints shows the number of channels after each bank ending in r
+func BankInlayHints(banks []Bank, r transport.Range) []transport.InlayHint {
+	hints := []transport.InlayHint{}
+	for _, bank := range banks {
+		if !RangeContains(r, transport.Range{Start: bank.Range.End, End: bank.Range.End}) {
+			continue
+		}
+		hints = append(hints, transport.InlayHint{
+			Position:    bank.Range.End,
+			Label:       []transport.InlayHintLabelPart{{Value: bank.Summary()}},
+			PaddingLeft: true,
+		})
+	}
+	return hints
+}
+
+// AddBankSymbols adds an outline entry for each bank under the innermost symbol containing it
+func AddBankSymbols(symbols []transport.DocumentSymbol, banks []Bank) []transport.DocumentSymbol {
+	for _, bank := range banks {
+		sym := transport.DocumentSymbol{
+			Name:           bank.Summary(),
+			Detail:         bank.Label,
+			Kind:           transport.Array,
+			Range:          bank.Range,
+			SelectionRange: bank.Range,
+		}
+		symbols = insertSymbol(symbols, sym)
+	}
+	return symbols
+}
+
+func insertSymbol(symbols []transport.DocumentSymbol, sym transport.DocumentSymbol) []transport.DocumentSymbol {
+	for i := range symbols {
+		if RangeContains(symbols[i].Range, sym.Range) {
+			symbols[i].Children = insertSymbol(symbols[i].Children, sym)
+			return symbols
+		}
+	}
+	return append(symbols, sym)
+}
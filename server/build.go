@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command building a runnable artifact of a process file with one of the project's build targets
+const buildCommand = "faustlsp.build"
+
+// Lines of a tool's output kept in the diagnostic of a failed build that isn't a Faust error
+const buildOutputLines = 10
+
+// Faust errors located in a file, like "a.dsp : 8 : ERROR : undefined symbol : foo"
+var buildFileErrorRegex = regexp.MustCompile(`^(.+?)\s*:\s*(\d+)[\s:]*\sERROR\s:\s(.*)$`)
+
+// BuildTarget is a faust2 script like faust2jaqt, faust2vst or faust2wasm building an application or plugin from a process file
+type BuildTarget struct {
+	Name string   `json:"name"`
+	Tool string   `json:"tool"`
+	Args []string `json:"args,omitempty"` // Options given to the tool before the file, like -osc or -midi
+}
+
+// Target returns the build target called name, or the first one if name is empty
+func (c FaustProjectConfig) Target(name string) (BuildTarget, bool) {
+	for _, target := range c.BuildTargets {
+		if name == "" || target.Name == name {
+			return target, true
+		}
+	}
+	return BuildTarget{}, false
+}
+
+// Argument of the faustlsp.build command
+type BuildArgs struct {
+	URI transport.DocumentURI `json:"uri"`
+	// Name of the build target, the first one if empty
+	Target string `json:"target,omitempty"`
+}
+
+// Result of the faustlsp.build command
+type BuildResult struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+func BuildCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", buildCommand, len(arguments))
+	}
+	var args BuildArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	path, err := util.URI2path(string(args.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	if !IsDSPFile(path) {
+		return []byte("null"), fmt.Errorf("can only build .dsp files: %s", path)
+	}
+	if _, ok := s.Files.GetFromPath(path); !ok {
+		return []byte("null"), fmt.Errorf("trying to build non-existent path: %s", path)
+	}
+	w := s.workspaceFor(path)
+	config := w.ConfigFor(path)
+	target, ok := config.Target(args.Target)
+	if !ok {
+		if args.Target == "" {
+			return []byte("null"), fmt.Errorf("no build targets in %s", faustConfigFile)
+		}
+		return []byte("null"), fmt.Errorf("no build target %s in %s", args.Target, faustConfigFile)
+	}
+
+	name := filepath.Base(path)
+	progress, buildCtx := s.beginCancellableProgress(ctx, fmt.Sprintf("Building %s with %s", name, target.Tool))
+	logging.Logger.Info("Building", "path", path, "target", target)
+	output, err := runBuild(buildCtx, path, w.Root, config, target, func(line string) {
+		progress.message(line)
+	})
+	if buildCtx.Err() != nil && ctx.Err() == nil {
+		progress.end("Canceled")
+		s.showMessage(transport.Info, fmt.Sprintf("Building %s was canceled", name))
+		return []byte("null"), nil
+	}
+
+	// Build errors replace the compiler's until the file is diagnosed again
+	diagnostics := []transport.Diagnostic{}
+	if err != nil {
+		logging.Logger.Error("Build failed", "path", path, "error", err)
+		diagnostics = append(diagnostics, BuildDiagnostic(target.Tool, path, cmp.Or(output, err.Error())))
+		progress.end("Failed")
+		s.showMessage(transport.Error, fmt.Sprintf("Building %s with %s failed", name, target.Tool))
+	} else {
+		progress.end("Done")
+		s.showMessage(transport.Info, fmt.Sprintf("Built %s with %s", name, target.Tool))
+	}
+	diagnostics = append(diagnostics, w.analysisDiagnostics(path, s)...)
+	s.diagChan <- transport.PublishDiagnosticsParams{URI: transport.DocumentURI(util.Path2URI(path)), Diagnostics: diagnostics}
+	return json.Marshal(BuildResult{Success: err == nil, Output: output})
+}
+
+// BuildToolArgs returns the arguments target's tool is run with on path, from the directory of path so that artifacts are written next to it,
+// or from the project's working directory, which gets the whole path
+func BuildToolArgs(path util.Path, root util.Path, config FaustProjectConfig, target BuildTarget) []string {
+	args := append([]string{}, target.Args...)
+	for _, include := range config.IncludeDir {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(root, include)
+		}
+		args = append(args, "-I", include)
+	}
+	if flag := precisionFlags[config.EffectivePrecision()]; flag != "" {
+		args = append(args, flag)
+	}
+	args = append(args, config.CompilerArgs...)
+	if config.WorkingDir != "" {
+		return append(args, path)
+	}
+	return append(args, filepath.Base(path))
+}
+
+// Runs target on the saved file at path, calling line with each line of its output as it comes
+func runBuild(ctx context.Context, path util.Path, root util.Path, config FaustProjectConfig, target BuildTarget, line func(string)) (string, error) {
+	cmd := exec.CommandContext(ctx, target.Tool, BuildToolArgs(path, root, config, target)...)
+	config.setupCommand(cmd, filepath.Dir(path), root)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		output.WriteString(scanner.Text() + "\n")
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			line(text)
+		}
+	}
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && output.Len() == 0 {
+		return "", fmt.Errorf("%s exited with status %d", target.Tool, exitErr.ExitCode())
+	}
+	return output.String(), err
+}
+
+// BuildDiagnostic returns the diagnostic of a failed build of path by tool from its output:
+// the Faust error it printed, on its line if it is in path, or the end of the output
+func BuildDiagnostic(tool string, path util.Path, output string) transport.Diagnostic {
+	d := transport.Diagnostic{
+		Severity: transport.SeverityError,
+		Source:   tool,
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if captures := buildFileErrorRegex.FindStringSubmatch(line); captures != nil {
+			if filepath.Base(captures[1]) == filepath.Base(path) {
+				var l uint32
+				fmt.Sscan(captures[2], &l)
+				if l > 0 {
+					l--
+				}
+				d.Range = transport.Range{Start: transport.Position{Line: l}, End: transport.Position{Line: l, Character: 2147483647}}
+			}
+			d.Message = captures[3]
+			return d
+		}
+		if _, message, ok := strings.Cut(line, "ERROR : "); ok {
+			d.Message = message
+			return d
+		}
+	}
+	if len(lines) > buildOutputLines {
+		lines = lines[len(lines)-buildOutputLines:]
+	}
+	d.Message = fmt.Sprintf("%s failed:\n%s", tool, strings.Join(lines, "\n"))
+	return d
+}
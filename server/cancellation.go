@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Cause of requests canceled because the document they were computing results for changed
+var errContentModified = errors.New("document changed while computing the result")
+
+// Cancel functions of the requests being handled, by request ID and by the document they were made for
+type requestCancels struct {
+	byID       map[string]context.CancelCauseFunc
+	byDocument map[util.Path]map[int64]context.CancelCauseFunc
+	next       int64
+}
+
+func requestKey(id any) string {
+	return fmt.Sprint(id)
+}
+
+// Key of the ID of the request a context is the context of
+type requestIDKey struct{}
+
+// Returns the context of the request with id, canceled by $/cancelRequest, and the function to call once it is handled
+func (s *Server) trackRequest(ctx context.Context, id any) (context.Context, func()) {
+	key := requestKey(id)
+	ctx, cancel := context.WithCancelCause(context.WithValue(ctx, requestIDKey{}, key))
+	s.requestsMu.Lock()
+	if s.requests.byID == nil {
+		s.requests.byID = make(map[string]context.CancelCauseFunc)
+	}
+	s.requests.byID[key] = cancel
+	s.requestsMu.Unlock()
+	return ctx, func() {
+		s.requestsMu.Lock()
+		delete(s.requests.byID, key)
+		s.requestsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Returns a context of a request canceled with errContentModified once the document at path changes,
+// for results that are stale once it does, and the function to call once they are computed
+func (s *Server) documentRequest(ctx context.Context, path util.Path) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s.requestsMu.Lock()
+	s.requests.next++
+	key := s.requests.next
+	if s.requests.byDocument == nil {
+		s.requests.byDocument = make(map[util.Path]map[int64]context.CancelCauseFunc)
+	}
+	if s.requests.byDocument[path] == nil {
+		s.requests.byDocument[path] = make(map[int64]context.CancelCauseFunc)
+	}
+	s.requests.byDocument[path][key] = cancel
+	s.requestsMu.Unlock()
+	return ctx, func() {
+		s.requestsMu.Lock()
+		delete(s.requests.byDocument[path], key)
+		if len(s.requests.byDocument[path]) == 0 {
+			delete(s.requests.byDocument, path)
+		}
+		s.requestsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Cancels the requests whose results are stale now that the document at path changed
+func (s *Server) cancelDocumentRequests(path util.Path) {
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	for _, cancel := range s.requests.byDocument[path] {
+		cancel(errContentModified)
+	}
+}
+
+// Error the response to a request whose context is done carries, or nil if it isn't
+func canceledRequestError(ctx context.Context) *transport.ResponseError {
+	if ctx.Err() == nil {
+		return nil
+	}
+	if errors.Is(context.Cause(ctx), errContentModified) {
+		return &transport.ResponseError{Code: int(transport.ContentModified), Message: errContentModified.Error()}
+	}
+	return &transport.ResponseError{Code: int(transport.RequestCancelled), Message: "request was canceled"}
+}
+
+// Cancels the requests being handled other than the one of ctx, like when shutting down
+func (s *Server) cancelRequests(ctx context.Context) {
+	own, _ := ctx.Value(requestIDKey{}).(string)
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	for key, cancel := range s.requests.byID {
+		if key != own {
+			cancel(context.Canceled)
+		}
+	}
+}
+
+// CancelRequest cancels the request of $/cancelRequest if it is still being handled
+func CancelRequest(ctx context.Context, s *Server, par json.RawMessage) error {
+	var params transport.CancelParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return err
+	}
+	s.requestsMu.Lock()
+	cancel, ok := s.requests.byID[requestKey(params.ID)]
+	s.requestsMu.Unlock()
+	if ok {
+		logging.Logger.Info("Canceling request", "id", params.ID)
+		cancel(context.Canceled)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command compiling a file to the source code of a backend
+const generateCodeCommand = "faustlsp.generateCode"
+
+// Extensions of the files generated by each backend passed to -lang
+var backendExtensions = map[string]string{
+	"c":       ".c",
+	"cpp":     ".cpp",
+	"cmajor":  ".cmajor",
+	"codebox": ".codebox",
+	"csharp":  ".cs",
+	"dlang":   ".d",
+	"java":    ".java",
+	"jax":     ".py",
+	"julia":   ".jl",
+	"llvm":    ".ll",
+	"rust":    ".rs",
+	"wasm":    ".wasm",
+	"wast":    ".wast",
+}
+
+// Argument of the faustlsp.generateCode command
+type GenerateCodeArgs struct {
+	URI transport.DocumentURI `json:"uri"`
+	// Backend given to -lang, like cpp, rust or wasm
+	Lang string `json:"lang"`
+}
+
+// Result of the faustlsp.generateCode command
+type GenerateCodeResult struct {
+	Output transport.DocumentURI `json:"output"`
+	Stdout string                `json:"stdout"`
+}
+
+func GenerateCodeCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", generateCodeCommand, len(arguments))
+	}
+	var args GenerateCodeArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	if _, ok := backendExtensions[args.Lang]; !ok {
+		return []byte("null"), fmt.Errorf("unknown backend %s, expected one of %s", args.Lang, strings.Join(backends(), ", "))
+	}
+	path, err := util.URI2path(string(args.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	if !IsDSPFile(path) {
+		return []byte("null"), fmt.Errorf("can only generate code of .dsp files: %s", path)
+	}
+	if _, ok := s.Files.GetFromPath(path); !ok {
+		return []byte("null"), fmt.Errorf("trying to generate code of non-existent path: %s", path)
+	}
+
+	w := s.workspaceFor(path)
+	output := CodeOutputPath(w.Root, w.ConfigFor(path).BuildDir, path, args.Lang)
+	logging.Logger.Info("Generating code", "path", path, "lang", args.Lang, "output", output)
+	compiledPath, _, config := w.compilation(path, w.ConfigFor(path))
+	stdout, err := generateCode(ctx, compiledPath, output, args.Lang, w.Root, config)
+	if err != nil {
+		logging.Logger.Error("Couldn't generate code", "path", path, "error", err)
+		s.showMessage(transport.Error, fmt.Sprintf("Couldn't generate %s code of %s: %s", args.Lang, filepath.Base(path), err))
+		return []byte("null"), nil
+	}
+	return json.Marshal(GenerateCodeResult{Output: transport.DocumentURI(util.Path2URI(output)), Stdout: stdout})
+}
+
+func backends() []string {
+	names := []string{}
+	for name := range backendExtensions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CodeOutputPath returns where the code generated for lang from path is written: next to path,
+// or at the same place relative to buildDir if the project has one. Relative build directories are relative to root.
+func CodeOutputPath(root util.Path, buildDir util.Path, path util.Path, lang string) util.Path {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + backendExtensions[lang]
+	if buildDir == "" {
+		return filepath.Join(filepath.Dir(path), name)
+	}
+	if !filepath.IsAbs(buildDir) {
+		buildDir = filepath.Join(root, buildDir)
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ""
+	}
+	return filepath.Join(buildDir, rel, name)
+}
+
+// Compiles path with the lang backend into output, returning what the compiler printed
+func generateCode(ctx context.Context, path util.Path, output util.Path, lang string, root util.Path, config FaustProjectConfig) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return "", err
+	}
+	args := append(compilerArgs(path, root, config), "-lang", lang, "-o", output)
+	var stdout, stderr bytes.Buffer
+	err := config.runCompiler(ctx, config.Command, args, filepath.Dir(path), root, func(cmd *exec.Cmd) error {
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		return cmd.Run()
+	})
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return stdout.String(), fmt.Errorf("%s", message)
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
+}
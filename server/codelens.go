@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Command compiling a file, publishing the compiler's diagnostics for it
+const compileFileCommand = "faustlsp.compileFile"
+
+// Data of reference count lenses, resolved with codeLens/resolve as counting references searches the whole workspace
+type referencesLensData struct {
+	URI      transport.DocumentURI `json:"uri"`
+	Position transport.Position    `json:"position"`
+}
+
+func CodeLens(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.CodeLensParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get code lenses of non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	processName := ""
+	if IsDSPFile(path) {
+		processName = s.workspaceFor(path).ConfigFor(path).processName()
+	}
+	return json.Marshal(CodeLenses(root, content, params.TextDocument.URI, processName))
+}
+
+// CodeLenses returns unresolved reference count lenses for each top-level definition of content,
+// and a lens compiling the file above the definition named processName. processName is empty for files without a process.
+func CodeLenses(root *tree_sitter.Node, content []byte, uri transport.DocumentURI, processName string) []transport.CodeLens {
+	lenses := []transport.CodeLens{}
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		name := definitionIdentifier(root.NamedChild(i))
+		if name == nil {
+			continue
+		}
+		r := ToRange(name)
+		if processName != "" && name.Utf8Text(content) == processName {
+			args, _ := json.Marshal(uri)
+			lenses = append(lenses, transport.CodeLens{
+				Range:   r,
+				Command: &transport.Command{Title: "Compile", Command: compileFileCommand, Arguments: []json.RawMessage{args}},
+			})
+		}
+		lenses = append(lenses, transport.CodeLens{
+			Range: r,
+			Data:  referencesLensData{URI: uri, Position: r.Start},
+		})
+	}
+	return lenses
+}
+
+func CodeLensResolve(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var lens transport.CodeLens
+	json.Unmarshal(par, &lens)
+
+	var data referencesLensData
+	raw, _ := json.Marshal(lens.Data)
+	if err := json.Unmarshal(raw, &data); err != nil || data.URI == "" {
+		return json.Marshal(lens)
+	}
+	result, err := s.findReferences(ctx, data.URI, data.Position, false, false)
+	if err != nil {
+		return []byte("null"), err
+	}
+	lens.Command = &transport.Command{Title: ReferenceCountTitle(len(result.References))}
+	return json.Marshal(lens)
+}
+
+// ReferenceCountTitle is the title of a lens counting n references
+func ReferenceCountTitle(n int) string {
+	if n == 1 {
+		return "1 reference"
+	}
+	return fmt.Sprintf("%d references", n)
+}
+
+func CompileCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	path, err := uriArgument(compileFileCommand, arguments)
+	if err != nil {
+		return []byte("null"), err
+	}
+
+	logging.Logger.Info("Compiling file", "path", path)
+	diagnostic, ok := s.workspaceFor(path).sendFileCompilerDiagnostics(s, path)
+	switch {
+	case !ok:
+		s.showMessage(transport.Warning, fmt.Sprintf("Couldn't compile %s: it is missing or has syntax errors", filepath.Base(path)))
+	case diagnostic.Code == compilerTimeoutCode:
+		// The user was already told about it
+	case diagnostic.Message != "":
+		s.showMessage(transport.Error, fmt.Sprintf("Compilation failed: %s", diagnostic.Message))
+	default:
+		s.showMessage(transport.Info, fmt.Sprintf("Compiled %s successfully", filepath.Base(path)))
+	}
+	return []byte("null"), nil
+}
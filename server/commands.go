@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Handlers of commands run with workspace/executeCommand, taking the command's arguments
+var commandHandlers = map[string]func(context.Context, *Server, []json.RawMessage) (json.RawMessage, error){
+	wrapInGroupCommand:           WrapInGroupCommand,
+	compileFileCommand:           CompileCommand,
+	generateSvgCommand:           GenerateSvgCommand,
+	showDependencyGraphCommand:   ShowDependencyGraphCommand,
+	restartIndexCommand:          RestartIndexCommand,
+	duplicateDefinitionCommand:   DuplicateDefinitionCommand,
+	renameCommand:                RenameCommand,
+	generateCodeCommand:          GenerateCodeCommand,
+	reportCommand:                ReportCommand,
+	buildCommand:                 BuildCommand,
+	exportDependencyGraphCommand: ExportDependencyGraphCommand,
+	addIncludeDirCommand:         AddIncludeDirCommand,
+	statusCommand:                StatusCommand,
+	selectProfileCommand:         SelectProfileCommand,
+	dspInfoCommand:               DSPInfoCommand,
+	openLogCommand:               OpenLogCommand,
+}
+
+// Names of the commands the server can execute
+func commandNames() []string {
+	names := []string{}
+	for name := range commandHandlers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func ExecuteCommand(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.ExecuteCommandParams
+	json.Unmarshal(par, &params)
+
+	logging.Logger.Info("Execute command request", "command", params.Command, "arguments", params.Arguments)
+	handler, ok := commandHandlers[params.Command]
+	if !ok {
+		return []byte("null"), fmt.Errorf("unknown command: %s", params.Command)
+	}
+	return handler(ctx, s, params.Arguments)
+}
+
+// Counter for IDs of workspace/applyEdit requests, which are strings so that they never collide with client request IDs
+var applyEditCounter atomic.Int64
+
+// Asks the client to apply edit. The client's response isn't waited for.
+func (s *Server) applyEdit(label string, edit transport.WorkspaceEdit) error {
+	params, err := json.Marshal(transport.ApplyWorkspaceEditParams{Label: label, Edit: edit})
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("faustlsp-apply-edit-%d", applyEditCounter.Add(1))
+	return s.Transport.WriteRequest(id, "workspace/applyEdit", params)
+}
+
+// Asks the client to apply edit and waits for it to answer whether it did
+func (s *Server) applyEditAndWait(ctx context.Context, label string, edit transport.WorkspaceEdit) (transport.ApplyWorkspaceEditResult, error) {
+	params, err := json.Marshal(transport.ApplyWorkspaceEditParams{Label: label, Edit: edit})
+	if err != nil {
+		return transport.ApplyWorkspaceEditResult{}, err
+	}
+	id := fmt.Sprintf("faustlsp-apply-edit-%d", applyEditCounter.Add(1))
+	response, err := s.sendRequest(ctx, id, "workspace/applyEdit", params)
+	if err != nil {
+		return transport.ApplyWorkspaceEditResult{}, err
+	}
+	var result transport.ApplyWorkspaceEditResult
+	err = json.Unmarshal(response.Result, &result)
+	return result, err
+}
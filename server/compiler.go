@@ -1,13 +1,19 @@
 package server
 
 import (
+	"context"
+	"maps"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 )
 
 type FaustError struct {
@@ -45,20 +51,52 @@ func getFaustErrorReportingType(s string) FaustErrorReportingType {
 	return FileError
 }
 
+// Makes cmd run in dir, or in the project's working directory relative to root if it has one, with the project's environment variables
+func (config FaustProjectConfig) setupCommand(cmd *exec.Cmd, dir util.Path, root util.Path) {
+	cmd.Dir = dir
+	if config.WorkingDir != "" {
+		cmd.Dir = config.WorkingDir
+		if !filepath.IsAbs(cmd.Dir) {
+			cmd.Dir = filepath.Join(root, cmd.Dir)
+		}
+	}
+	if len(config.Env) > 0 {
+		cmd.Env = os.Environ()
+		for _, name := range slices.Sorted(maps.Keys(config.Env)) {
+			cmd.Env = append(cmd.Env, name+"="+config.Env[name])
+		}
+	}
+}
+
 // TODO: When handling initialize, send diagnostics capability based on whether PATH has faust or some other compiler path provided by project configuration
-func getCompilerDiagnostics(path string, dirPath string, cfg FaustProjectConfig) transport.Diagnostic {
-	cmd := exec.Command(cfg.Command, path, "-pn", cfg.ProcessName)
-	if dirPath != "" {
-		cmd.Dir = dirPath
+// Compiling stops once ctx is done, with no diagnostic
+func getCompilerDiagnostics(ctx context.Context, path string, dirPath string, cfg FaustProjectConfig) transport.Diagnostic {
+	args := []string{path, "-pn", cfg.ProcessName, precisionFlags[cfg.EffectivePrecision()]}
+	for _, include := range cfg.IncludeDir {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dirPath, include)
+		}
+		args = append(args, "-I", include)
 	}
+	args = append(args, cfg.CompilerArgs...)
 	var errors strings.Builder
-	cmd.Stderr = &errors
-	err := cmd.Run()
+	err := cfg.runCompiler(ctx, cfg.Command, args, dirPath, dirPath, func(cmd *exec.Cmd) error {
+		cmd.Stderr = &errors
+		return cmd.Run()
+	})
 	faustErrors := errors.String()
 	logging.Logger.Info("Return code of faust compiler", "error", err)
-	if err == nil {
+	if err == nil || ctx.Err() != nil {
 		return transport.Diagnostic{}
 	}
+	if timeout, ok := err.(*CompilerTimeoutError); ok {
+		return transport.Diagnostic{
+			Message:  timeout.Error(),
+			Severity: transport.DiagnosticSeverity(transport.Warning),
+			Code:     compilerTimeoutCode,
+			Source:   "faustlsp",
+		}
+	}
 
 	errorType := getFaustErrorReportingType(faustErrors)
 	logging.Logger.Info("Got error from compiler", "path", path, "type", errorType, "output", faustErrors)
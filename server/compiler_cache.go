@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Last compiler diagnostic of a file, with the key of what it was compiled from
+type compilerCacheEntry struct {
+	key        [sha256.Size]byte
+	diagnostic transport.Diagnostic
+}
+
+// Paths imported by a file, for the content they were found in
+type importsEntry struct {
+	hash    [sha256.Size]byte
+	imports []string
+}
+
+// Key of compiling path with config, hashing the config, the compiler's version and the contents of path and of the files it imports transitively.
+// Imported files that aren't in the store are only hashed with their modification time and size.
+func (w *Workspace) compilationKey(s *Server, path util.Path, config FaustProjectConfig) [sha256.Size]byte {
+	h := sha256.New()
+	encoded, _ := json.Marshal(config)
+	h.Write(encoded)
+	fmt.Fprintf(h, "\x00%s\x00", s.probeCompiler(config.Command).Version)
+
+	files, missing := w.importClosure(s, path)
+	for _, file := range files {
+		if f, ok := s.Files.GetFromPath(file); ok {
+			f.mu.RLock()
+			fmt.Fprintf(h, "%s %x\x00", file, f.Hash)
+			f.mu.RUnlock()
+		} else if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(h, "%s %d %d\x00", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	// Files that can't be found yet may be created
+	for _, imported := range missing {
+		fmt.Fprintf(h, "missing %s\x00", imported)
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// Returns path and the files it imports transitively, in the order they are found, and the imported paths that can't be found.
+// Imports of files that aren't in the store aren't followed.
+func (w *Workspace) importClosure(s *Server, path util.Path) ([]util.Path, []string) {
+	files := []util.Path{path}
+	missing := []string{}
+	for i := 0; i < len(files); i++ {
+		for _, imported := range w.importsOf(s, files[i]) {
+			resolvedPath, _ := w.ResolveFilePath(imported, w.ImportRoot(path))
+			switch {
+			case resolvedPath == "":
+				missing = append(missing, imported)
+			case !slices.Contains(files, resolvedPath):
+				files = append(files, resolvedPath)
+			}
+		}
+	}
+	return files, missing
+}
+
+// Paths imported by the file at path, parsed again only when its content changed
+func (w *Workspace) importsOf(s *Server, path util.Path) []string {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return nil
+	}
+	f.mu.RLock()
+	contentHash := f.Hash
+	f.mu.RUnlock()
+
+	w.mu.Lock()
+	entry, ok := w.importsCache[path]
+	w.mu.Unlock()
+	if !ok || entry.hash != contentHash {
+		entry = importsEntry{hash: contentHash}
+		tree, content := f.Tree()
+		for _, imported := range ImportedFiles(tree.RootNode(), content) {
+			entry.imports = append(entry.imports, imported.Path)
+		}
+		tree.Close()
+		w.mu.Lock()
+		if w.importsCache == nil {
+			w.importsCache = make(map[util.Path]importsEntry)
+		}
+		w.importsCache[path] = entry
+		w.mu.Unlock()
+	}
+	return entry.imports
+}
+
+// Compiler diagnostic of path if it was last compiled from key
+func (w *Workspace) cachedCompilation(path util.Path, key [sha256.Size]byte) (transport.Diagnostic, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.compilerCache[path]
+	if !ok || entry.key != key {
+		return transport.Diagnostic{}, false
+	}
+	return entry.diagnostic, true
+}
+
+func (w *Workspace) cacheCompilation(path util.Path, key [sha256.Size]byte, diagnostic transport.Diagnostic) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.compilerCache == nil {
+		w.compilerCache = make(map[util.Path]compilerCacheEntry)
+	}
+	w.compilerCache[path] = compilerCacheEntry{key: key, diagnostic: diagnostic}
+}
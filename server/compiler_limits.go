@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/carn181/faustlsp/util"
+)
+
+// Seconds a compiler process has to finish by default before it is killed
+const defaultCompilerTimeout = 30
+
+// Time given to a killed compiler to close its output before the server stops waiting for it
+const compilerWaitDelay = time.Second
+
+// Code of diagnostics telling that the compiler was killed for taking too long
+const compilerTimeoutCode = "compiler-timeout"
+
+// Compiler processes that can run at once, others waiting for one of them to finish
+var compilerSlots = make(chan struct{}, runtime.NumCPU())
+
+// CompilerTimeoutError is returned when the compiler was killed for running longer than its timeout
+type CompilerTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *CompilerTimeoutError) Error() string {
+	return fmt.Sprintf("the compiler was stopped after running for %s, the program may recurse without end. Raise \"compiler_timeout\" in %s if it needs more time", e.Timeout, faustConfigFile)
+}
+
+// Time the compiler has to finish, 0 for no limit
+func (c FaustProjectConfig) compilerTimeout() time.Duration {
+	return time.Duration(c.CompilerTimeout) * time.Second
+}
+
+// Runs name with args from dir once a compiler slot is free, like setupCommand for the project rooted at root, and kills it after the config's timeout.
+// run runs the command, capturing its output as needed. A command killed for taking too long returns a CompilerTimeoutError.
+func (c FaustProjectConfig) runCompiler(ctx context.Context, name string, args []string, dir util.Path, root util.Path, run func(*exec.Cmd) error) error {
+	select {
+	case compilerSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-compilerSlots }()
+	metrics.compilerRuns.Add(1)
+
+	timeout := c.compilerTimeout()
+	processCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		processCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(processCtx, name, args...)
+	cmd.WaitDelay = compilerWaitDelay
+	c.setupCommand(cmd, dir, root)
+	err := run(cmd)
+	// Deadlines of the caller, like the one of hovers, are theirs to report
+	if err != nil && ctx.Err() == nil && errors.Is(processCtx.Err(), context.DeadlineExceeded) {
+		return &CompilerTimeoutError{Timeout: timeout}
+	}
+	return err
+}
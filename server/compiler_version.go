@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Oldest compiler whose options and error messages the server handles, older ones get a warning
+const minimumCompilerVersion = "2.40.0"
+
+// Time the compiler has to print its version and library directory
+const compilerProbeTimeout = 5 * time.Second
+
+// Version in the output of faust --version, like "FAUST Version 2.75.7"
+var compilerVersionRegex = regexp.MustCompile(`(?i)version\s+(\d+(?:\.\d+)*)`)
+
+// CompilerInfo is what the server found out about the compiler of the config by running it
+type CompilerInfo struct {
+	Command    string    `json:"command"`
+	Path       util.Path `json:"path"`       // Empty if the command isn't in PATH
+	Version    string    `json:"version"`    // Empty if the compiler couldn't tell it
+	LibraryDir util.Path `json:"libraryDir"` // Directory faust -dspdir prints
+}
+
+// Compiler found on startup and again when the config's command changes, and the command the user was warned about
+type compilerProbe struct {
+	mu     sync.Mutex
+	probed bool
+	info   CompilerInfo
+	warned string
+}
+
+// ProbeCompiler runs command to find its version and library directory
+func ProbeCompiler(command string) CompilerInfo {
+	info := CompilerInfo{Command: command}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return info
+	}
+	info.Path = path
+	run := func(arg string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), compilerProbeTimeout)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, path, arg).Output()
+		if err != nil {
+			logging.Logger.Error("Couldn't run the compiler", "command", command, "arg", arg, "error", err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	info.Version, _ = ParseCompilerVersion(run("--version"))
+	info.LibraryDir = run("-dspdir")
+	return info
+}
+
+// ParseCompilerVersion returns the version in the output of faust --version
+func ParseCompilerVersion(output string) (string, bool) {
+	captures := compilerVersionRegex.FindStringSubmatch(output)
+	if captures == nil {
+		return "", false
+	}
+	return captures[1], true
+}
+
+// CompareVersions compares dotted versions number by number, missing numbers being 0
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// Problem returns why the compiler can't be relied on, or an empty string if it can
+func (info CompilerInfo) Problem() string {
+	switch {
+	case info.Path == "":
+		return fmt.Sprintf("The Faust compiler %s wasn't found in PATH, so diagnostics, code generation and diagrams that need it are turned off. Set \"command\" in %s to its path", info.Command, faustConfigFile)
+	case info.Version != "" && CompareVersions(info.Version, minimumCompilerVersion) < 0:
+		return fmt.Sprintf("The Faust compiler %s is version %s, older than %s. Its errors may not be shown correctly", info.Command, info.Version, minimumCompilerVersion)
+	}
+	return ""
+}
+
+// Finds out about the compiler command unless it already did
+func (s *Server) probeCompiler(command string) CompilerInfo {
+	s.compiler.mu.Lock()
+	if s.compiler.probed && s.compiler.info.Command == command {
+		info := s.compiler.info
+		s.compiler.mu.Unlock()
+		return info
+	}
+	s.compiler.mu.Unlock()
+
+	info := ProbeCompiler(command)
+	logging.Logger.Info("Found compiler", "info", info)
+	s.compiler.mu.Lock()
+	s.compiler.probed = true
+	s.compiler.info = info
+	s.compiler.mu.Unlock()
+	return info
+}
+
+// Finds out about the compiler command, warning the user once if it is missing or too old rather than letting the features needing it fail silently
+func (s *Server) checkCompiler(command string) {
+	info := s.probeCompiler(command)
+	problem := info.Problem()
+	s.compiler.mu.Lock()
+	warn := problem != "" && s.compiler.warned != command
+	if warn {
+		s.compiler.warned = command
+	}
+	s.compiler.mu.Unlock()
+	if warn {
+		s.reportProblem(transport.Warning, problem, nil, "command", command)
+	}
+}
+
+// FaustStatus answers faustlsp/status requests with the result of the faustlsp.status command
+func FaustStatus(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	return StatusCommand(ctx, s, nil)
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"regexp"
+	"strings"
 	"unicode"
 
 	"github.com/carn181/faustlsp/logging"
@@ -10,6 +12,17 @@ import (
 	"github.com/carn181/faustlsp/util"
 )
 
+// Ranks of completion items, given as their sort text before sorting, so that the closest definitions come first
+const (
+	localRank  = "0" // with and letrec definitions and arguments in scope
+	fileRank   = "1" // Top-level definitions of the file
+	importRank = "2" // Definitions of imported workspace files, and workspace snippets
+	globalRank = "3" // Standard library and other files outside the workspace, and primitives
+)
+
+// Accesses of libraries used in expressions, like library("filters.lib").
+var libraryAccessPattern = regexp.MustCompile(`library\s*\(\s*"([^"]*)"\s*\)\s*\.$`)
+
 func Completion(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
 	logging.Logger.Info("Got Completion Request", "request", string(par))
 
@@ -23,43 +36,194 @@ func Completion(ctx context.Context, s *Server, par json.RawMessage) (json.RawMe
 	results := GetPossibleSymbols(params.Position, handle.Path, &s.Store, string(s.Files.encoding))
 
 	replaceRange := transport.Range{}
+	// Snippets don't complete library accesses like os.osc
+	afterAccess := false
+	library, accessesLibrary := "", false
 	f, ok := s.Files.Get(handle)
 	if ok {
 		f.mu.RLock()
+		// Paths of imports are completed with files rather than symbols
+		if offset, err := positionToOffset(f.lines, params.Position, f.Content, string(s.Files.encoding)); err == nil {
+			if typed, ok := ImportPathAt(f.Content, offset); ok {
+				typedName := typed[strings.LastIndexByte(typed, '/')+1:]
+				start, _ := offsetToPosition(f.lines, offset-uint(len(typedName)), f.Content, string(s.Files.encoding))
+				f.mu.RUnlock()
+				dirs := s.workspaceFor(handle.Path).importCompletionDirs(handle.Path)
+				return json.Marshal(ImportPathCompletionItems(typed, handle.Path, dirs, transport.Range{Start: start, End: params.Position}))
+			}
+		}
 		replaceRange = FindCompletionReplaceRange(params.Position, string(f.Content), string(s.Files.encoding))
 		logging.Logger.Info("Replace Range", "range", replaceRange)
+		if start, err := positionToOffset(f.lines, replaceRange.Start, f.Content, string(s.Files.encoding)); err == nil && start > 0 {
+			afterAccess = f.Content[start-1] == '.'
+			library, accessesLibrary = LibraryAccessAt(f.Content, start)
+		}
 		f.mu.RUnlock()
 	}
-	var items = []transport.CompletionItem{}
+	// Quotes and slashes only trigger completion for paths of imports
+	if trigger := params.Context.TriggerCharacter; trigger == `"` || trigger == "/" {
+		return []byte("[]"), nil
+	}
+	if accessesLibrary {
+		results = s.librarySymbols(handle.Path, library)
+	}
+	items := SymbolCompletionItems(results, handle.Path, replaceRange, s.isExternalFile)
+	if !afterAccess {
+		items = append(items, rankCompletionItems(UIPrimitiveCompletionItems(replaceRange, s.snippetSupport), globalRank)...)
+		version := s.probeCompiler(s.workspaceFor(handle.Path).ConfigFor(handle.Path).Command).Version
+		items = append(items, rankCompletionItems(PrimitiveCompletionItems(replaceRange, s.snippetSupport, version), globalRank)...)
+		// Clients without snippet support would insert the tab stops of snippets as is
+		if s.snippetSupport {
+			items = append(items, rankCompletionItems(SnippetCompletionItems(s.workspaceFor(handle.Path).snippetsFor(handle.Path), replaceRange), importRank)...)
+			items = append(items, rankCompletionItems(SnippetCompletionItems(builtinSnippets, replaceRange), globalRank)...)
+		}
+	}
+
+	items = SortCompletionItems(items)
+	logging.Logger.Info("Completion results", "results", items)
+
+	resp, err := json.Marshal(items)
+	if err != nil {
+		return []byte("null"), err
+	}
+	return resp, nil
+}
+
+// LibraryAccessAt returns the path of the library whose definitions are accessed by the identifier starting at offset, like filters.lib in library("filters.lib").lowpass
+func LibraryAccessAt(content []byte, offset uint) (string, bool) {
+	match := libraryAccessPattern.FindSubmatch(content[:offset])
+	if match == nil {
+		return "", false
+	}
+	return string(match[1]), true
+}
+
+// Definitions of the library imported as library in the file at path, once it has been parsed
+func (s *Server) librarySymbols(path util.Path, library string) []CompletionSym {
+	w := s.workspaceFor(path)
+	resolvedPath, _ := w.ResolveFilePath(library, w.ImportRoot(path))
+	f, ok := s.Files.GetFromPath(resolvedPath)
+	if resolvedPath == "" || !ok {
+		return []CompletionSym{}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.Scope == nil {
+		return []CompletionSym{}
+	}
+	return FindSymbolsNew(f.Scope, "", &s.Store, map[util.Path]struct{}{})
+}
+
+// SymbolCompletionItems completes symbols in the file at path by replacing r, ranking them by where they are defined.
+// external tells whether a file is outside the workspace.
+func SymbolCompletionItems(symbols []CompletionSym, path util.Path, r transport.Range, external func(util.Path) bool) []transport.CompletionItem {
+	items := []transport.CompletionItem{}
 	plainText := transport.PlainTextTextFormat
-	for _, sym := range results {
+	for _, sym := range symbols {
 		items = append(items, transport.CompletionItem{
-			Label: sym.name,
-			Kind:  transport.VariableCompletion,
+			Label:    sym.name,
+			Kind:     transport.VariableCompletion,
+			SortText: completionRank(sym, path, external),
 			//			InsertText: sym.name,
 			InsertTextFormat: &plainText,
 			TextEdit: transport.TextEdit{
 				NewText: sym.name,
-				Range:   replaceRange,
+				Range:   r,
 			},
-
-			// Documentation: &transport.Or_CompletionItem_documentation{
-			//	Value: transport.MarkupContent{
-			//		Kind:  "plaintext",
-			//		Value: sym.docs.Full,
-			//	},
-			// },
-			// Detail: sym.docs.Usage,
 		})
+		// Documentation is only looked up once an item is selected, by completionItem/resolve
+		if sym.file != "" {
+			items[len(items)-1].Data = completionData{URI: transport.DocumentURI(util.Path2URI(sym.file)), Position: sym.position}
+		}
 	}
+	return items
+}
 
-	logging.Logger.Info("Completion results", "results", items)
+// Data of the completion item of a symbol, locating its definition
+type completionData struct {
+	ID       string                `json:"id"`
+	URI      transport.DocumentURI `json:"uri"`
+	Position transport.Position    `json:"position"`
+}
 
-	resp, err := json.Marshal(items)
-	if err != nil {
+// CompletionResolve attaches the documentation, usage and definition preview of the symbol of a selected completion item
+func CompletionResolve(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var item transport.CompletionItem
+	if err := json.Unmarshal(par, &item); err != nil {
 		return []byte("null"), err
 	}
-	return resp, nil
+	var data completionData
+	raw, _ := json.Marshal(item.Data)
+	if err := json.Unmarshal(raw, &data); err != nil || data.URI == "" {
+		return json.Marshal(item)
+	}
+	path, err := util.URI2path(string(data.URI))
+	if err != nil {
+		return json.Marshal(item)
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		logging.Logger.Info("Completion item defined in non-existent path", "path", path)
+		return json.Marshal(item)
+	}
+	tree, content := f.Tree()
+	f.mu.RLock()
+	lines, fileScope := f.lines, f.Scope
+	f.mu.RUnlock()
+	offset, err := positionToOffset(lines, data.Position, content, string(s.Files.encoding))
+	if err != nil {
+		tree.Close()
+		return json.Marshal(item)
+	}
+
+	ident, scope := FindSymbolScope(tree.RootNode(), content, fileScope, offset)
+	tree.Close()
+	if ident == "" {
+		return json.Marshal(item)
+	}
+	sym, err := ResolveSymbol(ident, scope, &s.Store)
+	if err != nil {
+		logging.Logger.Info("Couldn't resolve completion item", "label", item.Label, "error", err)
+		return json.Marshal(item)
+	}
+	item.Detail = strings.TrimSpace(sym.Docs.Usage)
+	if docs := s.symbolDocumentation(sym); docs != "" {
+		item.Documentation = &transport.Or_CompletionItem_documentation{
+			Value: transport.MarkupContent{Kind: transport.Markdown, Value: docs},
+		}
+	}
+	return json.Marshal(item)
+}
+
+// Markdown documenting sym in hovers and completion items: its doc comment, precision variants and definition preview
+func (s *Server) symbolDocumentation(sym Symbol) string {
+	docs := sym.Docs.Full
+	if note := precisionNote(sym.Variants, s.Store.Precision); note != "" {
+		docs = strings.TrimSpace(docs + "\n\n" + note)
+	}
+	if preview, ok := s.definitionHover(sym); ok {
+		docs = strings.TrimSpace(docs + "\n\n" + preview)
+	}
+	return docs
+}
+
+func completionRank(sym CompletionSym, path util.Path, external func(util.Path) bool) string {
+	switch {
+	case sym.local:
+		return localRank
+	case sym.file == path:
+		return fileRank
+	case sym.file != "" && !external(sym.file):
+		return importRank
+	}
+	return globalRank
+}
+
+func rankCompletionItems(items []transport.CompletionItem, rank string) []transport.CompletionItem {
+	for i := range items {
+		items[i].SortText = rank
+	}
+	return items
 }
 
 func FindCompletionReplaceRange(pos transport.Position, content, encoding string) transport.Range {
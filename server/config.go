@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"path/filepath"
+	"slices"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
@@ -10,56 +12,149 @@ import (
 )
 
 type FaustProjectConfig struct {
-	Command             string      `json:"command,omitempty"`
-	Type                string      `json:"type"` // Actually make this enum between Process or Library eventually
-	ProcessName         string      `json:"process_name,omitempty"`
-	ProcessFiles        []util.Path `json:"process_files,omitempty"`
-	IncludeDir          []util.Path `json:"include,omitempty"`
-	CompilerDiagnostics bool        `json:"compiler_diagnostics,omitempty"`
+	Command             string             `json:"command,omitempty"`
+	Type                string             `json:"type"` // Actually make this enum between Process or Library eventually
+	ProcessName         string             `json:"process_name,omitempty"`
+	ProcessFiles        []util.Path        `json:"process_files,omitempty"`
+	IncludeDir          []util.Path        `json:"include,omitempty"`
+	CompilerDiagnostics bool               `json:"compiler_diagnostics,omitempty"`
+	Formatter           string             `json:"formatter,omitempty"`           // builtin or faustfmt
+	Precision           string             `json:"precision,omitempty"`           // single, double, quad or fixedpoint
+	TextualReferences   bool               `json:"textual_references,omitempty"`  // Also find references in comments, documentation and UI labels
+	UnusedDiagnostics   bool               `json:"unused_diagnostics,omitempty"`  // Report top-level definitions never used in the workspace
+	ShadowDiagnostics   bool               `json:"shadow_diagnostics,omitempty"`  // Warn about with block definitions shadowing outer names
+	Strict              StrictConfig       `json:"strict"`                        // Opt-in declare, naming, length and nesting rules
+	BuildDir            util.Path          `json:"build_dir,omitempty"`           // Where faustlsp.generateCode writes code, next to the sources if empty
+	BuildTargets        []BuildTarget      `json:"build_targets,omitempty"`       // faust2 scripts that faustlsp.build can run on process files
+	PreviewDepth        int                `json:"preview_depth"`                 // with and letrec blocks shown nested in definition previews before their bodies are elided, -1 for no limit
+	InferInclude        bool               `json:"infer_include,omitempty"`       // Also include the workspace directories of imported files that can't be found otherwise
+	LibraryDir          util.Path          `json:"library_dir,omitempty"`         // Directory of the Faust libraries, found with the compiler or in common install directories if empty
+	Exclude             []string           `json:"exclude,omitempty"`             // .gitignore patterns of workspace files to leave out, along with the .gitignore file's
+	CompilerArgs        []string           `json:"compiler_args,omitempty"`       // Passed as they are to faust and the build tools, after the options the server gives
+	Env                 map[string]string  `json:"env,omitempty"`                 // Environment variables added to the server's for the compiler and build tools
+	WorkingDir          util.Path          `json:"working_dir,omitempty"`         // Directory the compiler and build tools run in, relative to the workspace root, the compiled file's if empty
+	Profiles            map[string]Profile `json:"profiles,omitempty"`            // Variants of the command, compiler arguments and process files, one of which can be active
+	LibraryDiagnostics  bool               `json:"library_diagnostics,omitempty"` // Also compile .lib files through a wrapper referencing their definitions
+	CompilerTimeout     int                `json:"compiler_timeout"`              // Seconds the compiler has to finish before it is killed, 0 for no limit
+	InlayHints          InlayHintsConfig   `json:"inlay_hints"`                   // Kinds of inlay hints shown
+	Snippets            SnippetDefinitions `json:"snippets,omitempty"`            // Snippets completed in the project's files, in the format of snippets files
 }
 
+const (
+	BuiltinFormatter  = "builtin"
+	FaustfmtFormatter = "faustfmt"
+)
+
 func (w *Workspace) Rel2Abs(relPath string) util.Path {
 	return filepath.Join(w.Root, relPath)
 }
 
-func (w *Workspace) cleanDiagnostics(s *Server) {
-	for _, path := range w.Files {
-		f, _ := s.Files.GetFromPath(path)
-		f.mu.RLock()
-		path := f.Handle.Path
-		f.mu.RUnlock()
-		if IsFaustFile(path) {
-			w.DiagnoseFile(path, s)
+// Reloads the project config after its file changed, re-diagnosing the workspace with it.
+// If the file was deleted, the default config is used and the user is told about it.
+func (w *Workspace) reloadConfig(s *Server, deleted bool) {
+	if deleted {
+		if !w.hasConfigFile {
+			return
 		}
+		s.Files.RemoveFromPath(filepath.Join(w.Root, faustConfigFile))
+	}
+	w.loadConfigFiles(s)
+	// A new library directory is indexed too
+	go w.indexLibraries(s)
+	if deleted && !w.hasConfigFile {
+		s.showMessage(transport.Info, fmt.Sprintf("%s was deleted, using the default configuration", faustConfigFile))
 	}
+	w.cleanDiagnostics(s)
 }
 
 func (w *Workspace) sendCompilerDiagnostics(s *Server) {
-	for _, filePath := range w.Config.ProcessFiles {
-		path := filepath.Join(w.Root, filePath)
-		f, ok := s.Files.GetFromPath(path)
+	for _, path := range w.ProcessFiles() {
+		if w.ConfigFor(path).CompilerDiagnostics {
+			w.sendFileCompilerDiagnostics(s, path)
+		}
+	}
+	for _, path := range w.checkedLibraryFiles() {
+		w.sendFileCompilerDiagnostics(s, path)
+	}
+}
+
+// Compiles only the process files and checked libraries that are changed or import it transitively
+func (w *Workspace) sendAffectedCompilerDiagnostics(s *Server, changed util.Path) {
+	affected := func(path util.Path) bool {
+		files, _ := w.importClosure(s, path)
+		return slices.Contains(files, changed)
+	}
+	for _, path := range w.ProcessFiles() {
+		if w.ConfigFor(path).CompilerDiagnostics && affected(path) {
+			w.sendFileCompilerDiagnostics(s, path)
+		}
+	}
+	for _, path := range w.checkedLibraryFiles() {
+		if affected(path) {
+			w.sendFileCompilerDiagnostics(s, path)
+		}
+	}
+}
+
+// Compiles the file at path and publishes the compiler's error along with analysis warnings.
+// Returns the compiler's error, which has an empty message on success, or false if the file wasn't compiled because it is missing or has syntax errors.
+func (w *Workspace) sendFileCompilerDiagnostics(s *Server, path util.Path) (transport.Diagnostic, bool) {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return transport.Diagnostic{}, false
+	}
+	f.mu.RLock()
+	hasSyntaxErrors, version := f.hasSyntaxErrors, f.Version
+	f.mu.RUnlock()
+	if hasSyntaxErrors {
+		return transport.Diagnostic{}, false
+	}
 
-		if ok {
-			f.mu.RLock()
-			tempPath := w.TempDirPath(f.Handle.Path)
-			logging.Logger.Info("Generating Compiler Diagnostics", "temp_path", tempPath)
-			f.mu.RUnlock()
-			if !f.hasSyntaxErrors {
-				var diagnosticErrors = []transport.Diagnostic{}
-				uri := util.Path2URI(path)
-				logging.Logger.Info("Generating Compiler Diagnostics", "temp_path", tempPath)
-				diagnosticError := getCompilerDiagnostics(tempPath, w.Root, w.Config)
-				if diagnosticError.Message != "" {
-					diagnosticErrors = []transport.Diagnostic{diagnosticError}
-				}
-				d := transport.PublishDiagnosticsParams{
-					URI:         transport.DocumentURI(uri),
-					Diagnostics: diagnosticErrors,
-				}
-				s.diagChan <- d
+	var diagnosticErrors = []transport.Diagnostic{}
+	uri := util.Path2URI(path)
+	config := w.ConfigFor(path)
+	// The compiler isn't run again if neither the file, its imports nor the config changed
+	key := w.compilationKey(s, path, config)
+	diagnosticError, cached := w.cachedCompilation(path, key)
+	if cached {
+		metrics.compilerCacheHits.Add(1)
+	} else {
+		metrics.compilerCacheMisses.Add(1)
+		compiledPath, dir, config := w.compilation(path, config)
+		// Libraries have no process of their own
+		if IsLibFile(path) {
+			var err error
+			if compiledPath, dir, config, err = w.libraryCompilation(s, path, compiledPath, dir, config); err != nil {
+				logging.Logger.Error("Couldn't write library wrapper", "path", path, "error", err)
+				return transport.Diagnostic{}, false
 			}
 		}
+		logging.Logger.Info("Generating Compiler Diagnostics", "path", compiledPath)
+		ctx := s.sessionContext()
+		diagnosticError = getCompilerDiagnostics(ctx, compiledPath, dir, config)
+		// Compiling was stopped by shutting down, which tells nothing about the file
+		if ctx.Err() != nil {
+			return transport.Diagnostic{}, false
+		}
+		w.cacheCompilation(path, key, diagnosticError)
+		// The diagnostic alone could be taken for an error of the file
+		if diagnosticError.Code == compilerTimeoutCode {
+			s.showMessage(transport.Warning, fmt.Sprintf("Compiling %s: %s", filepath.Base(path), diagnosticError.Message))
+		}
+	}
+	if diagnosticError.Message != "" {
+		diagnosticErrors = []transport.Diagnostic{diagnosticError}
+		w.suggestIncludeDir(s, diagnosticError)
+	}
+	// Keep analysis warnings published along with syntax diagnostics
+	diagnosticErrors = append(diagnosticErrors, w.analysisDiagnostics(path, s)...)
+	d := transport.PublishDiagnosticsParams{
+		URI:         transport.DocumentURI(uri),
+		Version:     version,
+		Diagnostics: diagnosticErrors,
 	}
+	s.diagChan <- d
+	return diagnosticError, true
 }
 
 func (c *FaustProjectConfig) UnmarshalJSON(content []byte) error {
@@ -68,6 +163,13 @@ func (c *FaustProjectConfig) UnmarshalJSON(content []byte) error {
 		Command:             "faust",
 		ProcessName:         "process",
 		CompilerDiagnostics: true,
+		Formatter:           BuiltinFormatter,
+		Precision:           SinglePrecision,
+		UnusedDiagnostics:   true,
+		ShadowDiagnostics:   true,
+		PreviewDepth:        defaultPreviewDepth,
+		CompilerTimeout:     defaultCompilerTimeout,
+		InlayHints:          defaultInlayHintsConfig(),
 	}
 	if err := json.Unmarshal(content, &cfg); err != nil {
 		logging.Logger.Error("Failed to unmarshal FaustProjectConfig", "error", err)
@@ -98,10 +200,25 @@ func (w *Workspace) defaultConfig() FaustProjectConfig {
 		Type:                "process",
 		ProcessFiles:        w.getFaustDSPRelativePaths(),
 		CompilerDiagnostics: true,
+		Formatter:           BuiltinFormatter,
+		Precision:           SinglePrecision,
+		UnusedDiagnostics:   true,
+		ShadowDiagnostics:   true,
+		PreviewDepth:        defaultPreviewDepth,
+		CompilerTimeout:     defaultCompilerTimeout,
+		InlayHints:          defaultInlayHintsConfig(),
 	}
 	return config
 }
 
+// Name of the definition compiled as the process, never reported as unused
+func (c FaustProjectConfig) processName() string {
+	if c.ProcessName == "" {
+		return "process"
+	}
+	return c.ProcessName
+}
+
 func (w *Workspace) getFaustDSPRelativePaths() []util.Path {
 	var filePaths = []util.Path{}
 	for _, file := range w.Files {
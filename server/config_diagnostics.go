@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// A top-level key of a JSON object, with the byte ranges of it and of the strings it has as value, alone or in an array
+type jsonKey struct {
+	Name       string
+	Start, End uint
+	Strings    []jsonString
+}
+
+type jsonString struct {
+	Value      string
+	Start, End uint
+}
+
+// Keys of the top-level object of content, which must be valid JSON
+func jsonKeys(content []byte) []jsonKey {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	// Tokens start after the whitespace and separators following the previous one
+	start := func() uint {
+		offset := uint(dec.InputOffset())
+		for offset < uint(len(content)) && strings.IndexByte(" \t\r\n,:", content[offset]) >= 0 {
+			offset++
+		}
+		return offset
+	}
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	keys := []jsonKey{}
+	for dec.More() {
+		keyStart := start()
+		token, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		name, _ := token.(string)
+		key := jsonKey{Name: name, Start: keyStart, End: uint(dec.InputOffset())}
+
+		// Nested objects and arrays of the value are skipped, only keeping the strings of an array
+		open := []json.Delim{}
+		for {
+			valueStart := start()
+			token, err := dec.Token()
+			if err != nil {
+				return append(keys, key)
+			}
+			switch token := token.(type) {
+			case json.Delim:
+				if token == '{' || token == '[' {
+					open = append(open, token)
+				} else {
+					open = open[:len(open)-1]
+				}
+			case string:
+				if len(open) == 0 || (len(open) == 1 && open[0] == '[') {
+					key.Strings = append(key.Strings, jsonString{Value: token, Start: valueStart, End: uint(dec.InputOffset())})
+				}
+			}
+			if len(open) == 0 {
+				break
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Keys of .faustcfg.json, from the fields of the config
+func configFileKeys() map[string]struct{} {
+	keys := make(map[string]struct{})
+	t := reflect.TypeOf(FaustProjectConfig{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		keys[name] = struct{}{}
+	}
+	return keys
+}
+
+// ConfigDiagnostics returns the problems of content, a config file of the workspace at root: JSON syntax errors and values of the wrong type,
+// unknown keys, process files that don't exist and include directories that aren't directories. Positions are counted in code units of encoding.
+func ConfigDiagnostics(content []byte, root util.Path, encoding string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	lines := NewLineIndex(content)
+	diagnostic := func(start, end uint, severity transport.DiagnosticSeverity, message string) {
+		startPos, _ := offsetToPosition(lines, start, content, encoding)
+		endPos, _ := offsetToPosition(lines, end, content, encoding)
+		diagnostics = append(diagnostics, transport.Diagnostic{
+			Range:    transport.Range{Start: startPos, End: endPos},
+			Severity: severity,
+			Source:   "faustlsp",
+			Message:  message,
+		})
+	}
+
+	var config FaustProjectConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxError):
+			offset := uint(max(syntaxError.Offset-1, 0))
+			diagnostic(offset, offset+1, transport.SeverityError, fmt.Sprintf("Invalid JSON, using the default configuration: %s", syntaxError))
+			return diagnostics
+		case errors.As(err, &typeError):
+			// The error is shown on the top-level key the value is in
+			start, end := uint(typeError.Offset), uint(typeError.Offset)
+			name, _, _ := strings.Cut(typeError.Field, ".")
+			for _, key := range jsonKeys(content) {
+				if key.Name == name {
+					start, end = key.Start, key.End
+				}
+			}
+			diagnostic(start, end, transport.SeverityError,
+				fmt.Sprintf("%s must be of type %s, using the default configuration", typeError.Field, typeError.Type))
+			return diagnostics
+		default:
+			diagnostic(0, 0, transport.SeverityError, fmt.Sprintf("Invalid configuration, using the default one: %s", err))
+			return diagnostics
+		}
+	}
+
+	known := configFileKeys()
+	for _, key := range jsonKeys(content) {
+		if _, ok := known[key.Name]; !ok {
+			diagnostic(key.Start, key.End, transport.SeverityWarning, fmt.Sprintf("Unknown configuration key %q", key.Name))
+			continue
+		}
+		switch key.Name {
+		case "process_files":
+			for _, file := range key.Strings {
+				if info, err := os.Stat(filepath.Join(root, file.Value)); err != nil || info.IsDir() {
+					diagnostic(file.Start, file.End, transport.SeverityError, fmt.Sprintf("Process file %s doesn't exist", file.Value))
+				}
+			}
+		case "include":
+			for _, dir := range key.Strings {
+				path := dir.Value
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(root, path)
+				}
+				if info, err := os.Stat(path); err != nil {
+					diagnostic(dir.Start, dir.End, transport.SeverityWarning, fmt.Sprintf("Include directory %s doesn't exist", dir.Value))
+				} else if !info.IsDir() {
+					diagnostic(dir.Start, dir.End, transport.SeverityWarning, fmt.Sprintf("Include directory %s isn't a directory", dir.Value))
+				}
+			}
+		}
+	}
+	return diagnostics
+}
+
+// Publishes the problems of the workspace's config file, or clears them if it has none or is gone
+func (w *Workspace) publishConfigDiagnostics(s *Server, content []byte, exists bool) {
+	if w.Root == "" || s.diagChan == nil || (!exists && !w.hasConfigFile) {
+		return
+	}
+	diagnostics := []transport.Diagnostic{}
+	if exists {
+		diagnostics = ConfigDiagnostics(content, w.Root, string(s.Files.encoding))
+	}
+	s.diagChan <- transport.PublishDiagnosticsParams{
+		URI:         transport.DocumentURI(util.Path2URI(filepath.Join(w.Root, faustConfigFile))),
+		Diagnostics: diagnostics,
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+type ParseTreeParams struct {
+	TextDocument transport.TextDocumentIdentifier `json:"textDocument"`
+	// Only return the tree of the smallest node containing this range
+	Range *transport.Range `json:"range,omitempty"`
+}
+
+type ParseTreeResult struct {
+	// The tree-sitter S-expression of the parse tree
+	Tree string `json:"tree"`
+	// Range of the node the tree is of
+	Range transport.Range `json:"range"`
+}
+
+// ParseTree handles faustlsp/parseTree, a debugging request returning the tree-sitter parse tree of a document.
+// Useful for reporting issues about constructs that the server understands incorrectly.
+func ParseTree(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params ParseTreeParams
+	json.Unmarshal(par, &params)
+
+	logging.Logger.Info("Parse tree request", "params", string(par))
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get parse tree of non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	result, err := ParseTreeSExpression(root, content, params.Range, string(s.Files.encoding))
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(result)
+}
+
+// ParseTreeSExpression returns the S-expression of content's parse tree, or of the smallest node containing r if it isn't nil
+func ParseTreeSExpression(root *tree_sitter.Node, content []byte, r *transport.Range, encoding string) (ParseTreeResult, error) {
+	node := root
+	if r != nil {
+		start, err := PositionToOffset(r.Start, string(content), encoding)
+		if err != nil {
+			return ParseTreeResult{}, err
+		}
+		end, err := PositionToOffset(r.End, string(content), encoding)
+		if err != nil {
+			return ParseTreeResult{}, err
+		}
+		if descendant := node.NamedDescendantForByteRange(start, end); descendant != nil {
+			node = descendant
+		}
+	}
+
+	nodeRange := ToRange(node)
+	startPos, err := OffsetToPosition(node.StartByte(), string(content), encoding)
+	if err == nil {
+		nodeRange.Start = startPos
+	}
+	endPos, err := OffsetToPosition(node.EndByte(), string(content), encoding)
+	if err == nil {
+		nodeRange.End = endPos
+	}
+	return ParseTreeResult{Tree: node.ToSexp(), Range: nodeRange}, nil
+}
+
+// ParseResult is what the server extracts from a file, printed by faustlsp parse to attach to bug reports
+type ParseResult struct {
+	Path        util.Path          `json:"path"`
+	Tree        string             `json:"tree"` // S-expression of the parse tree, like faustlsp/parseTree
+	Symbols     []ParsedSymbol     `json:"symbols"`
+	Diagnostics []ReportDiagnostic `json:"diagnostics"`
+}
+
+// ParsedSymbol is a symbol of a file's scope, with the 1-based line and column of its identifier and the symbols scoped in it
+type ParsedSymbol struct {
+	Kind     string         `json:"kind"`
+	Name     string         `json:"name,omitempty"`
+	Line     uint32         `json:"line"`
+	Column   uint32         `json:"column"`
+	File     util.Path      `json:"file,omitempty"` // File imported by imports and libraries
+	Children []ParsedSymbol `json:"children,omitempty"`
+}
+
+// ParseFile parses the file at path and extracts its symbols and syntax errors like the server does for files opened in an editor,
+// resolving its imports from its directory like single files
+func ParseFile(path util.Path) (ParseResult, error) {
+	parser.Init()
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return ParseResult{}, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ParseResult{}, err
+	}
+
+	var files Files
+	files.Init(context.Background(), transport.UTF8)
+	store := Store{
+		Files:        &files,
+		Dependencies: NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*Scope),
+	}
+	w := Workspace{}
+	w.Config = w.defaultConfig()
+	store.Precision = w.Config.EffectivePrecision()
+
+	files.OpenFromPath(path)
+	f, ok := files.GetFromPath(path)
+	if !ok {
+		return ParseResult{}, fmt.Errorf("couldn't open %s", path)
+	}
+	w.AnalyzeFile(f, &store)
+
+	result := ParseResult{Path: path, Symbols: []ParsedSymbol{}, Diagnostics: []ReportDiagnostic{}}
+	for _, d := range files.TSDiagnostics(path).Diagnostics {
+		result.Diagnostics = append(result.Diagnostics, toReportDiagnostic(d))
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	parsed, err := ParseTreeSExpression(tree.RootNode(), content, nil, string(files.encoding))
+	if err != nil {
+		return ParseResult{}, err
+	}
+	result.Tree = parsed.Tree
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.Scope != nil {
+		result.Symbols = parsedSymbols(f.Scope.Symbols)
+	}
+	return result, nil
+}
+
+func parsedSymbols(symbols []*Symbol) []ParsedSymbol {
+	parsed := []ParsedSymbol{}
+	for _, sym := range symbols {
+		parsed = append(parsed, parsedSymbol(sym))
+	}
+	return parsed
+}
+
+func parsedSymbol(sym *Symbol) ParsedSymbol {
+	parsed := ParsedSymbol{
+		Kind:   sym.Kind.String(),
+		Name:   sym.Ident,
+		Line:   sym.Loc.Range.Start.Line + 1,
+		Column: sym.Loc.Range.Start.Character + 1,
+		File:   sym.File,
+	}
+	// Rules of cases, then arguments and definitions of functions and environments
+	for i := range sym.Children {
+		parsed.Children = append(parsed.Children, parsedSymbol(&sym.Children[i]))
+	}
+	for _, nested := range []*Scope{sym.Scope, sym.Expression} {
+		if nested != nil {
+			parsed.Children = append(parsed.Children, parsedSymbols(nested.Symbols)...)
+		}
+	}
+	return parsed
+}
+
+// Text lists the sections of the result for reading in an issue
+func (r ParseResult) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s\n\n# Syntax tree\n%s\n\n# Symbols\n", r.Path, r.Tree)
+	if len(r.Symbols) == 0 {
+		b.WriteString("No symbols\n")
+	}
+	writeParsedSymbols(&b, r.Symbols, 0)
+	b.WriteString("\n# Syntax diagnostics\n")
+	if len(r.Diagnostics) == 0 {
+		b.WriteString("No syntax errors\n")
+	}
+	for _, d := range r.Diagnostics {
+		fmt.Fprintf(&b, "%d:%d %s: %s\n", d.Line, d.Column, d.Severity, d.Message)
+	}
+	return b.String()
+}
+
+func writeParsedSymbols(b *strings.Builder, symbols []ParsedSymbol, depth int) {
+	for _, sym := range symbols {
+		fmt.Fprintf(b, "%s%s", strings.Repeat("  ", depth), sym.Kind)
+		if sym.Name != "" {
+			fmt.Fprintf(b, " %s", sym.Name)
+		}
+		fmt.Fprintf(b, " %d:%d", sym.Line, sym.Column)
+		if sym.File != "" {
+			fmt.Fprintf(b, " -> %s", sym.File)
+		}
+		b.WriteString("\n")
+		writeParsedSymbols(b, sym.Children, depth+1)
+	}
+}
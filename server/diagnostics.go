@@ -1,22 +1,125 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 )
 
 func (s *Server) GenerateDiagnostics() {
-	s.diagChan = make(chan transport.PublishDiagnosticsParams)
-
 	for {
-		logging.Logger.Info("Waiting for diagnostic\n")
+		logging.Logger.Debug("Waiting for diagnostic")
 		select {
 		case diag := <-s.diagChan:
+			SortDiagnostics(diag.Diagnostics)
 			content, _ := json.Marshal(diag)
-			logging.Logger.Info("Writing Diagnostic", "content", string(content))
+			logging.Logger.Debug("Writing diagnostics", "uri", diag.URI, "count", len(diag.Diagnostics))
 			s.Transport.WriteNotif("textDocument/publishDiagnostics", content)
+			metrics.diagnosticsPublished.Add(1)
+		}
+	}
+}
+
+// Time to wait for more changes before re-diagnosing the workspace, so that bursts of config saves lead to one pass
+const rediagnoseDelay = 200 * time.Millisecond
+
+// Re-diagnoses the whole workspace in the background, e.g. after its config changed
+func (w *Workspace) cleanDiagnostics(s *Server) {
+	if w.rediagnose == nil {
+		// Workspace isn't tracked in the background
+		w.rediagnoseWorkspace(context.Background(), s, nil)
+		return
+	}
+	select {
+	case w.rediagnose <- struct{}{}:
+	default:
+		// A pass is already pending
+	}
+}
+
+func (w *Workspace) scheduleDiagnostics(ctx context.Context, s *Server) {
+	// Initializing the workspace again replaces the channel
+	rediagnose := w.rediagnose
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rediagnose:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rediagnoseDelay):
+		}
+		w.rediagnoseWorkspace(ctx, s, rediagnose)
+	}
+}
+
+// Publishes diagnostics of every Faust file of the workspace, open documents first, then compiler diagnostics of process files.
+// The pass stops early if restart has a pending request, as the next pass supersedes it, or once ctx is done.
+func (w *Workspace) rediagnoseWorkspace(ctx context.Context, s *Server, restart chan struct{}) {
+	paths := w.diagnosticsOrder()
+	progress := s.beginProgress("Diagnosing workspace")
+	for i, path := range paths {
+		if len(restart) > 0 {
+			progress.end("Restarted after another change")
+			return
+		}
+		if ctx.Err() != nil {
+			progress.end("Stopped")
+			return
+		}
+		message := path
+		if rel, err := filepath.Rel(w.Root, path); err == nil {
+			message = rel
+		}
+		progress.report(message, i, len(paths))
+		w.publishFileDiagnostics(path, s)
+	}
+
+	// Files that lost compiler diagnostics, like ones removed from process_files, got them replaced above if they are in the workspace
+	processFiles := w.ProcessFiles()
+	for _, path := range w.diagnosedProcessFiles {
+		if !slices.Contains(processFiles, path) && !slices.Contains(paths, path) {
+			s.diagChan <- transport.PublishDiagnosticsParams{
+				URI:         transport.DocumentURI(util.Path2URI(path)),
+				Diagnostics: []transport.Diagnostic{},
+			}
+		}
+	}
+	w.diagnosedProcessFiles = processFiles
+	// Configs of subdirectories can turn compiler diagnostics on and off for their process files
+	if len(restart) == 0 && ctx.Err() == nil {
+		progress.report("Compiler diagnostics", len(paths), len(paths))
+		w.sendCompilerDiagnostics(s)
+	}
+	progress.end(fmt.Sprintf("Diagnosed %d files", len(paths)))
+}
+
+// Faust files of the workspace, with the ones open in the editor first
+func (w *Workspace) diagnosticsOrder() []util.Path {
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+
+	open := []util.Path{}
+	closed := []util.Path{}
+	for _, path := range files {
+		if !IsFaustFile(path) {
+			continue
+		}
+		if w.isOpen(path) {
+			open = append(open, path)
+		} else {
+			closed = append(closed, path)
 		}
 	}
+	return append(open, closed...)
 }
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Time after which generating a diagram for a hover is given up on
+const diagramTimeout = 10 * time.Second
+
+type diagramKey struct {
+	path       util.Path
+	definition string
+}
+
+// Diagram generated from a file's content with the given hash. Failures are kept too so that hovers don't retry them until the file changes.
+type diagramEntry struct {
+	hash [sha256.Size]byte
+	svg  util.Path
+	err  error
+}
+
+// Block diagrams generated for definitions of files, regenerated once the content of their file changes
+type diagramCache struct {
+	mu      sync.Mutex
+	entries map[diagramKey]diagramEntry
+	// Diagrams generated in the background for hovers
+	pending map[diagramKey]struct{}
+}
+
+// Returns the diagram of key generated from the content with the given hash, if it was and its SVG is still there
+func (c *diagramCache) lookup(key diagramKey, hash [sha256.Size]byte) (diagramEntry, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || entry.hash != hash {
+		return diagramEntry{}, false
+	}
+	if entry.err != nil {
+		return entry, true
+	}
+	// Diagrams removed from the cache directory are generated again
+	if _, err := os.Stat(entry.svg); err != nil {
+		return diagramEntry{}, false
+	}
+	return entry, true
+}
+
+// Returns the top-level SVG of the block diagram of definition in the file at path, generating it if the file changed since it last was
+func (s *Server) blockDiagram(ctx context.Context, path util.Path, definition string) (util.Path, error) {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return "", fmt.Errorf("trying to generate block diagram of non-existent path: %s", path)
+	}
+	f.mu.RLock()
+	hash := f.Hash
+	f.mu.RUnlock()
+
+	key := diagramKey{path: path, definition: definition}
+	if entry, ok := s.diagrams.lookup(key, hash); ok {
+		return entry.svg, entry.err
+	}
+
+	w := s.workspaceFor(path)
+	config := w.ConfigFor(path)
+	config.ProcessName = definition
+	compiledPath, _, config := w.compilation(path, config)
+	logging.Logger.Info("Generating block diagram", "path", compiledPath, "definition", definition)
+	entry := diagramEntry{hash: hash}
+	// Diagrams are generated in the overlay rather than next to the file in the workspace
+	generated, err := generateSvg(ctx, compiledPath, w.TempDirPath(filepath.Dir(path)), w.Root, config)
+	if err == nil {
+		entry.svg, err = w.storeSvg(generated, path, definition)
+	}
+	entry.err = err
+	// Timeouts say nothing about the file, so they aren't kept
+	if ctx.Err() == nil {
+		s.diagrams.mu.Lock()
+		if s.diagrams.entries == nil {
+			s.diagrams.entries = make(map[diagramKey]diagramEntry)
+		}
+		s.diagrams.entries[key] = entry
+		s.diagrams.mu.Unlock()
+	}
+	return entry.svg, entry.err
+}
+
+// Markdown showing the block diagram of the process defined in the file at path, appended to its hover.
+// Generating it can take seconds, so the hover doesn't wait for it: it is generated in the background and shown by hovers once it's ready.
+func (s *Server) diagramHover(path util.Path) (string, bool) {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return "", false
+	}
+	f.mu.RLock()
+	hash := f.Hash
+	f.mu.RUnlock()
+
+	definition := s.workspaceFor(path).ConfigFor(path).processName()
+	key := diagramKey{path: path, definition: definition}
+	if entry, ok := s.diagrams.lookup(key, hash); ok {
+		if entry.err != nil {
+			logging.Logger.Info("No block diagram for hover", "path", path, "error", entry.err)
+			return "", false
+		}
+		return DiagramMarkdown(util.Path2URI(entry.svg)), true
+	}
+
+	s.diagrams.mu.Lock()
+	_, generating := s.diagrams.pending[key]
+	if !generating {
+		if s.diagrams.pending == nil {
+			s.diagrams.pending = make(map[diagramKey]struct{})
+		}
+		s.diagrams.pending[key] = struct{}{}
+	}
+	s.diagrams.mu.Unlock()
+	if generating {
+		return "", false
+	}
+	go func() {
+		defer func() {
+			s.diagrams.mu.Lock()
+			delete(s.diagrams.pending, key)
+			s.diagrams.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(s.sessionContext(), diagramTimeout)
+		defer cancel()
+		if _, err := s.blockDiagram(ctx, path, definition); err != nil {
+			logging.Logger.Info("No block diagram for hover", "path", path, "error", err)
+		}
+	}()
+	return "", false
+}
+
+// DiagramMarkdown shows the diagram at uri, with a link opening it for editors that don't render images in hovers
+func DiagramMarkdown(uri util.URI) string {
+	return fmt.Sprintf("![Block diagram](%s)\n\n[Open block diagram](%s)", uri, uri)
+}
+
+// Top-level SVG in a directory of diagrams of definition
+func diagramFile(dir util.Path, definition string) util.Path {
+	for _, name := range []string{definition + ".svg", "process.svg"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+	return filepath.Join(dir, "process.svg")
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Time disk events are gathered for before being handled as one batch, so that checkouts and builds don't handle each of their changes on its own
+const diskEventWindow = 50 * time.Millisecond
+
+// CoalesceEvents merges events of the same path, in the order their paths first came up, dropping those superseded by later ones.
+// Files created then removed within the events are left out, changes of files removed afterwards only leave their removal,
+// and files removed then created again, like editors saving by replacing files, are written.
+func CoalesceEvents(events []fsnotify.Event) []fsnotify.Event {
+	type pending struct {
+		event   fsnotify.Event
+		created bool // The path didn't exist before the events
+		dropped bool
+	}
+	byPath := make(map[string]*pending)
+	order := []*pending{}
+	for _, event := range events {
+		p, ok := byPath[event.Name]
+		if !ok || p.dropped {
+			p = &pending{event: event, created: event.Has(fsnotify.Create)}
+			byPath[event.Name] = p
+			order = append(order, p)
+			continue
+		}
+		removed := p.event.Has(fsnotify.Remove) || p.event.Has(fsnotify.Rename)
+		switch {
+		case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+			p.event = event
+			p.dropped = p.created
+		case event.Has(fsnotify.Create) && removed:
+			p.event = fsnotify.Event{Name: event.Name, Op: fsnotify.Create | fsnotify.Write}
+		default:
+			p.event.Op |= event.Op
+			if event.RenamedFrom != "" {
+				p.event.RenamedFrom = event.RenamedFrom
+			}
+		}
+	}
+
+	coalesced := []fsnotify.Event{}
+	for _, p := range order {
+		if !p.dropped {
+			coalesced = append(coalesced, p.event)
+		}
+	}
+	return coalesced
+}
+
+// Ignore rules, config and snippets files change how other files are handled, so their events come first
+func isWorkspaceSettingsEvent(event fsnotify.Event) bool {
+	name := filepath.Base(event.Name)
+	return name == gitignoreFile || name == faustConfigFile || filepath.Join(filepath.Base(filepath.Dir(event.Name)), name) == filepath.FromSlash(snippetsFile)
+}
+
+// Handles coalesced disk events. Files are read by indexWorkers goroutines, then diagnosed once for the whole batch.
+func (w *Workspace) handleDiskEvents(events []fsnotify.Event, s *Server, watcher *fsnotify.Watcher) {
+	logging.Logger.Info("Handling disk events", "events", len(events))
+	if len(events) == 1 {
+		w.HandleDiskEvent(events[0], s, watcher)
+		return
+	}
+
+	byPath := make(map[util.Path]fsnotify.Event)
+	paths := []util.Path{}
+	for _, event := range events {
+		if isWorkspaceSettingsEvent(event) {
+			w.HandleDiskEvent(event, s, watcher)
+			continue
+		}
+		byPath[event.Name] = event
+		paths = append(paths, event.Name)
+	}
+
+	var mu sync.Mutex
+	changed := []util.Path{}
+	IndexFiles(paths, indexWorkers, func(path util.Path) {
+		paths := w.applyDiskEvent(byPath[path], s, watcher)
+		mu.Lock()
+		changed = append(changed, paths...)
+		mu.Unlock()
+	}, func(util.Path, int) {})
+	w.diagnoseChanged(changed, s)
+}
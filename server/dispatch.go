@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Requests handled at once, beyond which they wait for one to finish so that bursts of them don't starve document changes of the CPU
+var requestSlots = make(chan struct{}, max(runtime.NumCPU(), 4))
+
+// DocumentQueues orders the messages of each document: changes to a document are applied one at a time in the order they were received,
+// and requests about it wait for the changes received before them so that they see the document as the client did.
+// Changes to different documents and requests received between the same changes don't wait for each other.
+type DocumentQueues struct {
+	mu sync.Mutex
+	// Channels closed once the last change queued to each document is applied
+	last map[util.Path]chan struct{}
+}
+
+// Change queues a change to the document at path. wait returns once the changes queued before it are applied, and applied has to be called once it is.
+func (q *DocumentQueues) Change(path util.Path) (wait func(), applied func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.last == nil {
+		q.last = make(map[util.Path]chan struct{})
+	}
+	previous := q.last[path]
+	done := make(chan struct{})
+	q.last[path] = done
+
+	wait = func() {
+		if previous != nil {
+			<-previous
+		}
+	}
+	applied = func() {
+		close(done)
+		q.mu.Lock()
+		if q.last[path] == done {
+			delete(q.last, path)
+		}
+		q.mu.Unlock()
+	}
+	return wait, applied
+}
+
+// Pending returns the function waiting for the changes queued so far to the document at path, or to every document if path is empty.
+// It returns ctx's error if ctx is done first.
+func (q *DocumentQueues) Pending(path util.Path) func(ctx context.Context) error {
+	q.mu.Lock()
+	pending := []chan struct{}{}
+	for changed, done := range q.last {
+		if path == "" || changed == path {
+			pending = append(pending, done)
+		}
+	}
+	q.mu.Unlock()
+
+	return func(ctx context.Context) error {
+		for _, done := range pending {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+// Path of the document of the params of a message, "" if it isn't about one
+func messageDocument(params json.RawMessage) util.Path {
+	var p struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	}
+	if json.Unmarshal(params, &p) != nil || p.TextDocument.URI == "" {
+		return ""
+	}
+	path, err := util.URI2path(p.TextDocument.URI)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Applies a didOpen, didChange or didClose notification once the ones received before it for the same document are
+func (s *Server) dispatchChange(ctx context.Context, method string, msg []byte) {
+	var m transport.NotificationMessage
+	json.Unmarshal(msg, &m)
+	wait, applied := s.documents.Change(messageDocument(m.Params))
+	go func() {
+		defer applied()
+		wait()
+		s.HandleMethod(ctx, method, msg)
+	}()
+}
+
+// Handles a request once the changes received before it to its document, or to every document if it isn't about one, are applied and a request slot is free.
+// Other messages are handled right away.
+func (s *Server) dispatch(ctx context.Context, method string, msg []byte) {
+	handler, ok := requestHandlers[method]
+	if !ok {
+		go s.HandleMethod(ctx, method, msg)
+		return
+	}
+	var m transport.RequestMessage
+	json.Unmarshal(msg, &m)
+	// Tracked before waiting so that $/cancelRequest can cancel it while it waits
+	requestCtx, done := s.trackRequest(ctx, m.ID)
+	pending := s.documents.Pending(messageDocument(m.Params))
+	go func() {
+		if pending(requestCtx) == nil {
+			select {
+			case requestSlots <- struct{}{}:
+				defer func() { <-requestSlots }()
+			case <-requestCtx.Done():
+			}
+		}
+		logging.Logger.Debug("Dispatching request", "method", method, "id", m.ID)
+		s.respond(requestCtx, done, m, handler)
+	}()
+}
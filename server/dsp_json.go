@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+const dspInfoCommand = "faustlsp.dspInfo"
+
+// Time after which compiling a process file for a hover of its widgets is given up on
+const dspInfoTimeout = 10 * time.Second
+
+// DSPInfo is the description of a process file faust -json writes: its numbers of inputs and outputs, metadata and UI
+type DSPInfo struct {
+	Name      string              `json:"name"`
+	Filename  string              `json:"filename"`
+	Version   string              `json:"version"`
+	Inputs    int                 `json:"inputs"`
+	Outputs   int                 `json:"outputs"`
+	Libraries []string            `json:"library_list"`
+	Meta      []map[string]string `json:"meta"`
+	UI        []UIItem            `json:"ui"`
+}
+
+// UIItem is a widget or group of the UI of a DSPInfo, with the OSC address it is controlled with
+type UIItem struct {
+	Type    string              `json:"type"`
+	Label   string              `json:"label"`
+	Address string              `json:"address"`
+	Meta    []map[string]string `json:"meta"`
+	Init    *float64            `json:"init"`
+	Min     *float64            `json:"min"`
+	Max     *float64            `json:"max"`
+	Step    *float64            `json:"step"`
+	Items   []UIItem            `json:"items"`
+}
+
+// Description of a file compiled from the content, imports and config of a key, kept with its failure so that it isn't retried until they change
+type dspInfoEntry struct {
+	key  [sha256.Size]byte
+	raw  json.RawMessage
+	info DSPInfo
+	err  error
+}
+
+// Descriptions of process files, compiled again once the file, its imports or its config changes
+type dspInfoCache struct {
+	mu      sync.Mutex
+	entries map[util.Path]dspInfoEntry
+}
+
+// Returns the description faust -json gives of the process file at path as it writes it and as parsed, compiling it if it changed since it last was
+func (s *Server) dspInfo(ctx context.Context, path util.Path) (json.RawMessage, DSPInfo, error) {
+	if _, ok := s.Files.GetFromPath(path); !ok {
+		return nil, DSPInfo{}, fmt.Errorf("trying to describe non-existent path: %s", path)
+	}
+	w := s.workspaceFor(path)
+	config := w.ConfigFor(path)
+	key := w.compilationKey(s, path, config)
+	s.dspInfos.mu.Lock()
+	entry, ok := s.dspInfos.entries[path]
+	s.dspInfos.mu.Unlock()
+	if ok && entry.key == key {
+		return entry.raw, entry.info, entry.err
+	}
+
+	compiledPath, _, config := w.compilation(path, config)
+	logging.Logger.Info("Generating JSON description", "path", compiledPath)
+	entry = dspInfoEntry{key: key}
+	entry.raw, entry.err = generateJSON(ctx, compiledPath, w.TempDirPath(filepath.Dir(path)), w.Root, config)
+	if entry.err == nil {
+		entry.err = json.Unmarshal(entry.raw, &entry.info)
+	}
+	// Timeouts say nothing about the file, so they aren't kept
+	if ctx.Err() == nil {
+		s.dspInfos.mu.Lock()
+		if s.dspInfos.entries == nil {
+			s.dspInfos.entries = make(map[util.Path]dspInfoEntry)
+		}
+		s.dspInfos.entries[path] = entry
+		s.dspInfos.mu.Unlock()
+	}
+	return entry.raw, entry.info, entry.err
+}
+
+// Runs faust -json on path with absolute include directories, returning the JSON it wrote in outDir.
+// A relative working directory is relative to root.
+func generateJSON(ctx context.Context, path util.Path, outDir util.Path, root util.Path, config FaustProjectConfig) (json.RawMessage, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	args := append(compilerArgs(path, "", config), "-json", "-O", outDir, "-o", os.DevNull)
+	var output []byte
+	err := config.runCompiler(ctx, config.Command, args, filepath.Dir(path), root, func(cmd *exec.Cmd) (err error) {
+		output, err = cmd.CombinedOutput()
+		return err
+	})
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return nil, fmt.Errorf("%s", message)
+		}
+		return nil, err
+	}
+	// Faust writes the description of a.dsp in a.dsp.json in the output directory
+	jsonPath := filepath.Join(outDir, filepath.Base(path)+".json")
+	defer os.Remove(jsonPath)
+	return os.ReadFile(jsonPath)
+}
+
+// DSPInfoCommand returns the JSON faust -json writes for the process file whose URI is given
+func DSPInfoCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	path, err := uriArgument(dspInfoCommand, arguments)
+	if err != nil {
+		return []byte("null"), err
+	}
+	if !IsDSPFile(path) {
+		return []byte("null"), fmt.Errorf("can only describe .dsp files: %s", path)
+	}
+	raw, _, err := s.dspInfo(ctx, path)
+	if err != nil {
+		logging.Logger.Error("Couldn't describe process file", "path", path, "error", err)
+		s.showMessage(transport.Error, fmt.Sprintf("Couldn't compile %s to JSON: %s", filepath.Base(path), err))
+		return []byte("null"), nil
+	}
+	return raw, nil
+}
+
+// UIItems returns the items of ui, at any depth, of the primitive and label of a UI element in the source
+func UIItems(ui []UIItem, primitive string, label string) []UIItem {
+	// Labels of widgets can start with the path of the groups they are in, like h:Mixer/gain
+	name := uiLabelName(label)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+
+	items := []UIItem{}
+	var walk func(items []UIItem)
+	walk = func(children []UIItem) {
+		for _, item := range children {
+			if item.Type == primitive && item.Label == name {
+				items = append(items, item)
+			}
+			walk(item.Items)
+		}
+	}
+	walk(ui)
+	return items
+}
+
+// UIElementAt returns the primitive and label of the innermost UI element whose primitive or label is at offset
+func UIElementAt(root *tree_sitter.Node, content []byte, offset uint) (string, string, bool) {
+	for node := root.DescendantForByteRange(offset, offset); node != nil; node = node.Parent() {
+		if _, ok := uiSymbolKinds[node.Kind()]; !ok {
+			continue
+		}
+		// The other arguments of a widget, or the expression of a group, aren't about the element itself
+		label := node.ChildByFieldName("label")
+		if label == nil || offset > label.EndByte() {
+			return "", "", false
+		}
+		return uiPrimitive(node), label.Utf8Text(content), true
+	}
+	return "", "", false
+}
+
+// UIItemsMarkdown documents the items of the UI of a process file for a UI element's hover
+func UIItemsMarkdown(items []UIItem) string {
+	sections := []string{}
+	format := func(value *float64) string {
+		return strconv.FormatFloat(*value, 'g', -1, 64)
+	}
+	for _, item := range items {
+		lines := []string{}
+		// Groups have no address of their own
+		if item.Address != "" {
+			lines = append(lines, fmt.Sprintf("`%s`", item.Address))
+		} else if item.Items != nil {
+			lines = append(lines, fmt.Sprintf("%d items", len(item.Items)))
+		}
+		if item.Min != nil && item.Max != nil {
+			line := fmt.Sprintf("Range %s to %s", format(item.Min), format(item.Max))
+			if item.Step != nil {
+				line += fmt.Sprintf(", step %s", format(item.Step))
+			}
+			if item.Init != nil {
+				line += fmt.Sprintf(", initially %s", format(item.Init))
+			}
+			lines = append(lines, line)
+		}
+		for _, meta := range item.Meta {
+			for _, key := range slices.Sorted(maps.Keys(meta)) {
+				lines = append(lines, fmt.Sprintf("%s: %s", key, meta[key]))
+			}
+		}
+		sections = append(sections, strings.Join(lines, "  \n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// Markdown documenting the UI element at offset of the process file at path, with the addresses and ranges the compiler resolved
+func (s *Server) uiElementHover(ctx context.Context, path util.Path, root *tree_sitter.Node, content []byte, offset uint) (string, bool) {
+	// Without a workspace, each .dsp file is compiled on its own
+	if w := s.workspaceFor(path); !IsDSPFile(path) || (w.Root != "" && !slices.Contains(w.ProcessFiles(), path)) {
+		return "", false
+	}
+	primitive, label, ok := UIElementAt(root, content, offset)
+	if !ok {
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(ctx, dspInfoTimeout)
+	defer cancel()
+	_, info, err := s.dspInfo(ctx, path)
+	if err != nil {
+		logging.Logger.Info("No JSON description for hover", "path", path, "error", err)
+		return "", false
+	}
+	items := UIItems(info.UI, primitive, label)
+	if len(items) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("```faust\n%s(%s)\n```\n%s", primitive, label, UIItemsMarkdown(items)), true
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Command duplicating the definition under the cursor with a new name
+const duplicateDefinitionCommand = "faustlsp.duplicateDefinition"
+
+// Names accepted for duplicated definitions
+var definitionNameRegex = regexp.MustCompile(`^_*[a-zA-Z][_a-zA-Z0-9]*$`)
+
+// Argument of the faustlsp.duplicateDefinition command. Clients can prompt for the name, otherwise the definition's name is suffixed with a number.
+type DuplicateDefinitionArgs struct {
+	URI      transport.DocumentURI `json:"uri"`
+	Position transport.Position    `json:"position"`
+	Name     string                `json:"name,omitempty"`
+}
+
+// Result of the faustlsp.duplicateDefinition command, whose edit is applied by the server.
+// Cursor is the range of the new definition's name, so that clients can select it.
+type DuplicateDefinitionResult struct {
+	Edit   transport.WorkspaceEdit `json:"edit"`
+	Cursor transport.Location      `json:"cursor"`
+}
+
+func DuplicateDefinitionCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", duplicateDefinitionCommand, len(arguments))
+	}
+	var args DuplicateDefinitionArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	if args.Name != "" && !definitionNameRegex.MatchString(args.Name) {
+		return []byte("null"), fmt.Errorf("invalid definition name: %s", args.Name)
+	}
+
+	path, err := util.URI2path(string(args.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	if s.isExternalFile(path) {
+		return []byte("null"), fmt.Errorf("refusing to edit read-only file outside workspace: %s", path)
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to duplicate definition of non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	edit, nameRange, ok := DuplicateDefinitionEdit(root, content, args.Position, string(s.Files.encoding), args.Name)
+	if !ok {
+		return []byte("null"), fmt.Errorf("no definition to duplicate at %d:%d", args.Position.Line, args.Position.Character)
+	}
+	result := DuplicateDefinitionResult{
+		Edit:   transport.WorkspaceEdit{Changes: map[transport.DocumentURI][]transport.TextEdit{args.URI: {edit}}},
+		Cursor: transport.Location{URI: args.URI, Range: nameRange},
+	}
+	if err := s.applyEdit("Duplicate definition", result.Edit); err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(result)
+}
+
+// DuplicateDefinitionEdit returns the edit inserting a copy of the definition at pos named name on the line after it, indented like it,
+// and the range the copy's name will have. An empty name is replaced by the definition's name with the next unused number.
+func DuplicateDefinitionEdit(root *tree_sitter.Node, content []byte, pos transport.Position, encoding string, name string) (transport.TextEdit, transport.Range, bool) {
+	offset, err := PositionToOffset(pos, string(content), encoding)
+	if err != nil {
+		return transport.TextEdit{}, transport.Range{}, false
+	}
+
+	definition := definitionAt(root, offset)
+	if definition == nil || definition.HasError() {
+		return transport.TextEdit{}, transport.Range{}, false
+	}
+	ident := definitionIdentifier(definition)
+	if ident == nil {
+		return transport.TextEdit{}, transport.Range{}, false
+	}
+	if name == "" {
+		name = numberedName(root, content, ident.Utf8Text(content))
+	}
+
+	// The semicolon ending a definition is a token after it
+	end := definition.EndByte()
+	if next := definition.NextSibling(); next != nil && next.Kind() == ";" {
+		end = next.EndByte()
+	}
+	lineStart := definition.StartByte() - uint(definition.StartPosition().Column)
+	indent := string(content[lineStart:definition.StartByte()])
+	if strings.TrimSpace(indent) != "" {
+		indent = ""
+	}
+	copied := name + string(content[ident.EndByte():end])
+
+	// Comments after the definition stay on its line
+	lineEnd := end
+	for lineEnd < uint(len(content)) && content[lineEnd] != '\n' {
+		lineEnd++
+	}
+	at, _ := OffsetToPosition(lineEnd, string(content), encoding)
+	line := at.Line + 1
+	nameRange := transport.Range{
+		Start: transport.Position{Line: line, Character: uint32(len(indent))},
+		End:   transport.Position{Line: line, Character: uint32(len(indent) + len(name))},
+	}
+	return transport.TextEdit{
+		Range:   transport.Range{Start: at, End: at},
+		NewText: "\n" + indent + copied,
+	}, nameRange, true
+}
+
+// Innermost definition containing offset
+func definitionAt(root *tree_sitter.Node, offset uint) *tree_sitter.Node {
+	for node := root.NamedDescendantForByteRange(offset, offset); node != nil; node = node.Parent() {
+		if node.Kind() == "definition" || node.Kind() == "function_definition" {
+			return node
+		}
+	}
+	return nil
+}
+
+// Name made of name without its trailing number followed by the next number no identifier of the file uses, like voice2 for voice
+func numberedName(root *tree_sitter.Node, content []byte, name string) string {
+	used := make(map[string]struct{})
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if n.Kind() == "identifier" {
+			used[n.Utf8Text(content)] = struct{}{}
+		}
+		for i := uint(0); i < n.NamedChildCount(); i++ {
+			walk(n.NamedChild(i))
+		}
+	}
+	walk(root)
+
+	base := strings.TrimRight(name, "0123456789")
+	n := 1
+	if number, err := strconv.Atoi(name[len(base):]); err == nil {
+		n = number
+	}
+	for {
+		n++
+		candidate := fmt.Sprintf("%s%d", base, n)
+		if _, ok := used[candidate]; !ok {
+			return candidate
+		}
+	}
+}
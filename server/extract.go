@@ -0,0 +1,302 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Base name of extracted definitions, numbered if it is already used
+const extractedName = "extracted"
+
+// Nodes that can be part of an expression but aren't expressions themselves
+var nonExpressionKinds = map[string]struct{}{
+	"program":             {},
+	"definition":          {},
+	"function_definition": {},
+	"environment":         {},
+	"rec_environment":     {},
+	"recinition":          {},
+	"rules":               {},
+	"rule":                {},
+	"arguments":           {},
+	"parameters":          {},
+	"variants":            {},
+	"comment":             {},
+	"string":              {},
+	"file_import":         {},
+	"global_metadata":     {},
+	"function_metadata":   {},
+}
+
+// ExtractActions returns refactorings extracting the expression selected by r into a new definition, replacing the selection with its name.
+// The definition is added at the top level if the expression doesn't use local names, and in a with block of the enclosing definition.
+func ExtractActions(root *tree_sitter.Node, content []byte, uri transport.DocumentURI, r transport.Range, encoding string) []transport.CodeAction {
+	actions := []transport.CodeAction{}
+	start, err := PositionToOffset(r.Start, string(content), encoding)
+	if err != nil {
+		return actions
+	}
+	end, err := PositionToOffset(r.End, string(content), encoding)
+	if err != nil || end <= start {
+		return actions
+	}
+	// Ignore whitespace around the selection
+	for start < end && isSpace(content[start]) {
+		start++
+	}
+	for end > start && isSpace(content[end-1]) {
+		end--
+	}
+	if start == end {
+		return actions
+	}
+
+	node := selectedExpression(root, content, start, end)
+	if node == nil {
+		return actions
+	}
+	definition := enclosingDefinition(node)
+	if definition == nil || definition.HasError() {
+		return actions
+	}
+
+	name := uniqueName(root, content, extractedName)
+	expression := node.Utf8Text(content)
+	position := func(offset uint) transport.Position {
+		pos, _ := OffsetToPosition(offset, string(content), encoding)
+		return pos
+	}
+	replace := transport.TextEdit{
+		Range:   transport.Range{Start: position(start), End: position(end)},
+		NewText: name,
+	}
+	action := func(title string, insert transport.TextEdit) transport.CodeAction {
+		return transport.CodeAction{
+			Title: title,
+			Kind:  transport.RefactorExtract,
+			Edit: &transport.WorkspaceEdit{
+				Changes: map[transport.DocumentURI][]transport.TextEdit{
+					uri: {insert, replace},
+				},
+			},
+		}
+	}
+
+	// Top-level definitions can't see local names like function arguments and iteration variables
+	if !usesLocalNames(node, content) {
+		statement := node
+		for statement.Parent() != nil && statement.Parent().Kind() != "program" {
+			statement = statement.Parent()
+		}
+		at := position(statement.StartByte())
+		at.Character = 0
+		actions = append(actions, action(
+			fmt.Sprintf("Extract into top-level definition %s", name),
+			transport.TextEdit{
+				Range:   transport.Range{Start: at, End: at},
+				NewText: fmt.Sprintf("%s = %s;\n", name, expression),
+			},
+		))
+	}
+
+	if insert, ok := withBlockInsertion(definition, content, fmt.Sprintf("%s = %s;", name, expression)); ok {
+		at := position(insert.offset)
+		actions = append(actions, action(
+			fmt.Sprintf("Extract into with block definition %s", name),
+			transport.TextEdit{Range: transport.Range{Start: at, End: at}, NewText: insert.text},
+		))
+	}
+	return actions
+}
+
+// Expression spanning exactly [start, end), also allowing parentheses around it as they aren't part of the tree
+func selectedExpression(root *tree_sitter.Node, content []byte, start, end uint) *tree_sitter.Node {
+	for start < end {
+		node := root.NamedDescendantForByteRange(start, end)
+		if node != nil && node.StartByte() == start && node.EndByte() == end {
+			if !isExtractableExpression(node) {
+				return nil
+			}
+			return node
+		}
+		if content[start] != '(' || content[end-1] != ')' {
+			return nil
+		}
+		start, end = start+1, end-1
+		for start < end && isSpace(content[start]) {
+			start++
+		}
+		for end > start && isSpace(content[end-1]) {
+			end--
+		}
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isExtractableExpression(node *tree_sitter.Node) bool {
+	if _, ok := nonExpressionKinds[node.Kind()]; ok {
+		return false
+	}
+	if isDeclaration(node) {
+		return false
+	}
+	// Parts of a qualified name like lowpass in fi.lowpass
+	if parent := node.Parent(); parent != nil && parent.Kind() == "access" {
+		return false
+	}
+	return true
+}
+
+// Innermost definition whose value contains node
+func enclosingDefinition(node *tree_sitter.Node) *tree_sitter.Node {
+	for child, parent := node, node.Parent(); parent != nil; child, parent = parent, parent.Parent() {
+		if parent.Kind() != "definition" && parent.Kind() != "function_definition" {
+			continue
+		}
+		value := parent.ChildByFieldName("value")
+		if value != nil && value.StartByte() <= child.StartByte() && child.EndByte() <= value.EndByte() {
+			return parent
+		}
+		return nil
+	}
+	return nil
+}
+
+// Whether the expression uses names bound between it and the top level, like arguments, iteration variables and with block definitions
+func usesLocalNames(node *tree_sitter.Node, content []byte) bool {
+	bound := make(map[string]struct{})
+	addIdentifiers := func(n *tree_sitter.Node) {
+		if n == nil {
+			return
+		}
+		for i := uint(0); i < n.NamedChildCount(); i++ {
+			if child := n.NamedChild(i); child.Kind() == "identifier" {
+				bound[child.Utf8Text(content)] = struct{}{}
+			}
+		}
+	}
+	for ancestor := node.Parent(); ancestor != nil; ancestor = ancestor.Parent() {
+		switch ancestor.Kind() {
+		case "function_definition", "rule":
+			for i := uint(0); i < ancestor.NamedChildCount(); i++ {
+				if child := ancestor.NamedChild(i); child.Kind() == "arguments" {
+					addIdentifiers(child)
+				}
+			}
+		case "lambda":
+			for i := uint(0); i < ancestor.NamedChildCount(); i++ {
+				if child := ancestor.NamedChild(i); child.Kind() == "parameters" {
+					addIdentifiers(child)
+				}
+			}
+		case "iteration":
+			if current := ancestor.ChildByFieldName("current_iter"); current != nil {
+				bound[current.Utf8Text(content)] = struct{}{}
+			}
+		case "with_environment", "letrec_environment":
+			env := ancestor.ChildByFieldName("local_environment")
+			if env == nil {
+				break
+			}
+			for i := uint(0); i < env.NamedChildCount(); i++ {
+				statement := env.NamedChild(i)
+				for _, field := range []string{"variable", "name"} {
+					if ident := statement.ChildByFieldName(field); ident != nil {
+						bound[ident.Utf8Text(content)] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	uses := false
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if uses {
+			return
+		}
+		if n.Kind() == "identifier" {
+			if _, ok := bound[n.Utf8Text(content)]; ok {
+				uses = true
+			}
+			return
+		}
+		if n.Kind() == "access" {
+			// Only the first environment of a qualified name can be local
+			if env := n.ChildByFieldName("environment"); env != nil {
+				walk(env)
+			}
+			return
+		}
+		for i := uint(0); i < n.NamedChildCount(); i++ {
+			walk(n.NamedChild(i))
+		}
+	}
+	walk(node)
+	return uses
+}
+
+// Name based on base that isn't used by any identifier of the file
+func uniqueName(root *tree_sitter.Node, content []byte, base string) string {
+	used := make(map[string]struct{})
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if n.Kind() == "identifier" {
+			used[n.Utf8Text(content)] = struct{}{}
+		}
+		for i := uint(0); i < n.NamedChildCount(); i++ {
+			walk(n.NamedChild(i))
+		}
+	}
+	walk(root)
+
+	name := base
+	for i := 1; ; i++ {
+		if _, ok := used[name]; !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+type insertion struct {
+	offset uint
+	text   string
+}
+
+// Where to add a definition to the with block of a definition, creating the block if it doesn't have one
+func withBlockInsertion(definition *tree_sitter.Node, content []byte, text string) (insertion, bool) {
+	value := definition.ChildByFieldName("value")
+	if value == nil {
+		return insertion{}, false
+	}
+	if value.Kind() != "with_environment" {
+		return insertion{offset: value.EndByte(), text: fmt.Sprintf(" with { %s }", text)}, true
+	}
+
+	env := value.ChildByFieldName("local_environment")
+	if env == nil || env.ChildCount() == 0 {
+		return insertion{}, false
+	}
+	closing := env.Child(env.ChildCount() - 1)
+	if closing.Kind() != "}" {
+		return insertion{}, false
+	}
+	// Single line blocks get the definition before the closing brace, others on a new line indented like the other definitions
+	if closing.StartPosition().Row == env.StartPosition().Row {
+		return insertion{offset: closing.StartByte(), text: text + " "}, true
+	}
+	indent := strings.Repeat(" ", int(env.StartPosition().Column)+4)
+	if env.NamedChildCount() > 0 {
+		indent = strings.Repeat(" ", int(env.NamedChild(0).StartPosition().Column))
+	}
+	lineStart := closing.StartByte() - uint(closing.StartPosition().Column)
+	return insertion{offset: lineStart, text: indent + text + "\n"}, true
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+var (
+	filePattern   = transport.FilePattern
+	folderPattern = transport.FolderPattern
+)
+
+// Files and folders whose renames and deletions are told to the server before they happen
+var fileOperationFilters = []transport.FileOperationFilter{
+	{Scheme: "file", Pattern: transport.FileOperationPattern{Glob: "**/*.{dsp,lib}", Matches: &filePattern}},
+	{Scheme: "file", Pattern: transport.FileOperationPattern{Glob: "**", Matches: &folderPattern}},
+}
+
+// WillRenameFiles returns the edit updating the imports, libraries and components of workspace files that refer to files about to be renamed
+func WillRenameFiles(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.RenameFilesParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return []byte("null"), err
+	}
+
+	renames := map[util.Path]util.Path{}
+	for _, rename := range params.Files {
+		oldPath, err := util.URI2path(rename.OldURI)
+		if err != nil {
+			continue
+		}
+		newPath, err := util.URI2path(rename.NewURI)
+		if err != nil {
+			continue
+		}
+		renames[oldPath] = newPath
+	}
+
+	changes := map[transport.DocumentURI][]transport.TextEdit{}
+	for _, w := range s.workspaces() {
+		w.mu.Lock()
+		files := slices.Clone(w.Files)
+		w.mu.Unlock()
+		for _, path := range files {
+			if !IsFaustFile(path) {
+				continue
+			}
+			f, ok := s.Files.GetFromPath(path)
+			if !ok {
+				continue
+			}
+			tree, content := f.Tree()
+			resolve := func(importPath string) (util.Path, util.Path) {
+				return w.ResolveFilePath(importPath, w.ImportRoot(path))
+			}
+			edits := RenameImportEdits(tree.RootNode(), content, resolve, renames)
+			tree.Close()
+			if len(edits) > 0 {
+				changes[transport.DocumentURI(util.Path2URI(path))] = edits
+			}
+		}
+	}
+	logging.Logger.Info("Imports updated for renamed files", "renames", renames, "changes", changes)
+	if len(changes) == 0 {
+		return []byte("null"), nil
+	}
+	return json.Marshal(transport.WorkspaceEdit{Changes: changes})
+}
+
+// RenameImportEdits returns the edits of the paths imported in content that refer to the old paths of renames, renamed files or folders, so that they refer to their new paths.
+// resolve returns the path an imported file is found at and the directory it was found in, or "" if it can't be found.
+func RenameImportEdits(root *tree_sitter.Node, content []byte, resolve func(string) (util.Path, util.Path), renames map[util.Path]util.Path) []transport.TextEdit {
+	edits := []transport.TextEdit{}
+	for _, imp := range ImportedFiles(root, content) {
+		resolvedPath, dir := resolve(imp.Path)
+		if resolvedPath == "" {
+			continue
+		}
+		newPath, ok := renamedPath(resolvedPath, renames)
+		if !ok {
+			continue
+		}
+		edits = append(edits, transport.TextEdit{Range: imp.Range, NewText: `"` + renamedImportPath(imp.Path, dir, newPath) + `"`})
+	}
+	return edits
+}
+
+// Path of path once renames are done, if it is one of the renamed files or in one of the renamed folders
+func renamedPath(path util.Path, renames map[util.Path]util.Path) (util.Path, bool) {
+	if newPath, ok := renames[path]; ok {
+		return newPath, true
+	}
+	for oldPath, newPath := range renames {
+		if isWithin(path, oldPath) {
+			rel, err := filepath.Rel(oldPath, path)
+			if err == nil {
+				return filepath.Join(newPath, rel), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Import path of newPath written like importPath was, absolute or relative to dir, the directory importPath was found in
+func renamedImportPath(importPath string, dir util.Path, newPath util.Path) string {
+	if filepath.IsAbs(importPath) {
+		return newPath
+	}
+	rel, err := filepath.Rel(dir, newPath)
+	if err != nil {
+		return newPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// WillDeleteFiles warns about workspace files importing files about to be deleted, whose imports will be broken
+func WillDeleteFiles(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.DeleteFilesParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return []byte("null"), err
+	}
+	deleted := deletedPaths(params.Files)
+	dependents := []string{}
+	for _, path := range s.dependentsOf(deleted) {
+		dependents = append(dependents, filepath.Base(path))
+	}
+	if len(dependents) > 0 {
+		names := []string{}
+		for _, path := range deleted {
+			names = append(names, filepath.Base(path))
+		}
+		s.showMessage(transport.Warning, fmt.Sprintf("Deleting %s breaks the imports of %s", strings.Join(names, ", "), strings.Join(dependents, ", ")))
+	}
+	// Nothing is changed, imports are left for the user to fix
+	return []byte("null"), nil
+}
+
+// DidDeleteFiles diagnoses the workspace files importing deleted files again, without waiting for the disk events of the deletion
+func DidDeleteFiles(ctx context.Context, s *Server, par json.RawMessage) error {
+	var params transport.DeleteFilesParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return err
+	}
+	for _, path := range s.dependentsOf(deletedPaths(params.Files)) {
+		s.workspaceFor(path).DiagnoseFile(path, s)
+	}
+	return nil
+}
+
+func deletedPaths(files []transport.FileDelete) []util.Path {
+	paths := []util.Path{}
+	for _, file := range files {
+		if path, err := util.URI2path(file.URI); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Workspace files importing the deleted files, or files in the deleted folders
+func (s *Server) dependentsOf(deleted []util.Path) []util.Path {
+	dependents := []util.Path{}
+	for _, w := range s.workspaces() {
+		w.mu.Lock()
+		files := slices.Clone(w.Files)
+		w.mu.Unlock()
+		for _, path := range files {
+			if !IsFaustFile(path) || slices.ContainsFunc(deleted, func(d util.Path) bool { return isWithin(path, d) }) {
+				continue
+			}
+			f, ok := s.Files.GetFromPath(path)
+			if !ok {
+				continue
+			}
+			tree, content := f.Tree()
+			imports := DeletedImports(tree.RootNode(), content, w.ImportSearchPath(w.ImportRoot(path)), deleted)
+			tree.Close()
+			if len(imports) > 0 {
+				dependents = append(dependents, path)
+			}
+		}
+	}
+	return dependents
+}
+
+// DeletedImports returns the files imported in content that are, or were before being deleted, the deleted files or in the deleted folders.
+// Imports are looked up in the directories of searchPath in order, the deleted paths counting as existing.
+func DeletedImports(root *tree_sitter.Node, content []byte, searchPath []util.Path, deleted []util.Path) []ImportedFile {
+	isDeleted := func(path util.Path) bool {
+		return slices.ContainsFunc(deleted, func(d util.Path) bool { return isWithin(path, d) })
+	}
+	imports := []ImportedFile{}
+	for _, imp := range ImportedFiles(root, content) {
+		candidates := []util.Path{imp.Path}
+		if !filepath.IsAbs(imp.Path) {
+			candidates = []util.Path{}
+			for _, dir := range searchPath {
+				candidates = append(candidates, filepath.Join(dir, imp.Path))
+			}
+		}
+		for _, candidate := range candidates {
+			if isDeleted(candidate) {
+				imports = append(imports, imp)
+				break
+			}
+			if util.IsValidPath(candidate) {
+				break
+			}
+		}
+	}
+	return imports
+}
@@ -5,14 +5,16 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
-
+	"slices"
 	"sync"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/parser"
 	"github.com/carn181/faustlsp/transport"
 	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
 type File struct {
@@ -26,12 +28,54 @@ type File struct {
 
 	// File Content
 	Content []byte
+	// Offsets the lines of Content start at, kept along with it
+	lines LineIndex
 
 	// Hash for each file. Used for caching scopes.
 	Hash [sha256.Size]byte
 
+	// Version of the document in the editor, from the last change applied, 0 for files that aren't open
+	Version int32
+
 	// TODO: Shift away from using this in diagnostics checking step
 	hasSyntaxErrors bool
+
+	// Syntax tree of Content, reparsed incrementally after incremental changes. Nil until something needs it.
+	tree           *parser.Tree
+	trees          *parser.Pool
+	semanticTokens semanticTokensCache
+
+	// Guarded by the mutex of Files. Files open in the editor always keep their content, others can have it dropped
+	// once over the limit of Files, and read again from disk when they are next gotten.
+	open     bool
+	evicted  bool
+	counted  int    // Bytes of Content counted in the resident content of Files
+	lastUsed uint64 // When the file was last gotten, to drop the least recently used contents first
+}
+
+// Pool the file's syntax trees are made in
+func (f *File) treePool() *parser.Pool {
+	if f.trees == nil {
+		return &parser.Trees
+	}
+	return f.trees
+}
+
+// Tree returns a copy of the syntax tree of the file's content along with that content. The tree is parsed once and kept by the file,
+// reparsed incrementally as its content changes, so that features share it rather than each parsing the file again.
+// The copy stays valid whatever happens to the file meanwhile, and the caller closes it once done with its nodes.
+func (f *File) Tree() (*parser.Tree, []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.syntaxTree().Copy(), f.Content
+}
+
+// Syntax tree kept by the file, parsed if there is none. f.mu must be locked.
+func (f *File) syntaxTree() *parser.Tree {
+	if f.tree == nil {
+		f.tree = f.treePool().Parse(f.Content)
+	}
+	return f.tree
 }
 
 func (f *File) LogValue() slog.Value {
@@ -45,23 +89,24 @@ func (f *File) LogValue() slog.Value {
 }
 
 func (f *File) DocumentSymbols() []transport.DocumentSymbol {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	t := parser.ParseTree(f.Content)
+	t, content := f.Tree()
 	defer t.Close()
-	return parser.DocumentSymbols(t, f.Content)
+	return parser.DocumentSymbols(t, content)
 	//	return []transport.DocumentSymbol{}
 }
 
+func (f *File) FoldingRanges(ctx context.Context) ([]transport.FoldingRange, error) {
+	t, content := f.Tree()
+	defer t.Close()
+	return parser.FoldingRanges(ctx, t, content)
+}
+
 func (f *File) TSDiagnostics() transport.PublishDiagnosticsParams {
 	logging.Logger.Info("Waiting for lock", "file", f.Handle.Path)
 	f.mu.Lock()
 
 	logging.Logger.Info("Got lock", "file", f.Handle.Path)
-	t := parser.ParseTree(f.Content)
-
-	errors := parser.TSDiagnostics(f.Content, t)
+	errors := parser.TSDiagnostics(f.Content, f.syntaxTree())
 	if len(errors) == 0 {
 		f.hasSyntaxErrors = false
 	} else {
@@ -69,22 +114,137 @@ func (f *File) TSDiagnostics() transport.PublishDiagnosticsParams {
 	}
 	d := transport.PublishDiagnosticsParams{
 		URI:         transport.DocumentURI(f.Handle.URI),
+		Version:     f.Version,
 		Diagnostics: errors,
 	}
 	f.mu.Unlock()
 	return d
 }
 
+// Bytes of content of files not open in the editor kept in memory by default
+const defaultContentLimit = 64 << 20
+
 type Files struct {
 	// Absolute Paths Only
 	fs       map[util.Handle]*File
 	mu       sync.Mutex
 	encoding transport.PositionEncodingKind // Position Encoding for applying incremental changes. UTF-16 and UTF-32 supported
+
+	// Syntax trees kept by files between requests
+	trees parser.Pool
+
+	// Content of files not open in the editor kept in memory, and how much of it there can be
+	resident     int
+	contentLimit int
+	clock        uint64
 }
 
 func (files *Files) Init(context context.Context, encoding transport.PositionEncodingKind) {
 	files.fs = make(map[util.Handle]*File)
 	files.encoding = encoding
+	files.contentLimit = defaultContentLimit
+}
+
+// Closes the syntax trees kept by the files, which are parsed again if they are needed later
+func (files *Files) closeTrees() {
+	files.mu.Lock()
+	fs := slices.Collect(maps.Values(files.fs))
+	files.mu.Unlock()
+	for _, f := range fs {
+		f.mu.Lock()
+		f.resetTree()
+		f.mu.Unlock()
+	}
+}
+
+// SetContentLimit sets the bytes of content of files not open in the editor that are kept in memory, dropping the least recently used ones beyond it
+func (files *Files) SetContentLimit(limit int) {
+	files.mu.Lock()
+	files.contentLimit = limit
+	files.evict()
+	files.mu.Unlock()
+}
+
+// ResidentContent returns the bytes of content of files not open in the editor that are in memory
+func (files *Files) ResidentContent() int {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	return files.resident
+}
+
+// MarkOpen tells whether the file at path is open in the editor, which keeps its content in memory
+func (files *Files) MarkOpen(path util.Path, open bool) {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	f, ok := files.fs[util.FromPath(path)]
+	if !ok {
+		return
+	}
+	f.open = open
+	f.mu.RLock()
+	files.account(f)
+	f.mu.RUnlock()
+	files.evict()
+}
+
+// Counts the content of f in the resident content. Called with the mutex of files held, and f's read.
+func (files *Files) account(f *File) {
+	counted := 0
+	if !f.open && !f.evicted {
+		counted = len(f.Content)
+	}
+	files.resident += counted - f.counted
+	f.counted = counted
+}
+
+// Drops the contents of the least recently used files not open in the editor until the resident content is within the limit.
+// Called with the mutex of files held. The file gotten last and files someone holds the lock of are skipped, as their content is in use.
+func (files *Files) evict() {
+	for files.contentLimit > 0 && files.resident > files.contentLimit {
+		var victim *File
+		for _, f := range files.fs {
+			if f.counted > 0 && f.lastUsed != files.clock && (victim == nil || f.lastUsed < victim.lastUsed) && f.mu.TryLock() {
+				if victim != nil {
+					victim.mu.Unlock()
+				}
+				victim = f
+			}
+		}
+		if victim == nil {
+			return
+		}
+		logging.Logger.Info("Dropping content of file", "path", victim.Handle.Path, "bytes", victim.counted)
+		victim.Content = nil
+		victim.lines = nil
+		victim.evicted = true
+		victim.resetTree()
+		files.account(victim)
+		victim.mu.Unlock()
+	}
+}
+
+// Reads the content of f from disk again if it was dropped. Scopes of files that changed since they were analyzed are stale, so they are dropped too.
+func (files *Files) reload(f *File) {
+	content, err := os.ReadFile(f.Handle.Path)
+	if err != nil {
+		logging.Logger.Error("Couldn't read dropped content of file again", "path", f.Handle.Path, "error", err)
+	}
+
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	f.mu.Lock()
+	if f.evicted {
+		if hash := sha256.Sum256(content); hash != f.Hash {
+			f.Hash = hash
+			f.Scope = nil
+		}
+		f.Content = content
+		f.lines = NewLineIndex(content)
+		f.evicted = false
+		files.account(f)
+	}
+	f.mu.Unlock()
+	files.evict()
 }
 
 func (files *Files) OpenFromURI(uri util.URI) {
@@ -101,7 +261,9 @@ func (files *Files) OpenFromPath(path util.Path) {
 }
 
 func (files *Files) Open(handle util.Handle) {
-	_, ok := files.Get(handle)
+	files.mu.Lock()
+	_, ok := files.fs[handle]
+	files.mu.Unlock()
 	// If File already in store, ignore
 	if ok {
 		logging.Logger.Info("File already in store", "handle.Path", handle.Path)
@@ -121,12 +283,11 @@ func (files *Files) Open(handle util.Handle) {
 	var file = File{
 		Handle:  handle,
 		Content: content,
+		lines:   NewLineIndex(content),
 		Hash:    sha256.Sum256(content),
+		trees:   &files.trees,
 	}
-
-	files.mu.Lock()
-	files.fs[handle] = &file
-	files.mu.Unlock()
+	files.insert(&file)
 }
 
 func (files *Files) AddFromURI(uri util.URI, content []byte) {
@@ -139,17 +300,38 @@ func (files *Files) AddFromURI(uri util.URI, content []byte) {
 
 func (files *Files) Add(handle util.Handle, content []byte) {
 	var file = File{
-		Handle: handle, Content: content, Hash: sha256.Sum256(content),
+		Handle: handle, Content: content, lines: NewLineIndex(content), Hash: sha256.Sum256(content), trees: &files.trees,
 	}
+	files.insert(&file)
+}
+
+func (files *Files) insert(f *File) {
 	files.mu.Lock()
-	files.fs[handle] = &file
-	files.mu.Unlock()
+	defer files.mu.Unlock()
+	if previous, ok := files.fs[f.Handle]; ok {
+		f.open = previous.open
+		files.resident -= previous.counted
+	}
+	files.clock++
+	f.lastUsed = files.clock
+	files.fs[f.Handle] = f
+	files.account(f)
+	files.evict()
 }
 
 func (files *Files) Get(handle util.Handle) (*File, bool) {
 	files.mu.Lock()
 	file, ok := files.fs[handle]
+	evicted := false
+	if ok {
+		files.clock++
+		file.lastUsed = files.clock
+		evicted = file.evicted
+	}
 	files.mu.Unlock()
+	if evicted {
+		files.reload(file)
+	}
 	return file, ok
 }
 
@@ -186,40 +368,104 @@ func (files *Files) ModifyFull(path util.Path, content string) {
 	f, ok := files.GetFromPath(path)
 	if !ok {
 		logging.Logger.Error("file to modify not in file store", "path", path)
-		files.mu.Unlock()
 		return
 	}
 
 	files.mu.Lock()
 	f.mu.Lock()
-	f.Content = []byte(content)
-	f.Hash = sha256.Sum256(f.Content)
+	files.replaceContent(f, []byte(content))
 	f.mu.Unlock()
+	files.evict()
 
 	files.mu.Unlock()
 }
 
+// ModifyFromDisk replaces the content of the file at path with content read from disk, unless the file is open in the editor, whose buffer wins,
+// or already has it. The check and the change are made under the file's lock, so that changes from the editor can't come in between.
+// Returns whether the content changed.
+func (files *Files) ModifyFromDisk(path util.Path, content []byte) bool {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	f, ok := files.fs[util.FromPath(path)]
+	if !ok {
+		return false
+	}
+	f.mu.Lock()
+	changed := !f.open && f.Hash != sha256.Sum256(content)
+	if changed {
+		files.replaceContent(f, content)
+	}
+	f.mu.Unlock()
+	files.evict()
+	return changed
+}
+
+// Replaces the content of f, called with the mutexes of files and f held
+func (files *Files) replaceContent(f *File, content []byte) {
+	f.Content = content
+	f.lines = NewLineIndex(content)
+	f.Hash = sha256.Sum256(content)
+	f.evicted = false
+	f.resetTree()
+	files.account(f)
+}
+
 func (files *Files) ModifyIncremental(path util.Path, changeRange transport.Range, content string) {
-	logging.Logger.Info("Applying Incremental Change", "path", path)
+	files.ApplyChanges(path, 0, []transport.TextDocumentContentChangeEvent{{Range: &changeRange, Text: content}})
+}
+
+// ApplyChanges applies the content changes of a didChange notification bringing the document to version, in order, changes without a range replacing the whole content.
+// They are applied to a piece table as a whole, so that the content is laid out once for all of them rather than copied for each, and the syntax tree reparsed once.
+// Changes for a version that isn't newer than the file's are out of order or repeated and are rejected, returning false. Version 0 applies changes without checking it.
+func (files *Files) ApplyChanges(path util.Path, version int32, changes []transport.TextDocumentContentChangeEvent) bool {
+	logging.Logger.Info("Applying Incremental Changes", "path", path, "version", version, "changes", len(changes))
 
 	f, ok := files.GetFromPath(path)
 	if !ok {
 		logging.Logger.Error("file to modify not in file store", "path", path)
-		files.mu.Unlock()
-		return
+		return false
 	}
-	result := ApplyIncrementalChange(changeRange, content, string(f.Content), string(files.encoding))
-	//	logging.Logger.Info("Before/After Incremental Change", "before", string(f.Content), "after", result)
-	logging.Logger.Info("Incremental Change Parameters ", "range", changeRange, "content", content)
-	logging.Logger.Info("Before/After Incremental Change", "before", string(f.Content), "after", result)
 
 	files.mu.Lock()
+	defer files.mu.Unlock()
 	f.mu.Lock()
-	f.Content = []byte(result)
+	if version != 0 && version <= f.Version {
+		logging.Logger.Warn("Rejecting changes older than the document", "path", path, "version", version, "document_version", f.Version)
+		f.mu.Unlock()
+		return false
+	}
+	before := len(f.Content)
+	text := NewText(f.Content)
+	edits := []tree_sitter.InputEdit{}
+	for _, change := range changes {
+		if change.Range == nil {
+			text = NewText([]byte(change.Text))
+			f.lines = NewLineIndex(change.Text)
+			f.resetTree()
+			edits = edits[:0]
+			continue
+		}
+		logging.Logger.Info("Incremental Change Parameters", "range", *change.Range)
+		// The range is found and the lines after it are moved with the line index, rather than by scanning the content
+		start, _ := text.PositionToOffset(f.lines, change.Range.Start, string(files.encoding))
+		end, _ := text.PositionToOffset(f.lines, change.Range.End, string(files.encoding))
+		end = max(start, end)
+		edited := f.lines.Edited(start, end, change.Text)
+		edits = append(edits, incrementalEdit(start, end, change.Text, f.lines, edited))
+		text.Replace(start, end, change.Text)
+		f.lines = edited
+	}
+	f.Content = text.Bytes()
+	logging.Logger.Info("Before/After Incremental Change", "before_length", before, "after_length", len(f.Content))
 	f.Hash = sha256.Sum256(f.Content)
+	f.editTree(edits...)
+	if version != 0 {
+		f.Version = version
+	}
+	files.account(f)
 	f.mu.Unlock()
-
-	files.mu.Unlock()
+	files.evict()
+	return true
 }
 
 func (files *Files) CloseFromURI(uri util.URI) {
@@ -245,28 +491,58 @@ func (files *Files) Close(handle util.Handle) {
 		return
 	}
 	f.mu.Lock()
+	f.resetTree()
+	f.Version = 0
 	f.mu.Unlock()
 	files.mu.Unlock()
 }
 
 func (files *Files) RemoveFromPath(path util.Path) {
 	handle := util.FromPath(path)
-	files.mu.Lock()
-	delete(files.fs, handle)
-	files.mu.Unlock()
+	files.Remove(handle)
 }
 
 func (files *Files) RemoveFromURI(uri util.URI) {
 	handle, _ := util.FromURI(uri)
+	files.Remove(handle)
+}
+
+func (files *Files) Remove(handle util.Handle) {
 	files.mu.Lock()
+	f, ok := files.fs[handle]
 	delete(files.fs, handle)
+	if ok {
+		files.resident -= f.counted
+	}
 	files.mu.Unlock()
+	if ok {
+		f.mu.Lock()
+		f.resetTree()
+		f.mu.Unlock()
+	}
 }
 
-func (files *Files) Remove(handle util.Handle) {
+// LiveTrees returns the number of syntax trees files keep open
+func (files *Files) LiveTrees() int {
+	return files.trees.Live()
+}
+
+// Hashes of the contents of all files
+func (files *Files) hashes() map[[sha256.Size]byte]struct{} {
 	files.mu.Lock()
-	delete(files.fs, handle)
+	fs := make([]*File, 0, len(files.fs))
+	for _, f := range files.fs {
+		fs = append(fs, f)
+	}
 	files.mu.Unlock()
+
+	hashes := make(map[[sha256.Size]byte]struct{}, len(fs))
+	for _, f := range fs {
+		f.mu.RLock()
+		hashes[f.Hash] = struct{}{}
+		f.mu.RUnlock()
+	}
+	return hashes
 }
 
 func (files *Files) String() string {
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// WorkspaceFolderRoots returns the roots of the workspace folders of an initialize request,
+// or of its root URI for clients that don't send workspace folders
+func WorkspaceFolderRoots(rootURI transport.DocumentURI, folders []transport.WorkspaceFolder) []util.Path {
+	roots := []util.Path{}
+	for _, folder := range folders {
+		if root, err := util.URI2path(string(folder.URI)); err == nil && !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 && rootURI != "" {
+		if root, err := util.URI2path(string(rootURI)); err == nil {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// OwningWorkspace returns the workspace whose root is the deepest one containing path, or nil if none contains it
+func OwningWorkspace(workspaces []*Workspace, path util.Path) *Workspace {
+	var owner *Workspace
+	for _, w := range workspaces {
+		if w.Root != "" && isWithin(path, w.Root) && (owner == nil || len(w.Root) > len(owner.Root)) {
+			owner = w
+		}
+	}
+	return owner
+}
+
+// All workspace folders, starting with the first one
+func (s *Server) workspaces() []*Workspace {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	return append([]*Workspace{&s.Workspace}, s.folders...)
+}
+
+// Workspace folder owning path, whose config applies to it. Files outside every folder belong to the first one.
+func (s *Server) workspaceFor(path util.Path) *Workspace {
+	if w := OwningWorkspace(s.workspaces(), path); w != nil {
+		return w
+	}
+	return &s.Workspace
+}
+
+// Whether path is outside every workspace folder, so treated as read-only
+func (s *Server) isExternalFile(path util.Path) bool {
+	for _, w := range s.workspaces() {
+		if !w.IsExternalFile(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tracks the workspace folders at roots other than the first one, starting those that are new and stopping those that were removed.
+// The first folder stays s.Workspace, replaced with replaceFirstFolder once it is removed.
+func (s *Server) setFolders(ctx context.Context, roots []util.Path) {
+	s.foldersMu.Lock()
+	kept := []*Workspace{}
+	removed := []*Workspace{}
+	for _, w := range s.folders {
+		if slices.Contains(roots, w.Root) {
+			kept = append(kept, w)
+			continue
+		}
+		logging.Logger.Info("Removing workspace folder", "root", w.Root)
+		if cancel, ok := s.folderCancels[w.Root]; ok {
+			cancel()
+			delete(s.folderCancels, w.Root)
+		}
+		removed = append(removed, w)
+	}
+	added := []*Workspace{}
+	for _, root := range roots {
+		if root == s.Workspace.Root || slices.ContainsFunc(kept, func(w *Workspace) bool { return w.Root == root }) {
+			continue
+		}
+		added = append(added, &Workspace{Root: root})
+	}
+	s.folders = append(kept, added...)
+	remaining := slices.Clone(s.folders)
+	if s.folderCancels == nil {
+		s.folderCancels = make(map[util.Path]context.CancelFunc)
+	}
+	contexts := make(map[*Workspace]context.Context)
+	for _, w := range added {
+		folderCtx, cancel := context.WithCancel(ctx)
+		s.folderCancels[w.Root] = cancel
+		contexts[w] = folderCtx
+	}
+	s.foldersMu.Unlock()
+
+	for _, w := range removed {
+		s.closeFolder(w, remaining)
+	}
+	for _, w := range added {
+		logging.Logger.Info("Adding workspace folder", "root", w.Root)
+		w.Init(contexts[w], s)
+	}
+}
+
+// Forgets the workspace folder w, whose tracking was stopped. Its files inside one of the remaining folders are handed to it,
+// the others lose their diagnostics and leave the index and the overlay, except those the editor has open,
+// which the first folder keeps track of.
+func (s *Server) closeFolder(w *Workspace, remaining []*Workspace) {
+	if w != &s.Workspace {
+		remaining = append([]*Workspace{&s.Workspace}, remaining...)
+	}
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+
+	rediagnose := make(map[*Workspace]struct{})
+	for _, path := range files {
+		if owner := OwningWorkspace(remaining, path); owner != nil {
+			owner.addFile(path)
+			rediagnose[owner] = struct{}{}
+			continue
+		}
+		if w.isOpen(path) {
+			continue
+		}
+		if IsFaustFile(path) && s.diagChan != nil {
+			s.diagChan <- transport.PublishDiagnosticsParams{
+				URI:         transport.DocumentURI(util.Path2URI(path)),
+				Diagnostics: []transport.Diagnostic{},
+			}
+		}
+		s.Files.RemoveFromPath(path)
+		s.Store.Dependencies.RemoveDependenciesForFile(path)
+		os.Remove(w.TempDirPath(path))
+	}
+	for _, handle := range w.openedHandles() {
+		owner := OwningWorkspace(remaining, handle.Path)
+		if owner == nil {
+			owner = &s.Workspace
+		}
+		if owner != w {
+			owner.markOpen(handle)
+		}
+	}
+
+	// The overlay of the folder is only removed if it isn't part of the overlay of another folder, or containing one
+	shared := slices.ContainsFunc(remaining, func(other *Workspace) bool {
+		return other.Root != "" && (isWithin(w.Root, other.Root) || isWithin(other.Root, w.Root))
+	})
+	if !shared {
+		os.RemoveAll(w.TempDirPath(w.Root))
+	}
+	s.Store.ReleaseUnused()
+	for owner := range rediagnose {
+		owner.cleanDiagnostics(s)
+	}
+}
+
+// Makes the workspace folder at root the first one once the first one was removed, with others the roots of the other folders
+func (s *Server) replaceFirstFolder(ctx context.Context, root util.Path, others []util.Path) {
+	logging.Logger.Info("Removing first workspace folder", "root", s.Workspace.Root, "replacement", root)
+	s.primaryCancel()
+	s.Workspace.running.Wait()
+	s.foldersMu.Lock()
+	remaining := slices.DeleteFunc(slices.Clone(s.folders), func(w *Workspace) bool { return w.Root == root })
+	s.foldersMu.Unlock()
+	s.closeFolder(&s.Workspace, remaining)
+	// Stops tracking root as a folder other than the first one, so that its files are read again by the first one
+	s.Workspace.Root = ""
+	s.setFolders(ctx, others)
+
+	opened := s.Workspace.openedHandles()
+	primaryCtx, cancel := context.WithCancel(ctx)
+	s.primaryCancel = cancel
+	s.Workspace.Root = root
+	s.sessionRoot = root
+	s.Workspace.Init(primaryCtx, s)
+
+	// Documents open in the editor were kept with their unsaved changes, which the new overlay doesn't have
+	for _, handle := range opened {
+		owner := s.workspaceFor(handle.Path)
+		owner.markOpen(handle)
+		if owner != &s.Workspace || !isWithin(handle.Path, root) {
+			continue
+		}
+		if !slices.Contains(s.Workspace.Files, handle.Path) {
+			s.Workspace.addFile(handle.Path)
+		}
+		if f, ok := s.Files.GetFromPath(handle.Path); ok {
+			f.mu.RLock()
+			content := f.Content
+			f.mu.RUnlock()
+			s.Workspace.syncOverlay(handle.Path, content)
+		}
+		s.Workspace.DiagnoseFile(handle.Path, s)
+	}
+}
+
+// Roots of the workspace folders other than the first one
+func (s *Server) folderRoots() []util.Path {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	roots := []util.Path{}
+	for _, w := range s.folders {
+		roots = append(roots, w.Root)
+	}
+	return roots
+}
+
+// DidChangeWorkspaceFolders starts and stops tracking the workspace folders the client added and removed
+func DidChangeWorkspaceFolders(ctx context.Context, s *Server, par json.RawMessage) error {
+	var params transport.DidChangeWorkspaceFoldersParams
+	json.Unmarshal(par, &params)
+	if s.workspaceCtx == nil {
+		logging.Logger.Warn("Got workspace folder changes before the workspace was initialized")
+		return nil
+	}
+
+	roots := append([]util.Path{s.Workspace.Root}, s.folderRoots()...)
+	for _, folder := range params.Event.Removed {
+		if root, err := util.URI2path(string(folder.URI)); err == nil {
+			roots = slices.DeleteFunc(roots, func(r util.Path) bool { return r == root })
+		}
+	}
+	for _, folder := range params.Event.Added {
+		if root, err := util.URI2path(string(folder.URI)); err == nil && !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	// Documents opened outside every folder are tracked by the first one, so the last folder is kept
+	if len(roots) == 0 {
+		logging.Logger.Warn("Keeping the last workspace folder", "root", s.Workspace.Root)
+		return nil
+	}
+	if roots[0] != s.Workspace.Root {
+		s.replaceFirstFolder(s.workspaceCtx, roots[0], roots[1:])
+	} else {
+		s.setFolders(s.workspaceCtx, roots[1:])
+	}
+	// Added folders can have their own settings
+	if s.configurationSupport {
+		s.fetchSettings(s.workspaceCtx)
+	}
+	return nil
+}
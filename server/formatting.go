@@ -10,11 +10,18 @@ import (
 	"strings"
 
 	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
 	"github.com/carn181/faustlsp/transport"
 	"github.com/carn181/faustlsp/util"
 )
 
+// Format formats content with the built-in tree-sitter based formatter
 func Format(content []byte, indent string) ([]byte, error) {
+	return parser.Format(content, indent)
+}
+
+// FormatWithFaustfmt formats content with the external faustfmt formatter
+func FormatWithFaustfmt(content []byte, indent string) ([]byte, error) {
 	// TODO: Allow to take faustExec and customQueryFile from config file
 	faustExec := "faustfmt"
 
@@ -42,9 +49,13 @@ func Format(content []byte, indent string) ([]byte, error) {
 }
 
 func GetIndent(par transport.DocumentFormattingParams) string {
-	if par.Options.InsertSpaces {
+	return getIndentFromOptions(par.Options)
+}
+
+func getIndentFromOptions(options transport.FormattingOptions) string {
+	if options.InsertSpaces {
 		s := ""
-		for range par.Options.TabSize {
+		for range options.TabSize {
 			s += " "
 		}
 		return s
@@ -64,23 +75,36 @@ func Formatting(ctx context.Context, s *Server, par json.RawMessage) (json.RawMe
 	}
 
 	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to format non-existent path: %s", path)
+	}
+	// Files outside the workspace are read-only
+	if s.isExternalFile(path) {
+		logging.Logger.Info("Not formatting file outside workspace", "path", path)
+		return []byte("null"), nil
+	}
+	if s.hasLongLines(f) {
+		return []byte("null"), nil
+	}
+	f.mu.RLock()
 	content := f.Content
-	var output []byte
-	if ok {
-		output, err = Format(content, GetIndent(params))
-		if err != nil {
-			logging.Logger.Error("Format error", "error", err)
-		}
+	f.mu.RUnlock()
+
+	format := Format
+	if s.workspaceFor(path).ConfigFor(path).Formatter == FaustfmtFormatter {
+		format = FormatWithFaustfmt
+	}
+	output, err := format(content, GetIndent(params))
+	if err != nil {
+		// Don't replace the document with empty output
+		logging.Logger.Error("Format error", "error", err)
+		return []byte("null"), nil
 	}
-	logging.Logger.Info("Got this for formatting", "output", string(output))
 
-	endPos := transport.Position{Line: 0, Character: 0}
-	if ok {
-		endPos, err = getDocumentEndPosition(string(content), string(s.Files.encoding))
-		if err != nil {
-			logging.Logger.Error("OffsetToPosition error", "error", err)
-			endPos = transport.Position{Line: 0, Character: 0}
-		}
+	endPos, err := getDocumentEndPosition(string(content), string(s.Files.encoding))
+	if err != nil {
+		logging.Logger.Error("OffsetToPosition error", "error", err)
+		endPos = transport.Position{Line: 0, Character: 0}
 	}
 
 	edit := transport.TextEdit{
@@ -94,3 +118,76 @@ func Formatting(ctx context.Context, s *Server, par json.RawMessage) (json.RawMe
 
 	return resultBytes, err
 }
+
+func RangeFormatting(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.DocumentRangeFormattingParams
+	json.Unmarshal(par, &params)
+
+	logging.Logger.Info("Range formatting request", "params", string(par))
+	edits, err := s.formatRange(params.TextDocument.URI, params.Range, params.Options)
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(edits)
+}
+
+func OnTypeFormatting(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.DocumentOnTypeFormattingParams
+	json.Unmarshal(par, &params)
+
+	logging.Logger.Info("On type formatting request", "params", string(par))
+	// Format the statement that the typed character ended. The position is right after it, and the trigger characters take one unit in every encoding.
+	start := params.Position
+	if start.Character > 0 {
+		start.Character--
+	}
+	edits, err := s.formatRange(params.TextDocument.URI, transport.Range{Start: start, End: params.Position}, params.Options)
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(edits)
+}
+
+// Formats the top-level statements overlapping a range of a document using the built-in formatter
+func (s *Server) formatRange(uri transport.DocumentURI, r transport.Range, options transport.FormattingOptions) ([]transport.TextEdit, error) {
+	path, err := util.URI2path(string(uri))
+	if err != nil {
+		return nil, err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return nil, fmt.Errorf("trying to format non-existent path: %s", path)
+	}
+	if s.isExternalFile(path) || s.hasLongLines(f) {
+		return []transport.TextEdit{}, nil
+	}
+	f.mu.RLock()
+	content := string(f.Content)
+	f.mu.RUnlock()
+
+	encoding := string(s.Files.encoding)
+	start, err := PositionToOffset(r.Start, content, encoding)
+	if err != nil {
+		return nil, err
+	}
+	end, err := PositionToOffset(r.End, content, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	output, replaceStart, replaceEnd, err := parser.FormatRange([]byte(content), getIndentFromOptions(options), start, end)
+	if err != nil {
+		// Nothing to format in range
+		logging.Logger.Info("Range format error", "error", err)
+		return []transport.TextEdit{}, nil
+	}
+
+	startPos, _ := OffsetToPosition(replaceStart, content, encoding)
+	endPos, _ := OffsetToPosition(replaceEnd, content, encoding)
+	return []transport.TextEdit{
+		{
+			Range:   transport.Range{Start: startPos, End: endPos},
+			NewText: string(output),
+		},
+	}, nil
+}
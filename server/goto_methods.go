@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/parser"
 	"github.com/carn181/faustlsp/transport"
 	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
 func GetDefinition(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
@@ -29,12 +31,26 @@ func GetDefinition(ctx context.Context, s *Server, par json.RawMessage) (json.Ra
 		logging.Logger.Error("File should've been in server file store", "path", path)
 	}
 
-	offset, err := PositionToOffset(params.Position, string(f.Content), string(s.Files.encoding))
+	offset, err := f.PositionToOffset(params.Position, string(s.Files.encoding))
 	if err != nil {
 		return []byte{}, err
 	}
 
-	ident, scope := FindSymbolScope(f.Content, f.Scope, offset)
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	// Paths of imports, libraries and components go to the file they import
+	if imported, ok := ImportAt(root, content, offset); ok {
+		w := s.workspaceFor(path)
+		resolvedPath, _ := w.ResolveFilePath(imported.Path, w.ImportRoot(path))
+		if resolvedPath == "" {
+			return []byte("null"), nil
+		}
+		return json.Marshal(transport.Location{URI: transport.DocumentURI(util.Path2URI(resolvedPath))})
+	}
+
+	ident, scope := FindSymbolScope(root, content, f.Scope, offset)
 
 	logging.Logger.Info("Got symbol at Location", "symbol", ident, "scope_exists", f.Scope != nil)
 
@@ -43,44 +59,8 @@ func GetDefinition(ctx context.Context, s *Server, par json.RawMessage) (json.Ra
 		return []byte("null"), nil
 	}
 
-	var loc Location
-	identSplit := strings.Split(ident, ".")
-
-	if len(identSplit) > 1 {
-		logging.Logger.Info("Resolving library symbol", "symbol", identSplit)
-		for i := range len(identSplit) - 1 {
-			libIdent := identSplit[i]
-
-			// Resolve as Environment
-			sym, err := FindEnvironmentIdent(libIdent, scope, &s.Store)
-			logging.Logger.Info("Resolved environment", "env", libIdent, "sym", sym.Ident, "loc", sym.Loc)
-			if err == nil {
-				loc = sym.Loc
-				scope = sym.Scope
-				continue
-			}
-
-			// Resolve as Library if not resolved as environment
-			file, err := FindLibraryIdent(libIdent, scope, &s.Store)
-			if err != nil {
-				break
-			}
-			logging.Logger.Info("Resolved library environment", "env", libIdent, "location", file)
-			f, ok := s.Store.Files.GetFromPath(file)
-			if ok {
-				f.mu.RLock()
-				logging.Logger.Info("Setting New Scope to", "path", file)
-				scope = f.Scope
-				f.mu.RUnlock()
-				if scope == nil {
-					break
-				}
-			}
-		}
-	}
-	ident = identSplit[len(identSplit)-1]
-
-	loc, err = FindDefinition(ident, scope, &s.Store)
+	sym, err := ResolveSymbol(ident, scope, &s.Store)
+	loc := sym.Loc
 
 	logging.Logger.Info("Got definition as", "location", loc, "error", err)
 	if err == nil {
@@ -114,12 +94,76 @@ func Hover(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage
 		logging.Logger.Error("File should've been in server file store", "path", path)
 	}
 
-	offset, err := PositionToOffset(params.Position, string(f.Content), string(s.Files.encoding))
+	offset, err := f.PositionToOffset(params.Position, string(s.Files.encoding))
 	if err != nil {
 		return []byte{}, err
 	}
 
-	ident, scope := FindSymbolScope(f.Content, f.Scope, offset)
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	// Composition operators
+	compiledArity := func(node *tree_sitter.Node) (Arity, bool) {
+		return s.compiledArity(node, content, path)
+	}
+	if docs, ok := operatorHover(root, content, offset, s.Store.Precision, compiledArity); ok {
+		logging.Logger.Info("Got operator hover", "docs", docs)
+		result, err := json.Marshal(transport.Hover{
+			Contents: transport.MarkupContent{
+				Kind:  transport.Markdown,
+				Value: docs,
+			},
+		})
+		return result, err
+	}
+
+	// Widgets of process files, with what the compiler resolved of them
+	if docs, ok := s.uiElementHover(ctx, path, root, content, offset); ok {
+		return json.Marshal(transport.Hover{
+			Contents: transport.MarkupContent{
+				Kind:  transport.Markdown,
+				Value: docs,
+			},
+		})
+	}
+
+	// Primitives only some compiler versions know
+	version := s.probeCompiler(s.workspaceFor(path).ConfigFor(path).Command).Version
+	if docs, ok := PrimitiveHover(root, content, offset, version); ok {
+		return json.Marshal(transport.Hover{
+			Contents: transport.MarkupContent{
+				Kind:  transport.Markdown,
+				Value: docs,
+			},
+		})
+	}
+
+	// Declare statements and imported files, with the metadata they declare
+	w := s.workspaceFor(path)
+	resolve := func(importPath string) util.Path {
+		resolvedPath, _ := w.ResolveFilePath(importPath, w.ImportRoot(path))
+		return resolvedPath
+	}
+	read := func(path util.Path) ([]byte, bool) {
+		if imported, ok := s.Files.GetFromPath(path); ok {
+			imported.mu.RLock()
+			defer imported.mu.RUnlock()
+			return imported.Content, true
+		}
+		content, err := os.ReadFile(path)
+		return content, err == nil
+	}
+	if docs, ok := MetadataHover(root, content, offset, resolve, read); ok {
+		return json.Marshal(transport.Hover{
+			Contents: transport.MarkupContent{
+				Kind:  transport.Markdown,
+				Value: docs,
+			},
+		})
+	}
+
+	ident, scope := FindSymbolScope(root, content, f.Scope, offset)
 
 	logging.Logger.Info("Got symbol at Location", "symbol", ident, "scope_exists", f.Scope != nil)
 
@@ -128,42 +172,18 @@ func Hover(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage
 		return []byte("null"), nil
 	}
 
-	identSplit := strings.Split(ident, ".")
-
-	if len(identSplit) > 1 {
-		logging.Logger.Info("Resolving library symbol", "symbol", identSplit)
-		for i := range len(identSplit) - 1 {
-			libIdent := identSplit[i]
-
-			// Resolve as Environment
-			sym, err := FindEnvironmentIdent(libIdent, scope, &s.Store)
-			logging.Logger.Info("Resolved environment", "env", libIdent, "sym", sym.Ident, "loc", sym.Loc)
-			if err == nil {
-				scope = sym.Scope
-				continue
-			}
+	sym, err := ResolveSymbol(ident, scope, &s.Store)
+	docs := ""
+	if err == nil {
+		docs = s.symbolDocumentation(sym)
+	}
 
-			// Resolve as Library if not resolved as environment
-			file, err := FindLibraryIdent(libIdent, scope, &s.Store)
-			if err != nil {
-				break
-			}
-			logging.Logger.Info("Resolved library environment", "env", libIdent, "location", file)
-			f, ok := s.Store.Files.GetFromPath(file)
-			if ok {
-				f.mu.RLock()
-				logging.Logger.Info("Setting New Scope to", "path", file)
-				scope = f.Scope
-				f.mu.RUnlock()
-				if scope == nil {
-					break
-				}
-			}
+	// The process of a file shows its block diagram
+	if err == nil && IsDSPFile(path) && ident == s.workspaceFor(path).ConfigFor(path).processName() && sym.Loc.File == path {
+		if diagram, ok := s.diagramHover(path); ok {
+			docs = strings.TrimSpace(docs + "\n\n" + diagram)
 		}
 	}
-	ident = identSplit[len(identSplit)-1]
-
-	docs, err := FindDocs(ident, scope, &s.Store)
 
 	logging.Logger.Info("Got docs as", "documentation", docs, "error", err)
 	if err == nil {
@@ -199,12 +219,14 @@ func GetReferences(ctx context.Context, s *Server, par json.RawMessage) (json.Ra
 		logging.Logger.Error("File should've been in server file store", "path", path)
 	}
 
-	offset, err := PositionToOffset(params.Position, string(f.Content), string(s.Files.encoding))
+	offset, err := f.PositionToOffset(params.Position, string(s.Files.encoding))
 	if err != nil {
 		return []byte{}, err
 	}
 
-	ident, scope := FindSymbolScope(f.Content, f.Scope, offset)
+	tree, content := f.Tree()
+	defer tree.Close()
+	ident, scope := FindSymbolScope(tree.RootNode(), content, f.Scope, offset)
 
 	logging.Logger.Info("Got symbol at Location", "symbol", ident, "scope", f.Scope == nil)
 
@@ -288,9 +310,9 @@ func GetRefsForFile(ident string, path util.Path, store *Store) []Location {
 	locations := []Location{}
 
 	// Parse through Scope
-	tree := parser.ParseTree(f.Content)
+	tree, content := f.Tree()
 	defer tree.Close()
-	results := parser.GetQueryMatches(RefQuery(ident), f.Content, tree)
+	results := parser.GetQueryMatches(RefQuery(ident), content, tree)
 
 	totalRefs := make(map[transport.Range]struct{})
 	for _, result := range results.Results {
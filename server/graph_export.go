@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command returning the import graph of the workspace as Graphviz DOT or JSON text
+const exportDependencyGraphCommand = "faustlsp.exportDependencyGraph"
+
+// Argument of the faustlsp.exportDependencyGraph command
+type ExportDependencyGraphArgs struct {
+	// dot or json
+	Format string `json:"format,omitempty"`
+}
+
+func ExportDependencyGraphCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	var args ExportDependencyGraphArgs
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments[0], &args); err != nil {
+			return []byte("null"), err
+		}
+	}
+	graph := s.importGraph()
+	switch args.Format {
+	case "dot":
+		return json.Marshal(graph.DOT(s.Workspace.Root))
+	case "", "json":
+		content, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			return []byte("null"), err
+		}
+		return json.Marshal(string(content))
+	}
+	return []byte("null"), fmt.Errorf("unknown graph format %s, expected dot or json", args.Format)
+}
+
+// DOT renders the graph for Graphviz. Files are labelled with their path relative to root,
+// files outside it with their name and a dashed outline, and imports with the definitions bound to them.
+func (g ImportGraph) DOT(root util.Path) string {
+	var b strings.Builder
+	b.WriteString("digraph imports {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, node := range g.Nodes {
+		label, external := string(node), false
+		if path, err := util.URI2path(string(node)); err == nil {
+			rel, err := filepath.Rel(root, path)
+			external = err != nil || strings.HasPrefix(rel, "..")
+			if external {
+				label = filepath.Base(path)
+			} else {
+				label = filepath.ToSlash(rel)
+			}
+		}
+		attributes := "label=" + dotQuote(label)
+		if external {
+			attributes += ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(string(node)), attributes)
+	}
+	for _, edge := range g.Edges {
+		definitions := []string{}
+		for _, binding := range g.Bindings {
+			if binding.From == edge.From && binding.To == edge.To && !slices.Contains(definitions, binding.Definition) {
+				definitions = append(definitions, binding.Definition)
+			}
+		}
+		fmt.Fprintf(&b, "\t%s -> %s", dotQuote(string(edge.From)), dotQuote(string(edge.To)))
+		if len(definitions) > 0 {
+			fmt.Fprintf(&b, " [label=%s]", dotQuote(strings.Join(definitions, ", ")))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
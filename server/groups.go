@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Command wrapping an expression in a UI group. Clients can prompt for the label and pass it in the command's arguments.
+const wrapInGroupCommand = "faustlsp.wrapInGroup"
+
+// Label of groups created without one
+const defaultGroupLabel = "group"
+
+var groupTypes = []string{"hgroup", "vgroup", "tgroup"}
+
+// Nodes that create UI elements
+var uiKinds = map[string]struct{}{
+	"button":         {},
+	"checkbox":       {},
+	"numeric_widget": {},
+	"bargraph":       {},
+	"group":          {},
+}
+
+// Argument of the faustlsp.wrapInGroup command
+type WrapInGroupArgs struct {
+	URI   transport.DocumentURI `json:"uri"`
+	Range transport.Range       `json:"range"`
+	// hgroup, vgroup or tgroup
+	Group string `json:"group"`
+	Label string `json:"label,omitempty"`
+}
+
+// GroupActions returns source actions wrapping the expression selected by r in each kind of group, if it contains UI elements
+func GroupActions(root *tree_sitter.Node, content []byte, uri transport.DocumentURI, r transport.Range, encoding string) []transport.CodeAction {
+	actions := []transport.CodeAction{}
+	if _, ok := wrapInGroupEdit(root, content, r, encoding, groupTypes[0], defaultGroupLabel); !ok {
+		return actions
+	}
+	for _, group := range groupTypes {
+		args, _ := json.Marshal(WrapInGroupArgs{URI: uri, Range: r, Group: group})
+		actions = append(actions, transport.CodeAction{
+			Title: fmt.Sprintf("Wrap in %s", group),
+			Kind:  transport.Source,
+			Command: &transport.Command{
+				Title:     fmt.Sprintf("Wrap in %s", group),
+				Command:   wrapInGroupCommand,
+				Arguments: []json.RawMessage{args},
+			},
+		})
+	}
+	return actions
+}
+
+func WrapInGroupCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", wrapInGroupCommand, len(arguments))
+	}
+	var args WrapInGroupArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+
+	path, err := util.URI2path(string(args.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	if s.isExternalFile(path) {
+		return []byte("null"), fmt.Errorf("refusing to edit read-only file outside workspace: %s", path)
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to wrap expression of non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	edit, ok := WrapInGroupEdit(root, content, args.Range, string(s.Files.encoding), args.Group, args.Label)
+	if !ok {
+		return []byte("null"), fmt.Errorf("can't wrap selection in %s", args.Group)
+	}
+	err = s.applyEdit(fmt.Sprintf("Wrap in %s", args.Group), transport.WorkspaceEdit{
+		Changes: map[transport.DocumentURI][]transport.TextEdit{args.URI: {edit}},
+	})
+	return []byte("null"), err
+}
+
+// WrapInGroupEdit returns the edit wrapping the expression selected by r in group("label", ...), if it contains UI elements
+func WrapInGroupEdit(root *tree_sitter.Node, content []byte, r transport.Range, encoding string, group string, label string) (transport.TextEdit, bool) {
+	if !slices.Contains(groupTypes, group) {
+		return transport.TextEdit{}, false
+	}
+	if label == "" {
+		label = defaultGroupLabel
+	}
+	return wrapInGroupEdit(root, content, r, encoding, group, label)
+}
+
+func wrapInGroupEdit(root *tree_sitter.Node, content []byte, r transport.Range, encoding string, group string, label string) (transport.TextEdit, bool) {
+	start, err := PositionToOffset(r.Start, string(content), encoding)
+	if err != nil {
+		return transport.TextEdit{}, false
+	}
+	end, err := PositionToOffset(r.End, string(content), encoding)
+	if err != nil || end <= start {
+		return transport.TextEdit{}, false
+	}
+	for start < end && isSpace(content[start]) {
+		start++
+	}
+	for end > start && isSpace(content[end-1]) {
+		end--
+	}
+
+	node := selectedExpression(root, content, start, end)
+	if node == nil || enclosingDefinition(node) == nil || !containsUI(node) {
+		return transport.TextEdit{}, false
+	}
+
+	startPos, _ := OffsetToPosition(start, string(content), encoding)
+	endPos, _ := OffsetToPosition(end, string(content), encoding)
+	escaped := strings.ReplaceAll(label, `"`, `\"`)
+	return transport.TextEdit{
+		Range:   transport.Range{Start: startPos, End: endPos},
+		NewText: fmt.Sprintf("%s(\"%s\", %s)", group, escaped, string(content[start:end])),
+	}, true
+}
+
+func containsUI(node *tree_sitter.Node) bool {
+	if _, ok := uiKinds[node.Kind()]; ok {
+		return true
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if containsUI(node.NamedChild(i)) {
+			return true
+		}
+	}
+	return false
+}
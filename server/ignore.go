@@ -0,0 +1,150 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/carn181/faustlsp/util"
+)
+
+// Patterns of files never scanned, copied or watched in workspaces: version control, dependencies and generated diagrams
+var defaultExcludes = []string{".git/", ".hg/", ".svn/", "node_modules/", ".venv/", "__pycache__/", "*-svg/"}
+
+// Git's ignore file, only read at the workspace root
+const gitignoreFile = ".gitignore"
+
+// IgnoreRules tells which paths of a workspace are left out, with the patterns of .gitignore files
+type IgnoreRules struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	segments []string // Slash-separated segments, where ** matches any number of them
+	negate   bool     // Patterns starting with ! include paths excluded by previous ones again
+	dirOnly  bool     // Patterns ending with / only match directories
+}
+
+// NewIgnoreRules parses patterns, lines of a .gitignore file. Later patterns take precedence over earlier ones.
+func NewIgnoreRules(patterns []string) IgnoreRules {
+	var rules IgnoreRules
+	for _, line := range patterns {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// Patterns without a slash in them match names at any depth, others are relative to the root
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		p.segments = strings.Split(line, "/")
+		rules.patterns = append(rules.patterns, p)
+	}
+	return rules
+}
+
+// Ignored tells if rel, a slash-separated path relative to the root, is left out, or is in a directory that is
+func (r IgnoreRules) Ignored(rel string, isDir bool) bool {
+	segments := strings.Split(strings.Trim(rel, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if r.matches(segments[:i], true) {
+			return true
+		}
+	}
+	return r.matches(segments, isDir)
+}
+
+func (r IgnoreRules) matches(segments []string, isDir bool) bool {
+	ignored := false
+	for _, p := range r.patterns {
+		if (!p.dirOnly || isDir) && matchSegments(p.segments, segments) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segments[0])
+	return err == nil && ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// Reads the ignore rules of the workspace: the default ones, then those of its .gitignore, its build directory and the exclude patterns of its config
+func (w *Workspace) loadIgnoreRules() {
+	patterns := append([]string{}, defaultExcludes...)
+	if content, err := os.ReadFile(filepath.Join(w.Root, gitignoreFile)); err == nil {
+		patterns = append(patterns, strings.Split(string(content), "\n")...)
+	}
+	config := w.config()
+	if config.BuildDir != "" && !filepath.IsAbs(config.BuildDir) {
+		patterns = append(patterns, "/"+filepath.ToSlash(filepath.Clean(config.BuildDir))+"/")
+	}
+	patterns = append(patterns, config.Exclude...)
+	rules := NewIgnoreRules(patterns)
+
+	w.mu.Lock()
+	w.ignore = rules
+	w.mu.Unlock()
+}
+
+// Ignored tells if the file or directory at path is in the workspace but left out of its scanning and watching.
+// The config file and the snippets file are never left out.
+func (w *Workspace) Ignored(path util.Path, isDir bool) bool {
+	rel, err := filepath.Rel(w.Root, path)
+	if w.Root == "" || err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if rel == faustConfigFile || rel == filepath.FromSlash(snippetsFile) {
+		return false
+	}
+	w.mu.Lock()
+	rules := w.ignore
+	w.mu.Unlock()
+	return rules.Ignored(filepath.ToSlash(rel), isDir)
+}
+
+// Walks the workspace like filepath.Walk, leaving out the files and directories it ignores
+func (w *Workspace) walk(fn filepath.WalkFunc) error {
+	return w.walkFrom(w.Root, fn)
+}
+
+// Walks dir, a directory of the workspace, like walk
+func (w *Workspace) walkFrom(dir util.Path, fn filepath.WalkFunc) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && w.Ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(path, info, err)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// String argument of an import, component or library call being typed, like import("filters.
+var importPathPattern = regexp.MustCompile(`\b(?:import|component|library)\s*\(\s*"([^"\n]*)$`)
+
+// ImportPathAt returns the part of the path typed before offset in content when it is inside the string argument of import, component or library
+func ImportPathAt(content []byte, offset uint) (string, bool) {
+	// Paths are on the line of the call
+	lineStart := 0
+	if i := strings.LastIndexByte(string(content[:offset]), '\n'); i >= 0 {
+		lineStart = i + 1
+	}
+	match := importPathPattern.FindSubmatch(content[lineStart:offset])
+	if match == nil {
+		return "", false
+	}
+	return string(match[1]), true
+}
+
+// ImportPathCompletionItems completes typed, the start of a path imported by the file at path, with the .lib and .dsp files and the directories
+// found in dirs, the first of which wins for entries of the same name. r is the range of the last element of typed, which items replace.
+func ImportPathCompletionItems(typed string, path util.Path, dirs []util.Path, r transport.Range) []transport.CompletionItem {
+	typedDir, typedName := "", typed
+	if i := strings.LastIndexByte(typed, '/'); i >= 0 {
+		typedDir, typedName = typed[:i+1], typed[i+1:]
+	}
+	plainText := transport.PlainTextTextFormat
+	items := []transport.CompletionItem{}
+	seen := map[string]struct{}{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(dir, filepath.FromSlash(typedDir)))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			entryPath := filepath.Join(dir, filepath.FromSlash(typedDir), name)
+			if _, ok := seen[name]; ok || strings.HasPrefix(name, ".") || !strings.HasPrefix(name, typedName) || entryPath == path {
+				continue
+			}
+			item := transport.CompletionItem{
+				Label:  name,
+				Kind:   transport.FileCompletion,
+				Detail: entryPath,
+				// Libraries and files come first, as directories are only on the way to them
+				SortText:         "0" + name,
+				InsertTextFormat: &plainText,
+				TextEdit:         transport.TextEdit{NewText: name, Range: r},
+			}
+			switch {
+			case entry.IsDir():
+				item.Kind = transport.FolderCompletion
+				item.Label += "/"
+				item.SortText = "1" + name
+				item.TextEdit = transport.TextEdit{NewText: name + "/", Range: r}
+			case !IsFaustFile(name):
+				continue
+			}
+			seen[name] = struct{}{}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Directories paths imported by the file at path are completed from: its own directory, then those imports are looked up in
+func (w *Workspace) importCompletionDirs(path util.Path) []util.Path {
+	dirs := []util.Path{filepath.Dir(path)}
+	for _, dir := range w.ImportSearchPath(w.ImportRoot(path)) {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
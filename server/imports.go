@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	"github.com/fsnotify/fsnotify"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Diagnostic code of warnings about imports using absolute paths
+const nonPortableImportCode = "non-portable-import"
+
+// ImportedFile is a file imported by import("..."), library("...") or component("...")
+type ImportedFile struct {
+	Path string
+	// Range of the path's string literal, including quotes
+	Range transport.Range
+	// Name of the definition a library is bound to, like fi in fi = library("filters.lib"), empty for imports
+	Definition string
+}
+
+// Kinds of nodes importing the file of their filename field
+var importKinds = map[string]struct{}{
+	"file_import": {},
+	"library":     {},
+	"component":   {},
+}
+
+// ImportedFiles returns the files imported in content with import, library or component
+func ImportedFiles(root *tree_sitter.Node, content []byte) []ImportedFile {
+	imports := []ImportedFile{}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if _, ok := importKinds[node.Kind()]; ok {
+			if fileName := node.ChildByFieldName("filename"); fileName != nil {
+				imported := ImportedFile{
+					Path:  stripQuotes(fileName.Utf8Text(content)),
+					Range: ToRange(fileName),
+				}
+				if node.Kind() == "library" {
+					imported.Definition = bindingName(node, content)
+				}
+				imports = append(imports, imported)
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return imports
+}
+
+// ImportAt returns the file imported by the import, library or component whose path string contains offset
+func ImportAt(root *tree_sitter.Node, content []byte, offset uint) (ImportedFile, bool) {
+	for _, imported := range ImportedFiles(root, content) {
+		start, err := PositionToOffset(imported.Range.Start, string(content), string(transport.UTF8))
+		if err != nil {
+			continue
+		}
+		end, err := PositionToOffset(imported.Range.End, string(content), string(transport.UTF8))
+		if err != nil {
+			continue
+		}
+		if start <= offset && offset <= end {
+			return imported, true
+		}
+	}
+	return ImportedFile{}, false
+}
+
+// Name of the innermost definition node is part of
+func bindingName(node *tree_sitter.Node, content []byte) string {
+	for parent := node.Parent(); parent != nil; parent = parent.Parent() {
+		if ident := definitionIdentifier(parent); ident != nil {
+			return ident.Utf8Text(content)
+		}
+	}
+	return ""
+}
+
+// ImportPathDiagnostics warns about imports with absolute paths, which break when the project is moved to another machine
+func ImportPathDiagnostics(root *tree_sitter.Node, content []byte) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	for _, imp := range ImportedFiles(root, content) {
+		if !filepath.IsAbs(imp.Path) {
+			continue
+		}
+		diagnostics = append(diagnostics, transport.Diagnostic{
+			Range:    imp.Range,
+			Severity: transport.DiagnosticSeverity(transport.Warning),
+			Code:     nonPortableImportCode,
+			Source:   "faustlsp",
+			Message:  fmt.Sprintf("Import of absolute path %s is not portable. Use a path relative to the project or an include directory instead", imp.Path),
+		})
+	}
+	return diagnostics
+}
+
+// PortableImportPath returns the path an absolute import path of the file at importingFile could be replaced with.
+// Paths are made relative to the first include directory, import root, FAUST_LIB_PATH directory or Faust library directory containing them,
+// else relative to the directory of importingFile.
+func (w *Workspace) PortableImportPath(importingFile util.Path, path util.Path) (string, bool) {
+	dirs := []util.Path{}
+	for _, dir := range w.config().IncludeDir {
+		if !filepath.IsAbs(dir) {
+			dir = w.Rel2Abs(dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, w.ImportRoot(importingFile))
+	dirs = append(dirs, filepath.SplitList(os.Getenv(faustLibPathEnv))...)
+	dirs = append(dirs, w.GetFaustDSPDir())
+
+	for _, dir := range dirs {
+		if dir != "" && isWithin(path, dir) {
+			rel, err := filepath.Rel(dir, path)
+			if err == nil {
+				return filepath.ToSlash(rel), true
+			}
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(importingFile), path)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Whether path is inside dir
+func isWithin(path util.Path, dir util.Path) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Whether a file is outside the workspace. Such files are indexed and watched, but treated as read-only.
+func (w *Workspace) IsExternalFile(path util.Path) bool {
+	return w.Root != "" && !isWithin(path, w.Root)
+}
+
+// Watches imported files that were found through an absolute path or an include directory outside the workspace.
+// Files from the Faust library directory aren't watched as they rarely change.
+func (w *Workspace) indexImport(importPath string, resolvedPath util.Path, dir util.Path) {
+	if resolvedPath == "" {
+		return
+	}
+	if filepath.IsAbs(importPath) || w.isIncludeDir(dir) {
+		w.watchExternalFile(resolvedPath)
+	}
+}
+
+func (w *Workspace) isIncludeDir(dir util.Path) bool {
+	for _, includeDir := range w.config().IncludeDir {
+		if !filepath.IsAbs(includeDir) {
+			includeDir = w.Rel2Abs(includeDir)
+		}
+		if includeDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Starts watching an imported file outside the workspace so that changes to it are picked up
+func (w *Workspace) watchExternalFile(path util.Path) {
+	if path == "" || !w.IsExternalFile(path) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.externalFiles[path]; ok {
+		return
+	}
+	w.externalFiles[path] = struct{}{}
+	// Files found before the watcher starts are added when it does
+	if w.watcher != nil {
+		w.addExternalWatch(path)
+	}
+}
+
+func (w *Workspace) addExternalWatch(path util.Path) {
+	if err := w.watcher.Add(path); err != nil {
+		logging.Logger.Error("Couldn't watch external file", "path", path, "error", err)
+		return
+	}
+	logging.Logger.Info("Watching external file", "path", path)
+}
+
+func (w *Workspace) isWatchedExternalFile(path util.Path) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.externalFiles[path]
+	return ok
+}
+
+// Reloads external files from disk on changes. Their copies in the overlay are left as they are, as they aren't part of the workspace.
+func (w *Workspace) handleExternalDiskEvent(event fsnotify.Event, path util.Path, s *Server) {
+	logging.Logger.Info("Got disk event for external file", "path", path, "event", event)
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		w.mu.Lock()
+		delete(w.externalFiles, path)
+		w.mu.Unlock()
+		s.Files.RemoveFromPath(path)
+
+		// Editors often save by replacing the file, so keep watching it if it still exists
+		if util.IsValidPath(path) {
+			s.Files.OpenFromPath(path)
+			w.watchExternalFile(path)
+		}
+	}
+	if event.Has(fsnotify.Write) {
+		if _, ok := s.Files.GetFromPath(path); !ok {
+			s.Files.OpenFromPath(path)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		s.Files.ModifyFull(path, string(content))
+	}
+
+	if f, ok := s.Files.GetFromPath(path); ok {
+		go w.AnalyzeFile(f, &s.Store)
+	}
+}
+
+func CodeAction(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.CodeActionParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte{}, err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte{}, fmt.Errorf("trying to get code actions from non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	actions := []transport.CodeAction{}
+	for _, imp := range ImportedFiles(root, content) {
+		if !filepath.IsAbs(imp.Path) || !rangesOverlap(imp.Range, params.Range) {
+			continue
+		}
+		portable, ok := s.workspaceFor(path).PortableImportPath(path, imp.Path)
+		if !ok {
+			continue
+		}
+		diagnostics := []transport.Diagnostic{}
+		for _, d := range params.Context.Diagnostics {
+			if d.Range == imp.Range {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		actions = append(actions, transport.CodeAction{
+			Title:       fmt.Sprintf("Import \"%s\" instead", portable),
+			Kind:        transport.QuickFix,
+			Diagnostics: diagnostics,
+			IsPreferred: true,
+			Edit: &transport.WorkspaceEdit{
+				Changes: map[transport.DocumentURI][]transport.TextEdit{
+					params.TextDocument.URI: {{Range: imp.Range, NewText: fmt.Sprintf("\"%s\"", portable)}},
+				},
+			},
+		})
+	}
+	actions = append(actions, GroupActions(root, content, params.TextDocument.URI, params.Range, string(s.Files.encoding))...)
+	actions = append(actions, ExtractActions(root, content, params.TextDocument.URI, params.Range, string(s.Files.encoding))...)
+	actions = append(actions, MissingImportActions(root, content, params.TextDocument.URI, params.Range, params.Context.Diagnostics)...)
+	actions = append(actions, s.workspaceFor(path).IncludeDirActions(params.Context.Diagnostics)...)
+	actions = append(actions, UnresolvedImportActions(root, content, params.TextDocument.URI, params.Range, params.Context.Diagnostics, s.workspaceFor(path).importCandidates(path))...)
+	SortCodeActions(actions)
+	logging.Logger.Info("Code actions", "actions", actions)
+
+	return json.Marshal(actions)
+}
+
+func rangesOverlap(a, b transport.Range) bool {
+	before := func(p, q transport.Position) bool {
+		return p.Line < q.Line || (p.Line == q.Line && p.Character < q.Character)
+	}
+	return !before(a.End, b.Start) && !before(b.End, a.Start)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command adding a directory to the include directories of the project config
+const addIncludeDirCommand = "faustlsp.addIncludeDir"
+
+// Compiler errors about imported files it couldn't find, like "ERROR : unable to open file filters2.lib"
+var cannotOpenFilePattern = regexp.MustCompile(`(?:cannot|unable to) open file\s*:?\s*"?([^"\s]+)"?`)
+
+// Argument of the faustlsp.addIncludeDir command
+type AddIncludeDirArgs struct {
+	// Directory relative to the workspace root
+	Dir util.Path `json:"dir"`
+	// Root of the workspace folder whose config is changed, the first one if empty
+	Root util.Path `json:"root,omitempty"`
+}
+
+// MissingImportFile returns the imported file a compiler error says can't be opened
+func MissingImportFile(message string) (string, bool) {
+	captures := cannotOpenFilePattern.FindStringSubmatch(message)
+	if captures == nil {
+		return "", false
+	}
+	return captures[1], true
+}
+
+// InferIncludeDir returns the directory relative to root that importPath can be found in among files, for imports that weren't found.
+// The first directory in lexical order is chosen when several contain the file.
+func InferIncludeDir(files []util.Path, root util.Path, importPath string) (util.Path, bool) {
+	if filepath.IsAbs(importPath) {
+		return "", false
+	}
+	suffix := "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(importPath)), "./")
+	dirs := []util.Path{}
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = "/" + filepath.ToSlash(rel)
+		if dir, ok := strings.CutSuffix(rel, suffix); ok && dir != "" {
+			dirs = append(dirs, filepath.FromSlash(strings.TrimPrefix(dir, "/")))
+		}
+	}
+	if len(dirs) == 0 {
+		return "", false
+	}
+	return slices.Min(dirs), true
+}
+
+// Directory of the workspace containing missing, an imported file that wasn't found
+func (w *Workspace) includeDirFor(missing string) (util.Path, bool) {
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+	dir, ok := InferIncludeDir(files, w.Root, missing)
+	if !ok || w.isIncludeDir(w.Rel2Abs(dir)) {
+		return "", false
+	}
+	return dir, true
+}
+
+// Include directories of the files imported in the workspace that can't be found otherwise, for projects with "infer_include"
+func (w *Workspace) inferredIncludeDirs(s *Server) []util.Path {
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+	inferred := []util.Path{}
+	for _, path := range files {
+		if !IsFaustFile(path) {
+			continue
+		}
+		f, ok := s.Files.GetFromPath(path)
+		if !ok {
+			continue
+		}
+		tree, content := f.Tree()
+		imports := ImportedFiles(tree.RootNode(), content)
+		tree.Close()
+		for _, imp := range imports {
+			if resolved, _ := w.ResolveFilePath(imp.Path, filepath.Dir(path)); resolved != "" {
+				continue
+			}
+			if dir, ok := InferIncludeDir(files, w.Root, imp.Path); ok && !slices.Contains(inferred, dir) {
+				logging.Logger.Info("Inferred include directory", "import", imp.Path, "dir", dir)
+				inferred = append(inferred, dir)
+			}
+		}
+	}
+	return inferred
+}
+
+// IncludeDirActions returns quick fixes adding the directory of a workspace file the compiler couldn't open to the include directories
+func (w *Workspace) IncludeDirActions(diagnostics []transport.Diagnostic) []transport.CodeAction {
+	actions := []transport.CodeAction{}
+	for _, d := range diagnostics {
+		missing, ok := MissingImportFile(d.Message)
+		if !ok {
+			continue
+		}
+		dir, ok := w.includeDirFor(missing)
+		if !ok {
+			continue
+		}
+		title := fmt.Sprintf("Add %s to include directories", filepath.ToSlash(dir))
+		args, _ := json.Marshal(AddIncludeDirArgs{Dir: dir, Root: w.Root})
+		actions = append(actions, transport.CodeAction{
+			Title:       title,
+			Kind:        transport.QuickFix,
+			Diagnostics: []transport.Diagnostic{d},
+			Command:     &transport.Command{Title: title, Command: addIncludeDirCommand, Arguments: []json.RawMessage{args}},
+		})
+	}
+	return actions
+}
+
+// Suggests adding the include directory of a file the compiler couldn't open, once per file
+func (w *Workspace) suggestIncludeDir(s *Server, d transport.Diagnostic) {
+	missing, ok := MissingImportFile(d.Message)
+	if !ok {
+		return
+	}
+	dir, ok := w.includeDirFor(missing)
+	if !ok {
+		return
+	}
+	if _, suggested := s.suggestedIncludeDirs.LoadOrStore(missing, struct{}{}); suggested {
+		return
+	}
+	s.showMessage(transport.Info, fmt.Sprintf("The compiler can't find %s, which is in %s. Add \"%s\" to \"include\" in %s, or run the quick fix on the error",
+		missing, filepath.ToSlash(dir), filepath.ToSlash(dir), faustConfigFile))
+}
+
+// AddIncludeDir returns the content of a project config with dir added to its include directories.
+// Other options are kept, and unknown ones too.
+func AddIncludeDir(content []byte, dir util.Path) ([]byte, error) {
+	config := make(map[string]json.RawMessage)
+	if strings.TrimSpace(string(content)) != "" {
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, err
+		}
+	}
+	include := []util.Path{}
+	if raw, ok := config["include"]; ok {
+		if err := json.Unmarshal(raw, &include); err != nil {
+			return nil, err
+		}
+	}
+	dir = filepath.ToSlash(dir)
+	if !slices.Contains(include, dir) {
+		include = append(include, dir)
+	}
+	raw, err := json.Marshal(include)
+	if err != nil {
+		return nil, err
+	}
+	config["include"] = raw
+	updated, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(updated, '\n'), nil
+}
+
+// AddIncludeDirCommand adds a directory to the include directories of the project config.
+// An existing config is edited in the editor so that the change can be undone, otherwise the config file is created.
+func AddIncludeDirCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", addIncludeDirCommand, len(arguments))
+	}
+	var args AddIncludeDirArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	if args.Dir == "" {
+		return []byte("null"), fmt.Errorf("%s expects a directory", addIncludeDirCommand)
+	}
+
+	w := &s.Workspace
+	if args.Root != "" {
+		w = s.workspaceFor(args.Root)
+	}
+	configPath := filepath.Join(w.Root, faustConfigFile)
+	if f, ok := s.Files.GetFromPath(configPath); ok {
+		f.mu.RLock()
+		content := f.Content
+		f.mu.RUnlock()
+		updated, err := AddIncludeDir(content, args.Dir)
+		if err != nil {
+			s.showMessage(transport.Error, fmt.Sprintf("Couldn't add %s to include directories, %s is invalid: %s", args.Dir, faustConfigFile, err))
+			return []byte("null"), nil
+		}
+		end, err := OffsetToPosition(uint(len(content)), string(content), string(s.Files.encoding))
+		if err != nil {
+			return []byte("null"), err
+		}
+		err = s.applyEdit(fmt.Sprintf("Add %s to include directories", args.Dir), transport.WorkspaceEdit{
+			Changes: map[transport.DocumentURI][]transport.TextEdit{
+				transport.DocumentURI(util.Path2URI(configPath)): {{Range: transport.Range{End: end}, NewText: string(updated)}},
+			},
+		})
+		return []byte("null"), err
+	}
+
+	updated, err := AddIncludeDir(nil, args.Dir)
+	if err != nil {
+		return []byte("null"), err
+	}
+	logging.Logger.Info("Creating config file to add include directory", "path", configPath, "dir", args.Dir)
+	if err := os.WriteFile(configPath, updated, 0644); err != nil {
+		s.showMessage(transport.Error, fmt.Sprintf("Couldn't create %s: %s", faustConfigFile, err))
+		return []byte("null"), nil
+	}
+	w.reloadConfig(s, false)
+	return []byte("null"), nil
+}
@@ -1,10 +1,8 @@
 package server
 
 import (
-	"fmt"
-	"unicode/utf8"
-
 	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
 func ApplyIncrementalChange(r transport.Range, newContent string, content string, encoding string) string {
@@ -14,78 +12,30 @@ func ApplyIncrementalChange(r transport.Range, newContent string, content string
 	return content[:start] + newContent + content[end:]
 }
 
-func PositionToOffset(pos transport.Position, s string, encoding string) (uint, error) {
-	if len(s) == 0 {
-		return 0, nil
-	}
-	indices := GetLineIndices(s)
-	if pos.Line > uint32(len(indices)) {
-		return 0, fmt.Errorf("invalid Line Number")
-	} else if pos.Line == uint32(len(indices)) {
-		return uint(len(s)), nil
+// Edit of a syntax tree for an incremental change replacing the bytes from start to end of a content with text,
+// lines and edited being the line indices of the content before and after it
+func incrementalEdit(start uint, end uint, text string, lines LineIndex, edited LineIndex) tree_sitter.InputEdit {
+	newEnd := start + uint(len(text))
+	return tree_sitter.InputEdit{
+		StartByte:      start,
+		OldEndByte:     end,
+		NewEndByte:     newEnd,
+		StartPosition:  lines.point(start),
+		OldEndPosition: lines.point(end),
+		NewEndPosition: edited.point(newEnd),
 	}
-	currChar := indices[pos.Line]
-	for i := 0; i < int(pos.Character); i++ {
-		if int(currChar) >= len(s) {
-			break // Prevent reading past end of string
-		}
-		r, w := utf8.DecodeRuneInString(s[currChar:])
-		if w == 0 {
-			break // Prevent infinite loop if decoding fails
-		}
-		currChar += uint(w)
-		if encoding == "utf-16" {
-			if r >= 0x10000 {
-				i++
-				if i == int(pos.Character) {
-					break
-				}
-			}
-		}
-	}
-	return currChar, nil
 }
 
-func OffsetToPosition(offset uint, s string, encoding string) (transport.Position, error) {
-	if len(s) == 0 || offset == 0 {
-		return transport.Position{Line: 0, Character: 0}, nil
-	}
-	line := uint32(0)
-	char := uint32(0)
-	str := []byte(s)
-
-	for i := uint(0); i < offset && i < uint(len(str)); {
-		r, w := utf8.DecodeRune(str[i:])
-		if w == 0 {
-			break // Prevent infinite loop if decoding fails
-		}
-		if r == '\n' {
-			line++
-			char = 0
-		} else {
-			char++
-			if r >= 0x10000 && encoding == "utf-16" {
-				char++
-			}
-		}
-		i += uint(w)
-	}
+func PositionToOffset(pos transport.Position, s string, encoding string) (uint, error) {
+	return positionToOffset(NewLineIndex(s), pos, s, encoding)
+}
 
-	return transport.Position{Line: line, Character: char}, nil
+func OffsetToPosition(offset uint, s string, encoding string) (transport.Position, error) {
+	return offsetToPosition(NewLineIndex(s), offset, s, encoding)
 }
 
 func GetLineIndices(s string) []uint {
-	//	logging.Logger.Printf("Got %s\n", s)
-	lines := []uint{0}
-	i := 0
-	for w := 0; i < len(s); i += w {
-		runeValue, width := utf8.DecodeRuneInString(s[i:])
-		if runeValue == '\n' {
-			lines = append(lines, uint(i)+1)
-		}
-		w = width
-	}
-	return lines
+	return NewLineIndex(s)
 }
 
 func getDocumentEndOffset(s string, encoding string) uint {
@@ -111,8 +61,9 @@ func getDocumentEndOffset(s string, encoding string) uint {
 	}
 }
 
+// Position of the end of s: its last line, after the code units of encoding of that line
 func getDocumentEndPosition(s string, encoding string) (transport.Position, error) {
-	offset := getDocumentEndOffset(s, encoding)
-	pos, err := OffsetToPosition(offset, s, encoding)
-	return pos, err
+	lines := NewLineIndex(s)
+	last := len(lines) - 1
+	return transport.Position{Line: uint32(last), Character: uint32(getDocumentEndOffset(s[lines[last]:], encoding))}, nil
 }
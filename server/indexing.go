@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Files analyzed at once when indexing, so that big workspaces and libraries don't start a goroutine per file
+var indexWorkers = runtime.GOMAXPROCS(0)
+
+// Analyzes the Faust files at paths with a pool of workers, reporting how many are done through a progress titled title.
+// Files that aren't in the store yet are opened from disk, unless ctx is done as the workspace folder was removed.
+// Those in diagnose are diagnosed as soon as they are analyzed, rather than once every file is.
+func (w *Workspace) indexFiles(ctx context.Context, s *Server, title string, paths []util.Path, diagnose map[util.Path]struct{}) {
+	progress := s.beginProgress(title)
+	IndexFiles(paths, indexWorkers, func(path util.Path) {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, ok := s.Files.GetFromPath(path); !ok {
+			s.Files.OpenFromPath(path)
+		}
+		f, ok := s.Files.GetFromPath(path)
+		if !ok {
+			return
+		}
+		w.AnalyzeFile(f, &s.Store)
+		if _, ok := diagnose[path]; ok {
+			w.DiagnoseFile(path, s)
+		}
+	}, func(path util.Path, done int) {
+		progress.report(fmt.Sprintf("%d/%d %s", done, len(paths), filepath.Base(path)), done, len(paths))
+	})
+	progress.end(fmt.Sprintf("Indexed %d files", len(paths)))
+	logging.Logger.Info("Indexed files", "title", title, "files", len(paths))
+}
+
+// IndexFiles calls analyze on each of paths from workers goroutines, then indexed with the number of files done so far
+func IndexFiles(paths []util.Path, workers int, analyze func(util.Path), indexed func(path util.Path, done int)) {
+	jobs := make(chan util.Path)
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for range max(min(workers, len(paths)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				analyze(path)
+				indexed(path, int(done.Add(1)))
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// InlayHintsConfig selects the kinds of inlay hints shown
+type InlayHintsConfig struct {
+	Parameters bool `json:"parameters"` // Names of the parameters arguments are given for at call sites
+	Arity      bool `json:"arity"`      // Outputs and inputs meeting at <: and :> compositions
+	Banks      bool `json:"banks"`      // Channels of par iterations and long ',' chains
+}
+
+func defaultInlayHintsConfig() InlayHintsConfig {
+	return InlayHintsConfig{Parameters: true, Arity: true, Banks: true}
+}
+
+func (c *InlayHintsConfig) UnmarshalJSON(content []byte) error {
+	type Config InlayHintsConfig
+	cfg := Config(defaultInlayHintsConfig())
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return err
+	}
+	*c = InlayHintsConfig(cfg)
+	return nil
+}
+
+func InlayHint(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.InlayHintParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte{}, err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte{}, fmt.Errorf("trying to get inlay hints from non-existent path: %s", path)
+	}
+	config := s.workspaceFor(path).ConfigFor(path).InlayHints
+
+	hints := []transport.InlayHint{}
+	if config.Banks {
+		hints = append(hints, BankInlayHints(f.Banks(s.Store.Precision), params.Range)...)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	f.mu.RLock()
+	fileScope := f.Scope
+	f.mu.RUnlock()
+	if config.Parameters {
+		parameters := func(callee *tree_sitter.Node) []string {
+			return s.parameterNames(callee, content, fileScope)
+		}
+		hints = append(hints, ParameterInlayHints(tree.RootNode(), content, params.Range, parameters)...)
+	}
+	if config.Arity {
+		hints = append(hints, ArityInlayHints(tree.RootNode(), content, params.Range, s.Store.Precision)...)
+	}
+	SortInlayHints(hints)
+	logging.Logger.Info("Inlay hints", "hints", hints)
+
+	return json.Marshal(hints)
+}
+
+// Names of the parameters of the function callee refers to, resolved in the scopes of the file
+func (s *Server) parameterNames(callee *tree_sitter.Node, content []byte, fileScope *Scope) []string {
+	if fileScope == nil {
+		return nil
+	}
+	ident := strings.Join(strings.Fields(callee.Utf8Text(content)), "")
+	scope := FindLowestScopeContainingRange(fileScope, ToRange(callee))
+	sym, err := ResolveSymbol(ident, scope, &s.Store)
+	if err != nil || sym.Kind != Function || sym.Scope == nil {
+		return nil
+	}
+	names := []string{}
+	for _, parameter := range sym.Scope.Symbols {
+		names = append(names, parameter.Ident)
+	}
+	return names
+}
+
+// ParameterInlayHints names the parameters arguments in r are given for, like freq: in fi.resonlp(freq: 1000, q: 2, gain: 0.5).
+// parameters returns the parameter names of the function a callee refers to, nil if they aren't known.
+// Arguments spelled like their parameter aren't hinted.
+func ParameterInlayHints(root *tree_sitter.Node, content []byte, r transport.Range, parameters func(*tree_sitter.Node) []string) []transport.InlayHint {
+	hints := []transport.InlayHint{}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if node.Kind() == "function_call" && !isCalled(node) {
+			hints = append(hints, callParameterHints(node, content, r, parameters)...)
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return hints
+}
+
+// Whether call is the callee of another call, like f(1) in f(1)(2)
+func isCalled(call *tree_sitter.Node) bool {
+	parent := call.Parent()
+	return parent != nil && parent.Kind() == "function_call" && isField(parent, "callee", call)
+}
+
+// Hints of the arguments of a chain of calls like f(1)(2, 3), given to the parameters of the innermost callee in order
+func callParameterHints(call *tree_sitter.Node, content []byte, r transport.Range, parameters func(*tree_sitter.Node) []string) []transport.InlayHint {
+	calls := []*tree_sitter.Node{}
+	callee := call
+	for callee != nil && callee.Kind() == "function_call" {
+		calls = append(calls, callee)
+		callee = callee.ChildByFieldName("callee")
+	}
+	if callee == nil || (callee.Kind() != "identifier" && callee.Kind() != "access") {
+		return nil
+	}
+	names := parameters(callee)
+	if len(names) == 0 {
+		return nil
+	}
+
+	hints := []transport.InlayHint{}
+	i := 0
+	for j := len(calls) - 1; j >= 0; j-- {
+		arguments := childOfKind(calls[j], "arguments")
+		if arguments == nil {
+			continue
+		}
+		for k := uint(0); k < arguments.NamedChildCount() && i < len(names); k++ {
+			argument := arguments.NamedChild(k)
+			if argument.Kind() == "comment" {
+				continue
+			}
+			name := names[i]
+			i++
+			position := ToRange(argument).Start
+			if argument.Utf8Text(content) == name || !RangeContains(r, transport.Range{Start: position, End: position}) {
+				continue
+			}
+			hints = append(hints, transport.InlayHint{
+				Position:     position,
+				Label:        []transport.InlayHintLabelPart{{Value: name + ":"}},
+				Kind:         transport.Parameter,
+				PaddingRight: true,
+			})
+		}
+	}
+	return hints
+}
+
+// ArityInlayHints shows the outputs of the left side and the inputs of the right side of <: and :> compositions in r, like <: 2→4,
+// when both are known without compiling
+func ArityInlayHints(root *tree_sitter.Node, content []byte, r transport.Range, precision string) []transport.InlayHint {
+	hints := []transport.InlayHint{}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if node.Kind() == "split" || node.Kind() == "merge" {
+			if hint, ok := arityInlayHint(node, content, precision); ok && RangeContains(r, transport.Range{Start: hint.Position, End: hint.Position}) {
+				hints = append(hints, hint)
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return hints
+}
+
+// Hint after the operator of a composition, with the outputs of its left side and the inputs of its right side
+func arityInlayHint(composition *tree_sitter.Node, content []byte, precision string) (transport.InlayHint, bool) {
+	left, right := composition.ChildByFieldName("left"), composition.ChildByFieldName("right")
+	if left == nil || right == nil {
+		return transport.InlayHint{}, false
+	}
+	var operator *tree_sitter.Node
+	for i := uint(0); i < composition.ChildCount(); i++ {
+		if child := composition.Child(i); !child.IsNamed() && child.StartByte() >= left.EndByte() {
+			operator = child
+			break
+		}
+	}
+	if operator == nil {
+		return transport.InlayHint{}, false
+	}
+	leftArity, lok := ExpressionArity(flowOperand(left, "right"), content, precision)
+	rightArity, rok := ExpressionArity(flowOperand(right, "left"), content, precision)
+	if !lok || !rok {
+		return transport.InlayHint{}, false
+	}
+	return transport.InlayHint{
+		Position:     ToRange(operator).End,
+		Label:        []transport.InlayHintLabelPart{{Value: fmt.Sprintf("%d→%d", leftArity.Outputs, rightArity.Inputs)}},
+		Kind:         transport.Type,
+		PaddingLeft:  true,
+		PaddingRight: true,
+	}, true
+}
+
+// Operand of a chain of :, <: and :> compositions whose outputs (side "right") or inputs (side "left") are those of the whole chain,
+// so that a <: b :> c, parsed as a <: (b :> c), shows the inputs of b after <: even when c's arity is unknown
+func flowOperand(node *tree_sitter.Node, side string) *tree_sitter.Node {
+	for node.Kind() == "sequential" || node.Kind() == "split" || node.Kind() == "merge" {
+		operand := node.ChildByFieldName(side)
+		if operand == nil {
+			break
+		}
+		node = operand
+	}
+	return node
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Library that defines all the standard prefixes
+const standardLibrary = "stdfaust.lib"
+
+// Environment prefixes defined by stdfaust.lib and the libraries they stand for
+var standardPrefixes = map[string]string{
+	"aa": "aanl.lib",
+	"sf": "all.lib",
+	"an": "analyzers.lib",
+	"ba": "basics.lib",
+	"co": "compressors.lib",
+	"de": "delays.lib",
+	"dm": "demos.lib",
+	"dx": "dx7.lib",
+	"en": "envelopes.lib",
+	"fd": "fds.lib",
+	"fi": "filters.lib",
+	"ho": "hoa.lib",
+	"it": "interpolators.lib",
+	"la": "linearalgebra.lib",
+	"lf": "lfos.lib",
+	"ma": "maths.lib",
+	"mi": "mi.lib",
+	"ef": "misceffects.lib",
+	"mo": "motion.lib",
+	"os": "oscillators.lib",
+	"no": "noises.lib",
+	"pf": "phaflangers.lib",
+	"pl": "platform.lib",
+	"pm": "physmodels.lib",
+	"qu": "quantizers.lib",
+	"rm": "reducemaps.lib",
+	"re": "reverbs.lib",
+	"ro": "routes.lib",
+	"si": "signals.lib",
+	"so": "soundfiles.lib",
+	"sp": "spats.lib",
+	"sy": "synths.lib",
+	"ve": "vaeffects.lib",
+	"vl": "version.lib",
+	"wa": "webaudio.lib",
+	"wd": "wdmodels.lib",
+}
+
+// Compiler errors like "undefined symbol : fi"
+var undefinedSymbolPattern = regexp.MustCompile(`undefined symbol\s*:\s*([\w.]+)`)
+
+// MissingImportActions returns quick fixes for standard library prefixes used in r or reported undefined in diagnostics, like fi in fi.lowpass, that the file doesn't define.
+// The fixes either import stdfaust.lib or define the prefix as the specific library.
+func MissingImportActions(root *tree_sitter.Node, content []byte, uri transport.DocumentURI, r transport.Range, diagnostics []transport.Diagnostic) []transport.CodeAction {
+	defined := make(map[string]struct{})
+	importsStandard := false
+	var lastImport *tree_sitter.Node
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		node := root.NamedChild(i)
+		switch node.Kind() {
+		case "definition", "function_definition":
+			name := node.ChildByFieldName("variable")
+			if name == nil {
+				name = node.ChildByFieldName("name")
+			}
+			if name != nil {
+				defined[name.Utf8Text(content)] = struct{}{}
+			}
+		case "file_import":
+			lastImport = node
+			if fileName := node.ChildByFieldName("filename"); fileName != nil && stripQuotes(fileName.Utf8Text(content)) == standardLibrary {
+				importsStandard = true
+			}
+		}
+	}
+	if importsStandard {
+		return []transport.CodeAction{}
+	}
+
+	// Prefixes to fix along with the diagnostics they fix
+	prefixes := make(map[string][]transport.Diagnostic)
+	addPrefix := func(prefix string) {
+		if _, ok := standardPrefixes[prefix]; !ok {
+			return
+		}
+		if _, ok := defined[prefix]; ok {
+			return
+		}
+		if _, ok := prefixes[prefix]; !ok {
+			prefixes[prefix] = []transport.Diagnostic{}
+		}
+	}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if !rangesOverlap(ToRange(node), r) {
+			return
+		}
+		if node.Kind() == "access" {
+			if env := node.ChildByFieldName("environment"); env != nil && env.Kind() == "identifier" {
+				addPrefix(env.Utf8Text(content))
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	for _, d := range diagnostics {
+		captures := undefinedSymbolPattern.FindStringSubmatch(d.Message)
+		if len(captures) < 2 {
+			continue
+		}
+		prefix, _, _ := strings.Cut(captures[1], ".")
+		addPrefix(prefix)
+		if fixed, ok := prefixes[prefix]; ok {
+			prefixes[prefix] = append(fixed, d)
+		}
+	}
+	if len(prefixes) == 0 {
+		return []transport.CodeAction{}
+	}
+
+	// Insert after the last import, or at the top of the file
+	insertAt := transport.Position{}
+	lineEnd := "\n"
+	if lastImport != nil {
+		end := lastImport.EndPosition()
+		if lastImport.EndByte() < uint(len(content)) {
+			insertAt = transport.Position{Line: uint32(end.Row) + 1}
+		} else {
+			insertAt = transport.Position{Line: uint32(end.Row), Character: uint32(end.Column)}
+			lineEnd = ""
+		}
+	}
+	edit := func(text string) *transport.WorkspaceEdit {
+		if lineEnd == "" {
+			text = "\n" + text
+		} else {
+			text += lineEnd
+		}
+		return &transport.WorkspaceEdit{
+			Changes: map[transport.DocumentURI][]transport.TextEdit{
+				uri: {{Range: transport.Range{Start: insertAt, End: insertAt}, NewText: text}},
+			},
+		}
+	}
+
+	names := []string{}
+	for prefix := range prefixes {
+		names = append(names, prefix)
+	}
+	slices.Sort(names)
+
+	allDiagnostics := []transport.Diagnostic{}
+	actions := []transport.CodeAction{}
+	for _, prefix := range names {
+		allDiagnostics = append(allDiagnostics, prefixes[prefix]...)
+		library := standardPrefixes[prefix]
+		actions = append(actions, transport.CodeAction{
+			Title:       fmt.Sprintf("Define %s = library(\"%s\")", prefix, library),
+			Kind:        transport.QuickFix,
+			Diagnostics: prefixes[prefix],
+			Edit:        edit(fmt.Sprintf("%s = library(\"%s\");", prefix, library)),
+		})
+	}
+	actions = append(actions, transport.CodeAction{
+		Title:       fmt.Sprintf("Import \"%s\"", standardLibrary),
+		Kind:        transport.QuickFix,
+		Diagnostics: allDiagnostics,
+		IsPreferred: true,
+		Edit:        edit(fmt.Sprintf("import(\"%s\");", standardLibrary)),
+	})
+	return actions
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Process of the wrapper compiled to check a library, named so that it can't clash with the library's own definitions
+const libraryCheckProcess = "faustlsp_library_check"
+
+// Values of definitions that aren't block diagrams, which the wrapper can't put in parallel
+var nonDiagramValues = []string{"string", "fstring", "environment", "library"}
+
+// Library files of the workspace whose configs ask for compiler diagnostics of libraries
+func (w *Workspace) checkedLibraryFiles() []util.Path {
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+
+	libraries := []util.Path{}
+	for _, path := range files {
+		if config := w.ConfigFor(path); IsLibFile(path) && config.LibraryDiagnostics && config.CompilerDiagnostics {
+			libraries = append(libraries, path)
+		}
+	}
+	return libraries
+}
+
+// LibraryCheckWrapper is a file importing the library at path and defining libraryCheckProcess as its top-level definitions in parallel,
+// so that compiling it checks them. Functions are left out, as they can't be checked without arguments.
+func LibraryCheckWrapper(path util.Path, content []byte) string {
+	tree := parser.ParseTree(content)
+	defer tree.Close()
+	root := tree.RootNode()
+
+	names := []string{}
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		node := root.NamedChild(i)
+		if node.Kind() != "definition" {
+			continue
+		}
+		value := node.ChildByFieldName("value")
+		if value == nil || slices.Contains(nonDiagramValues, value.Kind()) {
+			continue
+		}
+		if name := definitionIdentifier(node).Utf8Text(content); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	// Compiling the import alone still checks that the library and its imports can be loaded
+	process := "0"
+	if len(names) > 0 {
+		process = strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("import(%q);\n%s = %s;\n", filepath.ToSlash(path), libraryCheckProcess, process)
+}
+
+// Returns the wrapper to give the compiler to check the library at path, compiled as compiledPath from dir with config, along with the config compiling it.
+// The wrapper is written next to the overlay's copy of the library, and the library's directory is included for its relative imports.
+func (w *Workspace) libraryCompilation(s *Server, path, compiledPath, dir util.Path, config FaustProjectConfig) (util.Path, util.Path, FaustProjectConfig, error) {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return "", "", config, fmt.Errorf("%s isn't in the store", path)
+	}
+	f.mu.RLock()
+	wrapper := LibraryCheckWrapper(compiledPath, f.Content)
+	f.mu.RUnlock()
+
+	wrapperPath := w.TempDirPath(path) + ".dsp"
+	if err := os.MkdirAll(filepath.Dir(wrapperPath), 0755); err != nil {
+		return "", "", config, err
+	}
+	if err := os.WriteFile(wrapperPath, []byte(wrapper), 0644); err != nil {
+		return "", "", config, err
+	}
+	logging.Logger.Info("Checking library with wrapper", "path", path, "wrapper", wrapper)
+	config.ProcessName = libraryCheckProcess
+	if libraryDir := filepath.Dir(compiledPath); !slices.Contains(config.IncludeDir, libraryDir) {
+		config.IncludeDir = append([]util.Path{libraryDir}, config.IncludeDir...)
+	}
+	return wrapperPath, dir, config, nil
+}
@@ -5,37 +5,76 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"os"
+	"slices"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
-	"github.com/carn181/faustlsp/util"
 )
 
+// Information about the server in the initialize result, along with the compiler it found
+type serverInfo struct {
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	Compiler CompilerInfo `json:"compiler"`
+}
+
+// The server's information replaces the one of the result, as fields of the outer struct take precedence
+type initializeResult struct {
+	transport.InitializeResult
+	ServerInfo serverInfo `json:"serverInfo"`
+}
+
 // Initialize Handler
 func Initialize(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
 	// TODO: Error Handling
 
 	s.Status = Initializing
+	// Diagnostics and requests to the client wait for it to be initialized
+	s.Transport.Hold()
+	// Shutting down removed the overlay of the previous session
+	if s.tempDir == "" {
+		s.createTempDir()
+	}
 	var params transport.InitializeParams
 	json.Unmarshal(par, &params)
 	logging.Logger.Info("Got Initialize Parameters from Client", "params", par)
 
+	roots := WorkspaceFolderRoots(params.RootURI, params.WorkspaceFolders)
+	logging.Logger.Info("Got workspace folders", "roots", roots)
+	s.initialRoot = ""
+	s.initialFolders = nil
+	if len(roots) > 0 {
+		s.initialRoot = roots[0]
+		s.initialFolders = roots[1:]
+	}
+	// A client restarting with another root doesn't reuse the workspace of the session, which is stopped before the fields it reads are replaced
+	if s.workspaceCancel != nil && s.initialRoot != s.sessionRoot {
+		s.stopWorkspace()
+	}
+
 	// TODO: Choose ServerCapabilities based on ClientCapabilities
 	// Server Capabilities
 
 	// Don't select UTF-8, select UTF-32 and UTF-16 only
-	var positionEncoding transport.PositionEncodingKind
-	if params.Capabilities.General.PositionEncodings[0] == "utf-16" {
-		positionEncoding = transport.UTF16
-	} else if params.Capabilities.General.PositionEncodings[0] == "utf-32" {
-		positionEncoding = transport.UTF32
-	} else {
-		positionEncoding = transport.UTF16
+	// Clients that don't send position encodings only support UTF-16
+	var positionEncoding transport.PositionEncodingKind = transport.UTF16
+	if general := params.Capabilities.General; general != nil && len(general.PositionEncodings) > 0 {
+		if general.PositionEncodings[0] == "utf-32" {
+			positionEncoding = transport.UTF32
+		}
 	}
 	var result transport.InitializeResult = transport.InitializeResult{
 		Capabilities: transport.ServerCapabilities{
 			// TODO: Implement Incremental Changes for better synchronization
 			DocumentSymbolProvider: &transport.Or_ServerCapabilities_documentSymbolProvider{Value: true},
+			FoldingRangeProvider:   &transport.Or_ServerCapabilities_foldingRangeProvider{Value: true},
+			InlayHintProvider:      true,
+			CodeActionProvider: &transport.CodeActionOptions{
+				CodeActionKinds: []transport.CodeActionKind{transport.QuickFix, transport.RefactorExtract, transport.Source},
+			},
+			CodeLensProvider:       &transport.CodeLensOptions{ResolveProvider: true},
+			DocumentLinkProvider:   &transport.DocumentLinkOptions{},
+			ExecuteCommandProvider: &transport.ExecuteCommandOptions{Commands: commandNames()},
 			PositionEncoding:       &positionEncoding,
 			TextDocumentSync:       transport.Incremental,
 			Workspace: &transport.WorkspaceOptions{
@@ -43,23 +82,59 @@ func Initialize(ctx context.Context, s *Server, par json.RawMessage) (json.RawMe
 					Supported:           true,
 					ChangeNotifications: "ws",
 				},
+				FileOperations: &transport.FileOperationOptions{
+					WillRename: &transport.FileOperationRegistrationOptions{Filters: fileOperationFilters},
+					WillDelete: &transport.FileOperationRegistrationOptions{Filters: fileOperationFilters},
+					DidDelete:  &transport.FileOperationRegistrationOptions{Filters: fileOperationFilters},
+				},
+			},
+			DocumentFormattingProvider:      &transport.Or_ServerCapabilities_documentFormattingProvider{Value: true},
+			DocumentRangeFormattingProvider: &transport.Or_ServerCapabilities_documentRangeFormattingProvider{Value: true},
+			DocumentOnTypeFormattingProvider: &transport.DocumentOnTypeFormattingOptions{
+				FirstTriggerCharacter: ";",
+				MoreTriggerCharacter:  []string{"}"},
 			},
-			DocumentFormattingProvider: &transport.Or_ServerCapabilities_documentFormattingProvider{Value: true},
 			DefinitionProvider:         &transport.Or_ServerCapabilities_definitionProvider{Value: true},
-			HoverProvider:              &transport.Or_ServerCapabilities_hoverProvider{Value: true},
+			ReferencesProvider:         &transport.Or_ServerCapabilities_referencesProvider{Value: true},
+			LinkedEditingRangeProvider: &transport.Or_ServerCapabilities_linkedEditingRangeProvider{Value: true},
+			SemanticTokensProvider: semanticTokensOptions{
+				Legend: semanticTokensLegend,
+				Full:   transport.SemanticTokensFullDelta{Delta: true},
+			},
+			HoverProvider: &transport.Or_ServerCapabilities_hoverProvider{Value: true},
 			CompletionProvider: &transport.CompletionOptions{
-				TriggerCharacters: []string{"."},
+				TriggerCharacters: []string{".", `"`, "/"},
+				ResolveProvider:   true,
 			},
+			WorkspaceSymbolProvider: transport.WorkspaceSymbolOptions{ResolveProvider: true},
 		},
-		ServerInfo: &transport.ServerInfo{Name: "faust-lsp", Version: "0.0.1"},
 	}
+	s.dynamicRegistrations = dynamicProviders(params.Capabilities, &result.Capabilities)
 	s.Capabilities = result.Capabilities
+	// Registrations of a previous client are gone with it
+	s.mu.Lock()
+	s.registered = nil
+	s.mu.Unlock()
+	s.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
+	s.snippetSupport = params.Capabilities.TextDocument.Completion.CompletionItem.SnippetSupport
+	s.watchedFilesRegistration = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+	s.symbolResolveSupport = symbolRangeResolveSupport(params.Capabilities.Workspace.Symbol)
+	s.configurationSupport = params.Capabilities.Workspace.Configuration
+	s.configurationRegistration = params.Capabilities.Workspace.DidChangeConfiguration.DynamicRegistration
+	// Clients that can't give workspace files or answer configuration requests can configure the server from the start
+	s.initializationSettings = initializationSettings(params.InitializationOptions)
+	s.initializationSettings.applyLogLevel()
+	// The compiler of the config file is only known once the workspace is loaded, the one of the initialization options is told about here
+	command := "faust"
+	if s.initializationSettings.Command != nil {
+		command = *s.initializationSettings.Command
+	}
+	compiler := s.probeCompiler(command)
 
-	rootPath, _ := util.URI2path(string(params.RootURI))
-	logging.Logger.Info("Got workspace", "workspace", rootPath)
-	s.Workspace.Root = rootPath
-
-	resultBytes, err := json.Marshal(result)
+	resultBytes, err := json.Marshal(initializeResult{
+		InitializeResult: result,
+		ServerInfo:       serverInfo{Name: "faust-lsp", Version: "0.0.1", Compiler: compiler},
+	})
 	if err != nil {
 		return []byte{}, nil
 	}
@@ -70,12 +145,57 @@ func Initialize(ctx context.Context, s *Server, par json.RawMessage) (json.RawMe
 func Initialized(ctx context.Context, s *Server, par json.RawMessage) error {
 
 	s.Status = Running
-	go s.GenerateDiagnostics()
+	if err := s.Transport.Release(); err != nil {
+		logging.Logger.Warn("Couldn't write message", "error", err)
+	}
+	s.diagnosticsOnce.Do(func() {
+		s.diagChan = make(chan transport.PublishDiagnosticsParams)
+		go s.GenerateDiagnostics()
+	})
+	registrations := s.dynamicRegistrations
+	if s.watchedFilesRegistration {
+		registrations = append(registrations, fileWatcherRegistration())
+	}
+	if s.configurationRegistration {
+		registrations = append(registrations, configurationRegistration())
+	}
+	s.register(registrations)
+
+	// A client that restarted mid-session reuses the workspace of the previous session if it has the same root
+	if s.workspaceCancel != nil && s.initialRoot == s.sessionRoot {
+		logging.Logger.Info("Reusing workspace of previous session", "root", s.sessionRoot)
+		s.Files.encoding = *s.Capabilities.PositionEncoding
+		s.Workspace.Reset(s)
+		for _, w := range s.workspaces()[1:] {
+			if slices.Contains(s.initialFolders, w.Root) {
+				w.Reset(s)
+			}
+		}
+		s.setFolders(s.workspaceCtx, s.initialFolders)
+		if s.configurationSupport {
+			go s.fetchSettings(s.workspaceCtx)
+		}
+		return nil
+	}
+	// A previous workspace was stopped by initialize or shutdown, so nothing reads its fields anymore
+	s.Workspace.Root = s.initialRoot
+	workspaceCtx, cancel := context.WithCancel(ctx)
+	s.workspaceCancel = cancel
+	s.workspaceCtx = workspaceCtx
+	s.sessionRoot = s.Workspace.Root
+
 	s.Files.Init(ctx, *s.Capabilities.PositionEncoding)
 	s.Store.Files = &s.Files
 	s.Store.Dependencies = NewDependencyGraph()
 	s.Store.Cache = make(map[[sha256.Size]byte]*Scope)
-	s.Workspace.Init(ctx, s)
+	primaryCtx, primaryCancel := context.WithCancel(workspaceCtx)
+	s.primaryCancel = primaryCancel
+	s.Workspace.Init(primaryCtx, s)
+	s.setFolders(workspaceCtx, s.initialFolders)
+	// The client's settings are asked for once the workspace is loaded, as its answer is read by the loop handling this notification
+	if s.configurationSupport {
+		go s.fetchSettings(workspaceCtx)
+	}
 	logging.Logger.Info("Handling Initialized with diagnostics")
 	logging.Logger.Info("Started Diagnostic Handler")
 	// Send WorkspaceFolders Request
@@ -91,14 +211,51 @@ func Initialized(ctx context.Context, s *Server, par json.RawMessage) error {
 // Shutdown Handler
 func ShutdownEnd(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
 	s.Status = Shutdown
-	// Some Clients end the server right after sending shutdown like emacs lsp-mode
-	// Remove Temp Dir just in case
-	os.RemoveAll(s.tempDir)
+	// Everything is stopped before answering, as clients like emacs lsp-mode end the server right after the answer without sending exit.
+	// Clients initializing again after shutting down start from a fresh workspace.
+	s.stopSession(ctx)
 
 	content, err := json.Marshal([]byte(""))
 	return content, err
 }
 
+// Stops the work of the session for shutting down: the requests being handled other than the one of ctx, the watchers, indexing and compiler runs
+// of the workspace folders. Then the diagnostics computed so far are written, the syntax trees of files closed and the overlay removed.
+func (s *Server) stopSession(ctx context.Context) {
+	s.cancelRequests(ctx)
+	if s.workspaceCancel != nil {
+		s.stopWorkspace()
+	}
+	s.Files.closeTrees()
+	s.unregisterAll()
+	if err := s.Transport.Flush(); err != nil {
+		logging.Logger.Error("Couldn't write notifications", "error", err)
+	}
+	if s.tempDir != "" {
+		if err := os.RemoveAll(s.tempDir); err != nil {
+			logging.Logger.Error("Couldn't remove temp dir", "path", s.tempDir, "error", err)
+		}
+		s.tempDir = ""
+	}
+	logging.Logger.Info("Stopped session for shutdown")
+}
+
+// Stops tracking the workspace folders of the session, waiting for the goroutines reading their fields to end
+func (s *Server) stopWorkspace() {
+	s.workspaceCancel()
+	s.workspaceCancel = nil
+	s.sessionRoot = ""
+	s.foldersMu.Lock()
+	folders := s.folders
+	s.folders = nil
+	s.folderCancels = nil
+	s.foldersMu.Unlock()
+	s.Workspace.running.Wait()
+	for _, w := range folders {
+		w.running.Wait()
+	}
+}
+
 // Exit Handler
 func ExitEnd(ctx context.Context, s *Server, par json.RawMessage) error {
 	if s.Status == Shutdown {
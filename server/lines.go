@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// LineIndex holds the byte offsets the lines of a content start at, the first one being 0.
+// Files keep the one of their content, updated along with incremental changes, so that positions are converted without scanning the content.
+type LineIndex []uint
+
+// NewLineIndex returns the line index of s
+func NewLineIndex[T string | []byte](s T) LineIndex {
+	lines := LineIndex{0}
+	for i, c := range []byte(s) {
+		if c == '\n' {
+			lines = append(lines, uint(i)+1)
+		}
+	}
+	return lines
+}
+
+// Line of the content offset is in
+func (lines LineIndex) line(offset uint) int {
+	return sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+}
+
+// Row and byte column of offset, as tree-sitter counts them
+func (lines LineIndex) point(offset uint) tree_sitter.Point {
+	line := lines.line(offset)
+	return tree_sitter.Point{Row: uint(line), Column: offset - lines[line]}
+}
+
+// Edited returns the line index of the content resulting from replacing its bytes from start to end with text.
+// Lines starting in the replaced bytes are gone, and those after them are moved by the difference of lengths.
+func (lines LineIndex) Edited(start uint, end uint, text string) LineIndex {
+	first := lines.line(start) + 1
+	last := lines.line(end) + 1
+	edited := make(LineIndex, 0, len(lines)-(last-first)+strings.Count(text, "\n"))
+	edited = append(edited, lines[:first]...)
+	for i := 0; i < len(text); {
+		next := strings.IndexByte(text[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+		edited = append(edited, start+uint(i))
+	}
+	for _, offset := range lines[last:] {
+		edited = append(edited, offset-end+start+uint(len(text)))
+	}
+	return edited
+}
+
+// Byte offset in s, whose line index is lines, of pos, whose characters are code units of encoding
+func positionToOffset[T string | []byte](lines LineIndex, pos transport.Position, s T, encoding string) (uint, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	if pos.Line > uint32(len(lines)) {
+		return 0, fmt.Errorf("invalid Line Number")
+	} else if pos.Line == uint32(len(lines)) {
+		return uint(len(s)), nil
+	}
+	start := lines[pos.Line]
+	units := uint32(0)
+	for i, r := range string(s[start:]) {
+		if units >= pos.Character {
+			return start + uint(i), nil
+		}
+		units++
+		if encoding == "utf-16" && r >= 0x10000 {
+			units++
+		}
+	}
+	return uint(len(s)), nil
+}
+
+// Position in s, whose line index is lines, of offset, with characters counted in code units of encoding
+func offsetToPosition[T string | []byte](lines LineIndex, offset uint, s T, encoding string) (transport.Position, error) {
+	if len(s) == 0 || offset == 0 {
+		return transport.Position{Line: 0, Character: 0}, nil
+	}
+	// Only the offset's line is decoded, so that positions in long generated lines stay cheap to compute
+	offset = min(offset, uint(len(s)))
+	line := lines.line(offset)
+	end := offset
+	// A rune the offset falls into still counts
+	for end < uint(len(s)) && !utf8.RuneStart(s[end]) {
+		end++
+	}
+	char := uint32(0)
+	for _, r := range string(s[lines[line]:end]) {
+		char++
+		if encoding == "utf-16" && r >= 0x10000 {
+			char++
+		}
+	}
+	return transport.Position{Line: uint32(line), Character: char}, nil
+}
+
+// Content of the file along with its line index, for converting positions of a single version of it
+func (f *File) snapshot() ([]byte, LineIndex) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Content, f.lines
+}
+
+// PositionToOffset converts pos to a byte offset in the file's content with its line index
+func (f *File) PositionToOffset(pos transport.Position, encoding string) (uint, error) {
+	content, lines := f.snapshot()
+	return positionToOffset(lines, pos, content, encoding)
+}
+
+// OffsetToPosition converts a byte offset in the file's content to a position with its line index
+func (f *File) OffsetToPosition(offset uint, encoding string) (transport.Position, error) {
+	content, lines := f.snapshot()
+	return offsetToPosition(lines, offset, content, encoding)
+}
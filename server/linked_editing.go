@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Names linked ranges can be edited into
+const identifierWordPattern = `[a-zA-Z_][a-zA-Z_0-9]*`
+
+func LinkedEditingRange(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.LinkedEditingRangeParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get linked editing ranges from non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	f.mu.RLock()
+	lines, scope := f.lines, f.Scope
+	f.mu.RUnlock()
+
+	offset, err := positionToOffset(lines, params.Position, content, string(s.Files.encoding))
+	if err != nil {
+		return []byte("null"), err
+	}
+	ranges := LinkedParameterRanges(tree.RootNode(), content, scope, &s.Store, path, offset)
+	logging.Logger.Info("Linked editing ranges", "ranges", ranges)
+	if len(ranges) == 0 {
+		return []byte("null"), nil
+	}
+	return json.Marshal(transport.LinkedEditingRanges{Ranges: ranges, WordPattern: identifierWordPattern})
+}
+
+// LinkedParameterRanges returns the ranges of the parameter at offset in content, the file at path, and of its uses in the body it is a parameter of.
+// Parameters are those of function definitions and case rules, and iteration variables. Uses are found by resolving identifiers in scope, so that
+// names shadowing the parameter are left out. Returns nothing if there's no parameter at offset.
+func LinkedParameterRanges(root *tree_sitter.Node, content []byte, scope *Scope, store *Store, path util.Path, offset uint) []transport.Range {
+	if scope == nil {
+		return nil
+	}
+	node := root.DescendantForByteRange(offset, offset)
+	if node == nil || node.Kind() != "identifier" {
+		return nil
+	}
+	ident := qualifiedIdentifier(node, content)
+	identScope := FindLowestScopeContainingRange(scope, ToRange(node))
+
+	target, err := ResolveSymbol(ident, identScope, store)
+	if err != nil || target.Kind != Identifier || target.Loc.File != path {
+		return nil
+	}
+	return SymbolReferences(root, content, scope, store, target, true)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// URLs in declare statements like declare url "https://faust.grame.fr";
+var urlPattern = regexp.MustCompile(`https?://[^\s"<>]+`)
+
+func DocumentLink(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.DocumentLinkParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get document links from non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+
+	resolve := func(importPath string) util.Path {
+		w := s.workspaceFor(path)
+		resolvedPath, _ := w.ResolveFilePath(importPath, w.ImportRoot(path))
+		return resolvedPath
+	}
+	links := DocumentLinks(root, content, string(s.Files.encoding), resolve)
+	logging.Logger.Info("Document links", "links", links)
+	return json.Marshal(links)
+}
+
+// DocumentLinks returns links to the files imported in content and to the URLs in its declare statements.
+// resolve returns the path an imported file is found at, or "" if it can't be found.
+func DocumentLinks(root *tree_sitter.Node, content []byte, encoding string, resolve func(string) util.Path) []transport.DocumentLink {
+	links := []transport.DocumentLink{}
+	addLink := func(start, end uint, target string, tooltip string) {
+		startPos, err := OffsetToPosition(start, string(content), encoding)
+		if err != nil {
+			return
+		}
+		endPos, err := OffsetToPosition(end, string(content), encoding)
+		if err != nil {
+			return
+		}
+		uri := transport.URI(target)
+		links = append(links, transport.DocumentLink{
+			Range:   transport.Range{Start: startPos, End: endPos},
+			Target:  &uri,
+			Tooltip: tooltip,
+		})
+	}
+
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		switch node.Kind() {
+		case "file_import", "library", "component":
+			fileName := node.ChildByFieldName("filename")
+			if fileName == nil || fileName.EndByte()-fileName.StartByte() < 2 {
+				break
+			}
+			resolvedPath := resolve(stripQuotes(fileName.Utf8Text(content)))
+			if resolvedPath != "" {
+				// Link the path without its quotes
+				addLink(fileName.StartByte()+1, fileName.EndByte()-1, util.Path2URI(resolvedPath), resolvedPath)
+			}
+		case "global_metadata", "function_metadata":
+			value := node.ChildByFieldName("value")
+			if value == nil {
+				break
+			}
+			for _, match := range urlPattern.FindAllStringIndex(value.Utf8Text(content), -1) {
+				start := value.StartByte() + uint(match[0])
+				end := value.StartByte() + uint(match[1])
+				addLink(start, end, string(content[start:end]), "")
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return links
+}
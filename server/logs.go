@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+const openLogCommand = "faustlsp.openLog"
+
+type OpenLogResult struct {
+	Path util.Path             `json:"path"`
+	URI  transport.DocumentURI `json:"uri"`
+}
+
+// Returns the path and URI of the file the server logs to, for editors to open it
+func OpenLogCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	path := logging.Path()
+	if path == "" {
+		return []byte("null"), errors.New("the server doesn't log to a file")
+	}
+	return json.Marshal(OpenLogResult{Path: path, URI: transport.DocumentURI(util.Path2URI(path))})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Lines longer than this many bytes, typical of generated files, turn off semantic tokens and formatting,
+// which would stall the server and the editor without being of use on them
+const maxLineLength = 10000
+
+// LongestLine returns the length in bytes of the longest line of content
+func LongestLine(content []byte) int {
+	longest := 0
+	for {
+		i := bytes.IndexByte(content, '\n')
+		if i < 0 {
+			return max(longest, len(content))
+		}
+		longest = max(longest, i)
+		content = content[i+1:]
+	}
+}
+
+// Whether features are turned off for f because of its long lines, telling the user the first time it happens for the file
+func (s *Server) hasLongLines(f *File) bool {
+	f.mu.RLock()
+	longest := LongestLine(f.Content)
+	path := f.Handle.Path
+	f.mu.RUnlock()
+	if longest <= maxLineLength {
+		return false
+	}
+	if _, warned := s.longLineFiles.LoadOrStore(path, struct{}{}); !warned {
+		logging.Logger.Info("Turning off features for file with long lines", "path", path, "longest", longest)
+		s.showMessage(transport.Warning, fmt.Sprintf("%s has lines of up to %d bytes, semantic highlighting and formatting are turned off for it", filepath.Base(path), longest))
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Declare is a metadata statement like declare author "Grame"; with the name of the function it is about for function metadata
+type Declare struct {
+	Function string
+	Key      string
+	Value    string
+}
+
+// Declares returns the global metadata of content, in the order they are declared
+func Declares(root *tree_sitter.Node, content []byte) []Declare {
+	declares := []Declare{}
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		if child := root.NamedChild(i); child.Kind() == "global_metadata" {
+			if declare, ok := declareOf(child, content); ok {
+				declares = append(declares, declare)
+			}
+		}
+	}
+	return declares
+}
+
+// Metadata of a global_metadata or function_metadata node
+func declareOf(node *tree_sitter.Node, content []byte) (Declare, bool) {
+	key, value := node.ChildByFieldName("key"), node.ChildByFieldName("value")
+	if key == nil || value == nil || value.EndByte()-value.StartByte() < 2 {
+		return Declare{}, false
+	}
+	declare := Declare{Key: key.Utf8Text(content), Value: stripQuotes(value.Utf8Text(content))}
+	if function := node.ChildByFieldName("function_name"); function != nil {
+		declare.Function = function.Utf8Text(content)
+	}
+	return declare, true
+}
+
+// Markdown listing declares, like **author**: Grame
+func declaresMarkdown(declares []Declare) string {
+	lines := []string{}
+	for _, declare := range declares {
+		lines = append(lines, fmt.Sprintf("**%s**: %s", declare.Key, declare.Value))
+	}
+	return strings.Join(lines, "  \n")
+}
+
+// MetadataHover documents the declare statement at offset with the metadata of its file, or the imported file at offset with its own metadata.
+// resolve returns the path an imported file is found at, or "" if it can't be found, and read returns the content of a file.
+func MetadataHover(root *tree_sitter.Node, content []byte, offset uint, resolve func(string) util.Path, read func(util.Path) ([]byte, bool)) (string, bool) {
+	for node := root.DescendantForByteRange(offset, offset); node != nil; node = node.Parent() {
+		switch node.Kind() {
+		case "global_metadata":
+			declares := Declares(root, content)
+			if len(declares) == 0 {
+				return "", false
+			}
+			return declaresMarkdown(declares), true
+		case "function_metadata":
+			declare, ok := declareOf(node, content)
+			if !ok {
+				return "", false
+			}
+			return fmt.Sprintf("`%s`  \n%s", declare.Function, declaresMarkdown([]Declare{declare})), true
+		case "file_import", "library":
+			fileName := node.ChildByFieldName("filename")
+			if fileName == nil || fileName.EndByte()-fileName.StartByte() < 2 {
+				return "", false
+			}
+			path := resolve(stripQuotes(fileName.Utf8Text(content)))
+			if path == "" {
+				return "", false
+			}
+			imported, ok := read(path)
+			if !ok {
+				return "", false
+			}
+			docs := fmt.Sprintf("`%s`", path)
+			tree := parser.ParseTree(imported)
+			if declares := Declares(tree.RootNode(), imported); len(declares) > 0 {
+				docs += "\n\n" + declaresMarkdown(declares)
+			}
+			tree.Close()
+			return docs, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters and timings of the work of the server since it started, returned by faustlsp/status so that slowness can be told apart:
+// slow handlers, many compilations or compilations missing the cache
+var metrics = Metrics{started: time.Now()}
+
+// Metrics counts the messages handled by the server and how long they took, along with the work they led to
+type Metrics struct {
+	started time.Time
+
+	mu      sync.Mutex
+	methods map[string]*methodTimes
+
+	diagnosticsPublished atomic.Int64
+	compilerRuns         atomic.Int64
+	compilerCacheHits    atomic.Int64
+	compilerCacheMisses  atomic.Int64
+}
+
+// Times of the messages of a method
+type methodTimes struct {
+	count  int64
+	errors int64
+	total  time.Duration
+	max    time.Duration
+}
+
+// Records that a message of method was handled in duration, failing if failed
+func (m *Metrics) handled(method string, duration time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.methods == nil {
+		m.methods = make(map[string]*methodTimes)
+	}
+	times, ok := m.methods[method]
+	if !ok {
+		times = &methodTimes{}
+		m.methods[method] = times
+	}
+	times.count++
+	if failed {
+		times.errors++
+	}
+	times.total += duration
+	times.max = max(times.max, duration)
+}
+
+// Counts and times of the messages of a method, in milliseconds
+type MethodMetrics struct {
+	Count  int64   `json:"count"`
+	Errors int64   `json:"errors"`
+	MeanMs float64 `json:"meanMs"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+type MetricsSnapshot struct {
+	UptimeSeconds        float64                  `json:"uptimeSeconds"`
+	Methods              map[string]MethodMetrics `json:"methods"` // By method, for requests and notifications
+	DiagnosticsPublished int64                    `json:"diagnosticsPublished"`
+	CompilerRuns         int64                    `json:"compilerRuns"`
+	CompilerCacheHits    int64                    `json:"compilerCacheHits"` // Files not compiled again for diagnostics as nothing they depend on changed
+	CompilerCacheMisses  int64                    `json:"compilerCacheMisses"`
+}
+
+// Snapshot returns the metrics recorded so far
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		UptimeSeconds:        time.Since(m.started).Seconds(),
+		Methods:              make(map[string]MethodMetrics),
+		DiagnosticsPublished: m.diagnosticsPublished.Load(),
+		CompilerRuns:         m.compilerRuns.Load(),
+		CompilerCacheHits:    m.compilerCacheHits.Load(),
+		CompilerCacheMisses:  m.compilerCacheMisses.Load(),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for method, times := range m.methods {
+		snapshot.Methods[method] = MethodMetrics{
+			Count:  times.count,
+			Errors: times.errors,
+			MeanMs: milliseconds(times.total / time.Duration(times.count)),
+			MaxMs:  milliseconds(times.max),
+		}
+	}
+	return snapshot
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
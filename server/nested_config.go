@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Config of a directory of the workspace with a .faustcfg.json of its own, like a project of a monorepo.
+// The keys its file sets override the config of the directories above for the files beneath it.
+type nestedConfig struct {
+	dir    util.Path
+	config FaustProjectConfig
+	keys   map[string]struct{}
+}
+
+// Parses content, the config file of the directory dir of the workspace. Its process files are made relative to the workspace root
+// like those of the root config, and its include, build and working directories absolute, as they are relative to dir.
+func (w *Workspace) parseNestedConfig(dir util.Path, content []byte) (nestedConfig, error) {
+	keys := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nestedConfig{}, err
+	}
+	var config FaustProjectConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nestedConfig{}, err
+	}
+	// The options of the active profile are set by the file too
+	w.mu.Lock()
+	profile := w.profile
+	w.mu.Unlock()
+	if profiled, ok := config.withProfile(profile); ok {
+		config = profiled
+		for _, key := range config.Profiles[profile].keys() {
+			keys[key] = nil
+		}
+	}
+	rel, _ := filepath.Rel(w.Root, dir)
+	for i, file := range config.ProcessFiles {
+		config.ProcessFiles[i] = filepath.Join(rel, file)
+	}
+	for i, include := range config.IncludeDir {
+		if !filepath.IsAbs(include) {
+			config.IncludeDir[i] = filepath.Join(dir, include)
+		}
+	}
+	if config.BuildDir != "" && !filepath.IsAbs(config.BuildDir) {
+		config.BuildDir = filepath.Join(dir, config.BuildDir)
+	}
+	if config.WorkingDir != "" && !filepath.IsAbs(config.WorkingDir) {
+		config.WorkingDir = filepath.Join(dir, config.WorkingDir)
+	}
+	nested := nestedConfig{dir: dir, config: config, keys: make(map[string]struct{})}
+	for key := range keys {
+		nested.keys[key] = struct{}{}
+	}
+	return nested, nil
+}
+
+// Returns config with the keys set by the nested config file replaced by its values
+func (n nestedConfig) mergeInto(config FaustProjectConfig) FaustProjectConfig {
+	merged := reflect.ValueOf(&config).Elem()
+	values := reflect.ValueOf(n.config)
+	for i := range merged.NumField() {
+		key, _, _ := strings.Cut(merged.Type().Field(i).Tag.Get("json"), ",")
+		if _, ok := n.keys[key]; ok {
+			merged.Field(i).Set(values.Field(i))
+		}
+	}
+	return config
+}
+
+// Reads the config files of the workspace's subdirectories, publishing their problems, and clears the diagnostics of those that are gone
+func (w *Workspace) loadNestedConfigs(s *Server) {
+	w.mu.Lock()
+	paths := []util.Path{}
+	for _, path := range w.Files {
+		if filepath.Base(path) == faustConfigFile && filepath.Dir(path) != w.Root {
+			paths = append(paths, path)
+		}
+	}
+	previous := w.nestedConfigs
+	w.mu.Unlock()
+	// Outer directories come first, so that the configs of inner ones are merged after them
+	slices.SortFunc(paths, func(a, b util.Path) int {
+		return strings.Count(a, string(filepath.Separator)) - strings.Count(b, string(filepath.Separator))
+	})
+
+	configs := []nestedConfig{}
+	for _, path := range paths {
+		f, ok := s.Files.GetFromPath(path)
+		if !ok {
+			continue
+		}
+		f.mu.RLock()
+		content := f.Content
+		f.mu.RUnlock()
+		dir := filepath.Dir(path)
+		w.publishNestedConfigDiagnostics(s, path, ConfigDiagnostics(content, dir, string(s.Files.encoding)))
+		nested, err := w.parseNestedConfig(dir, content)
+		if err != nil {
+			s.reportProblem(transport.Warning, fmt.Sprintf("Invalid %s in %s, ignoring it", faustConfigFile, filepath.Base(dir)), err, "path", path)
+			continue
+		}
+		configs = append(configs, nested)
+	}
+	for _, n := range previous {
+		path := filepath.Join(n.dir, faustConfigFile)
+		if !slices.Contains(paths, path) {
+			w.publishNestedConfigDiagnostics(s, path, []transport.Diagnostic{})
+		}
+	}
+	logging.Logger.Info("Nested project configs", "dirs", len(configs))
+
+	w.mu.Lock()
+	w.nestedConfigs = configs
+	w.mu.Unlock()
+}
+
+func (w *Workspace) publishNestedConfigDiagnostics(s *Server, path util.Path, diagnostics []transport.Diagnostic) {
+	if s.diagChan == nil {
+		return
+	}
+	s.diagChan <- transport.PublishDiagnosticsParams{URI: transport.DocumentURI(util.Path2URI(path)), Diagnostics: diagnostics}
+}
+
+// Copy of the config of the workspace, which loading its config files replaces
+func (w *Workspace) config() FaustProjectConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Config
+}
+
+// ConfigFor returns the config applying to the file at path: the workspace's, with the keys set by the config files of
+// the directories between the root and the file replaced, from the outermost directory to the innermost one.
+func (w *Workspace) ConfigFor(path util.Path) FaustProjectConfig {
+	w.mu.Lock()
+	nested := w.nestedConfigs
+	config := w.Config
+	w.mu.Unlock()
+	for _, n := range nested {
+		if isWithin(path, n.dir) {
+			config = n.mergeInto(config)
+		}
+	}
+	return config
+}
+
+// ProcessFiles returns the absolute paths of the process files of the workspace: the files listed in the process files of their own config,
+// whether it is the root config or a nested one
+func (w *Workspace) ProcessFiles() []util.Path {
+	w.mu.Lock()
+	nested := w.nestedConfigs
+	candidates := slices.Clone(w.Config.ProcessFiles)
+	w.mu.Unlock()
+	for _, n := range nested {
+		candidates = append(candidates, n.config.ProcessFiles...)
+	}
+
+	files := []util.Path{}
+	for _, file := range candidates {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(w.Root, file)
+		}
+		if !slices.Contains(files, path) && slices.Contains(w.ConfigFor(path).ProcessFiles, file) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
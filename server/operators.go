@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+type compositionDoc struct {
+	Name        string
+	Description string
+	Rule        string
+}
+
+// Documentation of composition operators keyed by the tree-sitter node kind of the composition
+var compositionDocs = map[string]compositionDoc{
+	"sequential": {
+		Name:        "Sequential composition",
+		Description: "`A : B` connects each output of `A` to the corresponding input of `B`.",
+		Rule:        "The number of outputs of `A` must be equal to the number of inputs of `B`.",
+	},
+	"parallel": {
+		Name:        "Parallel composition",
+		Description: "`A , B` stacks `A` and `B` on top of each other. Inputs and outputs of the result are the inputs and outputs of `A` followed by those of `B`.",
+		Rule:        "Any arities are allowed.",
+	},
+	"split": {
+		Name:        "Split composition",
+		Description: "`A <: B` distributes the outputs of `A` to the inputs of `B`, repeating them cyclically (one to many).",
+		Rule:        "The number of inputs of `B` must be a multiple of the number of outputs of `A`.",
+	},
+	"merge": {
+		Name:        "Merge composition",
+		Description: "`A :> B` sums the outputs of `A` into the inputs of `B` (many to one). Output `i` of `A` goes to input `i mod inputs(B)` of `B`.",
+		Rule:        "The number of outputs of `A` must be a multiple of the number of inputs of `B`.",
+	},
+	"recursive": {
+		Name:        "Recursive composition",
+		Description: "`A ~ B` feeds the outputs of `A` back into its own inputs through `B`, with an implicit one-sample delay.",
+		Rule:        "`B` can't have more inputs than `A` has outputs, nor more outputs than `A` has inputs.",
+	},
+}
+
+// OperatorHover returns hover documentation for the composition operator at the given byte offset, if any.
+// Definitions used at the site are picked for the given precision when they have variants.
+func OperatorHover(root *tree_sitter.Node, content []byte, offset uint, precision string) (string, bool) {
+	return operatorHover(root, content, offset, precision, nil)
+}
+
+// Like OperatorHover, with arities that can't be computed statically found by compiledArity if it isn't nil
+func operatorHover(root *tree_sitter.Node, content []byte, offset uint, precision string, compiledArity func(*tree_sitter.Node) (Arity, bool)) (string, bool) {
+	node := root.DescendantForByteRange(offset, offset)
+	if node == nil || node.IsNamed() {
+		return "", false
+	}
+	operator := node.Kind()
+	composition := node.Parent()
+	if composition == nil {
+		return "", false
+	}
+	doc, ok := compositionDocs[composition.Kind()]
+	if !ok {
+		return "", false
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**`%s` %s**\n\n", operator, doc.Name)
+	fmt.Fprintf(&b, "%s\n\n", doc.Description)
+	fmt.Fprintf(&b, "*Arity rule*: %s\n", doc.Rule)
+
+	arity := func(node *tree_sitter.Node) (Arity, bool) {
+		if a, ok := ExpressionArity(node, content, precision); ok || compiledArity == nil {
+			return a, ok
+		}
+		return compiledArity(node)
+	}
+	left, lok := arity(composition.ChildByFieldName("left"))
+	right, rok := arity(composition.ChildByFieldName("right"))
+	if lok || rok {
+		b.WriteString("\n*At this site*:\n")
+		if lok {
+			fmt.Fprintf(&b, "- `A` has %s\n", left)
+		}
+		if rok {
+			fmt.Fprintf(&b, "- `B` has %s\n", right)
+		}
+		if lok && rok {
+			result, ok := CompositionArity(composition.Kind(), left, right)
+			if ok {
+				fmt.Fprintf(&b, "- Result has %s\n", result)
+			} else {
+				b.WriteString("- **Arities don't satisfy the rule above**\n")
+			}
+		}
+	}
+
+	return b.String(), true
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+)
+
+// List responses are sorted by range, then name, so that identical requests give identical responses.
+// Items also get IDs derived from their contents where the protocol has a data field, which stay the same across requests.
+
+func comparePositions(a, b transport.Position) int {
+	if c := cmp.Compare(a.Line, b.Line); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Character, b.Character)
+}
+
+func compareRanges(a, b transport.Range) int {
+	if c := comparePositions(a.Start, b.Start); c != 0 {
+		return c
+	}
+	return comparePositions(a.End, b.End)
+}
+
+// Short ID identifying an item by its contents
+func stableID(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:8])
+}
+
+func idData(id string) map[string]string {
+	return map[string]string{"id": id}
+}
+
+// SortDiagnostics sorts diagnostics by range then message, and assigns each one an ID
+func SortDiagnostics(diagnostics []transport.Diagnostic) {
+	slices.SortStableFunc(diagnostics, func(a, b transport.Diagnostic) int {
+		if c := compareRanges(a.Range, b.Range); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Message, b.Message); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Source, b.Source)
+	})
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		if d.Data != nil {
+			continue
+		}
+		data, _ := json.Marshal(idData(stableID(fmt.Sprint(d.Range), d.Source, fmt.Sprint(d.Code), d.Message)))
+		raw := json.RawMessage(data)
+		d.Data = &raw
+	}
+}
+
+// SortDocumentSymbols sorts symbols and their children by range then name
+func SortDocumentSymbols(symbols []transport.DocumentSymbol) {
+	slices.SortStableFunc(symbols, func(a, b transport.DocumentSymbol) int {
+		if c := compareRanges(a.Range, b.Range); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	for i := range symbols {
+		SortDocumentSymbols(symbols[i].Children)
+	}
+}
+
+// SortCompletionItems sorts completion items by the rank in their sort text then by label, removing duplicates, and sets their sort text and IDs so that editors keep this order
+func SortCompletionItems(items []transport.CompletionItem) []transport.CompletionItem {
+	// Items from inner scopes come first and shadow the same names from outer scopes
+	seen := make(map[string]struct{})
+	unique := []transport.CompletionItem{}
+	for _, item := range items {
+		if _, ok := seen[item.Label]; ok {
+			continue
+		}
+		seen[item.Label] = struct{}{}
+		unique = append(unique, item)
+	}
+
+	// Items are ordered by the rank given as their sort text, then by label
+	slices.SortStableFunc(unique, func(a, b transport.CompletionItem) int {
+		return cmp.Or(cmp.Compare(a.SortText, b.SortText), cmp.Compare(a.Label, b.Label))
+	})
+	width := len(fmt.Sprint(len(unique)))
+	for i := range unique {
+		unique[i].SortText = fmt.Sprintf("%0*d", width, i)
+		id := stableID(unique[i].Label, fmt.Sprint(unique[i].Kind))
+		if data, ok := unique[i].Data.(completionData); ok {
+			data.ID = id
+			unique[i].Data = data
+		} else {
+			unique[i].Data = idData(id)
+		}
+	}
+	return unique
+}
+
+// SortFoldingRanges sorts folds by their lines
+func SortFoldingRanges(folds []transport.FoldingRange) {
+	line := func(l *uint32) uint32 {
+		if l == nil {
+			return 0
+		}
+		return *l
+	}
+	slices.SortStableFunc(folds, func(a, b transport.FoldingRange) int {
+		if c := cmp.Compare(line(a.StartLine), line(b.StartLine)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(line(a.EndLine), line(b.EndLine)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.CollapsedText, b.CollapsedText)
+	})
+}
+
+// SortInlayHints sorts hints by position then label, and assigns each one an ID
+func SortInlayHints(hints []transport.InlayHint) {
+	label := func(h transport.InlayHint) string {
+		var b strings.Builder
+		for _, part := range h.Label {
+			b.WriteString(part.Value)
+		}
+		return b.String()
+	}
+	slices.SortStableFunc(hints, func(a, b transport.InlayHint) int {
+		if c := comparePositions(a.Position, b.Position); c != 0 {
+			return c
+		}
+		return cmp.Compare(label(a), label(b))
+	})
+	for i := range hints {
+		hints[i].Data = idData(stableID(fmt.Sprint(hints[i].Position), label(hints[i])))
+	}
+}
+
+// SortCodeActions sorts code actions by the range of their first diagnostic or edit, then title
+func SortCodeActions(actions []transport.CodeAction) {
+	actionRange := func(a transport.CodeAction) transport.Range {
+		if len(a.Diagnostics) > 0 {
+			return a.Diagnostics[0].Range
+		}
+		if a.Edit != nil {
+			for _, edits := range a.Edit.Changes {
+				if len(edits) > 0 {
+					return edits[0].Range
+				}
+			}
+		}
+		return transport.Range{}
+	}
+	slices.SortStableFunc(actions, func(a, b transport.CodeAction) int {
+		if c := compareRanges(actionRange(a), actionRange(b)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Title, b.Title)
+	})
+}
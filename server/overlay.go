@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+)
+
+// The compiler runs against the workspace itself. Only the unsaved buffers of documents open in the editor are written,
+// to an overlay mirroring the workspace in the temporary directory, which imports are resolved from first.
+
+// Writes content, the buffer of the document at path, to the overlay if it differs from the file on disk, and removes it from the overlay otherwise
+func (w *Workspace) syncOverlay(path util.Path, content []byte) {
+	if disk, err := os.ReadFile(path); err == nil && bytes.Equal(disk, content) {
+		w.removeOverlay(path)
+		return
+	}
+	overlayPath := w.TempDirPath(path)
+	os.MkdirAll(filepath.Dir(overlayPath), 0755)
+	if err := os.WriteFile(overlayPath, content, 0644); err != nil {
+		logging.Logger.Error("Couldn't write unsaved buffer to overlay", "path", overlayPath, "error", err)
+		return
+	}
+	w.mu.Lock()
+	if w.dirty == nil {
+		w.dirty = make(map[util.Path]struct{})
+	}
+	w.dirty[path] = struct{}{}
+	w.mu.Unlock()
+}
+
+// Removes the document at path from the overlay, once its buffer is saved, closed or its file removed
+func (w *Workspace) removeOverlay(path util.Path) {
+	os.Remove(w.TempDirPath(path))
+	w.mu.Lock()
+	delete(w.dirty, path)
+	w.mu.Unlock()
+}
+
+// Rewrites the copy of the saved file at path in the overlay, if compiling along unsaved buffers made one, so that it doesn't shadow content
+func (w *Workspace) refreshCopy(path util.Path, content []byte) {
+	if _, err := os.Stat(w.TempDirPath(path)); err == nil {
+		os.WriteFile(w.TempDirPath(path), content, 0644)
+	}
+}
+
+// DirtyFiles lists the documents whose unsaved buffers are in the overlay
+func (w *Workspace) DirtyFiles() []util.Path {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	files := []util.Path{}
+	for path := range w.dirty {
+		files = append(files, path)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// Returns the file to give the compiler to compile path, the directory to run it from and config with absolute include directories.
+// Without unsaved buffers, this is path itself from the import root. Otherwise path is compiled from the overlay, a copy being written if it is saved,
+// so that imports are looked up next to it in the overlay then in the workspace, and each include directory in the overlay before the workspace.
+func (w *Workspace) compilation(path util.Path, config FaustProjectConfig) (util.Path, util.Path, FaustProjectConfig) {
+	root := w.ImportRoot(path)
+	includes := []util.Path{}
+	for _, include := range config.IncludeDir {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(root, include)
+		}
+		includes = append(includes, include)
+	}
+
+	w.mu.Lock()
+	_, dirty := w.dirty[path]
+	overlaid := len(w.dirty) > 0
+	w.mu.Unlock()
+	if !overlaid {
+		config.IncludeDir = includes
+		return path, root, config
+	}
+
+	overlayPath := w.TempDirPath(path)
+	if !dirty {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			os.MkdirAll(filepath.Dir(overlayPath), 0755)
+			err = os.WriteFile(overlayPath, content, 0644)
+		}
+		if err != nil {
+			logging.Logger.Error("Couldn't copy file to overlay, compiling it from the workspace", "path", path, "error", err)
+			config.IncludeDir = includes
+			return path, root, config
+		}
+	}
+	config.IncludeDir = []util.Path{}
+	for _, dir := range append([]util.Path{filepath.Dir(path), root}, includes...) {
+		for _, include := range []util.Path{w.TempDirPath(dir), dir} {
+			if !slices.Contains(config.IncludeDir, include) {
+				config.IncludeDir = append(config.IncludeDir, include)
+			}
+		}
+	}
+	// Opening an overlay directory that isn't there would fail the compiler
+	os.MkdirAll(w.TempDirPath(root), 0755)
+	return overlayPath, w.TempDirPath(root), config
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Floating point precisions the Faust compiler can generate code for
+const (
+	SinglePrecision     = "single"
+	DoublePrecision     = "double"
+	QuadPrecision       = "quad"
+	FixedPointPrecision = "fixedpoint"
+)
+
+// Compiler flag selecting each precision. Single precision is the compiler's default.
+var precisionFlags = map[string]string{
+	SinglePrecision:     "-single",
+	DoublePrecision:     "-double",
+	QuadPrecision:       "-quad",
+	FixedPointPrecision: "-fx",
+}
+
+// Precision selected by each variant keyword (tree-sitter node kind) in front of a definition
+var variantPrecisions = map[string]string{
+	"single_precision":      SinglePrecision,
+	"double_precision":      DoublePrecision,
+	"quad_precision":        QuadPrecision,
+	"fixed_point_precision": FixedPointPrecision,
+}
+
+// Maximum number of significant decimal digits that are distinguishable in each precision
+var precisionDigits = map[string]int{
+	SinglePrecision: 9,
+	DoublePrecision: 17,
+	QuadPrecision:   36,
+}
+
+// EffectivePrecision returns the precision the project is compiled with, as set in the config
+func (c FaustProjectConfig) EffectivePrecision() string {
+	if _, ok := precisionFlags[c.Precision]; ok {
+		return c.Precision
+	}
+	return SinglePrecision
+}
+
+// Precision set in the config, empty if it isn't set to one the compiler can generate code for
+func (c FaustProjectConfig) explicitPrecision() string {
+	if _, ok := precisionFlags[c.Precision]; ok {
+		return c.Precision
+	}
+	return ""
+}
+
+// DefinitionVariants returns the precisions a definition, function definition or import is restricted to with singleprecision, doubleprecision etc.
+// Returns nil if the definition applies to all precisions.
+func DefinitionVariants(node *tree_sitter.Node) []string {
+	if node == nil || node.NamedChildCount() == 0 {
+		return nil
+	}
+	variants := node.NamedChild(0)
+	if variants.Kind() != "variants" {
+		return nil
+	}
+	var precisions []string
+	for i := uint(0); i < variants.NamedChildCount(); i++ {
+		if precision, ok := variantPrecisions[variants.NamedChild(i).Kind()]; ok {
+			precisions = append(precisions, precision)
+		}
+	}
+	return precisions
+}
+
+// Whether a definition restricted to variants is used when compiling in precision
+func variantsMatch(variants []string, precision string) bool {
+	return len(variants) == 0 || slices.Contains(variants, precision)
+}
+
+// Markdown note describing which precisions a symbol is defined for
+func precisionNote(variants []string, precision string) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	flags := make([]string, len(variants))
+	for i, variant := range variants {
+		flags[i] = fmt.Sprintf("`%s`", precisionFlags[variant])
+	}
+	note := fmt.Sprintf("*Only defined in %s precision (%s)*", strings.Join(variants, ", "), strings.Join(flags, ", "))
+	if !variantsMatch(variants, precision) {
+		note += fmt.Sprintf("  \n**Not used when compiling in %s precision**", precision)
+	}
+	return note
+}
+
+// PrecisionDiagnostics warns about real literals with more significant digits than the precision they are compiled in can represent.
+// Literals inside definitions restricted to some precisions are checked against those precisions instead of the project's.
+// precision is the one set for the project, empty if it isn't set, in which case literals outside such definitions are checked against
+// the default single precision with hints rather than warnings, as most projects never meant to choose it.
+func PrecisionDiagnostics(root *tree_sitter.Node, content []byte, precision string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	var walk func(node *tree_sitter.Node, precisions []string, severity transport.DiagnosticSeverity)
+	walk = func(node *tree_sitter.Node, precisions []string, severity transport.DiagnosticSeverity) {
+		if variants := DefinitionVariants(node); variants != nil {
+			precisions, severity = variants, transport.SeverityWarning
+		}
+		if node.Kind() == "real" {
+			diagnostics = append(diagnostics, literalPrecisionDiagnostics(node, content, precisions, severity)...)
+			return
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i), precisions, severity)
+		}
+	}
+	if precision == "" {
+		walk(root, []string{SinglePrecision}, transport.SeverityHint)
+	} else {
+		walk(root, []string{precision}, transport.SeverityWarning)
+	}
+	return diagnostics
+}
+
+func literalPrecisionDiagnostics(node *tree_sitter.Node, content []byte, precisions []string, severity transport.DiagnosticSeverity) []transport.Diagnostic {
+	literal := node.Utf8Text(content)
+	digits := significantDigits(literal)
+	for _, precision := range precisions {
+		limit, ok := precisionDigits[precision]
+		if !ok || digits <= limit {
+			continue
+		}
+		return []transport.Diagnostic{
+			{
+				Range:    ToRange(node),
+				Severity: severity,
+				Source:   "faustlsp",
+				Message:  fmt.Sprintf("Literal %s has %d significant digits, but %s precision can only represent %d", literal, digits, precision, limit),
+			},
+		}
+	}
+	return nil
+}
+
+// Number of significant decimal digits of a real literal like 3.1415, 1e-3 or .5
+func significantDigits(literal string) int {
+	mantissa, _, _ := strings.Cut(strings.ToLower(literal), "e")
+	mantissa = strings.TrimRight(mantissa, "f")
+	mantissa = strings.ReplaceAll(mantissa, ".", "")
+	mantissa = strings.TrimLeft(mantissa, "+-0")
+	mantissa = strings.TrimRight(mantissa, "0")
+	return len(mantissa)
+}
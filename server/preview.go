@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Nested with and letrec blocks shown in definition previews by default
+const defaultPreviewDepth = 1
+
+// Replaces the bodies of with and letrec blocks nested deeper than the preview depth
+const previewElision = "{ ... }"
+
+// Result of faustlsp/definitionBody, the whole statement defining a symbol
+type DefinitionBody struct {
+	URI   transport.DocumentURI `json:"uri"`
+	Range transport.Range       `json:"range"`
+	Text  string                `json:"text"`
+}
+
+// DefinitionPreview returns the text of definition with the bodies of the with and letrec blocks nested more than depth deep elided,
+// so that previews of long instruments stay readable. A negative depth keeps the whole definition.
+func DefinitionPreview(content []byte, definition *tree_sitter.Node, depth int) string {
+	var b strings.Builder
+	last := definition.StartByte()
+	var walk func(node *tree_sitter.Node, level int)
+	walk = func(node *tree_sitter.Node, level int) {
+		if node.Kind() == "with_environment" || node.Kind() == "letrec_environment" {
+			if env := node.ChildByFieldName("local_environment"); env != nil {
+				if expression := node.ChildByFieldName("expression"); expression != nil {
+					walk(expression, level)
+				}
+				if depth >= 0 && level >= depth {
+					b.Write(content[last:env.StartByte()])
+					b.WriteString(previewElision)
+					last = env.EndByte()
+					return
+				}
+				walk(env, level+1)
+				return
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i), level)
+		}
+	}
+	walk(definition, 0)
+	end := definition.EndByte()
+	// Definition nodes leave out their semicolon
+	if next := definition.NextSibling(); next != nil && next.Kind() == ";" {
+		end = next.EndByte()
+	}
+	b.Write(content[last:end])
+	return dedent(b.String(), definition.StartPosition().Column)
+}
+
+// Removes up to column leading blanks from the lines after the first, for text that started at column
+func dedent(text string, column uint) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		line := lines[i]
+		n := uint(0)
+		for n < column && n < uint(len(line)) && (line[n] == ' ' || line[n] == '\t') {
+			n++
+		}
+		lines[i] = line[n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Definition node of the symbol whose identifier is at loc, calling found with it and its file's content
+func (s *Server) withDefinition(loc Location, found func(content []byte, definition *tree_sitter.Node)) bool {
+	f, ok := s.Files.GetFromPath(loc.File)
+	if !ok {
+		return false
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	f.mu.RLock()
+	lines := f.lines
+	f.mu.RUnlock()
+
+	offset, err := positionToOffset(lines, loc.Range.Start, content, string(s.Files.encoding))
+	if err != nil {
+		return false
+	}
+	definition := definitionAt(tree.RootNode(), offset)
+	// Arguments and pattern variables are inside definitions without being defined by them
+	if definition == nil || definitionIdentifier(definition) == nil || ToRange(definitionIdentifier(definition)) != loc.Range {
+		return false
+	}
+	found(content, definition)
+	return true
+}
+
+// Markdown previewing the definition of sym, appended to its hover
+func (s *Server) definitionHover(sym Symbol) (string, bool) {
+	var preview string
+	ok := s.withDefinition(sym.Loc, func(content []byte, definition *tree_sitter.Node) {
+		preview = DefinitionPreview(content, definition, s.workspaceFor(sym.Loc.File).ConfigFor(sym.Loc.File).PreviewDepth)
+	})
+	if !ok {
+		return "", false
+	}
+	return "```faust\n" + preview + "\n```", true
+}
+
+// DefinitionBodyRequest returns the whole definition of the symbol at a position, for clients showing previews with elided blocks
+func DefinitionBodyRequest(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.TextDocumentPositionParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return []byte("null"), err
+	}
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get definition body from non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	f.mu.RLock()
+	lines, fileScope := f.lines, f.Scope
+	f.mu.RUnlock()
+	offset, err := positionToOffset(lines, params.Position, content, string(s.Files.encoding))
+	if err != nil {
+		tree.Close()
+		return []byte("null"), err
+	}
+
+	ident, scope := FindSymbolScope(tree.RootNode(), content, fileScope, offset)
+	tree.Close()
+	if ident == "" {
+		return []byte("null"), nil
+	}
+	sym, err := ResolveSymbol(ident, scope, &s.Store)
+	if err != nil {
+		logging.Logger.Info("No definition body", "ident", ident, "error", err)
+		return []byte("null"), nil
+	}
+	var body DefinitionBody
+	ok = s.withDefinition(sym.Loc, func(content []byte, definition *tree_sitter.Node) {
+		body = DefinitionBody{
+			URI:   transport.DocumentURI(util.Path2URI(sym.Loc.File)),
+			Range: ToRange(definition),
+			Text:  DefinitionPreview(content, definition, -1),
+		}
+	})
+	if !ok {
+		return []byte("null"), nil
+	}
+	return json.Marshal(body)
+}
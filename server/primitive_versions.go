@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+const unsupportedPrimitiveCode = "unsupported-primitive"
+
+// Primitive or syntax that compilers only know from some version on
+type versionedPrimitive struct {
+	name        string
+	since       string // First release of the compiler with it, from the Faust release notes
+	signature   string
+	description string
+	snippet     string // Completion with tab stops, empty for syntax that isn't completed
+	// Whether it is written as an identifier, which files can define themselves, rather than being a keyword or syntax
+	identifier bool
+}
+
+// Tree-sitter node kinds of the syntax, which are its keywords for primitives like lowest
+var versionedPrimitives = map[string]versionedPrimitive{
+	"waveform": {
+		name: "waveform", since: "2.0.0", signature: "waveform{values}",
+		description: "Constant periodic signal of the values, with their number as a second output.",
+		snippet:     "waveform{${1:0, 1}}",
+	},
+	"soundfile": {
+		name: "soundfile", since: "2.5.0", signature: "soundfile(label, outputs)",
+		description: "Plays the parts of the sound files of the label, with their lengths and rates as extra outputs.",
+		snippet:     `soundfile("${1:label}[url:{'${2:file.wav}'}]", ${3:1})`,
+	},
+	"route": {
+		name: "route", since: "2.8.0", signature: "route(inputs, outputs, connections)",
+		description: "Connects inputs to outputs by the pairs of numbers of the connections, starting from 1.",
+		snippet:     "route(${1:2}, ${2:2}, ${3:(1, 2), (2, 1)})",
+	},
+	"lowest": {
+		name: "lowest", since: "2.37.0", signature: "lowest(x)",
+		description: "Lowest value the compiler computed the signal can take.",
+		snippet:     "lowest(${1:x})",
+	},
+	"highest": {
+		name: "highest", since: "2.37.0", signature: "highest(x)",
+		description: "Highest value the compiler computed the signal can take.",
+		snippet:     "highest(${1:x})",
+	},
+	"assertbounds": {
+		name: "assertbounds", since: "2.37.0", signature: "assertbounds(lo, hi, x)",
+		description: "Tells the compiler that the signal stays between lo and hi.",
+		snippet:     "assertbounds(${1:lo}, ${2:hi}, ${3:x})",
+	},
+	"modulation": {
+		name: "widget modulation", since: "2.41.0", signature: `["label": circuit -> expression]`,
+		description: "Inserts the circuit between the widgets of the label in the expression and their uses.",
+	},
+	"ondemand": {
+		name: "ondemand", since: "2.69.0", signature: "ondemand(expression)",
+		description: "Computes the expression only on the samples its first input, a clock, is non-zero.",
+		snippet:     "ondemand(${1:expression})", identifier: true,
+	},
+	"upsampling": {
+		name: "upsampling", since: "2.69.0", signature: "upsampling(expression)",
+		description: "Computes the expression at a rate multiplied by its first input.",
+		snippet:     "upsampling(${1:expression})", identifier: true,
+	},
+	"downsampling": {
+		name: "downsampling", since: "2.69.0", signature: "downsampling(expression)",
+		description: "Computes the expression at a rate divided by its first input.",
+		snippet:     "downsampling(${1:expression})", identifier: true,
+	},
+}
+
+// Whether the compiler of version knows the primitive. Unknown versions are assumed to know everything.
+func (p versionedPrimitive) supportedBy(version string) bool {
+	return version == "" || CompareVersions(version, p.since) >= 0
+}
+
+// Versioned primitive node is, given the top-level names of its file, which primitives written as identifiers can be
+func primitiveOf(node *tree_sitter.Node, content []byte, defined map[string]struct{}) (versionedPrimitive, bool) {
+	kind := node.Kind()
+	if kind == "identifier" {
+		name := node.Utf8Text(content)
+		p, ok := versionedPrimitives[name]
+		if _, shadowed := defined[name]; !ok || !p.identifier || shadowed {
+			return versionedPrimitive{}, false
+		}
+		return p, true
+	}
+	p, ok := versionedPrimitives[kind]
+	if !ok || p.identifier || isOwnKeyword(node) {
+		return versionedPrimitive{}, false
+	}
+	return p, true
+}
+
+// Whether node is the keyword of a node of the same kind, like the one of waveform{...}, rather than a primitive like lowest on its own
+func isOwnKeyword(node *tree_sitter.Node) bool {
+	return !node.IsNamed() && node.Parent() != nil && node.Parent().Kind() == node.Kind()
+}
+
+// Names defined at the top level of the file whose tree is root
+func topLevelNames(root *tree_sitter.Node, content []byte) map[string]struct{} {
+	names := make(map[string]struct{})
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		if name := definitionIdentifier(root.NamedChild(i)); name != nil {
+			names[name.Utf8Text(content)] = struct{}{}
+		}
+	}
+	return names
+}
+
+// PrimitiveVersionDiagnostics warns about the primitives and syntax of content the compiler of version doesn't know yet.
+// Nothing is reported when the version is unknown.
+func PrimitiveVersionDiagnostics(root *tree_sitter.Node, content []byte, version string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	if version == "" {
+		return diagnostics
+	}
+	defined := topLevelNames(root, content)
+
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if p, ok := primitiveOf(node, content, defined); ok && !p.supportedBy(version) {
+			diagnostics = append(diagnostics, transport.Diagnostic{
+				Range:    ToRange(node),
+				Severity: transport.DiagnosticSeverity(transport.Warning),
+				Code:     unsupportedPrimitiveCode,
+				Source:   "faustlsp",
+				Message:  fmt.Sprintf("%s needs Faust %s or later, the compiler is version %s", p.name, p.since, version),
+			})
+		}
+		// Primitives like lowest are keyword tokens
+		for i := uint(0); i < node.ChildCount(); i++ {
+			walk(node.Child(i))
+		}
+	}
+	walk(root)
+	return diagnostics
+}
+
+// PrimitiveCompletionItems completes the versioned primitives the compiler of version knows by replacing r,
+// with tab stops for their parameters for clients supporting snippets
+func PrimitiveCompletionItems(r transport.Range, snippetSupport bool, version string) []transport.CompletionItem {
+	items := []transport.CompletionItem{}
+	format := transport.PlainTextTextFormat
+	if snippetSupport {
+		format = transport.SnippetTextFormat
+	}
+	for _, kind := range slices.Sorted(maps.Keys(versionedPrimitives)) {
+		p := versionedPrimitives[kind]
+		if p.snippet == "" || !p.supportedBy(version) {
+			continue
+		}
+		text := p.name
+		if snippetSupport {
+			text = p.snippet
+		}
+		items = append(items, transport.CompletionItem{
+			Label:  p.name,
+			Kind:   transport.FunctionCompletion,
+			Detail: p.signature,
+			Documentation: &transport.Or_CompletionItem_documentation{
+				Value: transport.MarkupContent{Kind: transport.Markdown, Value: fmt.Sprintf("%s\n\nSince Faust %s.", p.description, p.since)},
+			},
+			InsertTextFormat: &format,
+			TextEdit:         transport.TextEdit{NewText: text, Range: r},
+		})
+	}
+	return items
+}
+
+// PrimitiveHover documents the versioned primitive at offset, telling whether the compiler of version knows it
+func PrimitiveHover(root *tree_sitter.Node, content []byte, offset uint, version string) (string, bool) {
+	node := root.DescendantForByteRange(offset, offset)
+	if node == nil {
+		return "", false
+	}
+	if isOwnKeyword(node) {
+		node = node.Parent()
+	}
+	p, ok := primitiveOf(node, content, topLevelNames(root, content))
+	if !ok {
+		return "", false
+	}
+	docs := fmt.Sprintf("```faust\n%s\n```\n%s\n\nSince Faust %s.", p.signature, p.description, p.since)
+	if !p.supportedBy(version) {
+		docs += fmt.Sprintf(" The compiler is version %s and doesn't know it.", version)
+	}
+	return docs, true
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Time within which the same problem is only told to the client once, and in which at most maxProblems are told to it
+const (
+	problemInterval = time.Minute
+	maxProblems     = 5
+)
+
+// ProblemLimiter decides which problems of the server are told to the client,
+// so that a problem happening over and over, or many of them at once, don't flood the user with messages
+type ProblemLimiter struct {
+	mu sync.Mutex
+	// Last time each problem was told
+	told map[string]time.Time
+	// Times problems were told within the interval
+	recent []time.Time
+}
+
+// Allow tells whether the problem with message can be told to the client at now, counting it as told if so
+func (l *ProblemLimiter) Allow(message string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.told[message]; ok && now.Sub(last) < problemInterval {
+		return false
+	}
+	recent := l.recent[:0]
+	for _, t := range l.recent {
+		if now.Sub(t) < problemInterval {
+			recent = append(recent, t)
+		}
+	}
+	l.recent = recent
+	if len(l.recent) >= maxProblems {
+		return false
+	}
+	if l.told == nil {
+		l.told = make(map[string]time.Time)
+	}
+	l.told[message] = now
+	l.recent = append(l.recent, now)
+	return true
+}
+
+// Reports a problem of the server, like a missing compiler or an invalid config, rather than only writing it to the log file.
+// It is logged to the client's output with window/logMessage, and errors and warnings are shown to the user with window/showMessage,
+// unless the same message was told recently. err is appended to the message told, args are only logged along with it.
+func (s *Server) reportProblem(messageType transport.MessageType, message string, err error, args ...any) {
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	switch messageType {
+	case transport.Error:
+		logging.Logger.Error(message, args...)
+	case transport.Warning:
+		logging.Logger.Warn(message, args...)
+	case transport.Info:
+		logging.Logger.Info(message, args...)
+	default:
+		logging.Logger.Debug(message, args...)
+	}
+
+	if !s.problems.Allow(message, time.Now()) {
+		return
+	}
+	told := message
+	if err != nil {
+		told += ": " + err.Error()
+	}
+	s.logMessage(messageType, told)
+	if messageType == transport.Error || messageType == transport.Warning {
+		s.showMessage(messageType, told)
+	}
+}
+
+// Writes message to the client's output with window/logMessage
+func (s *Server) logMessage(messageType transport.MessageType, message string) {
+	params, _ := json.Marshal(transport.LogMessageParams{Type: messageType, Message: message})
+	if err := s.Transport.WriteNotif("window/logMessage", params); err != nil {
+		logging.Logger.Warn("Couldn't write message", "error", err)
+	}
+}
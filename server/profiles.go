@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command switching the profile of the project config diagnostics and builds use
+const selectProfileCommand = "faustlsp.selectProfile"
+
+// Profile is a named variant of the project config, like debug, release or wasm. The options it sets replace those of the config while it is active.
+type Profile struct {
+	Command      string      `json:"command,omitempty"`
+	CompilerArgs []string    `json:"compiler_args,omitempty"`
+	ProcessFiles []util.Path `json:"process_files,omitempty"`
+}
+
+// Keys of .faustcfg.json the profile sets
+func (p Profile) keys() []string {
+	keys := []string{}
+	if p.Command != "" {
+		keys = append(keys, "command")
+	}
+	if p.CompilerArgs != nil {
+		keys = append(keys, "compiler_args")
+	}
+	if p.ProcessFiles != nil {
+		keys = append(keys, "process_files")
+	}
+	return keys
+}
+
+// Returns the config with the options of its profile name applied, or the config as it is if it has no such profile
+func (config FaustProjectConfig) withProfile(name string) (FaustProjectConfig, bool) {
+	profile, ok := config.Profiles[name]
+	if name == "" || !ok {
+		return config, false
+	}
+	if profile.Command != "" {
+		config.Command = profile.Command
+	}
+	if profile.CompilerArgs != nil {
+		config.CompilerArgs = profile.CompilerArgs
+	}
+	if profile.ProcessFiles != nil {
+		config.ProcessFiles = profile.ProcessFiles
+	}
+	return config, true
+}
+
+// Name of the active profile: the one selected with faustlsp.selectProfile, or else the one of the client's settings
+func (w *Workspace) activeProfile(settings ClientSettings) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.selectedProfile != "" {
+		return w.selectedProfile
+	}
+	if settings.Profile != nil {
+		return *settings.Profile
+	}
+	return ""
+}
+
+// Whether the workspace's config or one of its nested configs has the profile name
+func (w *Workspace) hasProfile(name string) bool {
+	if _, ok := w.config().Profiles[name]; ok {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, n := range w.nestedConfigs {
+		if _, ok := n.config.Profiles[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Argument of the faustlsp.selectProfile command
+type SelectProfileArgs struct {
+	// Name of the profile, or empty to go back to the profile of the settings
+	Name string `json:"name"`
+	// Root of the workspace folder whose profile is switched, every folder if empty
+	Root util.Path `json:"root,omitempty"`
+}
+
+// SelectProfileCommand makes a profile of the project config active, re-diagnosing the workspace with it
+func SelectProfileCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", selectProfileCommand, len(arguments))
+	}
+	var args SelectProfileArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	workspaces := s.workspaces()
+	if args.Root != "" {
+		workspaces = []*Workspace{s.workspaceFor(args.Root)}
+	}
+	if args.Name != "" {
+		found := false
+		for _, w := range workspaces {
+			found = found || w.hasProfile(args.Name)
+		}
+		if !found {
+			return []byte("null"), fmt.Errorf("no profile %s in %s", args.Name, faustConfigFile)
+		}
+	}
+
+	logging.Logger.Info("Selecting profile", "name", args.Name, "root", args.Root)
+	for _, w := range workspaces {
+		w.mu.Lock()
+		w.selectedProfile = args.Name
+		w.mu.Unlock()
+		w.reloadConfig(s, false)
+	}
+	if args.Name != "" {
+		s.showMessage(transport.Info, fmt.Sprintf("Using the %s profile", args.Name))
+	}
+	return []byte("null"), nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Counter for tokens of server initiated progress. Progress requests get string IDs so they never collide with client request IDs.
+var progressCounter atomic.Int64
+
+// Work done progress shown by the editor for long running server tasks.
+// A zero Progress, for clients that don't support server initiated progress, does nothing.
+type Progress struct {
+	s     *Server
+	token string
+	// Cancels the context of cancellable progress
+	cancel context.CancelFunc
+}
+
+// Creates a progress with title if the client supports it
+func (s *Server) beginProgress(title string) Progress {
+	return s.createProgress(transport.WorkDoneProgressBegin{Kind: "begin", Title: title})
+}
+
+// Creates a progress with title that the user can cancel, returning a context canceled when they do or when ctx is.
+// The progress must be ended to release the context.
+func (s *Server) beginCancellableProgress(ctx context.Context, title string) (Progress, context.Context) {
+	p := s.createProgress(transport.WorkDoneProgressBegin{Kind: "begin", Title: title, Cancellable: true})
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
+	if p.s != nil {
+		s.pendingMu.Lock()
+		if s.progressCancels == nil {
+			s.progressCancels = make(map[string]context.CancelFunc)
+		}
+		s.progressCancels[p.token] = cancel
+		s.pendingMu.Unlock()
+	}
+	return p, ctx
+}
+
+func (s *Server) createProgress(begin transport.WorkDoneProgressBegin) Progress {
+	if !s.workDoneProgress {
+		return Progress{}
+	}
+	token := fmt.Sprintf("faustlsp-progress-%d", progressCounter.Add(1))
+	params, _ := json.Marshal(transport.WorkDoneProgressCreateParams{Token: token})
+	if err := s.Transport.WriteRequest(token, "window/workDoneProgress/create", params); err != nil {
+		logging.Logger.Error("Couldn't create progress", "error", err)
+		return Progress{}
+	}
+	p := Progress{s: s, token: token}
+	p.notify(begin)
+	return p
+}
+
+// WorkDoneProgressCancel handles the user canceling a cancellable progress
+func WorkDoneProgressCancel(ctx context.Context, s *Server, par json.RawMessage) error {
+	var params transport.WorkDoneProgressCancelParams
+	json.Unmarshal(par, &params)
+
+	token, _ := params.Token.(string)
+	s.pendingMu.Lock()
+	cancel, ok := s.progressCancels[token]
+	s.pendingMu.Unlock()
+	if ok {
+		logging.Logger.Info("Progress canceled by the user", "token", token)
+		cancel()
+	}
+	return nil
+}
+
+// Reports done out of total steps
+func (p Progress) report(message string, done, total int) {
+	var percentage uint32 = 100
+	if total > 0 {
+		percentage = uint32(done * 100 / total)
+	}
+	p.notify(transport.WorkDoneProgressReport{Kind: "report", Message: message, Percentage: &percentage})
+}
+
+// Reports message for work whose total isn't known
+func (p Progress) message(message string) {
+	p.notify(transport.WorkDoneProgressReport{Kind: "report", Message: message})
+}
+
+func (p Progress) end(message string) {
+	p.notify(transport.WorkDoneProgressEnd{Kind: "end", Message: message})
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	if p.s != nil {
+		p.s.pendingMu.Lock()
+		delete(p.s.progressCancels, p.token)
+		p.s.pendingMu.Unlock()
+	}
+}
+
+func (p Progress) notify(value any) {
+	if p.s == nil {
+		return
+	}
+	params, _ := json.Marshal(transport.ProgressParams{Token: p.token, Value: value})
+	if err := p.s.Transport.WriteNotif("$/progress", params); err != nil {
+		logging.Logger.Error("Couldn't report progress", "error", err)
+	}
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	cp "github.com/otiai10/copy"
+)
+
+const (
+	// Command generating the SVG block diagrams of a file's process
+	generateSvgCommand = "faustlsp.generateSvg"
+	// Command returning the import graph of the workspace
+	showDependencyGraphCommand = "faustlsp.showDependencyGraph"
+	// Command re-reading the workspace from disk and analyzing all its files again
+	restartIndexCommand = "faustlsp.restartIndex"
+)
+
+// Path of the file given by the URI that is the only argument of command
+func uriArgument(command string, arguments []json.RawMessage) (util.Path, error) {
+	if len(arguments) != 1 {
+		return "", fmt.Errorf("%s expects 1 argument, got %d", command, len(arguments))
+	}
+	var uri transport.DocumentURI
+	if err := json.Unmarshal(arguments[0], &uri); err != nil {
+		return "", err
+	}
+	return util.URI2path(string(uri))
+}
+
+func GenerateSvgCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	path, err := uriArgument(generateSvgCommand, arguments)
+	if err != nil {
+		return []byte("null"), err
+	}
+	if !IsDSPFile(path) {
+		return []byte("null"), fmt.Errorf("can only generate block diagrams of .dsp files: %s", path)
+	}
+	if _, ok := s.Files.GetFromPath(path); !ok {
+		return []byte("null"), fmt.Errorf("trying to generate block diagram of non-existent path: %s", path)
+	}
+
+	svg, err := s.blockDiagram(ctx, path, s.workspaceFor(path).ConfigFor(path).processName())
+	if err != nil {
+		logging.Logger.Error("Couldn't generate block diagram", "path", path, "error", err)
+		s.showMessage(transport.Error, fmt.Sprintf("Couldn't generate block diagram of %s: %s", filepath.Base(path), err))
+		return []byte("null"), nil
+	}
+	return json.Marshal(util.Path2URI(svg))
+}
+
+// Arguments compiling the process of path with the project's include directories, precision and compiler arguments
+func compilerArgs(path util.Path, root util.Path, config FaustProjectConfig) []string {
+	args := []string{path, "-pn", config.processName()}
+	for _, include := range config.IncludeDir {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(root, include)
+		}
+		args = append(args, "-I", include)
+	}
+	if flag := precisionFlags[config.EffectivePrecision()]; flag != "" {
+		args = append(args, flag)
+	}
+	return append(args, config.CompilerArgs...)
+}
+
+// Runs faust -svg on path with absolute include directories, returning the directory in outDir the diagrams were generated in.
+// A relative working directory is relative to root.
+func generateSvg(ctx context.Context, path util.Path, outDir util.Path, root util.Path, config FaustProjectConfig) (util.Path, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", err
+	}
+	args := append(compilerArgs(path, "", config), "-svg", "-O", outDir, "-o", os.DevNull)
+	var output []byte
+	err := config.runCompiler(ctx, config.Command, args, filepath.Dir(path), root, func(cmd *exec.Cmd) (err error) {
+		output, err = cmd.CombinedOutput()
+		return err
+	})
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return "", fmt.Errorf("%s", message)
+		}
+		return "", err
+	}
+	// Faust writes the diagrams of a.dsp in a-svg in the output directory
+	name := filepath.Base(path)
+	return filepath.Join(outDir, strings.TrimSuffix(name, filepath.Ext(name))+"-svg"), nil
+}
+
+// SvgDir returns the directory the block diagrams of a definition of path are kept in.
+// It only depends on the workspace, the file and the definition so that editors can keep a diagram open while it is regenerated,
+// and it outlives the temporary directory so that diagrams stay available after the server exits.
+func SvgDir(cacheDir util.Path, root util.Path, path util.Path, definition string) util.Path {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	rootHash := sha256.Sum256([]byte(root))
+	return filepath.Join(cacheDir, "faustlsp", "svg", hex.EncodeToString(rootHash[:8]), strings.TrimSuffix(rel, filepath.Ext(rel))+"-svg", definition)
+}
+
+// Moves diagrams generated in the temporary directory to the directory of the diagrams of definition, replacing the previous ones.
+// Returns the top-level diagram.
+func (w *Workspace) storeSvg(generated util.Path, path util.Path, definition string) (util.Path, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	dir := SvgDir(cacheDir, w.Root, path, definition)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	if err := cp.Copy(generated, dir); err != nil {
+		return "", err
+	}
+	os.RemoveAll(generated)
+	return diagramFile(dir, definition), nil
+}
+
+// ImportEdge is an import of To by From, both being file URIs
+type ImportEdge struct {
+	From transport.DocumentURI `json:"from"`
+	To   transport.DocumentURI `json:"to"`
+}
+
+// LibraryBinding is a definition of From bound to the library To, like fi = library("filters.lib")
+type LibraryBinding struct {
+	From       transport.DocumentURI `json:"from"`
+	Definition string                `json:"definition"`
+	To         transport.DocumentURI `json:"to"`
+}
+
+// ImportGraph is the result of faustlsp.showDependencyGraph. Nodes include imported files outside the workspace.
+type ImportGraph struct {
+	Nodes    []transport.DocumentURI `json:"nodes"`
+	Edges    []ImportEdge            `json:"edges"`
+	Bindings []LibraryBinding        `json:"bindings"`
+}
+
+func ShowDependencyGraphCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	return json.Marshal(s.importGraph())
+}
+
+// Import graph of the Faust files of the workspace
+func (s *Server) importGraph() ImportGraph {
+	s.Workspace.mu.Lock()
+	files := slices.Clone(s.Workspace.Files)
+	s.Workspace.mu.Unlock()
+
+	contents := make(map[util.Path][]byte)
+	for _, path := range files {
+		if !IsFaustFile(path) {
+			continue
+		}
+		if f, ok := s.Files.GetFromPath(path); ok {
+			f.mu.RLock()
+			contents[path] = f.Content
+			f.mu.RUnlock()
+		}
+	}
+	resolve := func(importPath string) util.Path {
+		resolvedPath, _ := s.Workspace.ResolveFilePath(importPath, s.Workspace.Root)
+		return resolvedPath
+	}
+	return ImportGraphOf(contents, resolve)
+}
+
+// ImportGraphOf returns the graph of the files imported by the files of contents, sorted by URI.
+// resolve returns the path an imported file is found at, or "" if it can't be found.
+func ImportGraphOf(contents map[util.Path][]byte, resolve func(string) util.Path) ImportGraph {
+	graph := ImportGraph{Nodes: []transport.DocumentURI{}, Edges: []ImportEdge{}, Bindings: []LibraryBinding{}}
+	nodes := make(map[transport.DocumentURI]struct{})
+	edges := make(map[ImportEdge]struct{})
+	bindings := make(map[LibraryBinding]struct{})
+	for path, content := range contents {
+		from := transport.DocumentURI(util.Path2URI(path))
+		nodes[from] = struct{}{}
+		tree := parser.ParseTree(content)
+		imports := ImportedFiles(tree.RootNode(), content)
+		tree.Close()
+		for _, imported := range imports {
+			resolvedPath := resolve(imported.Path)
+			if resolvedPath == "" {
+				continue
+			}
+			to := transport.DocumentURI(util.Path2URI(resolvedPath))
+			nodes[to] = struct{}{}
+			edges[ImportEdge{From: from, To: to}] = struct{}{}
+			if imported.Definition != "" {
+				bindings[LibraryBinding{From: from, Definition: imported.Definition, To: to}] = struct{}{}
+			}
+		}
+	}
+	for node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	for edge := range edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+	slices.Sort(graph.Nodes)
+	slices.SortFunc(graph.Edges, func(a, b ImportEdge) int {
+		if c := strings.Compare(string(a.From), string(b.From)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.To), string(b.To))
+	})
+	for binding := range bindings {
+		graph.Bindings = append(graph.Bindings, binding)
+	}
+	slices.SortFunc(graph.Bindings, func(a, b LibraryBinding) int {
+		if c := strings.Compare(string(a.From), string(b.From)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Definition, b.Definition); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.To), string(b.To))
+	})
+	return graph
+}
+
+func RestartIndexCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	count := 0
+	for _, w := range s.workspaces() {
+		count += w.restartIndex(ctx, s)
+	}
+	if err := ctx.Err(); err != nil {
+		return []byte("null"), err
+	}
+	s.showMessage(transport.Info, fmt.Sprintf("Reindexed %d files", count))
+	return []byte("null"), nil
+}
+
+// Re-reads the workspace's files from disk, picking up changes the watcher missed, and analyzes and diagnoses them again.
+// Documents open in the editor keep their unsaved contents, and indexing stops once ctx is done. Returns the number of files indexed.
+func (w *Workspace) restartIndex(ctx context.Context, s *Server) int {
+	// Config files are read first, as their exclude patterns apply to the walk
+	w.loadConfigFiles(s)
+	w.mu.Lock()
+	previous := slices.Clone(w.Files)
+	w.mu.Unlock()
+
+	files := WorkspaceFiles{}
+	w.walk(func(path string, info os.FileInfo, err error) error {
+		// Other files are only read once something needs them
+		if err == nil && !info.IsDir() && isIndexedFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	// Files are read again with a pool of workers, like when initializing
+	IndexFiles(files, indexWorkers, func(path util.Path) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		if _, ok := s.Files.GetFromPath(path); !ok {
+			s.Files.OpenFromPath(path)
+		} else {
+			s.Files.ModifyFromDisk(path, content)
+		}
+		if !w.isOpen(path) {
+			w.refreshCopy(path, content)
+		}
+	}, func(util.Path, int) {})
+
+	// Files deleted while the watcher wasn't looking
+	for _, path := range previous {
+		if !slices.Contains(files, path) {
+			s.Files.RemoveFromPath(path)
+			os.Remove(w.TempDirPath(path))
+		}
+	}
+
+	w.mu.Lock()
+	w.Files = files
+	w.usedNamesCache = make(map[util.Path]usedNamesEntry)
+	// Files outside the store may have changed without their modification times telling
+	w.compilerCache = make(map[util.Path]compilerCacheEntry)
+	w.mu.Unlock()
+
+	faustFiles := []util.Path{}
+	for _, path := range files {
+		if IsFaustFile(path) {
+			faustFiles = append(faustFiles, path)
+		}
+	}
+	w.indexFiles(ctx, s, "Reindexing workspace", faustFiles, nil)
+	w.goRunning(func() { w.indexLibraries(s) })
+	w.cleanDiagnostics(s)
+	logging.Logger.Info("Restarted index", "files", len(files))
+	return len(files)
+}
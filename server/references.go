@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Result of faustlsp/references. Textual matches are mentions of the symbol's name in comments, documentation and UI labels, which editors can show separately from code references.
+type ReferencesResult struct {
+	References     []transport.Location `json:"references"`
+	TextualMatches []transport.Location `json:"textualMatches"`
+}
+
+// References handles textDocument/references.
+// Textual matches are appended after code references if textual_references is enabled in the project config.
+func References(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.ReferenceParams
+	json.Unmarshal(par, &params)
+
+	textual := false
+	if path, err := util.URI2path(string(params.TextDocument.URI)); err == nil {
+		textual = s.workspaceFor(path).ConfigFor(path).TextualReferences
+	}
+	result, err := s.findReferences(ctx, params.TextDocument.URI, params.Position, params.Context.IncludeDeclaration, textual)
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(append(result.References, result.TextualMatches...))
+}
+
+// TextualReferences handles faustlsp/references, which always includes textual matches as a separate group
+func TextualReferences(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.ReferenceParams
+	json.Unmarshal(par, &params)
+
+	result, err := s.findReferences(ctx, params.TextDocument.URI, params.Position, params.Context.IncludeDeclaration, true)
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(result)
+}
+
+// Finds references to the symbol at pos in uri, stopping with ctx's error once ctx is done
+func (s *Server) findReferences(ctx context.Context, uri transport.DocumentURI, pos transport.Position, includeDeclaration bool, textual bool) (ReferencesResult, error) {
+	return s.searchReferences(ctx, uri, pos, includeDeclaration, textual, Progress{})
+}
+
+// Finds references like findReferences, reporting each file searched to progress. The search stops with ctx's error once ctx is done.
+func (s *Server) searchReferences(ctx context.Context, uri transport.DocumentURI, pos transport.Position, includeDeclaration bool, textual bool, progress Progress) (ReferencesResult, error) {
+	result := ReferencesResult{References: []transport.Location{}, TextualMatches: []transport.Location{}}
+
+	path, err := util.URI2path(string(uri))
+	if err != nil {
+		return result, err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return result, fmt.Errorf("trying to find references from non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	f.mu.RLock()
+	lines, scope := f.lines, f.Scope
+	f.mu.RUnlock()
+
+	offset, err := positionToOffset(lines, pos, content, string(s.Files.encoding))
+	if err != nil {
+		tree.Close()
+		return result, err
+	}
+	node := tree.RootNode().DescendantForByteRange(offset, offset)
+	if node == nil || node.Kind() != "identifier" {
+		tree.Close()
+		return result, nil
+	}
+	ident := qualifiedIdentifier(node, content)
+	identScope := FindLowestScopeContainingRange(scope, ToRange(node))
+	tree.Close()
+
+	target, err := ResolveSymbol(ident, identScope, &s.Store)
+	if err != nil {
+		logging.Logger.Info("Couldn't resolve symbol to find references of", "ident", ident, "error", err)
+		return result, nil
+	}
+	logging.Logger.Info("Finding references", "ident", ident, "definition", target.Loc)
+
+	paths := s.referencePaths(path)
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if rel, err := filepath.Rel(s.workspaceFor(path).Root, path); err == nil {
+			progress.report(rel, i, len(paths))
+		}
+		f, ok := s.Files.GetFromPath(path)
+		if !ok {
+			continue
+		}
+		tree, content := f.Tree()
+		f.mu.RLock()
+		scope := f.Scope
+		f.mu.RUnlock()
+
+		fileURI := transport.DocumentURI(util.Path2URI(path))
+		for _, r := range SymbolReferences(tree.RootNode(), content, scope, &s.Store, target, includeDeclaration) {
+			result.References = append(result.References, transport.Location{URI: fileURI, Range: r})
+		}
+		if textual {
+			for _, r := range TextualMatches(tree.RootNode(), content, target.Ident) {
+				result.TextualMatches = append(result.TextualMatches, transport.Location{URI: fileURI, Range: r})
+			}
+		}
+		tree.Close()
+	}
+	sortLocations(result.References)
+	sortLocations(result.TextualMatches)
+	return result, nil
+}
+
+// Faust files to look for references in: the files of every workspace folder, along with the current file if it is outside them
+func (s *Server) referencePaths(current util.Path) []util.Path {
+	paths := []util.Path{}
+	for _, w := range s.workspaces() {
+		w.mu.Lock()
+		for _, path := range w.Files {
+			if IsFaustFile(path) && !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+		w.mu.Unlock()
+	}
+	if !slices.Contains(paths, current) {
+		paths = append(paths, current)
+	}
+	return paths
+}
+
+// SymbolReferences returns the ranges of identifiers in content that resolve to target
+func SymbolReferences(root *tree_sitter.Node, content []byte, scope *Scope, store *Store, target Symbol, includeDeclaration bool) []transport.Range {
+	ranges := []transport.Range{}
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if node.Kind() == "identifier" && node.Utf8Text(content) == target.Ident {
+			if includeDeclaration || !isDeclaration(node) {
+				ident := qualifiedIdentifier(node, content)
+				sym, err := ResolveSymbol(ident, FindLowestScopeContainingRange(scope, ToRange(node)), store)
+				if err == nil && sym.Loc == target.Loc {
+					ranges = append(ranges, ToRange(node))
+				}
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return ranges
+}
+
+// Identifier node qualified by the environments it is accessed through, so that lowpass in fi.lowpass gives fi.lowpass while fi gives fi
+func qualifiedIdentifier(node *tree_sitter.Node, content []byte) string {
+	for {
+		parent := node.Parent()
+		if parent == nil || parent.Kind() != "access" {
+			break
+		}
+		definition := parent.ChildByFieldName("definition")
+		if definition == nil || definition.Id() != node.Id() {
+			break
+		}
+		node = parent
+	}
+	return strings.Join(strings.Fields(node.Utf8Text(content)), "")
+}
+
+// Whether an identifier is the name a definition, function argument or iteration variable is declared with
+func isDeclaration(node *tree_sitter.Node) bool {
+	parent := node.Parent()
+	if parent == nil {
+		return false
+	}
+	same := func(field string) bool {
+		n := parent.ChildByFieldName(field)
+		return n != nil && n.Id() == node.Id()
+	}
+	switch parent.Kind() {
+	case "definition":
+		return same("variable")
+	case "function_definition":
+		return same("name")
+	case "iteration":
+		return same("current_iter")
+	case "arguments":
+		grandparent := parent.Parent()
+		return grandparent != nil && grandparent.Kind() == "function_definition"
+	}
+	return false
+}
+
+// TextualMatches returns whole-word mentions of name in comments, documentation and UI labels of content
+func TextualMatches(root *tree_sitter.Node, content []byte, name string) []transport.Range {
+	ranges := []transport.Range{}
+	if name == "" {
+		return ranges
+	}
+	pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)
+
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if node.Kind() == "comment" || node.Kind() == "documentation" || isLabel(node) {
+			for _, match := range pattern.FindAllStringIndex(node.Utf8Text(content), -1) {
+				start := node.StartByte() + uint(match[0])
+				ranges = append(ranges, byteRange(content, start, start+uint(match[1]-match[0])))
+			}
+			return
+		}
+		for i := uint(0); i < node.ChildCount(); i++ {
+			walk(node.Child(i))
+		}
+	}
+	walk(root)
+	return ranges
+}
+
+// Whether node is the label string of a UI element like hslider("label", ...)
+func isLabel(node *tree_sitter.Node) bool {
+	if node.Kind() != "string" || node.Parent() == nil {
+		return false
+	}
+	label := node.Parent().ChildByFieldName("label")
+	return label != nil && label.Id() == node.Id()
+}
+
+// Range of bytes [start, end) of content, with columns in bytes like ToRange
+func byteRange(content []byte, start, end uint) transport.Range {
+	position := func(offset uint) transport.Position {
+		line := strings.Count(string(content[:offset]), "\n")
+		lineStart := strings.LastIndex(string(content[:offset]), "\n") + 1
+		return transport.Position{Line: uint32(line), Character: uint32(int(offset) - lineStart)}
+	}
+	return transport.Range{Start: position(start), End: position(end)}
+}
+
+func sortLocations(locations []transport.Location) {
+	slices.SortStableFunc(locations, func(a, b transport.Location) int {
+		if c := strings.Compare(string(a.URI), string(b.URI)); c != 0 {
+			return c
+		}
+		return compareRanges(a.Range, b.Range)
+	})
+}
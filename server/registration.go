@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Document filter of registration options. transport.DocumentFilter is a union the generated code doesn't marshal.
+type documentFilter struct {
+	Language string `json:"language,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+}
+
+// Documents providers registered with client/registerCapability apply to
+var faustDocumentSelector = []documentFilter{{Language: "faust"}, {Pattern: "**/*.{dsp,lib}"}}
+
+type textDocumentRegistrationOptions struct {
+	DocumentSelector []documentFilter `json:"documentSelector"`
+}
+
+type semanticTokensRegistrationOptions struct {
+	textDocumentRegistrationOptions
+	semanticTokensOptions
+}
+
+type onTypeFormattingRegistrationOptions struct {
+	textDocumentRegistrationOptions
+	transport.DocumentOnTypeFormattingOptions
+}
+
+// Takes the providers the client can register dynamically out of capabilities, returning their registrations instead.
+// Registering them lets clients enable them for Faust documents only, and unregister them when the session shuts down.
+// Providers of clients that can't register them stay in the capabilities.
+func dynamicProviders(client transport.ClientCapabilities, capabilities *transport.ServerCapabilities) []transport.Registration {
+	registrations := []transport.Registration{}
+	selector := textDocumentRegistrationOptions{DocumentSelector: faustDocumentSelector}
+	textDocument := client.TextDocument
+	if textDocument.SemanticTokens.DynamicRegistration {
+		registrations = append(registrations, transport.Registration{
+			ID:              "faustlsp-semantic-tokens",
+			Method:          "textDocument/semanticTokens",
+			RegisterOptions: semanticTokensRegistrationOptions{selector, capabilities.SemanticTokensProvider.(semanticTokensOptions)},
+		})
+		capabilities.SemanticTokensProvider = nil
+	}
+	if textDocument.Formatting != nil && textDocument.Formatting.DynamicRegistration {
+		registrations = append(registrations, transport.Registration{
+			ID:              "faustlsp-formatting",
+			Method:          "textDocument/formatting",
+			RegisterOptions: selector,
+		})
+		capabilities.DocumentFormattingProvider = nil
+	}
+	if textDocument.RangeFormatting != nil && textDocument.RangeFormatting.DynamicRegistration {
+		registrations = append(registrations, transport.Registration{
+			ID:              "faustlsp-range-formatting",
+			Method:          "textDocument/rangeFormatting",
+			RegisterOptions: selector,
+		})
+		capabilities.DocumentRangeFormattingProvider = nil
+	}
+	if textDocument.OnTypeFormatting != nil && textDocument.OnTypeFormatting.DynamicRegistration {
+		registrations = append(registrations, transport.Registration{
+			ID:              "faustlsp-on-type-formatting",
+			Method:          "textDocument/onTypeFormatting",
+			RegisterOptions: onTypeFormattingRegistrationOptions{selector, *capabilities.DocumentOnTypeFormattingProvider},
+		})
+		capabilities.DocumentOnTypeFormattingProvider = nil
+	}
+	if fileOperations := client.Workspace.FileOperations; fileOperations != nil && fileOperations.DynamicRegistration {
+		filters := map[string][]transport.FileOperationFilter{"filters": fileOperationFilters}
+		for _, method := range []string{"workspace/willRenameFiles", "workspace/willDeleteFiles", "workspace/didDeleteFiles"} {
+			registrations = append(registrations, transport.Registration{
+				ID:              "faustlsp-" + method,
+				Method:          method,
+				RegisterOptions: filters,
+			})
+		}
+		capabilities.Workspace.FileOperations = nil
+	}
+	return registrations
+}
+
+// Counter for IDs of client/registerCapability and client/unregisterCapability requests
+var registrationCounter atomic.Int64
+
+// Registers registrations with the client, which are unregistered when the session shuts down. The client's response isn't waited for.
+func (s *Server) register(registrations []transport.Registration) {
+	if len(registrations) == 0 {
+		return
+	}
+	params, _ := json.Marshal(transport.RegistrationParams{Registrations: registrations})
+	id := fmt.Sprintf("faustlsp-register-%d", registrationCounter.Add(1))
+	if err := s.Transport.WriteRequest(id, "client/registerCapability", params); err != nil {
+		logging.Logger.Error("Couldn't register capabilities", "error", err)
+		return
+	}
+	s.mu.Lock()
+	s.registered = append(s.registered, registrations...)
+	s.mu.Unlock()
+}
+
+// Unregisters what was registered with the client, so that initializing again can register it again
+func (s *Server) unregisterAll() {
+	s.mu.Lock()
+	registered := s.registered
+	s.registered = nil
+	s.mu.Unlock()
+	if len(registered) == 0 {
+		return
+	}
+	unregistrations := []transport.Unregistration{}
+	for _, registration := range registered {
+		unregistrations = append(unregistrations, transport.Unregistration{ID: registration.ID, Method: registration.Method})
+	}
+	params, _ := json.Marshal(transport.UnregistrationParams{Unregisterations: unregistrations})
+	id := fmt.Sprintf("faustlsp-unregister-%d", registrationCounter.Add(1))
+	if err := s.Transport.WriteRequest(id, "client/unregisterCapability", params); err != nil {
+		logging.Logger.Error("Couldn't unregister capabilities", "error", err)
+	}
+}
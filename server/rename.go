@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command renaming a symbol and its references in the whole workspace, applied by the server so that it can report the outcome
+const renameCommand = "faustlsp.rename"
+
+// Argument of the faustlsp.rename command
+type RenameArgs struct {
+	URI      transport.DocumentURI `json:"uri"`
+	Position transport.Position    `json:"position"`
+	NewName  string                `json:"newName"`
+}
+
+// RenameEdit is the edit renaming a symbol, along with the number of references in read-only files outside the workspace it leaves unchanged
+type RenameEdit struct {
+	Edit    transport.WorkspaceEdit
+	Edits   int
+	Skipped int
+}
+
+func RenameCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	if len(arguments) != 1 {
+		return []byte("null"), fmt.Errorf("%s expects 1 argument, got %d", renameCommand, len(arguments))
+	}
+	var args RenameArgs
+	if err := json.Unmarshal(arguments[0], &args); err != nil {
+		return []byte("null"), err
+	}
+	if !definitionNameRegex.MatchString(args.NewName) {
+		return []byte("null"), fmt.Errorf("invalid name: %s", args.NewName)
+	}
+	oldName, err := s.identifierAt(args.URI, args.Position)
+	if err != nil {
+		return []byte("null"), err
+	}
+
+	progress, searchCtx := s.beginCancellableProgress(ctx, fmt.Sprintf("Renaming %s", oldName))
+	result, err := s.searchReferences(searchCtx, args.URI, args.Position, true, false, progress)
+	if errors.Is(err, context.Canceled) {
+		progress.end("Canceled")
+		s.showMessage(transport.Info, fmt.Sprintf("Renaming %s was canceled, nothing was changed", oldName))
+		return []byte("null"), nil
+	}
+	if err != nil {
+		progress.end("Failed")
+		return []byte("null"), err
+	}
+	if len(result.References) == 0 {
+		progress.end("Nothing to rename")
+		return []byte("null"), fmt.Errorf("no symbol to rename at %d:%d", args.Position.Line, args.Position.Character)
+	}
+
+	rename := RenameReferences(result.References, args.NewName, s.isExternalFile)
+	progress.report("Applying edits", len(rename.Edit.Changes), len(rename.Edit.Changes))
+	applied, err := s.applyEditAndWait(ctx, fmt.Sprintf("Rename %s to %s", oldName, args.NewName), rename.Edit)
+	progress.end("")
+	if err != nil {
+		return []byte("null"), err
+	}
+	messageType, message := RenameSummary(oldName, args.NewName, rename, applied)
+	logging.Logger.Info("Rename result", "message", message)
+	s.showMessage(messageType, message)
+	return []byte("null"), nil
+}
+
+// Name of the identifier at pos
+func (s *Server) identifierAt(uri transport.DocumentURI, pos transport.Position) (string, error) {
+	path, err := util.URI2path(string(uri))
+	if err != nil {
+		return "", err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return "", fmt.Errorf("trying to rename symbol of non-existent path: %s", path)
+	}
+	tree, content := f.Tree()
+	defer tree.Close()
+	f.mu.RLock()
+	lines := f.lines
+	f.mu.RUnlock()
+
+	offset, err := positionToOffset(lines, pos, content, string(s.Files.encoding))
+	if err != nil {
+		return "", err
+	}
+	node := tree.RootNode().DescendantForByteRange(offset, offset)
+	if node == nil || node.Kind() != "identifier" {
+		return "", fmt.Errorf("no symbol to rename at %d:%d", pos.Line, pos.Character)
+	}
+	return node.Utf8Text(content), nil
+}
+
+// RenameReferences returns the edit replacing each reference with newName, leaving out files that readOnly returns true for
+func RenameReferences(references []transport.Location, newName string, readOnly func(util.Path) bool) RenameEdit {
+	rename := RenameEdit{Edit: transport.WorkspaceEdit{Changes: map[transport.DocumentURI][]transport.TextEdit{}}}
+	for _, ref := range references {
+		path, err := util.URI2path(string(ref.URI))
+		if err != nil || readOnly(path) {
+			rename.Skipped++
+			continue
+		}
+		edits := rename.Edit.Changes[ref.URI]
+		if slices.ContainsFunc(edits, func(e transport.TextEdit) bool { return e.Range == ref.Range }) {
+			continue
+		}
+		rename.Edit.Changes[ref.URI] = append(edits, transport.TextEdit{Range: ref.Range, NewText: newName})
+		rename.Edits++
+	}
+	return rename
+}
+
+// RenameSummary returns the message telling the user how applying rename went
+func RenameSummary(oldName string, newName string, rename RenameEdit, result transport.ApplyWorkspaceEditResult) (transport.MessageType, string) {
+	files := len(rename.Edit.Changes)
+	if !result.Applied {
+		message := fmt.Sprintf("Couldn't rename %s to %s", oldName, newName)
+		if result.FailureReason != "" {
+			message += ": " + result.FailureReason
+		}
+		if result.FailedChange > 0 {
+			message += fmt.Sprintf(" (change %d of %d failed)", result.FailedChange+1, files)
+		}
+		return transport.Error, message
+	}
+	message := fmt.Sprintf("Renamed %s to %s: %d %s in %d %s", oldName, newName, rename.Edits, plural(rename.Edits, "edit"), files, plural(files, "file"))
+	if rename.Skipped > 0 {
+		message += fmt.Sprintf(", %d %s in read-only files left unchanged", rename.Skipped, plural(rename.Skipped, "reference"))
+		return transport.Warning, message
+	}
+	return transport.Info, message
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Command returning the health report of the workspace
+const reportCommand = "faustlsp.report"
+
+// Report gathers the diagnostics of every Faust file of a project, the compilation of its process files and its import graph,
+// for CI artifacts and code review summaries
+type Report struct {
+	Root         util.Path       `json:"root"`
+	Summary      ReportSummary   `json:"summary"`
+	Files        []FileReport    `json:"files"`
+	Compilations []CompileReport `json:"compilations"`
+	Dependencies ImportGraph     `json:"dependencies"`
+}
+
+type ReportSummary struct {
+	Files    int `json:"files"`
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+	Unused   int `json:"unused"`
+}
+
+// FileReport lists the diagnostics of a file, whose path is relative to the project's root
+type FileReport struct {
+	Path        string             `json:"path"`
+	Diagnostics []ReportDiagnostic `json:"diagnostics"`
+}
+
+// ReportDiagnostic is a diagnostic with 1-based lines and columns, as shown by editors and compilers
+type ReportDiagnostic struct {
+	Line     uint32 `json:"line"`
+	Column   uint32 `json:"column"`
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CompileReport is the result of compiling a process file
+type CompileReport struct {
+	Path         string `json:"path"`
+	Milliseconds int64  `json:"milliseconds"`
+	Error        string `json:"error,omitempty"`
+	Line         uint32 `json:"line,omitempty"` // 1-based line of the error, if the compiler gave one
+}
+
+var severityNames = map[transport.DiagnosticSeverity]string{
+	transport.SeverityError:       "error",
+	transport.SeverityWarning:     "warning",
+	transport.SeverityInformation: "information",
+	transport.SeverityHint:        "hint",
+}
+
+// BuildReport analyzes contents, the Faust files of the project at root, and compiles its process files with compile.
+// resolve returns the path an imported file is found at, or "" if it can't be found.
+func BuildReport(root util.Path, contents map[util.Path][]byte, config FaustProjectConfig, resolve func(string) util.Path, compile func(util.Path) transport.Diagnostic) Report {
+	report := Report{Root: root, Files: []FileReport{}, Compilations: []CompileReport{}}
+	rel := report.relativePath
+
+	// Each file is parsed once for all the passes over it
+	trees := make(map[util.Path]*parser.Tree, len(contents))
+	for path, content := range contents {
+		trees[path] = parser.ParseTree(content)
+	}
+	defer func() {
+		for _, tree := range trees {
+			tree.Close()
+		}
+	}()
+
+	var used map[string]struct{}
+	if config.UnusedDiagnostics {
+		used = make(map[string]struct{})
+		for path, content := range contents {
+			for name := range UsedNames(trees[path].RootNode(), content) {
+				used[name] = struct{}{}
+			}
+		}
+	}
+
+	paths := []util.Path{}
+	for path := range contents {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	syntaxErrors := make(map[util.Path]bool)
+	for _, path := range paths {
+		content, tree := contents[path], trees[path]
+		diagnostics := parser.TSDiagnostics(content, tree)
+		syntaxErrors[path] = len(diagnostics) > 0
+		if len(diagnostics) == 0 {
+			fileUsed := used
+			if !IsDSPFile(path) {
+				fileUsed = nil
+			}
+			// Compiling the process files already fails on primitives the compiler doesn't know
+			diagnostics = AnalysisDiagnostics(tree.RootNode(), content, path, config, fileUsed, "")
+		}
+
+		file := FileReport{Path: rel(path), Diagnostics: []ReportDiagnostic{}}
+		for _, d := range diagnostics {
+			file.Diagnostics = append(file.Diagnostics, toReportDiagnostic(d))
+			report.Summary.count(d)
+		}
+		report.Files = append(report.Files, file)
+	}
+	report.Summary.Files = len(paths)
+
+	for _, file := range config.ProcessFiles {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		compilation := CompileReport{Path: rel(path)}
+		if _, ok := contents[path]; !ok {
+			compilation.Error = "file not found"
+		} else if syntaxErrors[path] {
+			compilation.Error = "not compiled as it has syntax errors"
+		} else {
+			start := time.Now()
+			d := compile(path)
+			compilation.Milliseconds = time.Since(start).Milliseconds()
+			if d.Message != "" {
+				compilation.Error = strings.TrimSpace(d.Message)
+				if d.Range.Start.Line > 0 {
+					compilation.Line = d.Range.Start.Line + 1
+				}
+			}
+		}
+		if compilation.Error != "" {
+			report.Summary.Errors++
+		}
+		report.Compilations = append(report.Compilations, compilation)
+	}
+
+	report.Dependencies = ImportGraphOf(contents, resolve)
+	return report
+}
+
+// Path relative to the project's root, for paths inside it
+func (r Report) relativePath(path util.Path) string {
+	if rel, err := filepath.Rel(r.Root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+func (summary *ReportSummary) count(d transport.Diagnostic) {
+	switch {
+	case d.Code == unusedDefinitionCode:
+		summary.Unused++
+	case d.Severity == transport.SeverityError:
+		summary.Errors++
+	case d.Severity == transport.SeverityWarning:
+		summary.Warnings++
+	}
+}
+
+func toReportDiagnostic(d transport.Diagnostic) ReportDiagnostic {
+	code := ""
+	if c, ok := d.Code.(string); ok {
+		code = c
+	}
+	return ReportDiagnostic{
+		Line:     d.Range.Start.Line + 1,
+		Column:   d.Range.Start.Character + 1,
+		Severity: severityNames[d.Severity],
+		Code:     code,
+		Message:  strings.TrimSpace(d.Message),
+	}
+}
+
+// Markdown renders the report for code review summaries
+func (r Report) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# Faust project report\n\n")
+	fmt.Fprintf(&b, "%d files, %d errors, %d warnings, %d unused definitions\n", r.Summary.Files, r.Summary.Errors, r.Summary.Warnings, r.Summary.Unused)
+
+	b.WriteString("\n## Diagnostics\n\n")
+	diagnostics := 0
+	for _, file := range r.Files {
+		for _, d := range file.Diagnostics {
+			if diagnostics == 0 {
+				b.WriteString("| File | Line | Severity | Message |\n|---|---|---|---|\n")
+			}
+			diagnostics++
+			fmt.Fprintf(&b, "| %s | %d | %s | %s |\n", file.Path, d.Line, d.Severity, markdownCell(d.Message))
+		}
+	}
+	if diagnostics == 0 {
+		b.WriteString("No diagnostics\n")
+	}
+
+	b.WriteString("\n## Compilation\n\n")
+	if len(r.Compilations) == 0 {
+		b.WriteString("No process files\n")
+	} else {
+		b.WriteString("| File | Time | Result |\n|---|---|---|\n")
+		for _, c := range r.Compilations {
+			result := "ok"
+			if c.Error != "" {
+				result = markdownCell(c.Error)
+			}
+			fmt.Fprintf(&b, "| %s | %d ms | %s |\n", c.Path, c.Milliseconds, result)
+		}
+	}
+
+	b.WriteString("\n## Dependencies\n\n")
+	if len(r.Dependencies.Edges) == 0 {
+		b.WriteString("No imports\n")
+	}
+	for _, edge := range r.Dependencies.Edges {
+		fmt.Fprintf(&b, "- %s imports %s\n", r.fileName(edge.From), r.fileName(edge.To))
+	}
+	return b.String()
+}
+
+// Name of a file in reports: its relative path, or its URI if it isn't a path
+func (r Report) fileName(uri transport.DocumentURI) string {
+	if path, err := util.URI2path(string(uri)); err == nil {
+		return r.relativePath(path)
+	}
+	return string(uri)
+}
+
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// ProjectReport builds the report of the project at root from the files on disk, for running outside an editor
+func ProjectReport(root util.Path) (Report, error) {
+	parser.Init()
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return Report{}, err
+	}
+	w := Workspace{Root: root}
+	// Files are left out like in the editor, with the exclude patterns of the config, which is fully read once process files can default to the .dsp files found
+	if content, err := os.ReadFile(filepath.Join(root, faustConfigFile)); err == nil {
+		json.Unmarshal(content, &w.Config)
+	}
+	w.loadIgnoreRules()
+	contents := make(map[util.Path][]byte)
+	err = w.walk(func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			w.Files = append(w.Files, path)
+			if IsFaustFile(path) {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				contents[path] = content
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	w.Config = w.defaultConfig()
+	if content, err := os.ReadFile(filepath.Join(root, faustConfigFile)); err == nil {
+		if w.Config, err = w.parseConfig(content); err != nil {
+			return Report{}, fmt.Errorf("invalid %s: %w", faustConfigFile, err)
+		}
+	}
+	resolve := func(importPath string) util.Path {
+		resolvedPath, _ := w.ResolveFilePath(importPath, root)
+		return resolvedPath
+	}
+	compile := func(path util.Path) transport.Diagnostic {
+		return reportCompilation(context.Background(), path, root, w.Config)
+	}
+	return BuildReport(root, contents, w.Config, resolve, compile), nil
+}
+
+// Compiles path for a report, where a missing compiler has to show up as a failure rather than be ignored like in diagnostics
+func reportCompilation(ctx context.Context, path util.Path, root util.Path, config FaustProjectConfig) transport.Diagnostic {
+	if _, err := exec.LookPath(config.Command); err != nil {
+		return transport.Diagnostic{Message: fmt.Sprintf("couldn't run %s: %s", config.Command, err)}
+	}
+	return getCompilerDiagnostics(ctx, path, root, config)
+}
+
+// Argument of the faustlsp.report command
+type ReportArgs struct {
+	// json, markdown, sarif or github
+	Format string `json:"format,omitempty"`
+}
+
+func ReportCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	var args ReportArgs
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments[0], &args); err != nil {
+			return []byte("null"), err
+		}
+	}
+
+	s.Workspace.mu.Lock()
+	files := slices.Clone(s.Workspace.Files)
+	s.Workspace.mu.Unlock()
+	contents := make(map[util.Path][]byte)
+	for _, path := range files {
+		if !IsFaustFile(path) {
+			continue
+		}
+		if f, ok := s.Files.GetFromPath(path); ok {
+			f.mu.RLock()
+			contents[path] = f.Content
+			f.mu.RUnlock()
+		}
+	}
+	resolve := func(importPath string) util.Path {
+		resolvedPath, _ := s.Workspace.ResolveFilePath(importPath, s.Workspace.Root)
+		return resolvedPath
+	}
+	// Compiling along the overlay uses unsaved changes
+	workspaceConfig := s.Workspace.config()
+	compile := func(path util.Path) transport.Diagnostic {
+		compiledPath, dir, config := s.Workspace.compilation(path, workspaceConfig)
+		return reportCompilation(ctx, compiledPath, dir, config)
+	}
+
+	progress := s.beginProgress("Building project report")
+	report := BuildReport(s.Workspace.Root, contents, workspaceConfig, resolve, compile)
+	progress.end(fmt.Sprintf("%d errors, %d warnings", report.Summary.Errors, report.Summary.Warnings))
+	// Files left uncompiled would pass for files without errors
+	if err := ctx.Err(); err != nil {
+		return []byte("null"), err
+	}
+	switch args.Format {
+	case "markdown":
+		return json.Marshal(report.Markdown())
+	case "github":
+		return json.Marshal(report.GitHubAnnotations())
+	case "sarif":
+		return report.SARIF()
+	}
+	return json.Marshal(report)
+}
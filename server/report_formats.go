@@ -0,0 +1,163 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Rules of results without a diagnostic code
+const (
+	syntaxErrorRule  = "syntax-error"
+	compileErrorRule = "compile-error"
+	otherRule        = "faustlsp"
+)
+
+// Levels of GitHub annotations and SARIF results for each severity
+var (
+	annotationLevels = map[string]string{"error": "error", "warning": "warning", "information": "notice", "hint": "notice"}
+	sarifLevels      = map[string]string{"error": "error", "warning": "warning", "information": "note", "hint": "note"}
+)
+
+// Finding of a report: a diagnostic or a failed compilation
+type reportFinding struct {
+	path     string
+	line     uint32
+	column   uint32
+	severity string
+	rule     string
+	message  string
+}
+
+func (r Report) findings() []reportFinding {
+	findings := []reportFinding{}
+	for _, file := range r.Files {
+		for _, d := range file.Diagnostics {
+			rule := d.Code
+			if rule == "" && d.Severity == "error" {
+				rule = syntaxErrorRule
+			}
+			findings = append(findings, reportFinding{
+				path:     file.Path,
+				line:     d.Line,
+				column:   d.Column,
+				severity: d.Severity,
+				rule:     cmp.Or(rule, otherRule),
+				message:  d.Message,
+			})
+		}
+	}
+	for _, c := range r.Compilations {
+		if c.Error != "" {
+			findings = append(findings, reportFinding{path: c.Path, line: max(c.Line, 1), column: 1, severity: "error", rule: compileErrorRule, message: c.Error})
+		}
+	}
+	return findings
+}
+
+// GitHubAnnotations renders the report as GitHub Actions workflow commands, which annotate the lines of pull requests
+func (r Report) GitHubAnnotations() string {
+	var b strings.Builder
+	for _, f := range r.findings() {
+		fmt.Fprintf(&b, "::%s file=%s,line=%d,col=%d,title=%s::%s\n",
+			annotationLevels[f.severity], annotationProperty(f.path), f.line, f.column, annotationProperty(f.rule), annotationData(f.message))
+	}
+	return b.String()
+}
+
+func annotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func annotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   uint32 `json:"startLine"`
+	StartColumn uint32 `json:"startColumn"`
+}
+
+// SARIF renders the report as a SARIF 2.1.0 log, which code scanning tools show on pull requests.
+// Diagnostic codes are the rules of the results.
+func (r Report) SARIF() ([]byte, error) {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "faustlsp",
+			InformationURI: "https://github.com/carn181/faustlsp",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	rules := make(map[string]struct{})
+	for _, f := range r.findings() {
+		if _, ok := rules[f.rule]; !ok {
+			rules[f.rule] = struct{}{}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: f.rule})
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  f.rule,
+			Level:   sarifLevels[f.severity],
+			Message: sarifMessage{Text: f.message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: f.path},
+				Region:           sarifRegion{StartLine: f.line, StartColumn: f.column},
+			}}},
+		})
+	}
+	return json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+}
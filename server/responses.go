@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Sends a request to the client and waits for its response, or for ctx to be done
+func (s *Server) sendRequest(ctx context.Context, id string, method string, params json.RawMessage) (transport.ResponseMessage, error) {
+	response := make(chan transport.ResponseMessage, 1)
+	s.pendingMu.Lock()
+	if s.pending == nil {
+		s.pending = make(map[string]chan transport.ResponseMessage)
+	}
+	s.pending[id] = response
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+	}()
+
+	if err := s.Transport.WriteRequest(id, method, params); err != nil {
+		return transport.ResponseMessage{}, err
+	}
+	select {
+	case msg := <-response:
+		if msg.Error != nil {
+			return msg, fmt.Errorf("%s failed: %s", method, msg.Error.Message)
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return transport.ResponseMessage{}, ctx.Err()
+	}
+}
+
+// Passes a response from the client to the request waiting for it, if any
+func (s *Server) handleResponse(content []byte) {
+	var msg transport.ResponseMessage
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return
+	}
+	id, ok := msg.ID.(string)
+	if !ok {
+		return
+	}
+	s.pendingMu.Lock()
+	response, ok := s.pending[id]
+	s.pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case response <- msg:
+	default:
+		logging.Logger.Warn("Got more than one response to request", "id", id)
+	}
+}
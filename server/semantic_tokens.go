@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Token types and modifiers, in the order of the legend sent to the client
+const (
+	namespaceToken uint32 = iota
+	functionToken
+	parameterToken
+	variableToken
+	propertyToken
+	stringToken
+	numberToken
+	commentToken
+)
+
+const declarationModifier uint32 = 1 << 0
+
+var semanticTokensLegend = transport.SemanticTokensLegend{
+	TokenTypes:     []string{"namespace", "function", "parameter", "variable", "property", "string", "number", "comment"},
+	TokenModifiers: []string{"declaration"},
+}
+
+// Semantic tokens capability. Full is an object here, which the generated Or_ wrapper doesn't marshal as.
+type semanticTokensOptions struct {
+	Legend transport.SemanticTokensLegend    `json:"legend"`
+	Full   transport.SemanticTokensFullDelta `json:"full"`
+}
+
+// Counter for result IDs of semantic tokens, so deltas are only computed against what the client has
+var semanticTokensCounter atomic.Int64
+
+type semanticToken struct {
+	start, end uint
+	tokenType  uint32
+	modifiers  uint32
+}
+
+// Byte range of a file, including both ends so that edits touching a token invalidate it
+type byteSpan struct {
+	start, end uint
+}
+
+func (a byteSpan) overlaps(start, end uint) bool {
+	return a.start <= end && start <= a.end
+}
+
+// Semantic tokens of a file, kept between requests. After edits only the tokens of dirty spans are computed again.
+type semanticTokensCache struct {
+	computed bool
+	tokens   []semanticToken
+	dirty    []byteSpan
+
+	// Last result sent to the client
+	resultID string
+	data     []uint32
+}
+
+// Shifts tokens after edit, invalidating the ones it touches
+func (c *semanticTokensCache) edit(edit tree_sitter.InputEdit) {
+	if !c.computed {
+		return
+	}
+	shift := func(offset uint) uint {
+		return uint(int(offset) + int(edit.NewEndByte) - int(edit.OldEndByte))
+	}
+
+	tokens := c.tokens[:0]
+	for _, t := range c.tokens {
+		if t.end < edit.StartByte {
+			tokens = append(tokens, t)
+		} else if t.start > edit.OldEndByte {
+			t.start, t.end = shift(t.start), shift(t.end)
+			tokens = append(tokens, t)
+		}
+	}
+	c.tokens = tokens
+
+	edited := byteSpan{edit.StartByte, edit.NewEndByte}
+	dirty := []byteSpan{}
+	for _, span := range c.dirty {
+		switch {
+		case span.end < edit.StartByte:
+			dirty = append(dirty, span)
+		case span.start > edit.OldEndByte:
+			dirty = append(dirty, byteSpan{shift(span.start), shift(span.end)})
+		default:
+			edited.start = min(edited.start, span.start)
+			if span.end > edit.OldEndByte {
+				edited.end = max(edited.end, shift(span.end))
+			}
+		}
+	}
+	c.dirty = append(dirty, edited)
+}
+
+// Marks tokens between start and end for recomputing, e.g. where the syntax tree changed
+func (c *semanticTokensCache) invalidate(start, end uint) {
+	if c.computed {
+		c.dirty = append(c.dirty, byteSpan{start, end})
+	}
+}
+
+// Forgets all tokens, e.g. after the whole content changed. The last result is kept to compute deltas from.
+func (c *semanticTokensCache) reset() {
+	c.computed = false
+	c.tokens = nil
+	c.dirty = nil
+}
+
+// Brings tokens up to date with root, walking only the parts of the tree in dirty spans.
+// If ctx is done before, the cache is left as it was and ctx's error is returned.
+func (c *semanticTokensCache) update(ctx context.Context, root *tree_sitter.Node, content []byte) error {
+	if !c.computed {
+		tokens := []semanticToken{}
+		if err := collectSemanticTokens(ctx, root, content, byteSpan{0, uint(len(content))}, &tokens); err != nil {
+			return err
+		}
+		c.tokens = tokens
+		c.computed = true
+		c.dirty = nil
+		return nil
+	}
+	if len(c.dirty) == 0 {
+		return nil
+	}
+
+	fresh := []semanticToken{}
+	for _, span := range mergeSpans(c.dirty) {
+		if err := collectSemanticTokens(ctx, root, content, span, &fresh); err != nil {
+			return err
+		}
+	}
+	kept := []semanticToken{}
+	for _, t := range c.tokens {
+		stale := slices.ContainsFunc(c.dirty, func(span byteSpan) bool { return span.overlaps(t.start, t.end) })
+		if !stale {
+			kept = append(kept, t)
+		}
+	}
+	// Tokens found in dirty spans can extend past them, over old tokens of the same nodes
+	for _, t := range fresh {
+		kept = slices.DeleteFunc(kept, func(old semanticToken) bool { return old.start < t.end && t.start < old.end })
+	}
+	tokens := append(kept, fresh...)
+	sort.SliceStable(tokens, func(i, j int) bool { return tokens[i].start < tokens[j].start })
+	c.tokens = slices.CompactFunc(tokens, func(a, b semanticToken) bool { return a.start == b.start })
+	c.dirty = nil
+	return nil
+}
+
+func mergeSpans(spans []byteSpan) []byteSpan {
+	spans = slices.Clone(spans)
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := []byteSpan{}
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, span.end)
+		} else {
+			merged = append(merged, span)
+		}
+	}
+	return merged
+}
+
+// Appends the tokens of node in span to tokens, stopping with ctx's error once it is done, which is checked at each inner node
+func collectSemanticTokens(ctx context.Context, node *tree_sitter.Node, content []byte, span byteSpan, tokens *[]semanticToken) error {
+	if !span.overlaps(node.StartByte(), node.EndByte()) {
+		return nil
+	}
+	if tokenType, modifiers, ok := classifyToken(node); ok {
+		*tokens = append(*tokens, semanticToken{node.StartByte(), node.EndByte(), tokenType, modifiers})
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if err := collectSemanticTokens(ctx, node.NamedChild(i), content, span, tokens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Token type of node, from its kind and where it appears in its parent
+func classifyToken(node *tree_sitter.Node) (uint32, uint32, bool) {
+	switch node.Kind() {
+	case "comment", "documentation":
+		return commentToken, 0, true
+	case "string", "fstring":
+		return stringToken, 0, true
+	case "int", "real":
+		return numberToken, 0, true
+	case "identifier":
+	default:
+		return 0, 0, false
+	}
+
+	parent := node.Parent()
+	if parent == nil {
+		return variableToken, 0, true
+	}
+	switch parent.Kind() {
+	case "function_definition":
+		if isField(parent, "name", node) {
+			return functionToken, declarationModifier, true
+		}
+	case "definition":
+		if isField(parent, "variable", node) {
+			return variableToken, declarationModifier, true
+		}
+	case "recinition":
+		if isField(parent, "name", node) {
+			return variableToken, declarationModifier, true
+		}
+	case "arguments":
+		if grandparent := parent.Parent(); grandparent != nil && grandparent.Kind() == "function_definition" {
+			return parameterToken, declarationModifier, true
+		}
+	case "parameters":
+		return parameterToken, declarationModifier, true
+	case "iteration":
+		if isField(parent, "current_iter", node) {
+			return parameterToken, declarationModifier, true
+		}
+	case "access":
+		if isField(parent, "environment", node) {
+			return namespaceToken, 0, true
+		}
+		if call := parent.Parent(); call != nil && call.Kind() == "function_call" && isField(call, "callee", parent) {
+			return functionToken, 0, true
+		}
+		return propertyToken, 0, true
+	case "function_call":
+		if isField(parent, "callee", node) {
+			return functionToken, 0, true
+		}
+	case "global_metadata":
+		return propertyToken, 0, true
+	case "function_metadata":
+		if isField(parent, "function_name", node) {
+			return functionToken, 0, true
+		}
+		return propertyToken, 0, true
+	}
+	return variableToken, 0, true
+}
+
+func isField(parent *tree_sitter.Node, field string, node *tree_sitter.Node) bool {
+	child := parent.ChildByFieldName(field)
+	return child != nil && child.Id() == node.Id()
+}
+
+// Encodes tokens relative to each other as the protocol expects, splitting tokens that span several lines
+func encodeSemanticTokens(tokens []semanticToken, content []byte, encoding string) []uint32 {
+	lines := GetLineIndices(string(content))
+	data := []uint32{}
+	var prevLine, prevChar uint32
+	// Columns are counted from the previous token of the same line rather than from the line's start,
+	// so that encoding stays linear on long generated lines
+	var prevOffset uint
+	column := func(line int, start uint) uint32 {
+		if uint32(line) == prevLine && len(data) > 0 && prevOffset >= lines[line] && prevOffset <= start {
+			return prevChar + uint32(getDocumentEndOffset(string(content[prevOffset:start]), encoding))
+		}
+		return uint32(getDocumentEndOffset(string(content[lines[line]:start]), encoding))
+	}
+	for _, t := range tokens {
+		line := sort.Search(len(lines), func(i int) bool { return lines[i] > t.start }) - 1
+		for start := t.start; start < t.end && line < len(lines); line++ {
+			end := t.end
+			if line+1 < len(lines) {
+				end = min(end, lines[line+1]-1)
+			}
+			if end > start && content[end-1] == '\r' {
+				end--
+			}
+			if end > start {
+				char := column(line, start)
+				length := uint32(getDocumentEndOffset(string(content[start:end]), encoding))
+				deltaLine, deltaChar := uint32(line)-prevLine, char
+				if deltaLine == 0 {
+					deltaChar = char - prevChar
+				}
+				data = append(data, deltaLine, deltaChar, length, t.tokenType, t.modifiers)
+				prevLine, prevChar, prevOffset = uint32(line), char, start
+			}
+			if line+1 < len(lines) {
+				start = lines[line+1]
+			}
+		}
+	}
+	return data
+}
+
+// Keeps the syntax tree and semantic tokens of the file in sync with incremental edits of its content, in the order they were made.
+// The tree is reparsed once for all of them. f.mu must be locked, with Content already edited.
+func (f *File) editTree(edits ...tree_sitter.InputEdit) {
+	for _, edit := range edits {
+		f.semanticTokens.edit(edit)
+	}
+	if f.tree == nil || len(edits) == 0 {
+		return
+	}
+	for _, edit := range edits {
+		f.tree.Edit(&edit)
+	}
+	tree := f.treePool().Reparse(f.Content, f.tree)
+	for _, r := range f.tree.ChangedRanges(tree.Tree) {
+		f.semanticTokens.invalidate(r.StartByte, r.EndByte)
+	}
+	f.tree.Close()
+	f.tree = tree
+}
+
+// Closes the syntax tree and drops the semantic tokens, e.g. after the whole content changed or the file was removed. f.mu must be locked.
+func (f *File) resetTree() {
+	if f.tree != nil {
+		f.tree.Close()
+		f.tree = nil
+	}
+	f.semanticTokens.reset()
+}
+
+// SemanticTokens returns all semantic tokens of the file, or ctx's error if it is done before they are computed
+func (f *File) SemanticTokens(ctx context.Context, encoding string) (transport.SemanticTokens, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.updateSemanticTokens(ctx, encoding); err != nil {
+		return transport.SemanticTokens{}, err
+	}
+	return transport.SemanticTokens{ResultID: f.semanticTokens.resultID, Data: f.semanticTokens.data}, nil
+}
+
+// SemanticTokensDelta returns the edits turning the tokens of previousResultID into the current ones.
+// It returns false if previousResultID isn't the last result, in which case all tokens have to be sent.
+func (f *File) SemanticTokensDelta(ctx context.Context, previousResultID string, encoding string) (transport.SemanticTokensDelta, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := f.semanticTokens.data
+	if f.semanticTokens.resultID == "" || f.semanticTokens.resultID != previousResultID {
+		return transport.SemanticTokensDelta{}, false, nil
+	}
+	if err := f.updateSemanticTokens(ctx, encoding); err != nil {
+		return transport.SemanticTokensDelta{}, false, err
+	}
+	return transport.SemanticTokensDelta{
+		ResultID: f.semanticTokens.resultID,
+		Edits:    semanticTokensEdits(previous, f.semanticTokens.data),
+	}, true, nil
+}
+
+func (f *File) updateSemanticTokens(ctx context.Context, encoding string) error {
+	if err := f.semanticTokens.update(ctx, f.syntaxTree().RootNode(), f.Content); err != nil {
+		return err
+	}
+	f.semanticTokens.data = encodeSemanticTokens(f.semanticTokens.tokens, f.Content, encoding)
+	f.semanticTokens.resultID = strconv.FormatInt(semanticTokensCounter.Add(1), 10)
+	return nil
+}
+
+// Single edit replacing what differs between old and new, which only spans the modified region after small edits
+func semanticTokensEdits(old, new []uint32) []transport.SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(old) && prefix == len(new) {
+		return []transport.SemanticTokensEdit{}
+	}
+	return []transport.SemanticTokensEdit{{
+		Start:       uint32(prefix),
+		DeleteCount: uint32(len(old) - prefix - suffix),
+		Data:        slices.Clone(new[prefix : len(new)-suffix]),
+	}}
+}
+
+func SemanticTokensFull(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.SemanticTokensParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get semantic tokens of non-existent path: %s", path)
+	}
+	if s.hasLongLines(f) {
+		return json.Marshal(transport.SemanticTokens{Data: []uint32{}})
+	}
+	ctx, done := s.documentRequest(ctx, path)
+	defer done()
+	tokens, err := f.SemanticTokens(ctx, string(s.Files.encoding))
+	if err != nil {
+		return []byte("null"), err
+	}
+	return json.Marshal(tokens)
+}
+
+func SemanticTokensFullDelta(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.SemanticTokensDeltaParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to get semantic tokens of non-existent path: %s", path)
+	}
+	if s.hasLongLines(f) {
+		return json.Marshal(transport.SemanticTokens{Data: []uint32{}})
+	}
+	ctx, done := s.documentRequest(ctx, path)
+	defer done()
+	delta, ok, err := f.SemanticTokensDelta(ctx, params.PreviousResultID, string(s.Files.encoding))
+	if err != nil {
+		return []byte("null"), err
+	}
+	if !ok {
+		logging.Logger.Info("Sending all semantic tokens", "previousResultId", params.PreviousResultID)
+		tokens, err := f.SemanticTokens(ctx, string(s.Files.encoding))
+		if err != nil {
+			return []byte("null"), err
+		}
+		return json.Marshal(tokens)
+	}
+	return json.Marshal(delta)
+}
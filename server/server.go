@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/parser"
@@ -36,7 +39,8 @@ type Server struct {
 	// Capabalities
 	Capabilities transport.ServerCapabilities
 
-	// Workspace and Files are different because in future should allow having multiple workspaces while having one main File Store, but both have to be synchronized on each document Change
+	// Workspace and Files are different because there can be multiple workspaces while having one main File Store, but both have to be synchronized on each document Change.
+	// Workspace is the first workspace folder, the other ones are in folders.
 	Workspace Workspace
 	Files     Files
 	Store     Store
@@ -51,24 +55,105 @@ type Server struct {
 	// Request Id Counter for new requ ests
 	reqIdCtr int
 
-	// Temporary Directory where we replicate workspace for diagnostics
+	// Temporary Directory with the overlay of unsaved buffers given to the compiler
 	tempDir util.Path
 
 	// Diagnostic Channel
-	diagChan chan transport.PublishDiagnosticsParams
+	diagChan        chan transport.PublishDiagnosticsParams
+	diagnosticsOnce sync.Once
+
+	// Workspace root of the current session and cancel function to stop tracking it.
+	// Kept across initialize requests so that clients restarting mid-session don't need the workspace to be indexed again.
+	sessionRoot     util.Path
+	workspaceCancel context.CancelFunc
+	workspaceCtx    context.Context
+	// Cancel function to stop tracking the first workspace folder only, for replacing it once it is removed
+	primaryCancel context.CancelFunc
+
+	// Workspace folders other than the first one, with the cancel functions stopping to track them by root
+	foldersMu     sync.Mutex
+	folders       []*Workspace
+	folderCancels map[util.Path]context.CancelFunc
+	// Roots of the first workspace folder of the initialize request and of the other ones, installed once the client is initialized
+	initialRoot    util.Path
+	initialFolders []util.Path
+
+	// Whether the client supports progress created by the server
+	workDoneProgress bool
+	// Files with long lines the user was told features are turned off for
+	longLineFiles sync.Map
+	// Files the compiler couldn't open whose include directory was suggested
+	suggestedIncludeDirs sync.Map
+
+	// Whether the client supports snippets in completion items
+	snippetSupport bool
+	// Whether the client supports registering for workspace/didChangeWatchedFiles
+	watchedFilesRegistration bool
+	// Whether the client resolves the ranges of workspace symbols
+	symbolResolveSupport bool
+	// Whether the client answers workspace/configuration requests, and supports registering for workspace/didChangeConfiguration
+	configurationSupport      bool
+	configurationRegistration bool
+	// Settings given in the initialization options, which those asked for later replace
+	initializationSettings ClientSettings
+	// Providers the client registers dynamically once initialized instead of taking them from the initialize result,
+	// and the registrations made with it so far, guarded by mu
+	dynamicRegistrations []transport.Registration
+	registered           []transport.Registration
+
+	// Arities of expressions found by compiling them, shared by hovers
+	snippetArities *ArityCache
+	// Block diagrams shown in hovers and generated by faustlsp.generateSvg
+	diagrams diagramCache
+	// Descriptions of process files faust -json writes, shown in hovers of their widgets and returned by faustlsp.dspInfo
+	dspInfos dspInfoCache
+	// Version and library directory of the compiler of the first workspace folder's config
+	compiler compilerProbe
+
+	// Channels receiving the client's responses to requests waiting for them, by request ID
+	pendingMu sync.Mutex
+	pending   map[string]chan transport.ResponseMessage
+	// Cancel functions of cancellable progress, by progress token
+	progressCancels map[string]context.CancelFunc
+	// Cancel functions of the requests being handled
+	requestsMu sync.Mutex
+	requests   requestCancels
+	// Problems of the server told to the client recently
+	problems ProblemLimiter
+	// Order of the changes to each document and of the requests about it
+	documents DocumentQueues
 }
 
-// Initialize Server
-func (s *Server) Init(transp transport.TransportMethod) {
+// Initialize Server, returning the error of setting up its transport
+func (s *Server) Init(transp transport.TransportMethod) error {
+	if err := s.Transport.Init(transport.Server, transp); err != nil {
+		return err
+	}
+	s.init()
+	return nil
+}
+
+// Initialize Server for a client connected through conn
+func (s *Server) InitConn(conn net.Conn) {
+	s.Transport.InitConn(transport.Server, conn)
+	s.init()
+}
+
+func (s *Server) init() {
 	s.Status = Created
-	s.Transport.Init(transport.Server, transp)
 	parser.Init()
+	s.snippetArities = NewArityCache(snippetArityCacheSize)
+	s.createTempDir()
+}
 
-	// Create Temporary Directory
-	faustTemp := filepath.Join(os.TempDir(), "faustlsp") // No need to create $TEMPDIR/faustlsp as logging should create it
+// Creates the temporary directory of the overlay, which shutting down removes
+func (s *Server) createTempDir() {
+	// Created by logging too, unless logs are written elsewhere
+	faustTemp := filepath.Join(os.TempDir(), "faustlsp")
+	os.MkdirAll(faustTemp, 0750)
 	temp_dir, err := os.MkdirTemp(faustTemp, "faustlsp-")
 	if err != nil {
-		logging.Logger.Error("Couldn't create temp dir", "error", err)
+		s.reportProblem(transport.Error, "Couldn't create the temporary directory, unsaved changes won't be compiled", err, "dir", faustTemp)
 		return
 	} else {
 		logging.Logger.Info("Created Temp Directory", "path", temp_dir)
@@ -85,9 +170,9 @@ func (s *Server) Run(ctx context.Context) error {
 	select {
 	case err := <-end:
 		if err != nil {
-			errormsg := "Ending because of error (" + err.Error() + ")"
-			logging.Logger.Info(errormsg)
-			fmt.Println(errormsg)
+			logging.Logger.Info("Ending because of error", "error", err)
+			// Stdout is the client's stream
+			fmt.Fprintf(os.Stderr, "Ending because of error (%s)\n", err)
 			returnError = errors.New(err.Error())
 		} else {
 			logging.Logger.Info("LSP Successfully Exited")
@@ -97,6 +182,7 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 
 	// TODO: Have a proper cleanup function here
+	s.Transport.Flush()
 	parser.Close()
 	os.RemoveAll(s.tempDir)
 	return returnError
@@ -122,11 +208,27 @@ func (s *Server) Loop(ctx context.Context, end chan<- error) {
 		msg, err = s.Transport.Read()
 		if err != nil {
 			logging.Logger.Error("Scanning error", "error", err)
+			// The message was skipped, its ID is unknown without reading it
+			var tooLarge *transport.MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				err = s.Transport.WriteResponse(nil, nil, &transport.ResponseError{Code: int(transport.InvalidRequest), Message: err.Error()})
+				continue
+			}
+			if s.Transport.Closed {
+				err = nil
+			}
+			break
 		}
 
 		// Parse JSON RPC Message here and get method
 		method, err = transport.GetMethod(msg)
 		if len(method) == 0 {
+			// Responses to requests like window/workDoneProgress/create don't need handling unless they are waited for
+			if err == nil && transport.IsResponse(msg) {
+				logging.Logger.Debug("Got response from client", "response", string(msg))
+				s.handleResponse(msg)
+				continue
+			}
 			break
 		}
 		if err != nil {
@@ -134,7 +236,7 @@ func (s *Server) Loop(ctx context.Context, end chan<- error) {
 			break
 		}
 
-		logging.Logger.Debug("Got Method: " + method)
+		logging.Logger.Debug("Got method", "method", method)
 
 		// Validate Message (error if the client shouldn't be sending that method)
 		err = s.ValidateMethod(method)
@@ -148,12 +250,14 @@ func (s *Server) Loop(ctx context.Context, end chan<- error) {
 		switch method {
 		case "exit", "shutdown", "initialize", "initialized":
 			s.HandleMethod(ctx, method, msg)
+		case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+			s.dispatchChange(ctx, method, msg)
 		default:
-			go s.HandleMethod(ctx, method, msg)
+			s.dispatch(ctx, method, msg)
 		}
 	}
 	if s.Status == ExitError {
-		err = errors.New("exiting ungracefully")
+		err = errors.New("Exiting Ungracefully")
 		end <- err
 	} else if s.Status == Exit {
 		end <- nil
@@ -176,8 +280,9 @@ func (s *Server) ValidateMethod(method string) error {
 			return errors.New("Server not started, but received " + method)
 		}
 	case Shutdown:
-		if method != "exit" {
-			return errors.New("Can only exit" + method)
+		// Clients can start a new session on the same server after shutting down
+		if method != "exit" && method != "initialize" {
+			return errors.New("Can only exit or initialize after shutdown, but received " + method)
 		}
 	}
 	return nil
@@ -190,27 +295,8 @@ func (s *Server) HandleMethod(ctx context.Context, method string, content []byte
 	if ok {
 		var m transport.RequestMessage
 		json.Unmarshal(content, &m)
-		logging.Logger.Debug("Request ID", "type", reflect.TypeOf(m.ID), "value", m.ID)
-		if reflect.TypeOf(m.ID).String() == "float64" {
-			s.reqIdCtr = int(m.ID.(float64) + 1)
-		}
-
-		// Main handle method for request and get response
-		resp, err := handler(ctx, s, m.Params)
-
-		var responseError *transport.ResponseError
-		if err != nil {
-			responseError = &transport.ResponseError{
-				Code:    int(transport.InternalError),
-				Message: err.Error(),
-			}
-		}
-		err = s.Transport.WriteResponse(m.ID, resp, responseError)
-		if err != nil {
-			logging.Logger.Warn(err.Error())
-			return
-		}
-
+		requestCtx, done := s.trackRequest(ctx, m.ID)
+		s.respond(requestCtx, done, m, handler)
 		return
 	}
 	handler2, ok := notificationHandlers[method]
@@ -219,32 +305,133 @@ func (s *Server) HandleMethod(ctx context.Context, method string, content []byte
 		json.Unmarshal(content, &m)
 
 		// Send Request Message to appropriate Handler
-		err := handler2(ctx, s, m.Params)
+		start := time.Now()
+		err := func() (err error) {
+			defer s.recoverPanic(method, &err)
+			return handler2(ctx, s, m.Params)
+		}()
+		metrics.handled(method, time.Since(start), err != nil)
 		if err != nil {
-			logging.Logger.Warn(err.Error())
+			logging.Logger.Warn("Couldn't handle notification", "method", method, "error", err)
 			return
 		}
 	}
 	return
 }
 
+// Answers request m with the result of handler, or with the error of ctx once it is canceled. done is called once handler returns.
+func (s *Server) respond(ctx context.Context, done func(), m transport.RequestMessage, handler func(context.Context, *Server, json.RawMessage) (json.RawMessage, error)) {
+	logging.Logger.Debug("Request ID", "type", reflect.TypeOf(m.ID), "value", m.ID)
+	if reflect.TypeOf(m.ID).String() == "float64" {
+		s.reqIdCtr = int(m.ID.(float64) + 1)
+	}
+
+	// Main handle method for request and get response, unless it was canceled while waiting to be handled
+	var resp json.RawMessage
+	var err error
+	if ctx.Err() == nil {
+		start := time.Now()
+		resp, err = s.handleRequest(ctx, m, handler)
+		metrics.handled(m.Method, time.Since(start), err != nil)
+	}
+	responseError := canceledRequestError(ctx)
+	done()
+
+	if responseError != nil {
+		resp = nil
+	} else if err != nil {
+		responseError = &transport.ResponseError{
+			Code:    int(transport.InternalError),
+			Message: err.Error(),
+		}
+	}
+	if err := s.Transport.WriteResponse(m.ID, resp, responseError); err != nil {
+		logging.Logger.Warn("Couldn't write message", "error", err)
+	}
+}
+
+// Handles request m with handler, a panic of which is answered with an error rather than ending the server
+func (s *Server) handleRequest(ctx context.Context, m transport.RequestMessage, handler func(context.Context, *Server, json.RawMessage) (json.RawMessage, error)) (resp json.RawMessage, err error) {
+	defer s.recoverPanic(m.Method, &err)
+	return handler(ctx, s, m.Params)
+}
+
+// Deferred by handlers of method to recover from their panics, which are bugs: err is set to the panic,
+// its stack is written to the log and the user is asked to report it with the log
+func (s *Server) recoverPanic(method string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logging.Logger.Error("Handler panicked", "method", method, "panic", r, "stack", string(debug.Stack()))
+	*err = fmt.Errorf("internal error handling %s: %v", method, r)
+	s.reportProblem(transport.Error, fmt.Sprintf("faustlsp crashed handling %s. Please report it at %s with the log at %s", method, issuesURL, logging.Path()), nil)
+}
+
+// Where bugs are reported
+const issuesURL = "https://github.com/carn181/faustlsp/issues"
+
+// Context of the work the current session does in the background, like compiling files for diagnostics, canceled when it shuts down
+func (s *Server) sessionContext() context.Context {
+	if s.workspaceCtx == nil {
+		return context.Background()
+	}
+	return s.workspaceCtx
+}
+
+// Shows message to the user with window/showMessage
+func (s *Server) showMessage(messageType transport.MessageType, message string) {
+	params, _ := json.Marshal(transport.ShowMessageParams{Type: messageType, Message: message})
+	if err := s.Transport.WriteNotif("window/showMessage", params); err != nil {
+		logging.Logger.Warn("Couldn't write message", "error", err)
+	}
+}
+
 // Map from method to method handler for request methods
 var requestHandlers = map[string]func(context.Context, *Server, json.RawMessage) (json.RawMessage, error){
-	"initialize":                  Initialize,
-	"textDocument/documentSymbol": TextDocumentSymbol,
-	"textDocument/formatting":     Formatting,
-	"textDocument/definition":     GetDefinition,
-	"textDocument/hover":          Hover,
-	"textDocument/completion":     Completion,
-	"shutdown":                    ShutdownEnd,
+	"initialize":                             Initialize,
+	"textDocument/documentSymbol":            TextDocumentSymbol,
+	"textDocument/foldingRange":              FoldingRange,
+	"textDocument/inlayHint":                 InlayHint,
+	"textDocument/codeAction":                CodeAction,
+	"textDocument/documentLink":              DocumentLink,
+	"workspace/executeCommand":               ExecuteCommand,
+	"textDocument/codeLens":                  CodeLens,
+	"codeLens/resolve":                       CodeLensResolve,
+	"textDocument/references":                References,
+	"textDocument/linkedEditingRange":        LinkedEditingRange,
+	"textDocument/semanticTokens/full":       SemanticTokensFull,
+	"textDocument/semanticTokens/full/delta": SemanticTokensFullDelta,
+	"faustlsp/references":                    TextualReferences,
+	"faustlsp/parseTree":                     ParseTree,
+	"faustlsp/definitionBody":                DefinitionBodyRequest,
+	"faustlsp/status":                        FaustStatus,
+	"textDocument/formatting":                Formatting,
+	"textDocument/rangeFormatting":           RangeFormatting,
+	"textDocument/onTypeFormatting":          OnTypeFormatting,
+	"textDocument/definition":                GetDefinition,
+	"textDocument/hover":                     Hover,
+	"textDocument/completion":                Completion,
+	"completionItem/resolve":                 CompletionResolve,
+	"workspace/symbol":                       WorkspaceSymbols,
+	"workspaceSymbol/resolve":                WorkspaceSymbolResolve,
+	"workspace/willRenameFiles":              WillRenameFiles,
+	"workspace/willDeleteFiles":              WillDeleteFiles,
+	"shutdown":                               ShutdownEnd,
 }
 
 // Map from method to method handler for request methods
 var notificationHandlers = map[string]func(context.Context, *Server, json.RawMessage) error{
-	"initialized":            Initialized,
-	"textDocument/didOpen":   TextDocumentOpen,
-	"textDocument/didChange": TextDocumentChangeIncremental,
-	"textDocument/didClose":  TextDocumentClose,
+	"initialized":                         Initialized,
+	"textDocument/didOpen":                TextDocumentOpen,
+	"textDocument/didChange":              TextDocumentChangeIncremental,
+	"textDocument/didClose":               TextDocumentClose,
+	"workspace/didChangeWatchedFiles":     DidChangeWatchedFiles,
+	"workspace/didChangeWorkspaceFolders": DidChangeWorkspaceFolders,
+	"workspace/didChangeConfiguration":    DidChangeConfiguration,
+	"workspace/didDeleteFiles":            DidDeleteFiles,
+	"window/workDoneProgress/cancel":      WorkDoneProgressCancel,
+	"$/cancelRequest":                     CancelRequest,
 	// The save action of textDocument/didSave should be handled by our watcher to our store, so no need to handle
 	"exit": ExitEnd,
 }
@@ -262,7 +449,36 @@ func TextDocumentSymbol(ctx context.Context, s *Server, par json.RawMessage) (js
 	if !ok {
 		return []byte{}, fmt.Errorf("trying to get symbols from non-existent path: %s", path)
 	}
-	result := f.DocumentSymbols()
+	// UI elements go first so that banks inside groups end up under them
+	result := AddUISymbols(f.DocumentSymbols(), f.UISymbols())
+	result = AddBankSymbols(result, f.Banks(s.Store.Precision))
+	SortDocumentSymbols(result)
+
+	resultBytes, err := json.Marshal(result)
+
+	return resultBytes, err
+}
+
+func FoldingRange(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.FoldingRangeParams
+	json.Unmarshal(par, &params)
+
+	path, err := util.URI2path(string(params.TextDocument.URI))
+	if err != nil {
+		return []byte{}, err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte{}, fmt.Errorf("trying to get folding ranges from non-existent path: %s", path)
+	}
+	ctx, done := s.documentRequest(ctx, path)
+	defer done()
+	result, err := f.FoldingRanges(ctx)
+	if err != nil {
+		return []byte("null"), err
+	}
+	result = BankFoldingRanges(f.Banks(s.Store.Precision), result)
+	SortFoldingRanges(result)
 
 	resultBytes, err := json.Marshal(result)
 
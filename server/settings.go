@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Section of the client's settings for the server
+const settingsSection = "faustlsp"
+
+// Time the client has to answer a workspace/configuration request
+const settingsTimeout = 10 * time.Second
+
+var configurationCounter atomic.Int64
+
+// ClientSettings are the settings of the editor in its faustlsp section, with the keys of .faustcfg.json. Settings that aren't given are nil.
+// They come between the defaults and the project config: a setting applies unless the workspace's .faustcfg.json sets the same key,
+// as the project's config is shared by everyone working on it. Settings of the initialization options come before those asked for later.
+type ClientSettings struct {
+	Command             *string     `json:"command,omitempty"`
+	IncludeDir          []util.Path `json:"include,omitempty"`
+	CompilerDiagnostics *bool       `json:"compiler_diagnostics,omitempty"`
+	UnusedDiagnostics   *bool       `json:"unused_diagnostics,omitempty"`
+	ShadowDiagnostics   *bool       `json:"shadow_diagnostics,omitempty"`
+	TextualReferences   *bool       `json:"textual_references,omitempty"`
+	Formatter           *string     `json:"formatter,omitempty"`
+	LogLevel            *string     `json:"log_level,omitempty"` // debug, info, warn or error, for the whole server
+	Profile             *string     `json:"profile,omitempty"`   // Profile of the project config to use
+}
+
+// Returns the settings with those given in other replacing them
+func (settings ClientSettings) merge(other ClientSettings) ClientSettings {
+	merged := reflect.ValueOf(&settings).Elem()
+	given := reflect.ValueOf(other)
+	for i := range given.NumField() {
+		if !given.Field(i).IsNil() {
+			merged.Field(i).Set(given.Field(i))
+		}
+	}
+	return settings
+}
+
+// Settings in the initialization options of the client, which are either the settings or have them in a faustlsp section
+func initializationSettings(options any) ClientSettings {
+	if options == nil {
+		return ClientSettings{}
+	}
+	content, _ := json.Marshal(options)
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(content, &sections); err == nil {
+		if section, ok := sections[settingsSection]; ok {
+			content = section
+		}
+	}
+	return parseSettings(content)
+}
+
+// Sets the level of the server's logs if the settings have one
+func (settings ClientSettings) applyLogLevel() {
+	if settings.LogLevel == nil {
+		return
+	}
+	if err := logging.SetLevel(*settings.LogLevel); err != nil {
+		logging.Logger.Error("Invalid log level", "level", *settings.LogLevel, "error", err)
+	}
+}
+
+// Settings in content, the faustlsp section of the client's settings. Invalid or missing sections have no settings.
+func parseSettings(content json.RawMessage) ClientSettings {
+	var settings ClientSettings
+	if len(content) == 0 {
+		return settings
+	}
+	if err := json.Unmarshal(content, &settings); err != nil {
+		logging.Logger.Error("Invalid client settings", "settings", string(content), "error", err)
+		return ClientSettings{}
+	}
+	return settings
+}
+
+// Returns config with the settings applied, except for the keys set in project, the content of the project's config file if it has one
+func (settings ClientSettings) applyTo(config FaustProjectConfig, project []byte) FaustProjectConfig {
+	keys := map[string]json.RawMessage{}
+	if len(project) > 0 {
+		json.Unmarshal(project, &keys)
+	}
+	unset := func(key string) bool {
+		_, ok := keys[key]
+		return !ok
+	}
+	if settings.Command != nil && unset("command") {
+		config.Command = *settings.Command
+	}
+	if settings.IncludeDir != nil && unset("include") {
+		config.IncludeDir = settings.IncludeDir
+	}
+	if settings.CompilerDiagnostics != nil && unset("compiler_diagnostics") {
+		config.CompilerDiagnostics = *settings.CompilerDiagnostics
+	}
+	if settings.UnusedDiagnostics != nil && unset("unused_diagnostics") {
+		config.UnusedDiagnostics = *settings.UnusedDiagnostics
+	}
+	if settings.ShadowDiagnostics != nil && unset("shadow_diagnostics") {
+		config.ShadowDiagnostics = *settings.ShadowDiagnostics
+	}
+	if settings.TextualReferences != nil && unset("textual_references") {
+		config.TextualReferences = *settings.TextualReferences
+	}
+	if settings.Formatter != nil && unset("formatter") {
+		config.Formatter = *settings.Formatter
+	}
+	return config
+}
+
+// Gives the workspace new settings of the client. Its tracking loop applies them, keeping only the latest ones if it is busy.
+func (w *Workspace) updateSettings(settings ClientSettings, s *Server) {
+	if w.settingsChanges == nil {
+		// Workspace isn't tracked in the background
+		w.applySettings(settings, s)
+		return
+	}
+	select {
+	case <-w.settingsChanges:
+	default:
+	}
+	select {
+	case w.settingsChanges <- settings:
+	default:
+	}
+}
+
+// Reloads the config with settings and re-diagnoses the workspace with it, if they changed
+func (w *Workspace) applySettings(settings ClientSettings, s *Server) {
+	w.mu.Lock()
+	changed := !reflect.DeepEqual(w.settings, settings)
+	w.settings = settings
+	w.mu.Unlock()
+	if !changed {
+		return
+	}
+	logging.Logger.Info("Client settings changed", "root", w.Root, "settings", settings)
+	// The level of logs is the server's, so only the first folder's settings have it
+	if w == &s.Workspace {
+		s.initializationSettings.merge(settings).applyLogLevel()
+	}
+	w.loadConfigFiles(s)
+	w.cleanDiagnostics(s)
+}
+
+// Asks the client for the settings of each workspace folder with workspace/configuration, and gives them to the workspaces
+func (s *Server) fetchSettings(ctx context.Context) {
+	workspaces := s.workspaces()
+	items := make([]transport.ConfigurationItem, 0, len(workspaces))
+	for _, w := range workspaces {
+		item := transport.ConfigurationItem{Section: settingsSection}
+		if w.Root != "" {
+			uri := transport.URI(util.Path2URI(w.Root))
+			item.ScopeURI = &uri
+		}
+		items = append(items, item)
+	}
+	params, _ := json.Marshal(transport.ConfigurationParams{Items: items})
+
+	ctx, cancel := context.WithTimeout(ctx, settingsTimeout)
+	defer cancel()
+	id := fmt.Sprintf("faustlsp-configuration-%d", configurationCounter.Add(1))
+	response, err := s.sendRequest(ctx, id, "workspace/configuration", params)
+	if err != nil {
+		logging.Logger.Error("Couldn't get client settings", "error", err)
+		return
+	}
+	var sections []json.RawMessage
+	if err := json.Unmarshal(response.Result, &sections); err != nil {
+		logging.Logger.Error("Invalid workspace/configuration response", "result", string(response.Result), "error", err)
+		return
+	}
+	for i, w := range workspaces {
+		if i < len(sections) {
+			w.updateSettings(parseSettings(sections[i]), s)
+		}
+	}
+}
+
+// Registration for workspace/didChangeConfiguration notifications of the faustlsp section
+func configurationRegistration() transport.Registration {
+	return transport.Registration{
+		ID:              "faustlsp-configuration",
+		Method:          "workspace/didChangeConfiguration",
+		RegisterOptions: map[string]string{"section": settingsSection},
+	}
+}
+
+// DidChangeConfiguration gets the new settings of the client. Clients supporting workspace/configuration are asked for them,
+// as they send no settings or ones that aren't scoped to workspace folders, otherwise those sent apply to every folder.
+func DidChangeConfiguration(ctx context.Context, s *Server, par json.RawMessage) error {
+	if s.configurationSupport {
+		s.fetchSettings(ctx)
+		return nil
+	}
+	var params struct {
+		Settings map[string]json.RawMessage `json:"settings"`
+	}
+	json.Unmarshal(par, &params)
+	settings := parseSettings(params.Settings[settingsSection])
+	for _, w := range s.workspaces() {
+		w.updateSettings(settings, s)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Number of snippet arities kept by the server
+const snippetArityCacheSize = 256
+
+// Time after which compiling a snippet is given up on, so that hovers don't hang
+const snippetCompileTimeout = 10 * time.Second
+
+// Key of a snippet arity: hash of the expression, of the statements it can use, and of the precision it's compiled in
+type SnippetKey [sha256.Size]byte
+
+func NewSnippetKey(statements string, expression string, precision string) SnippetKey {
+	statementsHash := sha256.Sum256([]byte(statements))
+	expressionHash := sha256.Sum256([]byte(expression))
+	key := append(statementsHash[:], expressionHash[:]...)
+	return SnippetKey(sha256.Sum256(append(key, precision...)))
+}
+
+type snippetArity struct {
+	key   SnippetKey
+	arity Arity
+	ok    bool
+}
+
+// ArityCache keeps the arities of compiled snippets, evicting the least recently used ones past its size.
+// Snippets that failed to compile are kept too, so they aren't compiled again.
+type ArityCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[SnippetKey]*list.Element
+	order   *list.List
+}
+
+func NewArityCache(size int) *ArityCache {
+	return &ArityCache{size: size, entries: make(map[SnippetKey]*list.Element), order: list.New()}
+}
+
+// Arity returns the cached arity of key, calling compute if it isn't cached
+func (c *ArityCache) Arity(key SnippetKey, compute func() (Arity, bool)) (Arity, bool) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		entry := elem.Value.(snippetArity)
+		c.mu.Unlock()
+		return entry.arity, entry.ok
+	}
+	c.mu.Unlock()
+
+	arity, ok := compute()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, cached := c.entries[key]; !cached {
+		c.entries[key] = c.order.PushFront(snippetArity{key: key, arity: arity, ok: ok})
+		for c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(snippetArity).key)
+		}
+	}
+	return arity, ok
+}
+
+func (c *ArityCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Arity of an expression that can't be computed statically, found by compiling it as the process of a snippet
+// made of the other top-level statements of its file
+func (s *Server) compiledArity(node *tree_sitter.Node, content []byte, path util.Path) (Arity, bool) {
+	if node == nil {
+		return Arity{}, false
+	}
+	w := s.workspaceFor(path)
+	config := w.ConfigFor(path)
+	statements := snippetStatements(node, content, config.processName())
+	expression := node.Utf8Text(content)
+	key := NewSnippetKey(statements, expression, config.EffectivePrecision())
+	return s.snippetArities.Arity(key, func() (Arity, bool) {
+		return compileSnippetArity(statements+"process = "+expression+";\n", filepath.Dir(path), w.Root, config)
+	})
+}
+
+// Top-level statements of the file containing node, without the process definition which the snippet replaces
+func snippetStatements(node *tree_sitter.Node, content []byte, processName string) string {
+	root := node
+	for root.Parent() != nil {
+		root = root.Parent()
+	}
+	var b strings.Builder
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		statement := root.NamedChild(i)
+		switch statement.Kind() {
+		case "definition", "function_definition":
+			if name := definitionIdentifier(statement); name != nil && name.Utf8Text(content) == processName {
+				continue
+			}
+			b.WriteString(statement.Utf8Text(content) + ";\n")
+		case "global_metadata", "function_metadata":
+			b.WriteString(statement.Utf8Text(content) + ";\n")
+		case "file_import":
+			b.WriteString(statement.Utf8Text(content) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// Compiles snippet with faust -json, reading the number of inputs and outputs of its process from the generated JSON
+func compileSnippetArity(snippet string, dir util.Path, root util.Path, config FaustProjectConfig) (Arity, bool) {
+	tempDir, err := os.MkdirTemp("", "faustlsp-snippet-")
+	if err != nil {
+		return Arity{}, false
+	}
+	defer os.RemoveAll(tempDir)
+	snippetPath := filepath.Join(tempDir, "snippet.dsp")
+	if err := os.WriteFile(snippetPath, []byte(snippet), 0644); err != nil {
+		return Arity{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), snippetCompileTimeout)
+	defer cancel()
+	args := []string{snippetPath, "-json", "-O", tempDir, "-o", os.DevNull, "-I", dir}
+	for _, include := range config.IncludeDir {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(root, include)
+		}
+		args = append(args, "-I", include)
+	}
+	if flag := precisionFlags[config.EffectivePrecision()]; flag != "" {
+		args = append(args, flag)
+	}
+	args = append(args, config.CompilerArgs...)
+	var output []byte
+	err = config.runCompiler(ctx, config.Command, args, dir, root, func(cmd *exec.Cmd) (err error) {
+		output, err = cmd.CombinedOutput()
+		return err
+	})
+	if err != nil {
+		logging.Logger.Info("Couldn't compile snippet to get its arity", "error", err, "output", string(output))
+		return Arity{}, false
+	}
+
+	content, err := os.ReadFile(snippetPath + ".json")
+	if err != nil {
+		return Arity{}, false
+	}
+	var result struct {
+		Inputs  *int `json:"inputs"`
+		Outputs *int `json:"outputs"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil || result.Inputs == nil || result.Outputs == nil {
+		return Arity{}, false
+	}
+	return Arity{Inputs: *result.Inputs, Outputs: *result.Outputs}, true
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Snippets shared by a team, in the format of VS Code snippet files
+const snippetsFile = ".faustlsp/snippets.json"
+
+// Snippet is a completion inserting Body, with tab stops like $1 or ${2:default}, when typing Prefix
+type Snippet struct {
+	Label       string
+	Prefix      string
+	Body        string
+	Description string
+}
+
+// Strings of snippet files, which can be split in an array of lines
+type snippetLines []string
+
+func (l *snippetLines) UnmarshalJSON(content []byte) error {
+	var line string
+	if err := json.Unmarshal(content, &line); err == nil {
+		*l = []string{line}
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(content, &lines); err != nil {
+		return err
+	}
+	*l = lines
+	return nil
+}
+
+// SnippetDefinition is a snippet of a snippets file or of the snippets section of the project config, by label
+type SnippetDefinition struct {
+	Prefix      snippetLines `json:"prefix"`
+	Body        snippetLines `json:"body"`
+	Description string       `json:"description"`
+}
+
+// SnippetDefinitions are the snippets of a snippets file by label
+type SnippetDefinitions map[string]SnippetDefinition
+
+// ParseSnippets reads snippets from a snippets file mapping each snippet's label to its prefix, body and description.
+// Snippets with several prefixes are completed by each of them.
+func ParseSnippets(content []byte) ([]Snippet, error) {
+	var file SnippetDefinitions
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	return snippetsOf(file), nil
+}
+
+// Snippets of definitions by label, sorted by prefix
+func snippetsOf(definitions SnippetDefinitions) []Snippet {
+	snippets := []Snippet{}
+	for label, snippet := range definitions {
+		prefixes := snippet.Prefix
+		if len(prefixes) == 0 {
+			prefixes = []string{label}
+		}
+		for _, prefix := range prefixes {
+			snippets = append(snippets, Snippet{
+				Label:       label,
+				Prefix:      prefix,
+				Body:        strings.Join(snippet.Body, "\n"),
+				Description: snippet.Description,
+			})
+		}
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		if snippets[i].Prefix != snippets[j].Prefix {
+			return snippets[i].Prefix < snippets[j].Prefix
+		}
+		return snippets[i].Label < snippets[j].Label
+	})
+	return snippets
+}
+
+// Snippets of common Faust idioms completed in every file
+var builtinSnippets = []Snippet{
+	{
+		Label:       "Stereo effect",
+		Prefix:      "stereo",
+		Body:        "import(\"stdfaust.lib\");\n\n${1:fx} = ${2:_};\nprocess = ${1:fx}, ${1:fx};$0",
+		Description: "Effect applied to both channels",
+	},
+	{
+		Label:       "Oscillator with UI",
+		Prefix:      "oscui",
+		Body:        "freq = hslider(\"${1:freq}[unit:Hz]\", ${2:440}, 20, 20000, 1);\ngain = hslider(\"${3:gain}\", ${4:0.5}, 0, 1, 0.01) : si.smoo;\nprocess = os.${5:osc}(freq) * gain;$0",
+		Description: "Oscillator with frequency and gain sliders",
+	},
+	{
+		Label:       "Pattern matching definition",
+		Prefix:      "case",
+		Body:        "${1:f} = case {\n\t(${2:0}) => ${3:_};\n\t(${4:n}) => ${5:_};\n};$0",
+		Description: "Definition by cases of its argument",
+	},
+	{
+		Label:       "Feedback loop with letrec",
+		Prefix:      "letrec",
+		Body:        "${1:y} letrec {\n\t'${1:y} = ${2:_} + ${3:0.5} * ${1:y};\n}$0",
+		Description: "Signal defined from its previous sample",
+	},
+	{
+		Label:       "Sliding window iteration",
+		Prefix:      "sliding",
+		Body:        "${1:slidingMax}(N) = _ <: par(${2:i}, N, @(${2:i})) : ba.parallel${3:Max}(N);$0",
+		Description: "Reduction of the last N samples, like slidingMin",
+	},
+}
+
+// SnippetCompletionItems returns completion items inserting snippets in place of r
+func SnippetCompletionItems(snippets []Snippet, r transport.Range) []transport.CompletionItem {
+	items := []transport.CompletionItem{}
+	format := transport.SnippetTextFormat
+	for _, snippet := range snippets {
+		item := transport.CompletionItem{
+			Label:            snippet.Prefix,
+			Kind:             transport.SnippetCompletion,
+			Detail:           snippet.Label,
+			InsertTextFormat: &format,
+			TextEdit: transport.TextEdit{
+				NewText: snippet.Body,
+				Range:   r,
+			},
+		}
+		if snippet.Description != "" {
+			item.LabelDetails = &transport.CompletionItemLabelDetails{Description: snippet.Description}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Reads the workspace's snippets file, if any
+func (w *Workspace) loadSnippets(s *Server) {
+	path := filepath.Join(w.Root, filepath.FromSlash(snippetsFile))
+	snippets := []Snippet{}
+	// Single files have no snippets file
+	if w.Root != "" {
+		s.Files.OpenFromPath(path)
+	}
+	if f, ok := s.Files.GetFromPath(path); ok && w.Root != "" {
+		f.mu.RLock()
+		parsed, err := ParseSnippets(f.Content)
+		f.mu.RUnlock()
+		if err != nil {
+			logging.Logger.Error("Invalid snippets file", "error", err)
+			s.showMessage(transport.Warning, fmt.Sprintf("Couldn't read %s: %s", snippetsFile, err))
+		} else {
+			snippets = parsed
+		}
+	}
+	w.mu.Lock()
+	w.snippets = snippets
+	w.mu.Unlock()
+	logging.Logger.Info("Loaded snippets", "count", len(snippets))
+}
+
+// Snippets of the workspace's snippets file and of the snippets section of the config of the file at path
+func (w *Workspace) snippetsFor(path util.Path) []Snippet {
+	return append(slices.Clone(w.Snippets()), snippetsOf(w.ConfigFor(path).Snippets)...)
+}
+
+func (w *Workspace) Snippets() []Snippet {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snippets
+}
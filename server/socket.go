@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/carn181/faustlsp/logging"
+)
+
+// Serve serves the clients connecting to ln one after another, for clients that launch the server separately and connect to it.
+// Each client gets a server of its own, so that nothing of a session, like its workspace, open documents or shutdown state, is left for the next one.
+// It returns once ctx is done, or with the error of accepting a connection.
+func Serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	logging.Logger.Info("Listening for clients", "address", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		logging.Logger.Info("Client connected", "address", conn.RemoteAddr().String())
+
+		// Goroutines of the session, like the watcher of its workspace, end with it
+		sessionCtx, cancel := context.WithCancel(ctx)
+		var s Server
+		s.InitConn(conn)
+		err = s.Run(sessionCtx)
+		cancel()
+		s.Transport.Close()
+		logging.Logger.Info("Client disconnected", "address", conn.RemoteAddr().String(), "error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Environment variable listing directories the compiler looks for imported files in before its library directory
+const faustLibPathEnv = "FAUST_LIB_PATH"
+
+// Command returning how the server finds the compiler and imported files
+const statusCommand = "faustlsp.status"
+
+// Directories the Faust libraries are installed in by packages and installers, tried when the compiler isn't available
+var libraryInstallDirs = []util.Path{
+	"/usr/local/share/faust",
+	"/usr/share/faust",
+	"/opt/homebrew/share/faust",
+	"/opt/local/share/faust",
+	`C:\Program Files\Faust\share\faust`,
+}
+
+// Faust library directory of a workspace, looked up once per config, and the one whose libraries were indexed
+type libraryIndex struct {
+	mu      sync.Mutex
+	located bool
+	dir     util.Path
+	indexed util.Path
+}
+
+// LocateLibraryDir returns the directory of the Faust libraries: the configured one, relative to root if it isn't absolute,
+// else the one compilerDir reports, else the first of candidates. Directories without stdfaust.lib are skipped.
+func LocateLibraryDir(configured util.Path, root util.Path, compilerDir func() util.Path, candidates []util.Path) util.Path {
+	isLibraryDir := func(dir util.Path) bool {
+		return dir != "" && util.IsValidPath(filepath.Join(dir, standardLibrary))
+	}
+	if configured != "" {
+		if !filepath.IsAbs(configured) {
+			configured = filepath.Join(root, configured)
+		}
+		if isLibraryDir(configured) {
+			return configured
+		}
+		logging.Logger.Error("Configured library directory has no standard library", "dir", configured, "file", standardLibrary)
+	}
+	if dir := compilerDir(); isLibraryDir(dir) {
+		return dir
+	}
+	for _, dir := range candidates {
+		if isLibraryDir(dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// Library directory of the compiler, which -dspdir prints
+func compilerLibraryDir(command string) util.Path {
+	if _, err := exec.LookPath(command); err != nil {
+		logging.Logger.Error("Couldn't find faust command in PATH", "cmd", command)
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), compilerProbeTimeout)
+	defer cancel()
+	var output strings.Builder
+	cmd := exec.CommandContext(ctx, command, "-dspdir")
+	cmd.Stdout = &output
+	_ = cmd.Run()
+	return strings.TrimSpace(output.String())
+}
+
+// Install directories of the Faust libraries on this machine, including the user's own
+func installDirs() []util.Path {
+	dirs := slices.Clone(libraryInstallDirs)
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".local", "share", "faust"))
+	}
+	return dirs
+}
+
+func (w *Workspace) libraryDir() util.Path {
+	w.libraries.mu.Lock()
+	defer w.libraries.mu.Unlock()
+	if !w.libraries.located {
+		config := w.config()
+		command := config.Command
+		w.libraries.dir = LocateLibraryDir(config.LibraryDir, w.Root, func() util.Path { return compilerLibraryDir(command) }, installDirs())
+		w.libraries.located = true
+		logging.Logger.Info("Faust library directory", "dir", w.libraries.dir)
+	}
+	return w.libraries.dir
+}
+
+// Forgets the library directory so that it is looked up again with a new config
+func (w *Workspace) resetLibraryDir() {
+	w.libraries.mu.Lock()
+	w.libraries.located = false
+	w.libraries.mu.Unlock()
+}
+
+// LibraryFiles returns the .lib files of the Faust library directory dir
+func LibraryFiles(dir util.Path) []util.Path {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return []util.Path{}
+	}
+	files := []util.Path{}
+	for _, entry := range entries {
+		if !entry.IsDir() && IsLibFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files
+}
+
+// Parses the Faust libraries in the background so that their definitions are in the store before files import them,
+// for their completion, hover and definitions. They are parsed once, later imports reuse their cached scopes.
+func (w *Workspace) indexLibraries(s *Server) {
+	dir := w.libraryDir()
+	if dir == "" {
+		logging.Logger.Info("Not indexing Faust libraries as they couldn't be found")
+		return
+	}
+	w.libraries.mu.Lock()
+	indexed := w.libraries.indexed == dir
+	w.libraries.indexed = dir
+	w.libraries.mu.Unlock()
+	if indexed {
+		return
+	}
+
+	// Libraries are shared by the workspace folders, so they stay indexed when the folder is removed, until the session shuts down
+	ctx := s.sessionContext()
+	w.indexFiles(ctx, s, "Indexing Faust libraries", LibraryFiles(dir), nil)
+	if ctx.Err() != nil {
+		w.libraries.mu.Lock()
+		w.libraries.indexed = ""
+		w.libraries.mu.Unlock()
+	}
+}
+
+// ImportSearchPath returns the directories relative imports are looked up in, in the compiler's order:
+// rootDir, the include directories, those of FAUST_LIB_PATH, then the Faust library directory
+func (w *Workspace) ImportSearchPath(rootDir util.Path) []util.Path {
+	dirs := []util.Path{rootDir}
+	for _, includeDir := range w.config().IncludeDir {
+		if !filepath.IsAbs(includeDir) {
+			includeDir = w.Rel2Abs(includeDir)
+		}
+		dirs = append(dirs, includeDir)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv(faustLibPathEnv))...)
+	dirs = append(dirs, w.libraryDir())
+	return slices.DeleteFunc(dirs, func(dir util.Path) bool { return dir == "" })
+}
+
+// Result of the faustlsp.status command
+type Status struct {
+	Command      string          `json:"command"`
+	CompilerPath string          `json:"compilerPath"` // Empty if the compiler isn't in PATH
+	LibraryDir   util.Path       `json:"libraryDir"`
+	FaustLibPath string          `json:"faustLibPath"`
+	SearchPath   []util.Path     `json:"searchPath"` // Directories imports of workspace files are looked up in, in order
+	Profile      string          `json:"profile"`    // Active profile of the project config, empty if none
+	Version      string          `json:"version"`    // Version of the compiler, empty if it couldn't be found or didn't tell
+	Metrics      MetricsSnapshot `json:"metrics"`
+}
+
+func StatusCommand(ctx context.Context, s *Server, arguments []json.RawMessage) (json.RawMessage, error) {
+	config := s.Workspace.config()
+	compilerPath, _ := exec.LookPath(config.Command)
+	return json.Marshal(Status{
+		Command:      config.Command,
+		CompilerPath: compilerPath,
+		LibraryDir:   s.Workspace.libraryDir(),
+		FaustLibPath: os.Getenv(faustLibPathEnv),
+		SearchPath:   s.Workspace.ImportSearchPath(s.Workspace.Root),
+		Profile:      s.Workspace.profile,
+		Version:      s.probeCompiler(config.Command).Version,
+		Metrics:      metrics.Snapshot(),
+	})
+}
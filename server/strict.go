@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Diagnostic codes of strict mode
+const (
+	missingDeclareCode = "missing-declare"
+	namingCode         = "naming-convention"
+	fileLengthCode     = "file-too-long"
+	nestingCode        = "nesting-too-deep"
+)
+
+// Nodes that nest expressions in a block, counted for the nesting depth
+var nestingKinds = map[string]struct{}{
+	"environment":     {},
+	"rec_environment": {},
+	"pattern":         {},
+	"lambda":          {},
+	"iteration":       {},
+}
+
+// StrictConfig is the opt-in strict analysis profile, for teams standardizing their codebases.
+// It is enabled with "strict": true, or with an object overriding some of the defaults.
+type StrictConfig struct {
+	Enabled          bool     `json:"enabled"`
+	RequiredDeclares []string `json:"required_declares"` // Keys every library file must declare
+	DefinitionNaming string   `json:"definition_naming"` // Pattern definition names must match
+	ArgumentNaming   string   `json:"argument_naming"`   // Pattern function argument names must match
+	MaxLines         int      `json:"max_lines"`         // Lines a file can have at most, 0 for no limit
+	MaxNesting       int      `json:"max_nesting"`       // Blocks like with, case or par that can be nested at most, 0 for no limit
+}
+
+func defaultStrictConfig() StrictConfig {
+	return StrictConfig{
+		RequiredDeclares: []string{"name", "author", "license"},
+		DefinitionNaming: `^_*[a-z][a-zA-Z0-9_]*$`,
+		ArgumentNaming:   `^_*[a-z][a-zA-Z0-9_]*$`,
+		MaxLines:         1000,
+		MaxNesting:       5,
+	}
+}
+
+func (c *StrictConfig) UnmarshalJSON(content []byte) error {
+	cfg := defaultStrictConfig()
+	var enabled bool
+	if err := json.Unmarshal(content, &enabled); err == nil {
+		cfg.Enabled = enabled
+		*c = cfg
+		return nil
+	}
+	type Config StrictConfig
+	strict := Config(cfg)
+	strict.Enabled = true
+	if err := json.Unmarshal(content, &strict); err != nil {
+		return err
+	}
+	*c = StrictConfig(strict)
+	return nil
+}
+
+// StrictDiagnostics reports warnings for what config forbids in content: missing declare statements in libraries,
+// names not following the naming patterns, and files longer or more nested than allowed
+func StrictDiagnostics(root *tree_sitter.Node, content []byte, config StrictConfig, library bool) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	warning := func(r transport.Range, code string, message string) {
+		diagnostics = append(diagnostics, transport.Diagnostic{
+			Range:    r,
+			Severity: transport.DiagnosticSeverity(transport.Warning),
+			Code:     code,
+			Source:   "faustlsp",
+			Message:  message,
+		})
+	}
+
+	if library {
+		declared := make(map[string]struct{})
+		for i := uint(0); i < root.NamedChildCount(); i++ {
+			if child := root.NamedChild(i); child.Kind() == "global_metadata" {
+				if key := child.ChildByFieldName("key"); key != nil {
+					declared[key.Utf8Text(content)] = struct{}{}
+				}
+			}
+		}
+		for _, key := range config.RequiredDeclares {
+			if _, ok := declared[key]; !ok {
+				warning(transport.Range{}, missingDeclareCode, fmt.Sprintf("Library doesn't declare its %s, e.g. declare %s \"...\";", key, key))
+			}
+		}
+	}
+
+	if lines := strings.Count(string(content), "\n") + 1; config.MaxLines > 0 && lines > config.MaxLines {
+		line := uint32(config.MaxLines)
+		warning(transport.Range{Start: transport.Position{Line: line}, End: transport.Position{Line: line}}, fileLengthCode,
+			fmt.Sprintf("File has %d lines, more than the %d allowed", lines, config.MaxLines))
+	}
+
+	definitionNaming := namingPattern(config.DefinitionNaming)
+	argumentNaming := namingPattern(config.ArgumentNaming)
+	checkName := func(ident *tree_sitter.Node, pattern *regexp.Regexp, what string) {
+		if ident == nil || pattern == nil || ident.Kind() != "identifier" {
+			return
+		}
+		if name := ident.Utf8Text(content); !pattern.MatchString(name) {
+			warning(ToRange(ident), namingCode, fmt.Sprintf("%s name %s doesn't match %s", what, name, pattern))
+		}
+	}
+
+	var walk func(node *tree_sitter.Node, depth int)
+	walk = func(node *tree_sitter.Node, depth int) {
+		switch node.Kind() {
+		case "definition", "function_definition":
+			checkName(definitionIdentifier(node), definitionNaming, "Definition")
+			if arguments := childOfKind(node, "arguments"); arguments != nil {
+				for i := uint(0); i < arguments.NamedChildCount(); i++ {
+					checkName(arguments.NamedChild(i), argumentNaming, "Argument")
+				}
+			}
+		case "lambda":
+			if parameters := childOfKind(node, "parameters"); parameters != nil {
+				for i := uint(0); i < parameters.NamedChildCount(); i++ {
+					checkName(parameters.NamedChild(i), argumentNaming, "Argument")
+				}
+			}
+		}
+		if _, ok := nestingKinds[node.Kind()]; ok {
+			depth++
+			// Only the outermost block past the limit is reported
+			if config.MaxNesting > 0 && depth == config.MaxNesting+1 {
+				warning(ToRange(node), nestingCode, fmt.Sprintf("Blocks are nested %d deep here, more than the %d allowed", depth, config.MaxNesting))
+			}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i), depth)
+		}
+	}
+	walk(root, 0)
+	return diagnostics
+}
+
+func namingPattern(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logging.Logger.Error("Invalid naming pattern in strict config", "pattern", pattern, "error", err)
+		return nil
+	}
+	return re
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"log/slog"
-	"os/exec"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -92,6 +91,12 @@ type Symbol struct {
 	// File path to import scope from
 	File util.Path
 
+	// For definitions that only rename another symbol (e.g. filters = fi; or lowpass = fi.lowpass;), the qualified identifier being aliased
+	Alias string
+
+	// Precisions the definition is restricted to (e.g. doubleprecision PI = ...;). Empty if defined for all precisions
+	Variants []string
+
 	// Documentation
 	Docs Documentation
 }
@@ -373,6 +378,18 @@ func (dg *DependencyGraph) GetImporters(path string) []string {
 	return importers
 }
 
+// GetImports returns the files the given file imports.
+func (dg *DependencyGraph) GetImports(path string) []string {
+	dg.mu.RLock()
+	defer dg.mu.RUnlock()
+
+	imported := []string{}
+	for importedPath := range dg.imports[path] {
+		imported = append(imported, importedPath)
+	}
+	return imported
+}
+
 type SymbolKey struct {
 	File util.Path
 	Name string
@@ -390,6 +407,41 @@ type Store struct {
 	References   ReferenceMap
 	Dependencies DependencyGraph
 	Cache        map[[sha256.Size]byte]*Scope
+
+	// Trees the cached scopes were parsed from, closed when the scope is dropped from the cache as symbols point into them
+	trees map[[sha256.Size]byte]*parser.Tree
+
+	// Precision the project is compiled in, used to pick between singleprecision/doubleprecision/... variants of definitions
+	Precision string
+}
+
+// Caches the scope parsed from tree, keeping tree open until the scope is released
+func (store *Store) cacheScope(hash [sha256.Size]byte, scope *Scope, tree *parser.Tree) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.Cache[hash] = scope
+	if store.trees == nil {
+		store.trees = make(map[[sha256.Size]byte]*parser.Tree)
+	}
+	if old, ok := store.trees[hash]; ok && old != tree {
+		old.Close()
+	}
+	store.trees[hash] = tree
+}
+
+// ReleaseUnused closes the trees of cached scopes whose content no file has anymore, e.g. after edits or removals.
+// Their scopes are dropped from the cache as their symbols' nodes aren't valid anymore.
+func (store *Store) ReleaseUnused() {
+	hashes := store.Files.hashes()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for hash, tree := range store.trees {
+		if _, ok := hashes[hash]; !ok {
+			tree.Close()
+			delete(store.trees, hash)
+			delete(store.Cache, hash)
+		}
+	}
 }
 
 // This needs workspace to be able to resolve the file path
@@ -443,23 +495,26 @@ func (workspace *Workspace) ParseFile(f *File, store *Store, visited map[util.Pa
 	if _, ok := visited[f.Handle.Path]; !ok {
 		f.mu.Lock()
 		// Check if file content of this type is already parsed
+		store.mu.Lock()
 		scope, ok := store.Cache[f.Hash]
+		store.mu.Unlock()
 		if ok {
 			logging.Logger.Info("File already parsed, using cached scope", "file", f.Handle.Path)
 			f.Scope = scope
 			f.mu.Unlock()
 		} else {
 
-			tree := parser.ParseTree(f.Content)
+			// The scope keeps a copy of the file's tree, which its symbols point into
+			tree := f.syntaxTree().Copy()
 			root := tree.RootNode()
 			scope := NewScope(nil, ToRange(root))
 			visited[f.Handle.Path] = struct{}{}
 			workspace.ParseASTNode(root, f, scope, store, visited, fileChan)
 			f.Scope = scope
-			store.Cache[f.Hash] = scope
+			store.cacheScope(f.Hash, scope, tree)
 			f.mu.Unlock()
 
-			//			tree.Close()
+			store.ReleaseUnused()
 			logging.Logger.Info("Parsed file", "path", f.Handle.Path)
 		}
 	} else {
@@ -501,7 +556,8 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 			}
 
 			libraryFilePath := stripQuotes(fileName.Utf8Text(currentFile.Content))
-			resolvedPath, _ := workspace.ResolveFilePath(libraryFilePath, workspace.Root)
+			resolvedPath, dir := workspace.ResolveFilePath(libraryFilePath, workspace.ImportRoot(currentFile.Handle.Path))
+			workspace.indexImport(libraryFilePath, resolvedPath, dir)
 
 			logging.Logger.Info("AST Traversal: Got library definition", "file", resolvedPath, "ident", identName)
 			fileChan <- resolvedPath
@@ -556,10 +612,14 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 				},
 				identName,
 				value, expr, ParseDocumentation(node, currentFile.Content))
+			if valueGrammarName == "identifier" || valueGrammarName == "access" {
+				sym.Alias = strings.Join(strings.Fields(value.Utf8Text(currentFile.Content)), "")
+			}
+			sym.Variants = DefinitionVariants(node)
 			scope.addSymbol(&sym)
 		}
 	case "environment":
-		logging.Logger.Info("AST Traversal: Parsing Environment without identifier", "environment", node.Utf8Text(currentFile.Content))
+		logging.Logger.Debug("AST Traversal: Parsing Environment without identifier", "length", node.EndByte()-node.StartByte())
 		node = node.NextSibling()
 		if node == nil {
 			logging.Logger.Info("AST Traversal: Got environment without definitions. Ignoring.")
@@ -595,14 +655,14 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 		}
 
 		argumentsScope := NewScope(scope, ToRange(node))
-		logging.Logger.Info("AST Traversal: Got function_definition", "arguments", arguments.GrammarName(), "functionName", functionName.Utf8Text(currentFile.Content))
+		logging.Logger.Debug("AST Traversal: Got function_definition", "arguments", arguments.GrammarName(), "length", node.EndByte()-node.StartByte())
 		for i := uint(0); i < arguments.ChildCount(); i++ {
 			argumentNode := arguments.Child(i)
 			if !argumentNode.IsNamed() {
 				continue
 			}
 
-			logging.Logger.Info("AST Traversal: Parsing function argument", "arg", argumentNode.GrammarName(), "content", argumentNode.Utf8Text(currentFile.Content))
+			logging.Logger.Debug("AST Traversal: Parsing function argument", "arg", argumentNode.GrammarName(), "length", argumentNode.EndByte()-argumentNode.StartByte())
 
 			arg := NewIdentifier(
 				Location{
@@ -623,8 +683,9 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 			return
 		}
 
-		// Treat it as a part of a pattern scope because arguments defined are only in function scope
-		exprScope := NewScope(scope, ToRange(node))
+		// Treat it as a part of a pattern scope because arguments defined are only in function scope.
+		// Nested in the arguments' scope so that scopes inside the expression are found under them and resolve to them
+		exprScope := NewScope(argumentsScope, ToRange(node))
 		logging.Logger.Info("Parsing function value using separate scope")
 		for i := uint(0); i < node.ChildCount(); i++ {
 			workspace.ParseASTNode(node.Child(i), currentFile, exprScope, store, visited, fileChan)
@@ -641,6 +702,7 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 			exprScope,
 			ParseDocumentation(node, currentFile.Content),
 		)
+		functionNode.Variants = DefinitionVariants(node)
 
 		scope.addSymbol(&functionNode)
 		logging.Logger.Info("Current scope values", "scope_children", len(scope.Children), "scope_symbols", len(scope.Symbols))
@@ -664,7 +726,7 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 		logging.Logger.Info("Current scope values", "scope", scope)
 
 	case "with_environment":
-		logging.Logger.Info("AST Traversal: Got with environment", "text", node.Utf8Text(currentFile.Content))
+		logging.Logger.Debug("AST Traversal: Got with environment", "length", node.EndByte()-node.StartByte())
 
 		expr := node.ChildByFieldName("expression")
 
@@ -684,7 +746,8 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 			workspace.ParseASTNode(environment.NamedChild(i), currentFile, withScope, store, visited, fileChan)
 		}
 
-		exprScope := NewScope(scope, ToRange(node))
+		// Nested in the definitions' scope like function expressions in their arguments'
+		exprScope := NewScope(withScope, ToRange(node))
 		logging.Logger.Info("AST Traversal: Parsing expr definition", "child", expr.GrammarName())
 		workspace.ParseASTNode(expr, currentFile, exprScope, store, visited, fileChan)
 
@@ -696,7 +759,7 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 		logging.Logger.Info("Current scope values", "scope", scope)
 
 	case "letrec_environment":
-		logging.Logger.Info("AST Traversal: Got letrec environment", "text", node.Utf8Text(currentFile.Content))
+		logging.Logger.Debug("AST Traversal: Got letrec environment", "length", node.EndByte()-node.StartByte())
 		expr := node.ChildByFieldName("expression")
 		if expr == nil {
 			logging.Logger.Error("AST Traversal: LetRec environment without expression. Skipping")
@@ -714,7 +777,7 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 			workspace.ParseASTNode(environment.Child(i), currentFile, letRecScope, store, visited, fileChan)
 		}
 
-		exprScope := NewScope(scope, ToRange(node))
+		exprScope := NewScope(letRecScope, ToRange(node))
 		workspace.ParseASTNode(expr, currentFile, exprScope, store, visited, fileChan)
 
 		sym := NewLetRecEnvironment(Location{
@@ -734,7 +797,8 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 
 		// Strip quotes as file name comes as "file_name" not just file_name in tree_sitter grammar
 		file := stripQuotes(fileNode.Utf8Text(currentFile.Content))
-		resolvedPath, _ := workspace.ResolveFilePath(file, workspace.Root)
+		resolvedPath, dir := workspace.ResolveFilePath(file, workspace.ImportRoot(currentFile.Handle.Path))
+		workspace.indexImport(file, resolvedPath, dir)
 		logging.Logger.Info("AST Traversal: Got import statement. Going through tree", "file", resolvedPath)
 
 		fileChan <- resolvedPath
@@ -752,6 +816,22 @@ func (workspace *Workspace) ParseASTNode(node *tree_sitter.Node, currentFile *Fi
 		logging.Logger.Info("Current scope values", "scope", scope)
 		// TODO: Recursively parse the imported file if it exists
 
+	// Libraries and components used in expressions, like library("filters.lib").lowpass, aren't bound to a name
+	case "library", "component":
+		fileNode := node.ChildByFieldName("filename")
+		if fileNode == nil || fileNode.EndByte()-fileNode.StartByte() < 2 {
+			return
+		}
+		file := stripQuotes(fileNode.Utf8Text(currentFile.Content))
+		resolvedPath, dir := workspace.ResolveFilePath(file, workspace.ImportRoot(currentFile.Handle.Path))
+		workspace.indexImport(file, resolvedPath, dir)
+		logging.Logger.Info("AST Traversal: Got expression importing file", "kind", name, "file", resolvedPath)
+
+		// Parsed so that accesses of libraries complete
+		if resolvedPath != "" {
+			fileChan <- resolvedPath
+		}
+
 	case "iteration":
 		logging.Logger.Info("AST Traversal: Got iteration node")
 
@@ -880,45 +960,43 @@ func stripQuotes(s string) string {
 	return stripped
 }
 
+// GetFaustDSPDir returns the directory of the Faust libraries, empty if they couldn't be found
 func (w *Workspace) GetFaustDSPDir() string {
-	faustCommand := w.Config.Command
-	_, err := exec.LookPath(faustCommand)
-	if err != nil {
-		logging.Logger.Error("Couldn't find faust command in PATH", "cmd", faustCommand)
-	}
-	var output strings.Builder
-	cmd := exec.Command(faustCommand, "-dspdir")
-	cmd.Stdout = &output
-
-	_ = cmd.Run()
-	faustDSPDirPath := output.String()
-	// Remove \n at the end
-	faustDSPDirPath = faustDSPDirPath[:len(faustDSPDirPath)-1]
-	return faustDSPDirPath
+	return w.libraryDir()
 }
 
 // Resolves a given file path like the Faust compiler does when it has to import a file
 // Returns the path along with the directory/workspace path the file was found in
 func (w *Workspace) ResolveFilePath(relPath util.Path, rootDir util.Path) (path util.Path, dir util.Path) {
-	// File in workspace
-	path1 := filepath.Join(rootDir, relPath)
-	//	logging.Logger.Info("Trying path", "path", path1)
-	if util.IsValidPath(path1) {
-		return path1, rootDir
+	// Absolute path
+	if filepath.IsAbs(relPath) {
+		if util.IsValidPath(relPath) {
+			return relPath, filepath.Dir(relPath)
+		}
+		logging.Logger.Info("Couldn't resolve absolute file path", "path", relPath)
+		return "", ""
 	}
 
-	// File in Faust System Library DSP directory
-	faustDSPDir := w.GetFaustDSPDir()
-	path2 := filepath.Join(faustDSPDir, relPath)
-	//	logging.Logger.Info("Trying path", "path", path2)
-	if util.IsValidPath(path2) {
-		return path2, faustDSPDir
+	// File in workspace, include directories of project config, FAUST_LIB_PATH or Faust System Library DSP directory
+	for _, dir := range w.ImportSearchPath(rootDir) {
+		if path := filepath.Join(dir, relPath); util.IsValidPath(path) {
+			return path, dir
+		}
 	}
 
 	logging.Logger.Info("Couldn't resolve file path")
 	return "", ""
 }
 
+// ImportRoot returns the directory relative imports of the file at path are resolved from first: the workspace root,
+// or the file's own directory for single files opened without a workspace
+func (w *Workspace) ImportRoot(path util.Path) util.Path {
+	if w.Root == "" {
+		return filepath.Dir(path)
+	}
+	return w.Root
+}
+
 func FindSymbol(ident string, scope *Scope, store *Store) (Symbol, error) {
 	var visited = make(map[util.Path]struct{})
 
@@ -930,13 +1008,22 @@ func FindSymbolHelper(ident string, scope *Scope, store *Store, visited *map[uti
 		return Symbol{}, fmt.Errorf("Invalid scope")
 	}
 
-	// 1) Check current scope's definitions for this symbol
+	// 1) Check current scope's definitions for this symbol, preferring the variant defined for the project's precision
+	var found *Symbol
 	for _, symbol := range scope.Symbols {
 
 		if symbol.Ident == ident {
-			return *symbol, nil
+			if variantsMatch(symbol.Variants, store.Precision) {
+				return *symbol, nil
+			}
+			if found == nil {
+				found = symbol
+			}
 		}
 	}
+	if found != nil {
+		return *found, nil
+	}
 
 	// 2) Check imported files for this symbol
 	// TODO: Instead of 2 loops, get import symbols in the first loop itself and iterate through that
@@ -966,42 +1053,102 @@ func FindSymbolHelper(ident string, scope *Scope, store *Store, visited *map[uti
 }
 
 func FindSymbolDefinition(ident string, scope *Scope, store *Store) (Symbol, error) {
-	identSplit := strings.Split(ident, ".")
+	return ResolveSymbol(ident, scope, store)
+}
+
+// Maximum number of aliases followed while resolving a symbol. Guards against alias cycles.
+const maxAliasDepth = 16
 
+// ResolveSymbol resolves a possibly qualified identifier like fi.lowpass from scope.
+// Prefixes are resolved through libraries, environments and aliases of those. If the final symbol is an alias of a library or environment,
+// or of a definition accessed through one like lp = fi.lowpass;, the aliased symbol is returned instead, following up to maxAliasDepth aliases.
+func ResolveSymbol(ident string, scope *Scope, store *Store) (Symbol, error) {
+	return resolveSymbol(ident, scope, store, 0)
+}
+
+func resolveSymbol(ident string, scope *Scope, store *Store, depth int) (Symbol, error) {
+	if depth > maxAliasDepth {
+		return Symbol{}, fmt.Errorf("Too many aliases when resolving %s", ident)
+	}
+
+	identSplit := strings.Split(ident, ".")
 	if len(identSplit) > 1 {
 		logging.Logger.Info("Resolving library symbol", "symbol", identSplit)
-		for i := range len(identSplit) - 1 {
-			libIdent := identSplit[i]
-
-			// Resolve as Environment
-			sym, err := FindEnvironmentIdent(libIdent, scope, store)
-			logging.Logger.Info("Resolved environment", "env", libIdent, "sym", sym.Ident, "loc", sym.Loc)
-			if err == nil {
-				scope = sym.Scope
-				continue
-			}
+		envScope, err := resolveEnvironmentScope(identSplit[:len(identSplit)-1], scope, store, depth)
+		if err != nil {
+			return Symbol{}, err
+		}
+		scope = envScope
+	}
+
+	sym, err := FindSymbol(identSplit[len(identSplit)-1], scope, store)
+	if err != nil {
+		return Symbol{}, err
+	}
+
+	// Follow aliases of libraries and environments, e.g. filters = fi;, and of their definitions, e.g. lp = fi.lowpass;,
+	// so that navigation lands on them. Other definitions that are an identifier, like process = voice;, are definitions of their own.
+	if sym.Alias != "" && sym.Expression != nil {
+		target, err := resolveSymbol(sym.Alias, sym.Expression.Parent, store, depth+1)
+		if err == nil && (target.Kind == Library || target.Kind == Environment || strings.Contains(sym.Alias, ".")) {
+			logging.Logger.Info("Following alias", "ident", sym.Ident, "alias", sym.Alias)
+			return target, nil
+		}
+	}
+	return sym, nil
+}
+
+// ResolveEnvironmentScope resolves a possibly qualified identifier like sf.fi to the scope of the library or environment it refers to
+func ResolveEnvironmentScope(ident string, scope *Scope, store *Store) (*Scope, error) {
+	return resolveEnvironmentScope(strings.Split(ident, "."), scope, store, 0)
+}
+
+func resolveEnvironmentScope(identSplit []string, scope *Scope, store *Store, depth int) (*Scope, error) {
+	if depth > maxAliasDepth {
+		return nil, fmt.Errorf("Too many aliases when resolving %s", strings.Join(identSplit, "."))
+	}
+
+	for _, ident := range identSplit {
+		sym, err := FindSymbol(ident, scope, store)
+		if err != nil {
+			return nil, err
+		}
+		logging.Logger.Info("Resolved environment", "env", ident, "kind", sym.Kind.String(), "loc", sym.Loc)
 
-			// Resolve as Library if not resolved as environment
-			file, err := FindLibraryIdent(libIdent, scope, store)
+		switch {
+		case sym.Kind == Library:
+			f, ok := store.Files.GetFromPath(sym.File)
+			if !ok {
+				store.Files.OpenFromPath(sym.File)
+				f, ok = store.Files.GetFromPath(sym.File)
+				if !ok {
+					return nil, fmt.Errorf("Couldn't open library %s", sym.File)
+				}
+			}
+			f.mu.RLock()
+			scope = f.Scope
+			f.mu.RUnlock()
+		case sym.Kind == Environment:
+			scope = sym.Scope
+		case sym.Alias != "" && sym.Expression != nil:
+			// Alias of another library or environment, e.g. filters = fi;
+			scope, err = resolveEnvironmentScope(strings.Split(sym.Alias, "."), sym.Expression.Parent, store, depth+1)
 			if err != nil {
-				break
+				return nil, err
 			}
-			logging.Logger.Info("Resolved library environment", "env", libIdent, "location", file)
-			f, ok := store.Files.GetFromPath(file)
-			if ok {
-				f.mu.RLock()
-				logging.Logger.Info("Setting New Scope to", "path", file)
-				scope = f.Scope
-				f.mu.RUnlock()
-				if scope == nil {
-					break
-				}
+		default:
+			env, err := FindFirstEnvironment(&sym)
+			if err != nil {
+				return nil, err
 			}
+			scope = env.Scope
 		}
-	}
-	ident = identSplit[len(identSplit)-1]
 
-	return FindSymbol(ident, scope, store)
+		if scope == nil {
+			return nil, fmt.Errorf("%s has no parsed scope", ident)
+		}
+	}
+	return scope, nil
 }
 
 func FindDefinition(ident string, scope *Scope, store *Store) (Location, error) {
@@ -1131,6 +1278,11 @@ func FindLibraryHelper(ident string, scope *Scope, store *Store, visited *map[ut
 type CompletionSym struct {
 	name string
 	docs Documentation
+	// File the symbol is defined in, and where in it
+	file     util.Path
+	position transport.Position
+	// Whether the symbol is defined in a with or letrec block, or is an argument, in scope at the completed position
+	local bool
 }
 
 func GetPossibleSymbols(pos transport.Position, filePath util.Path, store *Store, encoding string) []CompletionSym {
@@ -1141,7 +1293,7 @@ func GetPossibleSymbols(pos transport.Position, filePath util.Path, store *Store
 	}
 
 	// 1) Get scope at position
-	offset, err := PositionToOffset(pos, string(f.Content), encoding)
+	offset, err := f.PositionToOffset(pos, encoding)
 	if err != nil {
 		logging.Logger.Info("Couldn't convert position to offset", "pos", pos, "err", err)
 		return []CompletionSym{}
@@ -1158,15 +1310,7 @@ func GetPossibleSymbols(pos transport.Position, filePath util.Path, store *Store
 	if identifier == "" {
 		logging.Logger.Info("No identifier found at position, returning all symbols possible in current scope", "pos", pos, "offset", offset)
 
-		availableSymbols := []CompletionSym{}
-		for {
-			if scope == nil {
-				break
-			}
-			availableSymbols = append(availableSymbols, FindSymbolsNew(scope, "", store, make(map[util.Path]struct{}))...)
-			scope = scope.Parent
-		}
-		return availableSymbols
+		return scopeCompletionSymbols(scope, filePath, store)
 	}
 	if identifier[len(identifier)-1] == '.' {
 		// Remove trailing '.' if any
@@ -1174,13 +1318,13 @@ func GetPossibleSymbols(pos transport.Position, filePath util.Path, store *Store
 		// This is because completion is requested after '.'
 		//		logging.Logger.Info("Removing trailing '.' from identifier", "ident", identifier)
 		identifier = identifier[:len(identifier)-1]
-		sym, err := FindSymbolDefinition(identifier, scope, store)
+		envScope, err := ResolveEnvironmentScope(identifier, scope, store)
 		if err != nil {
 			//			logging.Logger.Info("Couldn't find symbol definition for identifier, checking with previous identifier", "ident", identifier, "err", err)
 			identifierSplit := strings.Split(identifier, ".")
 			if len(identifierSplit) > 2 {
 				identifier = strings.Join(identifierSplit[:len(identifierSplit)-1], ".")
-				sym, err = FindSymbolDefinition(identifier, scope, store)
+				envScope, err = ResolveEnvironmentScope(identifier, scope, store)
 				if err != nil {
 					//					logging.Logger.Info("Couldn't find symbol definition for identifier", "ident", identifier, "err", err)
 					return []CompletionSym{}
@@ -1189,39 +1333,28 @@ func GetPossibleSymbols(pos transport.Position, filePath util.Path, store *Store
 				return []CompletionSym{}
 			}
 		}
-		logging.Logger.Info("Found symbol definition for identifier", "ident", identifier, "loc", sym.Loc)
+		logging.Logger.Info("Found environment for identifier", "ident", identifier, "scope_range", envScope.Range)
 
-		if sym.Kind == Library {
-			logging.Logger.Info("Identifier is a library, getting symbols from file", "file", sym.File)
-			f, ok := store.Files.GetFromPath(sym.File)
-			if ok {
-				f.mu.RLock()
-				syms := FindSymbolsNew(f.Scope, "", store, make(map[util.Path]struct{}))
-				f.mu.RUnlock()
-				return syms
-			} else {
-				logging.Logger.Info("Couldn't find file for library", "file", sym.File)
-				return []CompletionSym{}
-			}
-		} else {
-			env, err := FindEnvironmentIdent(identifier, scope, store)
-			if err == nil {
-				return FindSymbolsNew(env.Scope, "", store, make(map[util.Path]struct{}))
-			}
-			return []CompletionSym{}
-		}
+		return FindSymbolsNew(envScope, "", store, make(map[util.Path]struct{}))
 	} else {
 		//		logging.Logger.Info("Identifier doesn't end with '.', returning all symbols in current scope", "ident", identifier)
-		availableSymbols := []CompletionSym{}
-		for {
-			if scope == nil {
-				break
+		return scopeCompletionSymbols(scope, filePath, store)
+	}
+}
+
+// Symbols of scope and of its parents, innermost first
+func scopeCompletionSymbols(scope *Scope, filePath util.Path, store *Store) []CompletionSym {
+	availableSymbols := []CompletionSym{}
+	for ; scope != nil; scope = scope.Parent {
+		symbols := FindSymbolsNew(scope, "", store, make(map[util.Path]struct{}))
+		if scope.Parent != nil {
+			for i := range symbols {
+				symbols[i].local = symbols[i].file == filePath
 			}
-			availableSymbols = append(availableSymbols, FindSymbolsNew(scope, "", store, make(map[util.Path]struct{}))...)
-			scope = scope.Parent
 		}
-		return availableSymbols
+		availableSymbols = append(availableSymbols, symbols...)
 	}
+	return availableSymbols
 }
 
 func JoinEnvIdent(parentSymbol, childSymbol string) string {
@@ -1242,7 +1375,7 @@ func AddEnvIdents(symbols []CompletionSym, parentSymbol string) []CompletionSym
 }
 
 func NewCompletionSym(sym *Symbol) CompletionSym {
-	return CompletionSym{name: sym.Ident, docs: sym.Docs}
+	return CompletionSym{name: sym.Ident, docs: sym.Docs, file: sym.Loc.File, position: sym.Loc.Range.Start}
 }
 
 func FindSymbolsNew(scope *Scope, parentSymbol string, store *Store, visited map[util.Path]struct{}) []CompletionSym {
@@ -1307,12 +1440,9 @@ func FindSymbolsInFile(sym *Symbol, parentSymbol string, store *Store, visited m
 	return symbols
 }
 
-func FindSymbolScope(content []byte, scope *Scope, offset uint) (string, *Scope) {
-	tree := parser.ParseTree(content)
-	fileAST := tree.RootNode()
-	defer tree.Close()
-	node := fileAST.DescendantForByteRange(offset, offset)
-	logging.Logger.Info("Got descendant node as", "type", node.GrammarName(), "content", node.Utf8Text(content), "location", ToRange(node))
+func FindSymbolScope(root *tree_sitter.Node, content []byte, scope *Scope, offset uint) (string, *Scope) {
+	node := root.DescendantForByteRange(offset, offset)
+	logging.Logger.Debug("Got descendant node as", "type", node.GrammarName(), "length", node.EndByte()-node.StartByte(), "location", ToRange(node))
 	switch node.GrammarName() {
 	case "identifier":
 		// If parent is access, keep finding scopes for each environment monoidically (e.g. lib.moo.foo.lay.f will be lib->moo->foo->lay->f)
@@ -7,6 +7,7 @@ import (
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
 	"github.com/carn181/faustlsp/util"
+	"github.com/fsnotify/fsnotify"
 )
 
 type TDChangeType int
@@ -29,24 +30,32 @@ func TextDocumentOpen(ctx context.Context, s *Server, par json.RawMessage) error
 	fileURI := params.TextDocument.URI
 
 	// Open File
-	s.Workspace.EditorOpenFile(util.URI(fileURI), &s.Files)
+	w := &s.Workspace
+	if path, err := util.URI2path(string(fileURI)); err == nil {
+		w = s.workspaceFor(path)
+	}
+	w.EditorOpenFile(util.URI(fileURI), &s.Files)
 
 	logging.Logger.Info("Opening File", "uri", string(fileURI))
 	f, ok := s.Files.GetFromURI(util.URI(fileURI))
 
 	if !ok {
-		s.Files.AddFromURI(util.URI(fileURI), []byte{})
+		// Documents that aren't on disk, like untitled ones, only have the content the editor sends
+		s.Files.AddFromURI(util.URI(fileURI), []byte(params.TextDocument.Text))
 		f, _ = s.Files.GetFromURI(util.URI(fileURI))
 	}
 
-	f.mu.RLock()
-	logging.Logger.Info("Current File", "content", f.Content)
+	s.Files.MarkOpen(f.Handle.Path, true)
+
+	f.mu.Lock()
+	f.Version = params.TextDocument.Version
+	logging.Logger.Info("Current File", "length", len(f.Content), "version", f.Version)
+	f.mu.Unlock()
 
-	s.Workspace.TDEvents <- TDEvent{Type: TDOpen, Path: f.Handle.Path}
-	f.mu.RUnlock()
+	w.TDEvents <- TDEvent{Type: TDOpen, Path: f.Handle.Path}
 
-	//	go s.Workspace.AnalyzeFile(f, &s.Store)
-	go s.Workspace.DiagnoseFile(f.Handle.Path, s)
+	//	go w.AnalyzeFile(f, &s.Store)
+	go w.DiagnoseFile(f.Handle.Path, s)
 
 	return nil
 }
@@ -61,10 +70,12 @@ func TextDocumentChangeFull(ctx context.Context, s *Server, par json.RawMessage)
 	if err != nil {
 		return err
 	}
-	for _, change := range params.ContentChanges {
-		s.Files.ModifyFull(path, change.Text)
+	// Results computed for the previous content are stale, and computing them holds the file
+	s.cancelDocumentRequests(path)
+	if !s.Files.ApplyChanges(path, params.TextDocument.Version, params.ContentChanges) {
+		return nil
 	}
-	s.Workspace.TDEvents <- TDEvent{Type: TDChange, Path: path}
+	s.workspaceFor(path).TDEvents <- TDEvent{Type: TDChange, Path: path}
 
 	logging.Logger.Info("Modified File", "fileURI", string(fileURI))
 	return nil
@@ -80,11 +91,12 @@ func TextDocumentChangeIncremental(ctx context.Context, s *Server, par json.RawM
 	if err != nil {
 		return err
 	}
-	for _, change := range params.ContentChanges {
-		s.Files.ModifyIncremental(path, *change.Range, change.Text)
+	s.cancelDocumentRequests(path)
+	if !s.Files.ApplyChanges(path, params.TextDocument.Version, params.ContentChanges) {
+		return nil
 	}
 
-	s.Workspace.TDEvents <- TDEvent{Type: TDChange, Path: path}
+	s.workspaceFor(path).TDEvents <- TDEvent{Type: TDChange, Path: path}
 
 	return nil
 }
@@ -99,9 +111,62 @@ func TextDocumentClose(ctx context.Context, s *Server, par json.RawMessage) erro
 
 	path, err := util.URI2path(string(fileURI))
 	logging.Logger.Error("Got error when getting path from URI", "error", err)
-	s.Workspace.TDEvents <- TDEvent{Type: TDClose, Path: path}
+	s.workspaceFor(path).TDEvents <- TDEvent{Type: TDClose, Path: path}
 
 	logging.Logger.Info("Closed File", "uri", string(fileURI))
 	//	logging.Logger.Printf("Current Files: %s\n", s.Files)
 	return nil
 }
+
+// Events of workspace/didChangeWatchedFiles as the file watcher would send them. Created files are read like written ones as the client sends nothing else for them.
+var watchedFileOps = map[transport.FileChangeType]fsnotify.Op{
+	transport.Created: fsnotify.Create | fsnotify.Write,
+	transport.Changed: fsnotify.Write,
+	transport.Deleted: fsnotify.Remove,
+}
+
+// Clients that can register for workspace/didChangeWatchedFiles watch the Faust files, config files and snippets of the workspace
+// instead of the workspace's file watcher, which then only watches imported files outside of it.
+// Their changes are handled in the workspace's tracking loop like those of the file watcher.
+func DidChangeWatchedFiles(ctx context.Context, s *Server, par json.RawMessage) error {
+	var params transport.DidChangeWatchedFilesParams
+	json.Unmarshal(par, &params)
+	if !s.watchedFilesRegistration {
+		return nil
+	}
+
+	for _, change := range params.Changes {
+		path, err := util.URI2path(string(change.URI))
+		if err != nil {
+			continue
+		}
+		op, ok := watchedFileOps[change.Type]
+		if !ok {
+			continue
+		}
+		logging.Logger.Info("Watched file changed", "path", path, "type", change.Type)
+		s.workspaceFor(path).clientEvents <- fsnotify.Event{Name: path, Op: op}
+	}
+	return nil
+}
+
+// Glob pattern watcher for client/registerCapability. transport.FileSystemWatcher's GlobPattern doesn't marshal to a plain string.
+type globWatcher struct {
+	GlobPattern string `json:"globPattern"`
+}
+
+// Files the client watches for the server when it can
+var watchedFileGlobs = []string{"**/*.dsp", "**/*.lib", "**/" + faustConfigFile, "**/" + snippetsFile}
+
+// Registration asking the client to send workspace/didChangeWatchedFiles for the files of the workspace
+func fileWatcherRegistration() transport.Registration {
+	watchers := []globWatcher{}
+	for _, glob := range watchedFileGlobs {
+		watchers = append(watchers, globWatcher{GlobPattern: glob})
+	}
+	return transport.Registration{
+		ID:              "faustlsp-file-watcher",
+		Method:          "workspace/didChangeWatchedFiles",
+		RegisterOptions: map[string][]globWatcher{"watchers": watchers},
+	}
+}
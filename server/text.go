@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Text is a piece table: content made of pieces of the bytes it started with and of a buffer text inserted later is appended to.
+// Replacing bytes only splits the pieces around them, so all the incremental changes of a notification are applied
+// without copying the content for each of them, which matters for long library files. Bytes lays the pieces out once they are applied.
+type Text struct {
+	original []byte
+	added    []byte
+	pieces   []piece
+	length   uint
+}
+
+// Bytes from start to end of the original content, or of the added buffer
+type piece struct {
+	added      bool
+	start, end uint
+}
+
+// NewText returns a text of content, which it doesn't modify
+func NewText(content []byte) *Text {
+	t := &Text{original: content, length: uint(len(content))}
+	if len(content) > 0 {
+		t.pieces = []piece{{start: 0, end: uint(len(content))}}
+	}
+	return t
+}
+
+// Len returns the length of the text in bytes
+func (t *Text) Len() uint {
+	return t.length
+}
+
+func (t *Text) bytes(p piece) []byte {
+	if p.added {
+		return t.added[p.start:p.end]
+	}
+	return t.original[p.start:p.end]
+}
+
+// Replace replaces the bytes of the text from start to end with text, both being clamped to its length
+func (t *Text) Replace(start uint, end uint, text string) {
+	end = min(end, t.length)
+	start = min(start, end)
+	pieces := make([]piece, 0, len(t.pieces)+2)
+	offset := uint(0)
+	inserted := false
+	insert := func() {
+		if !inserted && len(text) > 0 {
+			pieces = append(pieces, piece{added: true, start: uint(len(t.added)), end: uint(len(t.added) + len(text))})
+			t.added = append(t.added, text...)
+		}
+		inserted = true
+	}
+	for _, p := range t.pieces {
+		pieceStart, pieceEnd := offset, offset+p.end-p.start
+		offset = pieceEnd
+		switch {
+		case pieceEnd <= start:
+			pieces = append(pieces, p)
+		case pieceStart >= end:
+			insert()
+			pieces = append(pieces, p)
+		default:
+			// The piece overlaps the replaced bytes, only what is around them is kept
+			if pieceStart < start {
+				pieces = append(pieces, piece{added: p.added, start: p.start, end: p.start + start - pieceStart})
+			}
+			insert()
+			if pieceEnd > end {
+				pieces = append(pieces, piece{added: p.added, start: p.start + end - pieceStart, end: p.end})
+			}
+		}
+	}
+	insert()
+	t.pieces = pieces
+	t.length += uint(len(text)) - (end - start)
+}
+
+// Slice returns the bytes of the text from start to end, laying out only the pieces they are in
+func (t *Text) Slice(start uint, end uint) []byte {
+	end = min(end, t.length)
+	start = min(start, end)
+	slice := make([]byte, 0, end-start)
+	offset := uint(0)
+	for _, p := range t.pieces {
+		pieceStart, pieceEnd := offset, offset+p.end-p.start
+		offset = pieceEnd
+		if pieceEnd <= start {
+			continue
+		}
+		if pieceStart >= end {
+			break
+		}
+		b := t.bytes(p)
+		slice = append(slice, b[max(start, pieceStart)-pieceStart:min(end, pieceEnd)-pieceStart]...)
+	}
+	return slice
+}
+
+// Bytes lays out the whole text
+func (t *Text) Bytes() []byte {
+	if len(t.pieces) == 1 && !t.pieces[0].added && t.pieces[0].start == 0 && t.pieces[0].end == uint(len(t.original)) {
+		return t.original
+	}
+	return t.Slice(0, t.length)
+}
+
+// Calls fn with each byte of the text from offset on along with its offset, until it returns false
+func (t *Text) each(offset uint, fn func(offset uint, b byte) bool) {
+	pieceStart := uint(0)
+	for _, p := range t.pieces {
+		pieceEnd := pieceStart + p.end - p.start
+		if pieceEnd > offset {
+			b := t.bytes(p)
+			for i := max(offset, pieceStart); i < pieceEnd; i++ {
+				if !fn(i, b[i-pieceStart]) {
+					return
+				}
+			}
+		}
+		pieceStart = pieceEnd
+	}
+}
+
+// PositionToOffset converts pos, whose characters are code units of encoding, to a byte offset in the text, whose line index is lines.
+// Runes are counted from the bytes starting them, so that no piece is laid out.
+func (t *Text) PositionToOffset(lines LineIndex, pos transport.Position, encoding string) (uint, error) {
+	if t.length == 0 {
+		return 0, nil
+	}
+	if pos.Line > uint32(len(lines)) {
+		return 0, fmt.Errorf("invalid Line Number")
+	} else if pos.Line == uint32(len(lines)) {
+		return t.length, nil
+	}
+	result := t.length
+	units := uint32(0)
+	t.each(lines[pos.Line], func(offset uint, b byte) bool {
+		if !utf8.RuneStart(b) {
+			return true
+		}
+		if units >= pos.Character {
+			result = offset
+			return false
+		}
+		units++
+		if encoding == "utf-16" && b >= 0xF0 {
+			units++
+		}
+		return true
+	})
+	return result, nil
+}
+
+// OffsetToPosition converts a byte offset in the text, whose line index is lines, to a position with characters counted in code units of encoding
+func (t *Text) OffsetToPosition(lines LineIndex, offset uint, encoding string) (transport.Position, error) {
+	if t.length == 0 || offset == 0 {
+		return transport.Position{Line: 0, Character: 0}, nil
+	}
+	offset = min(offset, t.length)
+	line := lines.line(offset)
+	char := uint32(0)
+	t.each(lines[line], func(i uint, b byte) bool {
+		if i >= offset {
+			return false
+		}
+		if utf8.RuneStart(b) {
+			char++
+			if encoding == "utf-16" && b >= 0xF0 {
+				char++
+			}
+		}
+		return true
+	})
+	return transport.Position{Line: uint32(line), Character: char}, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/carn181/faustlsp/transport"
+)
+
+// UI primitive completed with tab stops for its label and parameters
+type uiPrimitiveSnippet struct {
+	name      string
+	signature string
+	snippet   string
+}
+
+var uiPrimitives = []uiPrimitiveSnippet{
+	{"hslider", "hslider(label, init, min, max, step)", `hslider("${1:label}", ${2:0.5}, ${3:0}, ${4:1}, ${5:0.01})`},
+	{"vslider", "vslider(label, init, min, max, step)", `vslider("${1:label}", ${2:0.5}, ${3:0}, ${4:1}, ${5:0.01})`},
+	{"nentry", "nentry(label, init, min, max, step)", `nentry("${1:label}", ${2:0}, ${3:0}, ${4:10}, ${5:1})`},
+	{"button", "button(label)", `button("${1:label}")`},
+	{"checkbox", "checkbox(label)", `checkbox("${1:label}")`},
+	{"hbargraph", "hbargraph(label, min, max)", `hbargraph("${1:label}", ${2:0}, ${3:1})`},
+	{"vbargraph", "vbargraph(label, min, max)", `vbargraph("${1:label}", ${2:0}, ${3:1})`},
+	{"hgroup", "hgroup(label, expression)", `hgroup("${1:label}", $0)`},
+	{"vgroup", "vgroup(label, expression)", `vgroup("${1:label}", $0)`},
+	{"tgroup", "tgroup(label, expression)", `tgroup("${1:label}", $0)`},
+}
+
+// UIPrimitiveCompletionItems completes UI primitives by replacing r. Clients supporting snippets get tab stops
+// for the label and each parameter, others only the primitive's name.
+func UIPrimitiveCompletionItems(r transport.Range, snippetSupport bool) []transport.CompletionItem {
+	items := []transport.CompletionItem{}
+	format := transport.PlainTextTextFormat
+	if snippetSupport {
+		format = transport.SnippetTextFormat
+	}
+	for _, primitive := range uiPrimitives {
+		text := primitive.name
+		if snippetSupport {
+			text = primitive.snippet
+		}
+		items = append(items, transport.CompletionItem{
+			Label:            primitive.name,
+			Kind:             transport.FunctionCompletion,
+			Detail:           primitive.signature,
+			InsertTextFormat: &format,
+			TextEdit: transport.TextEdit{
+				NewText: text,
+				Range:   r,
+			},
+		})
+	}
+	return items
+}
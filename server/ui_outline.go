@@ -0,0 +1,82 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Metadata in UI labels, like [style:knob] or [1]
+var labelMetadataRegex = regexp.MustCompile(`\[[^\]]*\]`)
+
+// Outline kinds of UI elements, so that editors show controls, displays and groups with different icons
+var uiSymbolKinds = map[string]transport.SymbolKind{
+	"numeric_widget": transport.Number,
+	"button":         transport.Boolean,
+	"checkbox":       transport.Boolean,
+	"bargraph":       transport.Event,
+	"group":          transport.Namespace,
+}
+
+// UISymbols returns an outline entry for each UI element under root, named by its label.
+// Elements inside a group are children of the group's entry.
+func UISymbols(root *tree_sitter.Node, content []byte) []transport.DocumentSymbol {
+	var walk func(node *tree_sitter.Node) []transport.DocumentSymbol
+	walk = func(node *tree_sitter.Node) []transport.DocumentSymbol {
+		symbols := []transport.DocumentSymbol{}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			child := node.NamedChild(i)
+			kind, ok := uiSymbolKinds[child.Kind()]
+			label := child.ChildByFieldName("label")
+			if !ok || label == nil {
+				symbols = append(symbols, walk(child)...)
+				continue
+			}
+			symbols = append(symbols, transport.DocumentSymbol{
+				Name:           uiLabelName(label.Utf8Text(content)),
+				Detail:         uiPrimitive(child),
+				Kind:           kind,
+				Range:          ToRange(child),
+				SelectionRange: ToRange(label),
+				Children:       walk(child),
+			})
+		}
+		return symbols
+	}
+	return walk(root)
+}
+
+// Name of the primitive creating a UI element, like hslider or vgroup
+func uiPrimitive(node *tree_sitter.Node) string {
+	if t := node.ChildByFieldName("type"); t != nil {
+		return strings.TrimSuffix(t.Kind(), "_type")
+	}
+	return node.Kind()
+}
+
+// Label of a UI element without its quotes and metadata
+func uiLabelName(label string) string {
+	if len(label) >= 2 {
+		label = stripQuotes(label)
+	}
+	if name := strings.TrimSpace(labelMetadataRegex.ReplaceAllString(label, "")); name != "" {
+		return name
+	}
+	return label
+}
+
+// AddUISymbols adds each UI hierarchy of ui under the innermost symbol containing it
+func AddUISymbols(symbols []transport.DocumentSymbol, ui []transport.DocumentSymbol) []transport.DocumentSymbol {
+	for _, sym := range ui {
+		symbols = insertSymbol(symbols, sym)
+	}
+	return symbols
+}
+
+func (f *File) UISymbols() []transport.DocumentSymbol {
+	t, content := f.Tree()
+	defer t.Close()
+	return UISymbols(t.RootNode(), content)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Code of diagnostics for imported files that can't be found
+const unresolvedImportCode = "unresolved-import"
+
+// UnresolvedImportDiagnostics reports the files imported in content with import, library or component that can't be found, suggesting the closest of candidates.
+// resolve returns the path an imported file is found at, or "" if it can't be found.
+func UnresolvedImportDiagnostics(root *tree_sitter.Node, content []byte, resolve func(string) util.Path, candidates []string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	for _, imp := range ImportedFiles(root, content) {
+		if imp.Path == "" || resolve(imp.Path) != "" {
+			continue
+		}
+		message := fmt.Sprintf("Couldn't find %s in the workspace, include directories or Faust libraries", imp.Path)
+		if suggestion, ok := ClosestImportPath(imp.Path, candidates); ok {
+			message += fmt.Sprintf(". Did you mean %s?", suggestion)
+		}
+		diagnostics = append(diagnostics, transport.Diagnostic{
+			Range:    imp.Range,
+			Severity: transport.DiagnosticSeverity(transport.Error),
+			Code:     unresolvedImportCode,
+			Source:   "faustlsp",
+			Message:  message,
+		})
+	}
+	return diagnostics
+}
+
+// UnresolvedImportActions returns quick fixes replacing the imported paths of unresolved import diagnostics in r with the closest of candidates
+func UnresolvedImportActions(root *tree_sitter.Node, content []byte, uri transport.DocumentURI, r transport.Range, diagnostics []transport.Diagnostic, candidates []string) []transport.CodeAction {
+	actions := []transport.CodeAction{}
+	for _, imp := range ImportedFiles(root, content) {
+		if !rangesOverlap(imp.Range, r) {
+			continue
+		}
+		fixed := []transport.Diagnostic{}
+		for _, d := range diagnostics {
+			if d.Code == unresolvedImportCode && d.Range == imp.Range {
+				fixed = append(fixed, d)
+			}
+		}
+		if len(fixed) == 0 {
+			continue
+		}
+		suggestion, ok := ClosestImportPath(imp.Path, candidates)
+		if !ok {
+			continue
+		}
+		actions = append(actions, transport.CodeAction{
+			Title:       fmt.Sprintf("Import \"%s\" instead", suggestion),
+			Kind:        transport.QuickFix,
+			Diagnostics: fixed,
+			IsPreferred: true,
+			Edit: &transport.WorkspaceEdit{
+				Changes: map[transport.DocumentURI][]transport.TextEdit{
+					uri: {{Range: imp.Range, NewText: fmt.Sprintf("\"%s\"", suggestion)}},
+				},
+			},
+		})
+	}
+	return actions
+}
+
+// ClosestImportPath returns the candidate with the fewest edits from path, if it is close enough to be a typo of it.
+// Candidates with as few edits come in their order.
+func ClosestImportPath(path string, candidates []string) (string, bool) {
+	best, bestDistance := "", len(path)/3+1
+	for _, candidate := range candidates {
+		if distance := editDistance(path, candidate); distance < bestDistance && distance > 0 {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best, best != ""
+}
+
+// Number of inserted, deleted and substituted bytes turning a into b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// Paths files of the workspace at path can import: Faust files relative to the workspace root or to the directories of the search path
+func (w *Workspace) importCandidates(path util.Path) []string {
+	candidates := []string{}
+	add := func(candidate string) {
+		if !slices.Contains(candidates, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if w.Root != "" {
+		w.mu.Lock()
+		files := slices.Clone(w.Files)
+		w.mu.Unlock()
+		for _, file := range files {
+			if rel, err := filepath.Rel(w.Root, file); err == nil && IsFaustFile(file) {
+				add(filepath.ToSlash(rel))
+			}
+		}
+	}
+	for _, dir := range w.ImportSearchPath(w.ImportRoot(path)) {
+		if dir == w.Root {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && IsFaustFile(entry.Name()) {
+				add(entry.Name())
+			}
+		}
+	}
+	return candidates
+}
+
+// Diagnostics of the files path imports that can't be found
+func (w *Workspace) unresolvedImportDiagnostics(path util.Path, root *tree_sitter.Node, content []byte) []transport.Diagnostic {
+	// Without the Faust libraries, imports of standard libraries can't be told apart from missing files
+	if w.libraryDir() == "" {
+		return []transport.Diagnostic{}
+	}
+	resolve := func(importPath string) util.Path {
+		resolvedPath, _ := w.ResolveFilePath(importPath, w.ImportRoot(path))
+		return resolvedPath
+	}
+	// Files to suggest are only listed once an import is missing
+	if diagnostics := UnresolvedImportDiagnostics(root, content, resolve, nil); len(diagnostics) == 0 {
+		return diagnostics
+	}
+	return UnresolvedImportDiagnostics(root, content, resolve, w.importCandidates(path))
+}
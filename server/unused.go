@@ -0,0 +1,239 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Diagnostic codes of unused definitions and with block definitions shadowing outer ones
+const (
+	unusedDefinitionCode = "unused-definition"
+	shadowedNameCode     = "shadowed-name"
+)
+
+// Names used by a file, cached by the file's hash
+type usedNamesEntry struct {
+	hash  [sha256.Size]byte
+	names map[string]struct{}
+}
+
+// UsedNames returns the names content refers to, without the names it declares.
+// Each part of a qualified name like fi.lowpass counts as used.
+func UsedNames(root *tree_sitter.Node, content []byte) map[string]struct{} {
+	names := make(map[string]struct{})
+	var walk func(node *tree_sitter.Node)
+	walk = func(node *tree_sitter.Node) {
+		if node.Kind() == "identifier" && !isDeclaration(node) {
+			names[node.Utf8Text(content)] = struct{}{}
+		}
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i))
+		}
+	}
+	walk(root)
+	return names
+}
+
+// UnusedDefinitionDiagnostics reports top-level definitions of content whose names aren't in used, except for the process
+func UnusedDefinitionDiagnostics(root *tree_sitter.Node, content []byte, used map[string]struct{}, processName string) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		name := definitionIdentifier(root.NamedChild(i))
+		if name == nil {
+			continue
+		}
+		ident := name.Utf8Text(content)
+		if _, ok := used[ident]; ok || ident == processName {
+			continue
+		}
+		diagnostics = append(diagnostics, transport.Diagnostic{
+			Range:    ToRange(name),
+			Severity: transport.SeverityHint,
+			Code:     unusedDefinitionCode,
+			Source:   "faustlsp",
+			Message:  fmt.Sprintf("%s is never used in the workspace", ident),
+			Tags:     []transport.DiagnosticTag{transport.Unnecessary},
+		})
+	}
+	return diagnostics
+}
+
+// ShadowDiagnostics warns about with and letrec block definitions named like a definition, argument or iteration variable they are nested in
+func ShadowDiagnostics(root *tree_sitter.Node, content []byte) []transport.Diagnostic {
+	diagnostics := []transport.Diagnostic{}
+	var walk func(node *tree_sitter.Node, outer map[string]transport.Range)
+	walk = func(node *tree_sitter.Node, outer map[string]transport.Range) {
+		// Names bound by node for its children, copied from outer on the first binding
+		bound := outer
+		copied := false
+		bind := func(names []*tree_sitter.Node) {
+			if len(names) == 0 {
+				return
+			}
+			if !copied {
+				bound = copyNames(outer)
+				copied = true
+			}
+			for _, n := range names {
+				bound[n.Utf8Text(content)] = ToRange(n)
+			}
+		}
+
+		switch node.Kind() {
+		case "program":
+			names := []*tree_sitter.Node{}
+			for i := uint(0); i < node.NamedChildCount(); i++ {
+				if name := definitionIdentifier(node.NamedChild(i)); name != nil {
+					names = append(names, name)
+				}
+			}
+			bind(names)
+		case "function_definition", "rule":
+			for i := uint(0); i < node.NamedChildCount(); i++ {
+				if child := node.NamedChild(i); child.Kind() == "arguments" {
+					bind(identifierChildren(child))
+				}
+			}
+		case "iteration":
+			if current := node.ChildByFieldName("current_iter"); current != nil {
+				bind([]*tree_sitter.Node{current})
+			}
+		case "with_environment", "letrec_environment":
+			env := node.ChildByFieldName("local_environment")
+			if env == nil {
+				break
+			}
+			names := []*tree_sitter.Node{}
+			for i := uint(0); i < env.NamedChildCount(); i++ {
+				statement := env.NamedChild(i)
+				name := definitionIdentifier(statement)
+				if name == nil && statement.Kind() == "recinition" {
+					name = statement.ChildByFieldName("name")
+				}
+				if name == nil {
+					continue
+				}
+				ident := name.Utf8Text(content)
+				if shadowed, ok := outer[ident]; ok {
+					diagnostics = append(diagnostics, transport.Diagnostic{
+						Range:    ToRange(name),
+						Severity: transport.DiagnosticSeverity(transport.Warning),
+						Code:     shadowedNameCode,
+						Source:   "faustlsp",
+						Message:  fmt.Sprintf("%s shadows %s defined at line %d", ident, ident, shadowed.Start.Line+1),
+					})
+				}
+				names = append(names, name)
+			}
+			bind(names)
+		}
+
+		for i := uint(0); i < node.NamedChildCount(); i++ {
+			walk(node.NamedChild(i), bound)
+		}
+	}
+	walk(root, map[string]transport.Range{})
+	return diagnostics
+}
+
+// Name of a definition or function definition node, or nil for other nodes
+func definitionIdentifier(node *tree_sitter.Node) *tree_sitter.Node {
+	switch node.Kind() {
+	case "definition":
+		return node.ChildByFieldName("variable")
+	case "function_definition":
+		return node.ChildByFieldName("name")
+	}
+	return nil
+}
+
+func identifierChildren(node *tree_sitter.Node) []*tree_sitter.Node {
+	identifiers := []*tree_sitter.Node{}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if child := node.NamedChild(i); child.Kind() == "identifier" {
+			identifiers = append(identifiers, child)
+		}
+	}
+	return identifiers
+}
+
+func copyNames(names map[string]transport.Range) map[string]transport.Range {
+	copied := make(map[string]transport.Range, len(names))
+	for name, r := range names {
+		copied[name] = r
+	}
+	return copied
+}
+
+// Names used anywhere in the workspace. Files are only parsed again when their content changed.
+func (w *Workspace) usedNames(s *Server) map[string]struct{} {
+	w.mu.Lock()
+	paths := slices.Clone(w.Files)
+	w.mu.Unlock()
+
+	used := make(map[string]struct{})
+	for _, path := range paths {
+		if !IsFaustFile(path) {
+			continue
+		}
+		names, _ := w.fileUsedNames(path, s)
+		for name := range names {
+			used[name] = struct{}{}
+		}
+	}
+	return used
+}
+
+// Names used by the file at path, parsed again if its content changed since they were cached.
+// changed is whether they differ from the cached ones, and is false if there were none.
+func (w *Workspace) fileUsedNames(path util.Path, s *Server) (names map[string]struct{}, changed bool) {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return nil, false
+	}
+	f.mu.RLock()
+	hash := f.Hash
+	f.mu.RUnlock()
+
+	w.mu.Lock()
+	entry, cached := w.usedNamesCache[path]
+	w.mu.Unlock()
+	if cached && entry.hash == hash {
+		return entry.names, false
+	}
+	tree, content := f.Tree()
+	names = UsedNames(tree.RootNode(), content)
+	tree.Close()
+	w.mu.Lock()
+	w.usedNamesCache[path] = usedNamesEntry{hash: hash, names: names}
+	w.mu.Unlock()
+	return names, cached && !maps.Equal(entry.names, names)
+}
+
+// Publishes the diagnostics of the files importing the one at path, and of those it imports, again once the names it uses changed,
+// as definitions of theirs it used can be unused now, or the other way around
+func (w *Workspace) rediagnoseUnusedDefinitions(path util.Path, s *Server) {
+	if _, changed := w.fileUsedNames(path, s); !changed {
+		return
+	}
+	related := s.Store.Dependencies.GetImporters(path)
+	related = append(related, s.Store.Dependencies.GetImports(path)...)
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+	for _, other := range related {
+		// Definitions of libraries are meant to be used by other projects, so only .dsp files of the workspace have unused definitions
+		if other == path || !IsDSPFile(other) || !slices.Contains(files, other) || !w.ConfigFor(other).UnusedDiagnostics {
+			continue
+		}
+		logging.Logger.Info("Diagnosing file again as names used by a related file changed", "path", other, "changed", path)
+		w.publishFileDiagnostics(other, s)
+	}
+}
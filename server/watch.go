@@ -0,0 +1,129 @@
+package server
+
+import (
+	"os"
+	"slices"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Time between passes comparing the directories watched with those of the workspace, catching changes the watcher missed
+const watchReconcileInterval = 30 * time.Second
+
+// Watches dir and the directories in it that aren't ignored, as watcher does not recursively watch subdirectories.
+// Indexed files already in them, created before they were watched like when unpacking a tree, are opened and analyzed. Returns them.
+func (w *Workspace) watchTree(dir util.Path, s *Server, watcher *fsnotify.Watcher) []util.Path {
+	opened := []util.Path{}
+	w.walkFrom(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				s.reportProblem(transport.Warning, "Couldn't watch some directories of the workspace, changes made outside the editor in them won't be picked up", err, "path", path)
+			}
+			return nil
+		}
+		if path := w.openFromDisk(path, s); path != "" {
+			opened = append(opened, path)
+		}
+		return nil
+	})
+	return opened
+}
+
+// Opens the indexed file at path if the store doesn't have it yet, returning it, or an empty path if it wasn't opened
+func (w *Workspace) openFromDisk(path util.Path, s *Server) util.Path {
+	if !isIndexedFile(path) {
+		return ""
+	}
+	if _, ok := s.Files.GetFromPath(path); ok {
+		return ""
+	}
+	s.Files.OpenFromPath(path)
+	w.addFile(path)
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return ""
+	}
+	if IsFaustFile(path) {
+		w.AnalyzeFile(f, &s.Store)
+	}
+	return path
+}
+
+// Stops watching dir and the directories in it, and forgets the files it had, once it is removed or renamed away.
+// Removals of files only have them to forget.
+func (w *Workspace) unwatchTree(dir util.Path, s *Server, watcher *fsnotify.Watcher) {
+	for _, path := range watcher.WatchList() {
+		if isWithin(path, dir) {
+			// Watches of removed directories are already gone
+			watcher.Remove(path)
+		}
+	}
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+	for _, path := range files {
+		if path != dir && isWithin(path, dir) {
+			w.forgetFile(path, s)
+		}
+	}
+}
+
+// Removes the file at path from the store, the workspace and the overlay
+func (w *Workspace) forgetFile(path util.Path, s *Server) {
+	s.Files.RemoveFromPath(path)
+	w.removeFile(path)
+	os.Remove(w.TempDirPath(path))
+}
+
+// Compares the directories watched with those of the workspace. Directories that aren't watched are, along with the files in them,
+// and those gone are no longer watched, files gone from the disk being forgotten too. Returns the files opened.
+func (w *Workspace) reconcileWatches(s *Server, watcher *fsnotify.Watcher) []util.Path {
+	watched := make(map[util.Path]struct{})
+	for _, path := range watcher.WatchList() {
+		if isWithin(path, w.Root) {
+			watched[path] = struct{}{}
+		}
+	}
+
+	opened := []util.Path{}
+	w.walk(func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if _, ok := watched[path]; ok {
+				delete(watched, path)
+			} else {
+				logging.Logger.Info("Watching directory missed by events", "path", path)
+				watcher.Add(path)
+			}
+			return nil
+		}
+		if path := w.openFromDisk(path, s); path != "" {
+			opened = append(opened, path)
+		}
+		return nil
+	})
+	for path := range watched {
+		logging.Logger.Info("No longer watching directory gone from the workspace", "path", path)
+		watcher.Remove(path)
+	}
+
+	w.mu.Lock()
+	files := slices.Clone(w.Files)
+	w.mu.Unlock()
+	for _, path := range files {
+		if !w.isOpen(path) && !util.IsValidPath(path) {
+			w.forgetFile(path, s)
+		}
+	}
+	return opened
+}
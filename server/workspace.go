@@ -2,19 +2,22 @@ package server
 
 import (
 	"context"
-	"io/fs"
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/transport"
 	"github.com/carn181/faustlsp/util"
 
 	"github.com/fsnotify/fsnotify"
-	cp "github.com/otiai10/copy"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
 const faustConfigFile = ".faustcfg.json"
@@ -40,19 +43,65 @@ type Workspace struct {
 	TDEvents chan TDEvent
 	Config   FaustProjectConfig
 
-	// Temporary directory where this workspace is replicated
+	// Temporary directory the overlay of unsaved buffers is in
 	tempDir     util.Path
 	openedFiles map[util.Handle]struct{}
+	// Documents whose unsaved buffers are in the overlay
+	dirty map[util.Path]struct{}
+
+	// Imported files outside the workspace, watched for changes but never modified
+	externalFiles map[util.Path]struct{}
+	watcher       *fsnotify.Watcher
+	// Changes of workspace files the client watches, sent with workspace/didChangeWatchedFiles instead of coming from watcher
+	clientEvents chan fsnotify.Event
+	// Goroutines started by Init, which read the fields of the workspace, waited for before it is initialized again
+	running sync.WaitGroup
+
+	// Requests to re-diagnose the whole workspace, handled in the background by scheduleDiagnostics
+	rediagnose chan struct{}
+	// Process files that got compiler diagnostics in the last full pass
+	diagnosedProcessFiles []util.Path
+	// Whether Config was read from a config file rather than being the default
+	hasConfigFile bool
+	// Names used by each Faust file, for finding unused definitions
+	usedNamesCache map[util.Path]usedNamesEntry
+	// Last compiler diagnostic of each file and the files each file imports, so that unchanged files aren't compiled again
+	compilerCache map[util.Path]compilerCacheEntry
+	importsCache  map[util.Path]importsEntry
+	// Snippets of the workspace's snippets file, offered in completions
+	snippets []Snippet
+	// Faust libraries the workspace's imports are resolved against
+	libraries libraryIndex
+	// Files and directories left out of scanning and watching
+	ignore IgnoreRules
+	// Settings of the client for the workspace, applied to Config, and new ones for the tracking loop to apply
+	settings        ClientSettings
+	settingsChanges chan ClientSettings
+	// Configs of subdirectories with a config file of their own, outer directories first
+	nestedConfigs []nestedConfig
+	// Profile selected with faustlsp.selectProfile, which overrides the one of the settings, and the profile applied to the configs
+	selectedProfile string
+	profile         string
 }
 
 func IsFaustFile(path util.Path) bool {
 	ext := filepath.Ext(path)
-	return ext == ".dsp" || ext == ".lib"
+	return ext == ".dsp" || ext == ".lib" || isUntitledDSP(path)
+}
+
+// Files read into the store when scanning the workspace: Faust files and config files
+func isIndexedFile(path util.Path) bool {
+	return IsFaustFile(path) || filepath.Base(path) == faustConfigFile
 }
 
 func IsDSPFile(path util.Path) bool {
 	ext := filepath.Ext(path)
-	return ext == ".dsp"
+	return ext == ".dsp" || isUntitledDSP(path)
+}
+
+// Documents that aren't files and have no extension, like untitled:Untitled-1, are edited as .dsp files
+func isUntitledDSP(path util.Path) bool {
+	return util.IsVirtualPath(path) && filepath.Ext(path) == ""
 }
 
 func IsLibFile(path util.Path) bool {
@@ -62,96 +111,193 @@ func IsLibFile(path util.Path) bool {
 
 func (workspace *Workspace) TempDirPath(filePath util.Path) util.Path {
 	result := filepath.Join(workspace.tempDir, filePath)
+	// The compiler is given a file synthesized for documents without one, named like a .dsp file
+	if isUntitledDSP(filePath) {
+		result += ".dsp"
+	}
 	return result
 }
 
 func (workspace *Workspace) Init(ctx context.Context, s *Server) {
+	// Goroutines of a previous initialization, whose context is done, read the fields replaced below
+	workspace.running.Wait()
+
 	// Open all files in workspace and add to File Store
 	workspace.Files = []util.Path{}
 	workspace.TDEvents = make(chan TDEvent)
+	workspace.clientEvents = make(chan fsnotify.Event)
+	workspace.mu.Lock()
 	workspace.openedFiles = make(map[util.Handle]struct{})
+	workspace.mu.Unlock()
+	workspace.dirty = make(map[util.Path]struct{})
+	workspace.externalFiles = make(map[util.Path]struct{})
 	workspace.tempDir = s.tempDir
+	workspace.rediagnose = make(chan struct{}, 1)
+	workspace.settingsChanges = make(chan ClientSettings, 1)
+	workspace.usedNamesCache = make(map[util.Path]usedNamesEntry)
+	workspace.compilerCache = make(map[util.Path]compilerCacheEntry)
+	workspace.importsCache = make(map[util.Path]importsEntry)
+	workspace.diagnosedProcessFiles = nil
+	workspace.goRunning(func() { workspace.scheduleDiagnostics(ctx, s) })
+
+	// Without a root, documents are handled one by one as the editor opens them
+	if workspace.Root == "" {
+		logging.Logger.Info("No workspace root, handling opened documents as single files")
+		workspace.loadConfigFiles(s)
+		workspace.goRunning(func() { workspace.indexLibraries(s) })
+		workspace.goRunning(func() { workspace.StartTrackingChanges(ctx, s) })
+		return
+	}
 
-	// Replicate Workspace in our Temp Dir by copying
 	logging.Logger.Info("Current workspace root", "path", workspace.Root)
 
-	tempWorkspacePath := filepath.Join(s.tempDir, workspace.Root)
-	err := cp.Copy(workspace.Root, tempWorkspacePath)
-	if err != nil {
-		logging.Logger.Error("Copying file error", "error", err)
-	}
-	logging.Logger.Info("Replicating Workspace in ", "path", tempWorkspacePath)
-
-	// Parse Config File
+	// Parse Config File first, as its exclude patterns apply to scanning
 	workspace.loadConfigFiles(s)
 
-	// Open the files in file store
-	err = filepath.Walk(workspace.Root, func(path string, info os.FileInfo, err error) error {
+	// Find the files of the workspace and read the new ones into the file store with a pool of workers,
+	// then analyze and diagnose the Faust ones in the background
+	faustFiles := []util.Path{}
+	newFiles := []util.Path{}
+	workspace.walk(func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
-			f, ok := s.Files.GetFromPath(path)
-
-			if !ok {
-				// Path relative to workspace
-				logging.Logger.Info("Opening file from workspace\n", "path", path)
-
-				s.Files.OpenFromPath(path)
-
+		// Other files are only read once something needs them
+		if !info.IsDir() && isIndexedFile(path) {
+			if _, ok := s.Files.GetFromPath(path); !ok {
 				workspace.addFile(path)
-
-				f, ok = s.Files.GetFromPath(path)
-				if ok {
-					workspace.DiagnoseFile(path, s)
-				}
+				newFiles = append(newFiles, path)
 			}
-			// Test if goroutine speeds this up
-			if ok {
-				if IsFaustFile(f.Handle.Path) {
-					go workspace.AnalyzeFile(f, &s.Store)
-				}
+			if IsFaustFile(path) {
+				faustFiles = append(faustFiles, path)
 			}
 		}
 		return nil
 	})
+	IndexFiles(newFiles, indexWorkers, func(path util.Path) {
+		logging.Logger.Debug("Opening file from workspace", "path", path)
+		s.Files.OpenFromPath(path)
+	}, func(util.Path, int) {})
+	diagnose := make(map[util.Path]struct{})
+	for _, path := range newFiles {
+		diagnose[path] = struct{}{}
+	}
+
+	logging.Logger.Info("Workspace Files", "files", len(workspace.Files))
+
+	// Configs of subdirectories are found along with the other files, and change how those beneath them are diagnosed
+	workspace.loadNestedConfigs(s)
+
+	workspace.goRunning(func() {
+		workspace.indexFiles(ctx, s, "Indexing workspace", faustFiles, diagnose)
+		workspace.indexLibraries(s)
+	})
+	workspace.goRunning(func() { workspace.StartTrackingChanges(ctx, s) })
+	logging.Logger.Info("Started workspace watcher", "root", workspace.Root)
+}
+
+// Runs fn in a goroutine the next initialization waits for
+func (workspace *Workspace) goRunning(fn func()) {
+	workspace.running.Add(1)
+	go func() {
+		defer workspace.running.Done()
+		fn()
+	}()
+}
+
+// Reset clears the state left by a previous client, keeping the files and parsed scopes of the workspace
+func (workspace *Workspace) Reset(s *Server) {
+	opened := workspace.takeOpened()
 
-	logging.Logger.Info("Workspace Files", "files", workspace.Files)
-	logging.Logger.Info("File Store", "files", &s.Files)
+	// Discard unsaved changes of documents the previous client had open
+	for handle := range opened {
+		path := handle.Path
+		content, err := os.ReadFile(path)
+		if err != nil {
+			s.Files.RemoveFromPath(path)
+			continue
+		}
+		if _, ok := s.Files.GetFromPath(path); !ok {
+			s.Files.OpenFromPath(path)
+		}
+		s.Files.ModifyFull(path, string(content))
+		workspace.removeOverlay(path)
+		if f, ok := s.Files.GetFromPath(path); ok && IsFaustFile(path) {
+			go workspace.AnalyzeFile(f, &s.Store)
+		}
+	}
 
-	go func() { workspace.StartTrackingChanges(ctx, s) }()
-	logging.Logger.Info("Started workspace watcher\n")
+	workspace.loadConfigFiles(s)
+	workspace.cleanDiagnostics(s)
 }
 
 func (workspace *Workspace) loadConfigFiles(s *Server) {
 	configFilePath := filepath.Join(workspace.Root, faustConfigFile)
 	f, ok := s.Files.GetFromPath(configFilePath)
 	var cfg FaustProjectConfig
+	var content, project []byte
 	var err error
-	if ok {
-		f.mu.RLock()
-		cfg, err = workspace.parseConfig(f.Content)
-		f.mu.RUnlock()
-		if err != nil {
-			cfg = workspace.defaultConfig()
-		}
+	if workspace.Root == "" {
+		// Single files have no project, and the config file would be looked up in the working directory
+		cfg = workspace.defaultConfig()
+		ok = false
 	} else {
-		// Try opening file if not opened but it exists
-		s.Files.OpenFromPath(configFilePath)
-		f, ok := s.Files.GetFromPath(configFilePath)
+		if !ok {
+			// Try opening file if not opened but it exists
+			s.Files.OpenFromPath(configFilePath)
+			f, ok = s.Files.GetFromPath(configFilePath)
+		}
 		if ok {
 			f.mu.RLock()
-			cfg, err = workspace.parseConfig(f.Content)
+			content = f.Content
 			f.mu.RUnlock()
+			cfg, err = workspace.parseConfig(content)
 			if err != nil {
+				s.reportProblem(transport.Error, fmt.Sprintf("Invalid %s, using the default configuration", faustConfigFile), err, "root", workspace.Root)
 				cfg = workspace.defaultConfig()
+			} else {
+				project = content
 			}
 		} else {
 			cfg = workspace.defaultConfig()
 		}
 	}
+	// Settings of the client apply on top of the defaults, unless the config file sets them
+	workspace.mu.Lock()
+	settings := s.initializationSettings.merge(workspace.settings)
+	workspace.mu.Unlock()
+	cfg = settings.applyTo(cfg, project)
+	// The active profile applies to the nested configs too, which can have it when the root config doesn't
+	profile := workspace.activeProfile(settings)
+	cfg, _ = cfg.withProfile(profile)
+	// Handlers and the tracking goroutine read the config, which is swapped in at once
+	workspace.mu.Lock()
+	workspace.profile = profile
 	workspace.Config = cfg
+	workspace.mu.Unlock()
+	workspace.resetLibraryDir()
+	if cfg.InferInclude {
+		// Resolving imports uses the include directories of the config
+		cfg.IncludeDir = append(slices.Clone(cfg.IncludeDir), workspace.inferredIncludeDirs(s)...)
+		workspace.mu.Lock()
+		workspace.Config = cfg
+		workspace.mu.Unlock()
+	}
+	// Problems of the config file are shown on it, rather than only falling back to the defaults
+	workspace.publishConfigDiagnostics(s, content, ok)
+	workspace.hasConfigFile = ok
+	// The store is shared by all workspace folders, and its precision is the first one's
+	if workspace == &s.Workspace {
+		s.Store.Precision = cfg.EffectivePrecision()
+		s.checkCompiler(cfg.Command)
+	}
 	logging.Logger.Info("Workspace Config", "config", cfg)
+	workspace.loadIgnoreRules()
+	workspace.loadSnippets(s)
+	workspace.loadNestedConfigs(s)
+	if profile != "" && !workspace.hasProfile(profile) {
+		logging.Logger.Error("Unknown profile, using the config without it", "profile", profile, "root", workspace.Root)
+	}
 }
 
 // Track and Replicate Changes to workspace
@@ -159,45 +305,75 @@ func (workspace *Workspace) loadConfigFiles(s *Server) {
 // TODO: Avoid repetition of getting relative paths
 func (workspace *Workspace) StartTrackingChanges(ctx context.Context, s *Server) {
 	// 1) Open All Files in Path with absolute Path recursively, store in s.Files, give pointers to Workspace.Files
-	// 2) Copy Directory to TempDir Workspace
-	// 3) Start Watching Changes like util
-	//    3*) If File open, get changes from filebuffer
-	//    3**) Replicate in disk + replicate in memory all these changes in both Files and Workspace.files
+	// 2) Start Watching Changes like util
+	//    2*) If File open, get changes from filebuffer, writing unsaved ones to the overlay
+	//    2**) Replicate in memory all these changes in both Files and Workspace.files
 
 	// Ideal Pipeline
-	// File Paths -> Content{Get from disk, Get from text document changes} -> Replicate in Disk TempDir -> ParseSymbols/Get Diagnostics from TempDir and Memory
+	// File Paths -> Content{Get from disk, Get from text document changes} -> Unsaved buffers in the overlay -> ParseSymbols/Get Diagnostics from the workspace, the overlay and Memory
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		logging.Logger.Error("Error in starting watcher", "error", err)
+		s.reportProblem(transport.Error, "Couldn't start watching the workspace, changes made outside the editor won't be picked up", err, "root", workspace.Root)
 	}
 
-	// Recursively add directories to watchlist
-	watcher.Add(workspace.Root)
-	err = filepath.Walk(workspace.Root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			watcher.Add(path)
-			logging.Logger.Info("Adding directory to watcher\n", path, workspace.Root)
-		}
-		return nil
-	})
+	// Events of this tracking, as initializing the workspace again replaces the channels
+	events := workspace.TDEvents
+	clientEvents := workspace.clientEvents
+	settingsChanges := workspace.settingsChanges
+
+	// Watch external files imported so far
+	workspace.mu.Lock()
+	workspace.watcher = watcher
+	for path := range workspace.externalFiles {
+		workspace.addExternalWatch(path)
+	}
+	workspace.mu.Unlock()
+
+	// Recursively add directories to watchlist, unless the client watches the workspace's files for us
+	var reconcile <-chan time.Time
+	if workspace.Root != "" && !s.watchedFilesRegistration {
+		err = workspace.walk(func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				watcher.Add(path)
+				logging.Logger.Debug("Adding directory to watcher", "path", path, "root", workspace.Root)
+			}
+			return nil
+		})
+		ticker := time.NewTicker(watchReconcileInterval)
+		defer ticker.Stop()
+		reconcile = ticker.C
+	}
 
+	// Disk events gathered since the first one that isn't handled yet, handled together once diskEventWindow expires
+	var pending []fsnotify.Event
+	var flush <-chan time.Time
 	for {
 		select {
 		// Editor TextDocument Events
 		// Assumes Method Handler has handled this event and has this file in Files Store
-		case change := <-workspace.TDEvents:
+		case change := <-events:
 			logging.Logger.Info("Handling TD Event", "event", change)
 			workspace.HandleEditorEvent(change, s)
 		// Disk Events
 		case event, ok := <-watcher.Events:
-			logging.Logger.Info("Handling Workspace Disk Event", "event", event)
+			logging.Logger.Info("Got Workspace Disk Event", "event", event)
 			if !ok {
 				return
 			}
-			workspace.HandleDiskEvent(event, s, watcher)
+			pending = append(pending, event)
+		case event := <-clientEvents:
+			logging.Logger.Info("Got Client File Event", "event", event)
+			pending = append(pending, event)
+		case <-flush:
+			workspace.handleDiskEvents(CoalesceEvents(pending), s, watcher)
+			pending, flush = nil, nil
+		case <-reconcile:
+			workspace.diagnoseChanged(workspace.reconcileWatches(s, watcher), s)
+		case settings := <-settingsChanges:
+			workspace.applySettings(settings, s)
 		// Watcher Errors
 		case _, ok := <-watcher.Errors:
 			if !ok {
@@ -208,10 +384,18 @@ func (workspace *Workspace) StartTrackingChanges(ctx context.Context, s *Server)
 			watcher.Close()
 			return
 		}
+		if len(pending) > 0 && flush == nil {
+			flush = time.After(diskEventWindow)
+		}
 	}
 }
 
 func (workspace *Workspace) HandleDiskEvent(event fsnotify.Event, s *Server, watcher *fsnotify.Watcher) {
+	workspace.diagnoseChanged(workspace.applyDiskEvent(event, s, watcher), s)
+}
+
+// Applies a disk event to the store and the workspace. Returns the files whose content changed in the store, which are left to diagnose.
+func (workspace *Workspace) applyDiskEvent(event fsnotify.Event, s *Server, watcher *fsnotify.Watcher) []util.Path {
 	// Path of original file
 	origPath, err := filepath.Localize(event.Name)
 
@@ -222,105 +406,127 @@ func (workspace *Workspace) HandleDiskEvent(event fsnotify.Event, s *Server, wat
 		origPath = event.Name
 	}
 
-	// If file of this path is already opened by editor, ignore this HandleDiskEvent
-	_, open := workspace.openedFiles[util.FromPath(origPath)]
-	if open {
-		return
+	// If file of this path is already opened by editor, its buffer is kept, and is only saved if the file now has its content,
+	// however the editor wrote it
+	if workspace.isOpen(origPath) {
+		if f, ok := s.Files.GetFromPath(origPath); ok {
+			f.mu.RLock()
+			content := f.Content
+			f.mu.RUnlock()
+			workspace.syncOverlay(origPath, content)
+		}
+		return nil
 	}
 
-	// Path relative to workspace
-	relPath := origPath[len(workspace.Root)+1:]
+	if workspace.isWatchedExternalFile(origPath) {
+		workspace.handleExternalDiskEvent(event, origPath, s)
+		return nil
+	}
 
-	// Reload config file if changed
-	if filepath.Base(relPath) == faustConfigFile {
-		workspace.loadConfigFiles(s)
-		workspace.cleanDiagnostics(s)
+	// Path relative to workspace
+	relPath, err := filepath.Rel(workspace.Root, origPath)
+	if workspace.Root == "" || err != nil || !isWithin(origPath, workspace.Root) {
+		logging.Logger.Info("Ignoring disk event outside of workspace", "path", origPath)
+		return nil
+	}
+	if relPath == gitignoreFile {
+		workspace.loadIgnoreRules()
+	}
+	fi, err := os.Stat(origPath)
+	if workspace.Ignored(origPath, err == nil && fi.IsDir()) {
+		return nil
 	}
 
-	// The equivalent of the workspace file path for the temporary directory
-	// Should be of the form TEMP_DIR/WORKSPACE_ROOT_PATH/relPath
-	tempDirFilePath := workspace.TempDirPath(origPath)
-	logging.Logger.Info("Got disk event for file", "path", origPath, "temp", tempDirFilePath, "event", event)
+	logging.Logger.Info("Got disk event for file", "path", origPath, "event", event)
+
+	// Editors saving atomically rename a file over the saved one, or rename the saved one away and write it again.
+	// Files removed or renamed away that are already back are written instead, so that they stay in the store.
+	if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && err == nil && !fi.IsDir() {
+		logging.Logger.Info("File removed by disk event is back, handling it as written", "path", origPath)
+		event.Op = fsnotify.Create | fsnotify.Write
+		event.RenamedFrom = ""
+	}
 
+	changed := []util.Path{}
 	// OS CREATE Event
 	if event.Has(fsnotify.Create) {
-		// Check if this is a rename Create or a normal new file create. fsnotify sends a rename and create event on file renames and the create event has the RenamedFrom field
-		if event.RenamedFrom == "" {
-			// Normal New File
-			// Ensure path exists to copy
-			// Sometimes files get deleted by text editors before this goroutine can handle it
-			fi, err := os.Stat(origPath)
-			if err != nil {
-				return
-			}
-
-			if fi.IsDir() {
-				// If a directory is being created, mkdir instead of create
-				os.MkdirAll(tempDirFilePath, fi.Mode().Perm())
-				// Add this new directory to watch as watcher does not recursively watch subdirectories
-				watcher.Add(origPath)
-			} else {
-				// Add it our server tracking and workspace
-				s.Files.OpenFromPath(origPath)
-
-				// Create File
-				f, err := os.Create(tempDirFilePath)
-				if err != nil {
-					logging.Logger.Error("Create File error", "error", err)
-				}
-				f.Chmod(fi.Mode())
-				f.Close()
+		// Renames create their new path too, with the RenamedFrom field, and the rename of the old one removes it
+		// Sometimes files get deleted by text editors before this goroutine can handle it
+		fi, err := os.Stat(origPath)
+		if err != nil {
+			return nil
+		}
 
-				workspace.addFile(origPath)
+		if fi.IsDir() {
+			// Add this new directory and those in it to watch, unless the client watches files for us, and open the files already in them
+			if !s.watchedFilesRegistration {
+				changed = append(changed, workspace.watchTree(origPath, s, watcher)...)
 			}
-		} else {
-			// Rename Create
-			oldFileRelPath := event.RenamedFrom[len(workspace.Root)+1:]
-			oldTempPath := filepath.Join(workspace.tempDir, workspace.Root, oldFileRelPath)
-
-			if util.IsValidPath(tempDirFilePath) && util.IsValidPath(oldTempPath) {
-				err := os.Rename(oldTempPath, tempDirFilePath)
-				if err != nil {
-					return
-				}
+		} else if isIndexedFile(origPath) {
+			// Add it our server tracking and workspace. Files renamed into place have content to read.
+			if _, ok := s.Files.GetFromPath(origPath); !ok {
+				s.Files.OpenFromPath(origPath)
+				changed = append(changed, origPath)
 			}
-
-			fi, _ := os.Stat(origPath)
-			if fi.IsDir() {
-				// Add this new directory to watch as watcher does not recursively watch subdirectories
-				watcher.Add(origPath)
+			workspace.addFile(origPath)
+			if event.RenamedFrom != "" {
+				event.Op |= fsnotify.Write
 			}
 		}
 	}
 
 	// OS REMOVE Event
-	if event.Has(fsnotify.Remove) {
-		// Remove from File Store, Workspace and Temp Directory
-		s.Files.RemoveFromPath(origPath)
-		workspace.removeFile(origPath)
-		os.Remove(tempDirFilePath)
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// Remove from File Store, Workspace and overlay, along with what was in it for directories
+		workspace.forgetFile(origPath, s)
+		workspace.unwatchTree(origPath, s, watcher)
 	}
 
 	// OS WRITE Event
 	if event.Has(fsnotify.Write) {
 		contents, _ := os.ReadFile(origPath)
-		os.WriteFile(tempDirFilePath, contents, fs.FileMode(os.O_TRUNC))
-		s.Files.ModifyFull(origPath, string(contents))
-		workspace.DiagnoseFile(origPath, s)
+		workspace.refreshCopy(origPath, contents)
+		// Files that aren't indexed are only in the store if something read them, and writes that leave them as they were are only diagnosed once.
+		// Documents the editor opened since are left to it.
+		if s.Files.ModifyFromDisk(origPath, contents) && !slices.Contains(changed, origPath) {
+			changed = append(changed, origPath)
+		}
 	}
+
+	// Reload config file once the store has its new contents
+	if relPath == faustConfigFile {
+		workspace.reloadConfig(s, event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename))
+	} else if filepath.Base(relPath) == faustConfigFile {
+		workspace.loadNestedConfigs(s)
+		workspace.cleanDiagnostics(s)
+	}
+	if relPath == filepath.FromSlash(snippetsFile) {
+		workspace.loadSnippets(s)
+	}
+	return changed
 }
 
-func (workspace *Workspace) HandleEditorEvent(change TDEvent, s *Server) {
-	// Temporary Directory
-	tempDir := s.tempDir
+// Diagnoses the files whose content changed on disk, on their own for a single file, otherwise in one pass over the workspace
+func (workspace *Workspace) diagnoseChanged(changed []util.Path, s *Server) {
+	switch len(changed) {
+	case 0:
+	case 1:
+		workspace.DiagnoseFile(changed[0], s)
+	default:
+		workspace.cleanDiagnostics(s)
+	}
+}
 
+func (workspace *Workspace) HandleEditorEvent(change TDEvent, s *Server) {
 	// Path of File that this Event affected
 	origFilePath := change.Path
 
 	// Reload config file if changed
 	if filepath.Base(origFilePath) == faustConfigFile {
-		workspace.loadConfigFiles(s)
-		workspace.cleanDiagnostics(s)
+		workspace.reloadConfig(s, false)
+	}
+	if origFilePath == filepath.Join(workspace.Root, filepath.FromSlash(snippetsFile)) {
+		workspace.loadSnippets(s)
 	}
 
 	file, ok := s.Files.GetFromPath(origFilePath)
@@ -328,51 +534,41 @@ func (workspace *Workspace) HandleEditorEvent(change TDEvent, s *Server) {
 		logging.Logger.Error("File should've been in File Store.", "path", origFilePath)
 	}
 
-	tempDirFilePath := filepath.Join(tempDir, origFilePath) // Construct the temporary file path
 	switch change.Type {
 	case TDOpen:
-		// Ensure directory exists before creating file. This mirrors the workspace's directory structure in the temp directory.
-		// TODO: Add this and sub-directories to watcher
-		dirPath := filepath.Dir(tempDirFilePath)
-		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-			err := os.MkdirAll(dirPath, 0755) // Create the directory and all parent directories with permissions 0755
-			if err != nil {
-				logging.Logger.Error("failed to create directory", "error", err)
-				break
-			}
-		}
-
-		// Create File in Temporary Directory. This creates an empty file at the temp path.
-		f, err := os.Create(tempDirFilePath)
-		if err != nil {
-			logging.Logger.Error("OS create error", "error", err)
-		}
 		file, ok := s.Store.Files.GetFromPath(origFilePath)
 		if ok {
 			file.mu.RLock()
-			os.WriteFile(tempDirFilePath, file.Content, fs.FileMode(os.O_TRUNC))
+			content := file.Content
+			analyzed := file.Scope != nil
 			file.mu.RUnlock()
+			// Editors can open documents with content that isn't saved
+			workspace.syncOverlay(origFilePath, content)
 
+			// Documents that weren't indexed with a workspace, like single files, are analyzed once opened
+			if !analyzed && IsFaustFile(origFilePath) {
+				go workspace.AnalyzeFile(file, &s.Store)
+			}
 		}
-		f.Close()
 	case TDChange:
-		// Write File to Temporary Directory. Updates the temporary file with the latest content from the editor.
-		logging.Logger.Info("Writing recent change to", "path", tempDirFilePath)
-		os.WriteFile(tempDirFilePath, file.Content, fs.FileMode(os.O_TRUNC)) // Write the file content to the temp file, overwriting existing content
-		content, _ := os.ReadFile(tempDirFilePath)
-		logging.Logger.Info("Current state of file", "path", tempDirFilePath, "content", string(content))
-		go s.Workspace.AnalyzeFile(file, &s.Store)
+		// Write the unsaved buffer to the overlay, or remove it once the change brings back the content on disk
+		file.mu.RLock()
+		content := file.Content
+		file.mu.RUnlock()
+		workspace.syncOverlay(origFilePath, content)
+		logging.Logger.Info("Current state of file", "path", origFilePath, "length", len(content))
+		go workspace.AnalyzeFile(file, &s.Store)
 		workspace.DiagnoseFile(origFilePath, s)
 
 	case TDClose:
-		// Sync file from disk on close if it exists and replicate it to temporary directory, else remove from Files Store
-		if util.IsValidPath(origFilePath) { // Check if the file path is valid
+		// Unsaved changes are discarded on close, syncing the file from disk if it exists, else removing it from Files Store
+		workspace.removeOverlay(origFilePath)
+		if util.IsVirtualPath(origFilePath) {
+			// Unsaved documents are gone once closed
+			s.Files.RemoveFromPath(origFilePath)
+		} else if util.IsValidPath(origFilePath) { // Check if the file path is valid
 			s.Files.OpenFromPath(origFilePath) // Reload the file from the specified path.
-
-			file, ok := s.Files.GetFromPath(origFilePath) // Retrieve the file again (unnecessary, can use the previous `file`)
-			if ok {
-				os.WriteFile(tempDirFilePath, file.Content, os.FileMode(os.O_TRUNC)) // Write content to temporary file, replicating it from disk.
-			}
+			s.Files.MarkOpen(origFilePath, false)
 			workspace.addFile(origFilePath)
 		} else {
 			s.Files.RemoveFromPath(origFilePath) // Remove the file from the file store if the path isn't valid
@@ -384,40 +580,131 @@ func (workspace *Workspace) HandleEditorEvent(change TDEvent, s *Server) {
 func (workspace *Workspace) EditorOpenFile(uri util.URI, files *Files) {
 	files.OpenFromURI(uri)
 	handle, _ := util.FromURI(uri)
+	workspace.markOpen(handle)
+}
+
+// Whether the document at path is open in the editor
+func (workspace *Workspace) isOpen(path util.Path) bool {
+	workspace.mu.Lock()
+	defer workspace.mu.Unlock()
+	_, open := workspace.openedFiles[util.FromPath(path)]
+	return open
+}
+
+func (workspace *Workspace) markOpen(handle util.Handle) {
+	workspace.mu.Lock()
 	workspace.openedFiles[handle] = struct{}{}
+	workspace.mu.Unlock()
+}
+
+// Documents open in the editor
+func (workspace *Workspace) openedHandles() []util.Handle {
+	workspace.mu.Lock()
+	defer workspace.mu.Unlock()
+	return slices.Collect(maps.Keys(workspace.openedFiles))
+}
+
+// Returns the documents open in the editor, forgetting them
+func (workspace *Workspace) takeOpened() map[util.Handle]struct{} {
+	workspace.mu.Lock()
+	defer workspace.mu.Unlock()
+	opened := workspace.openedFiles
+	workspace.openedFiles = make(map[util.Handle]struct{})
+	return opened
 }
 
 func (workspace *Workspace) addFile(path util.Path) {
 	workspace.mu.Lock()
-	workspace.Files = append(workspace.Files, path)
+	if !slices.Contains(workspace.Files, path) {
+		workspace.Files = append(workspace.Files, path)
+	}
 	workspace.mu.Unlock()
 }
 
 func (w *Workspace) DiagnoseFile(path util.Path, s *Server) {
 	if IsFaustFile(path) {
-		logging.Logger.Info("Diagnosing File", "path", path)
-
-		params := s.Files.TSDiagnostics(path)
-		logging.Logger.Info("Got Diagnose File", "params", params)
-		if params.URI != "" {
-			s.diagChan <- params
-		}
-		if len(params.Diagnostics) == 0 {
+		// Checked before publishing, which caches the names the file uses
+		w.rediagnoseUnusedDefinitions(path, s)
+		if w.publishFileDiagnostics(path, s) {
 			// Compiler Diagnostics if exists
-			if w.Config.CompilerDiagnostics {
+			if w.ConfigFor(path).CompilerDiagnostics {
 				logging.Logger.Info("Generating Compiler errors as no syntax errors")
-				w.sendCompilerDiagnostics(s)
+				// Without a workspace there are no process files, so each opened .dsp file is compiled on its own, as are documents that aren't saved
+				if w.Root == "" || util.IsVirtualPath(path) {
+					if IsDSPFile(path) {
+						w.sendFileCompilerDiagnostics(s, path)
+					}
+				} else {
+					w.sendAffectedCompilerDiagnostics(s, path)
+				}
 			}
 		}
 	}
 }
 
+// Publishes syntax errors of a file, or analysis warnings if there are none. Returns whether the file has no syntax errors.
+func (w *Workspace) publishFileDiagnostics(path util.Path, s *Server) bool {
+	logging.Logger.Info("Diagnosing File", "path", path)
+
+	params := s.Files.TSDiagnostics(path)
+	logging.Logger.Info("Got Diagnose File", "params", params)
+	syntaxErrors := len(params.Diagnostics)
+	if syntaxErrors == 0 {
+		params.Diagnostics = append(params.Diagnostics, w.analysisDiagnostics(path, s)...)
+	}
+	if params.URI != "" {
+		s.diagChan <- params
+	}
+	return syntaxErrors == 0
+}
+
+// Warnings found by analyzing a file of the workspace
+func (w *Workspace) analysisDiagnostics(path util.Path, s *Server) []transport.Diagnostic {
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []transport.Diagnostic{}
+	}
+	// Definitions of libraries are meant to be used by other projects
+	var used map[string]struct{}
+	config := w.ConfigFor(path)
+	if config.UnusedDiagnostics && IsDSPFile(path) {
+		used = w.usedNames(s)
+	}
+
+	version := s.probeCompiler(config.Command).Version
+
+	tree, content := f.Tree()
+	defer tree.Close()
+	root := tree.RootNode()
+	diagnostics := AnalysisDiagnostics(root, content, path, config, used, version)
+	return append(diagnostics, w.unresolvedImportDiagnostics(path, root, content)...)
+}
+
+// AnalysisDiagnostics returns warnings found by analyzing the file at path: literals that can't be represented in the configured precision,
+// non-portable imports, wrong numbers of arguments, primitives the compiler doesn't know, unused definitions, shadowed names and breaches of strict mode.
+// root is the syntax tree of content, shared by all the passes. used are the names used in the project, nil if unused definitions aren't reported.
+// version is the compiler's, empty if unknown.
+func AnalysisDiagnostics(root *tree_sitter.Node, content []byte, path util.Path, config FaustProjectConfig, used map[string]struct{}, version string) []transport.Diagnostic {
+	diagnostics := PrecisionDiagnostics(root, content, config.explicitPrecision())
+	diagnostics = append(diagnostics, ImportPathDiagnostics(root, content)...)
+	diagnostics = append(diagnostics, ArgumentCountDiagnostics(root, content, config.EffectivePrecision())...)
+	diagnostics = append(diagnostics, PrimitiveVersionDiagnostics(root, content, version)...)
+	if used != nil {
+		diagnostics = append(diagnostics, UnusedDefinitionDiagnostics(root, content, used, config.processName())...)
+	}
+	if config.ShadowDiagnostics {
+		diagnostics = append(diagnostics, ShadowDiagnostics(root, content)...)
+	}
+	if config.Strict.Enabled {
+		diagnostics = append(diagnostics, StrictDiagnostics(root, content, config.Strict, IsLibFile(path))...)
+	}
+	return diagnostics
+}
+
 func (workspace *Workspace) removeFile(path util.Path) {
 	workspace.mu.Lock()
-	for i, filePath := range workspace.Files {
-		if filePath == path {
-			workspace.Files = slices.Delete(workspace.Files, i, i)
-		}
-	}
+	workspace.Files = slices.DeleteFunc(workspace.Files, func(filePath util.Path) bool {
+		return filePath == path
+	})
 	workspace.mu.Unlock()
 }
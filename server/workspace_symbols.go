@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+// Symbol kinds of the top-level definitions listed by workspace/symbol
+var workspaceSymbolKinds = map[SymbolKind]transport.SymbolKind{
+	Definition:  transport.Variable,
+	Function:    transport.Function,
+	Environment: transport.Namespace,
+	Library:     transport.Module,
+}
+
+// Workspace symbol, whose location is a Location or a LocationUriOnly, which the generated Or_ wrapper doesn't marshal as
+type WorkspaceSymbol struct {
+	Name          string               `json:"name"`
+	Kind          transport.SymbolKind `json:"kind"`
+	ContainerName string               `json:"containerName,omitempty"`
+	Location      SymbolLocation       `json:"location"`
+	Data          *WorkspaceSymbolData `json:"data,omitempty"`
+}
+
+// Location of a workspace symbol, without a range until it is resolved if the client supports it
+type SymbolLocation struct {
+	URI   transport.DocumentURI `json:"uri"`
+	Range *transport.Range      `json:"range,omitempty"`
+}
+
+// Data of workspace symbols whose range is left to workspaceSymbol/resolve
+type WorkspaceSymbolData struct {
+	Name string `json:"name"`
+}
+
+// MatchesQuery tells if the characters of query appear in name in order, ignoring case, as the specification recommends
+func MatchesQuery(name string, query string) bool {
+	name = strings.ToLower(name)
+	for _, r := range strings.ToLower(query) {
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// FileWorkspaceSymbols lists the top-level definitions of scope, the scope of the file at path, matching query.
+// Ranges are only given if withRanges is set, otherwise the symbols carry what workspaceSymbol/resolve needs to find them.
+func FileWorkspaceSymbols(path util.Path, root util.Path, scope *Scope, query string, withRanges bool) []WorkspaceSymbol {
+	container := filepath.Base(path)
+	if rel, err := filepath.Rel(root, path); root != "" && err == nil && !strings.HasPrefix(rel, "..") {
+		container = filepath.ToSlash(rel)
+	}
+	symbols := []WorkspaceSymbol{}
+	for _, sym := range scope.Symbols {
+		kind, ok := workspaceSymbolKinds[sym.Kind]
+		if !ok || sym.Ident == "" || !MatchesQuery(sym.Ident, query) {
+			continue
+		}
+		symbol := WorkspaceSymbol{
+			Name:          sym.Ident,
+			Kind:          kind,
+			ContainerName: container,
+			Location:      SymbolLocation{URI: transport.DocumentURI(util.Path2URI(path))},
+		}
+		if withRanges {
+			r := sym.Loc.Range
+			symbol.Location.Range = &r
+		} else {
+			symbol.Data = &WorkspaceSymbolData{Name: sym.Ident}
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// Whether the client resolves the ranges of workspace symbols with workspaceSymbol/resolve
+func symbolRangeResolveSupport(capabilities *transport.WorkspaceSymbolClientCapabilities) bool {
+	return capabilities != nil && capabilities.ResolveSupport != nil && slices.Contains(capabilities.ResolveSupport.Properties, "location.range")
+}
+
+func WorkspaceSymbols(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var params transport.WorkspaceSymbolParams
+	if err := json.Unmarshal(par, &params); err != nil {
+		return []byte("null"), err
+	}
+
+	// Symbols come from the scopes of the index, files are only read again for the symbols that get resolved
+	result := []WorkspaceSymbol{}
+	listed := make(map[util.Path]struct{})
+	for _, w := range s.workspaces() {
+		w.mu.Lock()
+		files := slices.Clone(w.Files)
+		w.mu.Unlock()
+		for _, path := range files {
+			if err := ctx.Err(); err != nil {
+				return []byte("null"), err
+			}
+			if _, ok := listed[path]; ok || !IsFaustFile(path) {
+				continue
+			}
+			listed[path] = struct{}{}
+			f, ok := s.Files.GetFromPath(path)
+			if !ok {
+				continue
+			}
+			f.mu.RLock()
+			scope := f.Scope
+			f.mu.RUnlock()
+			if scope == nil {
+				continue
+			}
+			// Ranges are in bytes in the index, so without resolving them they have to be converted right away
+			symbols := FileWorkspaceSymbols(path, w.Root, scope, params.Query, !s.symbolResolveSupport)
+			if !s.symbolResolveSupport {
+				for i := range symbols {
+					*symbols[i].Location.Range = s.clientRange(f, *symbols[i].Location.Range)
+				}
+			}
+			result = append(result, symbols...)
+		}
+	}
+	slices.SortFunc(result, func(a, b WorkspaceSymbol) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return json.Marshal(result)
+}
+
+func WorkspaceSymbolResolve(ctx context.Context, s *Server, par json.RawMessage) (json.RawMessage, error) {
+	var symbol WorkspaceSymbol
+	if err := json.Unmarshal(par, &symbol); err != nil {
+		return []byte("null"), err
+	}
+	if symbol.Data == nil || symbol.Location.Range != nil {
+		return par, nil
+	}
+
+	path, err := util.URI2path(string(symbol.Location.URI))
+	if err != nil {
+		return []byte("null"), err
+	}
+	f, ok := s.Files.GetFromPath(path)
+	if !ok {
+		return []byte("null"), fmt.Errorf("trying to resolve workspace symbol of non-existent path: %s", path)
+	}
+	f.mu.RLock()
+	scope := f.Scope
+	f.mu.RUnlock()
+
+	// Definitions removed since the symbol was listed are located at the start of their file
+	r := transport.Range{}
+	if scope != nil {
+		for _, sym := range scope.Symbols {
+			if _, ok := workspaceSymbolKinds[sym.Kind]; ok && sym.Ident == symbol.Data.Name {
+				r = s.clientRange(f, sym.Loc.Range)
+				break
+			}
+		}
+	}
+	symbol.Location.Range = &r
+	symbol.Data = nil
+	return json.Marshal(symbol)
+}
+
+// Range in the position encoding of the client of r, a range of the index whose characters are bytes
+func (s *Server) clientRange(f *File, r transport.Range) transport.Range {
+	content, lines := f.snapshot()
+	return transport.Range{
+		Start: encodedPosition(r.Start, content, lines, string(s.Files.encoding)),
+		End:   encodedPosition(r.End, content, lines, string(s.Files.encoding)),
+	}
+}
+
+func encodedPosition(pos transport.Position, content []byte, lines LineIndex, encoding string) transport.Position {
+	if int(pos.Line) >= len(lines) {
+		return pos
+	}
+	position, err := offsetToPosition(lines, lines[pos.Line]+uint(pos.Character), content, encoding)
+	if err != nil {
+		return pos
+	}
+	return position
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestArgumentCountDiagnostics(t *testing.T) {
+	parser.Init()
+
+	code := `f(x, y) = x + y;
+g = case { (0) => 1; (x, y) => 2; };
+h = \(a).(a);
+k = case { (0, y) => y; (x, y) => x; };
+c(x) = 1, 2;
+n = \(a).(10);
+process = f(1)(2, 3), f(1), h(1, 2), k(1, 2), g(1), c(1)(2), n(1, 2) with {
+	p(f) = f(1, 2, 3);
+};
+`
+	diagnostics := server.ArgumentCountDiagnostics(parseRoot(t, []byte(code)), []byte(code), "single")
+	expected := []struct {
+		line      uint32
+		character uint32
+		severity  transport.DiagnosticSeverity
+	}{
+		// Second rule of g
+		{1, 21, transport.SeverityError},
+		// Results of f and h have inputs the extra arguments go to, while those of c and n have none
+		// c(1)(2)
+		{6, 52, transport.SeverityWarning},
+		// n(1, 2)
+		{6, 61, transport.SeverityWarning},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diagnostics)
+	}
+	for i, e := range expected {
+		d := diagnostics[i]
+		if d.Range.Start.Line != e.line || d.Range.Start.Character != e.character || d.Severity != e.severity {
+			t.Errorf("expected diagnostic at %d:%d with severity %v, got %v", e.line, e.character, e.severity, d)
+		}
+	}
+	if diagnostics[1].Message != "c takes 1 argument(s) and its result has no inputs, but is applied to 2" {
+		t.Errorf("unexpected message: %s", diagnostics[1].Message)
+	}
+}
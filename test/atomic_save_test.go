@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestAtomicSaves(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	path := filepath.Join(root, "main.dsp")
+	os.WriteFile(path, []byte("process = _;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	// The watcher is started in the background
+	time.Sleep(100 * time.Millisecond)
+
+	saved := func(content string) bool {
+		for range 50 {
+			if f, ok := s.Files.GetFromPath(path); ok && string(f.Content) == content {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	// Writing a temporary file then renaming it over the saved one
+	vscode := "process = *(0.5);\n"
+	temp := filepath.Join(root, ".main.dsp.tmp")
+	os.WriteFile(temp, []byte(vscode), 0644)
+	os.Rename(temp, path)
+	if !saved(vscode) {
+		t.Errorf("Expected the content of the file renamed over main.dsp in the store")
+	}
+
+	// Renaming the saved file away, writing it again then removing the backup
+	vim := "process = *(0.25);\n"
+	backup := path + "~"
+	os.Rename(path, backup)
+	os.WriteFile(path, []byte(vim), 0644)
+	os.Remove(backup)
+	if !saved(vim) {
+		t.Errorf("Expected main.dsp written again to stay in the store with its new content")
+	}
+	if _, ok := s.Files.GetFromPath(backup); ok {
+		t.Error("Expected the backup file to stay out of the store")
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestFindBanks(t *testing.T) {
+	parser.Init()
+
+	code := []byte(`N = 32;
+mixer = par(i, N, _ * 0.5);
+stereo = par(i, 4, _, _);
+single = par(i, 1, _);
+strip = _,
+	_,
+	_,
+	_,
+	_,
+	_,
+	*(2),
+	*(2);
+short = _, _;
+`)
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	banks := server.FindBanks(tree.RootNode(), code, server.SinglePrecision)
+	// Iterations of a single element aren't banks
+	if len(banks) != 3 {
+		t.Fatalf("FindBanks() = %v, want 3 banks", banks)
+	}
+
+	if banks[0].Summary() != "×32 channels" || banks[0].Label != "_ * 0.5" {
+		t.Errorf("par bank = %q %q", banks[0].Summary(), banks[0].Label)
+	}
+	// Elements with several outputs give several channels each
+	if banks[1].Summary() != "×4, 8 channels" || banks[1].Channels != 8 {
+		t.Errorf("stereo par bank = %q %d", banks[1].Summary(), banks[1].Channels)
+	}
+
+	strip := banks[2]
+	if strip.Count != 8 || len(strip.Runs) != 2 || strip.Runs[0].Count != 6 || strip.Runs[1].Text != "*(2)" {
+		t.Errorf("',' bank = %+v", strip)
+	}
+
+	// The run of _ starts on the line of its bank, which keeps the fold
+	folds := server.BankFoldingRanges(banks, nil)
+	if len(folds) != 2 || *folds[0].StartLine != 4 || *folds[0].EndLine != 11 || *folds[1].StartLine != 10 || folds[1].CollapsedText != "*(2) ×2" {
+		t.Errorf("BankFoldingRanges() = %+v", folds)
+	}
+	// Lines already folded aren't folded again
+	line := uint32(4)
+	if folds := server.BankFoldingRanges(banks, []transport.FoldingRange{{StartLine: &line}}); len(folds) != 2 || *folds[1].StartLine != 10 {
+		t.Errorf("BankFoldingRanges() with a fold on line 4 = %+v", folds)
+	}
+
+	hints := server.BankInlayHints(banks, transport.Range{End: transport.Position{Line: 1, Character: 100}})
+	if len(hints) != 1 || hints[0].Label[0].Value != "×32 channels" {
+		t.Errorf("BankInlayHints() = %+v", hints)
+	}
+
+	symbols := server.AddBankSymbols(parser.DocumentSymbols(tree, code), banks)
+	for _, sym := range symbols {
+		if sym.Name == "mixer" && (len(sym.Children) != 1 || sym.Children[0].Name != "×32 channels") {
+			t.Errorf("mixer symbol children = %+v", sym.Children)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestBuildTargets(t *testing.T) {
+	var config server.FaustProjectConfig
+	content := `{"build_targets": [{"name": "jack", "tool": "faust2jaqt", "args": ["-osc"]}, {"name": "plugin", "tool": "faust2vst"}], "include": ["libs"]}`
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, ok := config.Target(""); !ok || target.Tool != "faust2jaqt" {
+		t.Errorf("expected the first target by default, got %+v", target)
+	}
+	target, ok := config.Target("plugin")
+	if !ok || target.Tool != "faust2vst" {
+		t.Errorf("expected the plugin target, got %+v", target)
+	}
+	if _, ok := config.Target("missing"); ok {
+		t.Errorf("expected no missing target")
+	}
+
+	jack, _ := config.Target("jack")
+	args := server.BuildToolArgs("/project/synth/main.dsp", "/project", config, jack)
+	expected := []string{"-osc", "-I", "/project/libs", "-single", "main.dsp"}
+	if !slices.Equal(args, expected) {
+		t.Errorf("expected arguments %v, got %v", expected, args)
+	}
+}
+
+func TestBuildDiagnostic(t *testing.T) {
+	output := "Compiling main.dsp\n/tmp/project/main.dsp : 3 : ERROR : undefined symbol : gain\n"
+	d := server.BuildDiagnostic("faust2jaqt", "/project/main.dsp", output)
+	if d.Range.Start.Line != 2 || d.Message != "undefined symbol : gain" || d.Source != "faust2jaqt" {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+
+	d = server.BuildDiagnostic("faust2jaqt", "/project/main.dsp", "lib.lib : 4 : ERROR : syntax error\n")
+	if d.Range.Start.Line != 0 || d.Message != "syntax error" {
+		t.Errorf("expected errors in other files at the start, got %+v", d)
+	}
+
+	lines := []string{}
+	for i := range 20 {
+		lines = append(lines, "line "+string(rune('a'+i)))
+	}
+	d = server.BuildDiagnostic("faust2vst", "/project/main.dsp", strings.Join(lines, "\n"))
+	if !strings.HasPrefix(d.Message, "faust2vst failed:\nline k\n") || !strings.HasSuffix(d.Message, "line t") {
+		t.Errorf("expected the last lines of the output, got %q", d.Message)
+	}
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestCodeLenses(t *testing.T) {
+	parser.Init()
+
+	code := `import("stdfaust.lib");
+gain(x) = x * 0.5;
+process = gain;
+`
+	uri := transport.DocumentURI("file:///main.dsp")
+	lenses := server.CodeLenses(parseRoot(t, []byte(code)), []byte(code), uri, "process")
+	if len(lenses) != 3 {
+		t.Fatalf("expected 3 lenses, got %v", lenses)
+	}
+	if lenses[0].Command != nil || lenses[0].Range.Start.Line != 1 || lenses[0].Data == nil {
+		t.Errorf("expected unresolved reference lens for gain, got %v", lenses[0])
+	}
+	compile := lenses[1]
+	if compile.Command == nil || compile.Command.Title != "Compile" || compile.Command.Command != "faustlsp.compileFile" || compile.Range.Start.Line != 2 {
+		t.Errorf("expected compile lens for process, got %v", compile)
+	}
+	if lenses[2].Command != nil || lenses[2].Range.Start.Line != 2 {
+		t.Errorf("expected unresolved reference lens for process, got %v", lenses[2])
+	}
+
+	// Libraries have no process to compile
+	if lenses := server.CodeLenses(parseRoot(t, []byte(code)), []byte(code), uri, ""); len(lenses) != 2 {
+		t.Errorf("expected only reference lenses, got %v", lenses)
+	}
+
+	if title := server.ReferenceCountTitle(1); title != "1 reference" {
+		t.Errorf("unexpected title: %s", title)
+	}
+	if title := server.ReferenceCountTitle(3); title != "3 references" {
+		t.Errorf("unexpected title: %s", title)
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestCompilerArgs(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	// The compiler records where it runs, its environment and arguments
+	record := filepath.Join(t.TempDir(), "record")
+	command := filepath.Join(t.TempDir(), "faust")
+	script := "#!/bin/sh\necho \"$PWD|$FAUST_TEST|$*\" >> " + record + "\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(root, "build"), 0755)
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	config := `{"command": "` + command + `", "process_files": ["main.dsp"], "compiler_args": ["-vec", "-vs", "16"], "env": {"FAUST_TEST": "yes"}, "working_dir": "build"}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+
+	if _, err := server.ProjectReport(root); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(record)
+	var compilation string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.Contains(line, "main.dsp") {
+			compilation = line
+		}
+	}
+	fields := strings.SplitN(compilation, "|", 3)
+	if len(fields) != 3 {
+		t.Fatalf("Expected main.dsp to be compiled, got %q", content)
+	}
+	// Temporary directories can be behind symbolic links, which $PWD resolves
+	realRoot, _ := filepath.EvalSymlinks(root)
+	if dir, _ := filepath.EvalSymlinks(fields[0]); dir != filepath.Join(realRoot, "build") {
+		t.Errorf("Expected the compiler to run in the working directory, ran in %s", fields[0])
+	}
+	if fields[1] != "yes" {
+		t.Errorf("Expected the environment variables of the config, got %q", fields[1])
+	}
+	if !strings.HasSuffix(fields[2], "-vec -vs 16") {
+		t.Errorf("Expected the compiler arguments last, got %q", fields[2])
+	}
+
+	// Build tools get them too, along with the whole path as they don't run next to the file
+	target := server.BuildTarget{Name: "jack", Tool: "faust2jaqt"}
+	args := server.BuildToolArgs("/project/main.dsp", "/project", server.FaustProjectConfig{CompilerArgs: []string{"-vec"}, WorkingDir: "build"}, target)
+	if expected := []string{"-single", "-vec", "/project/main.dsp"}; !slices.Equal(args, expected) {
+		t.Errorf("Expected arguments %v, got %v", expected, args)
+	}
+}
+
+func TestCompilerTimeout(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	// The compiler never finishes
+	command := filepath.Join(t.TempDir(), "faust")
+	if err := os.WriteFile(command, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	config := `{"command": "` + command + `", "process_files": ["main.dsp"], "compiler_timeout": 1}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+
+	start := time.Now()
+	report, err := server.ProjectReport(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the compiler to be killed after its timeout, took %s", elapsed)
+	}
+	if len(report.Compilations) != 1 || !strings.Contains(report.Compilations[0].Error, "stopped after running for 1s") {
+		t.Errorf("Expected the compilation to fail with a timeout, got %+v", report.Compilations)
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestCompilerCache(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	// The compiler records the files it compiles
+	record := filepath.Join(t.TempDir(), "record")
+	command := filepath.Join(t.TempDir(), "faust")
+	script := "#!/bin/sh\ncase \"$1\" in *.dsp) echo \"$1\" >> " + record + ";; esac\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(root, "main.dsp")
+	lib := filepath.Join(root, "gain.lib")
+	os.WriteFile(main, []byte("import(\"gain.lib\");\nprocess = gain;\n"), 0644)
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+	// Compiler diagnostics are off so that only the commands below compile
+	config := `{"command": "` + command + `", "compiler_diagnostics": false}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	uri, _ := json.Marshal(transport.DocumentURI(util.Path2URI(main)))
+	compilations := func() int {
+		server.CompileCommand(t.Context(), &s, []json.RawMessage{uri})
+		content, _ := os.ReadFile(record)
+		return strings.Count(string(content), "main.dsp")
+	}
+	if n := compilations(); n != 1 {
+		t.Fatalf("Expected main.dsp to be compiled once, got %d", n)
+	}
+	if n := compilations(); n != 1 {
+		t.Errorf("Expected main.dsp not to be compiled again while nothing changed, got %d compilations", n)
+	}
+	// Changing an imported file compiles it again
+	s.Files.ModifyFull(lib, "gain = *(0.25);\n")
+	if n := compilations(); n != 2 {
+		t.Errorf("Expected main.dsp to be compiled again after its import changed, got %d compilations", n)
+	}
+}
+
+// Writer the server's messages can be read from while it writes them
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) take() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	content := b.buf.String()
+	b.buf.Reset()
+	return content
+}
+
+func TestAffectedCompilerDiagnostics(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	command := filepath.Join(t.TempDir(), "faust")
+	if err := os.WriteFile(command, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	a := filepath.Join(root, "a.dsp")
+	b := filepath.Join(root, "b.dsp")
+	lib := filepath.Join(root, "gain.lib")
+	os.WriteFile(a, []byte("import(\"gain.lib\");\nprocess = gain;\n"), 0644)
+	os.WriteFile(b, []byte("process = _;\n"), 0644)
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(`{"command": "`+command+`", "process_files": ["a.dsp", "b.dsp"]}`), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	var output lockedBuffer
+	s.Transport.Writer = &output
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	// Wait for the first passes to be over
+	for range 20 {
+		time.Sleep(300 * time.Millisecond)
+		if output.take() == "" {
+			break
+		}
+	}
+
+	// Only the process file importing the library is diagnosed again
+	s.Files.ModifyFull(lib, "gain = *(0.25);\n")
+	s.Workspace.DiagnoseFile(lib, &s)
+	time.Sleep(100 * time.Millisecond)
+	published := output.take()
+	if !strings.Contains(published, util.Path2URI(a)) {
+		t.Errorf("Expected a.dsp to be diagnosed after the library it imports changed, got %s", published)
+	}
+	if strings.Contains(published, util.Path2URI(b)) {
+		t.Errorf("Expected b.dsp not to be diagnosed, as it doesn't import the library, got %s", published)
+	}
+}
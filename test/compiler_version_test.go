@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestCompilerVersion(t *testing.T) {
+	logging.Init()
+	output := "FAUST Version 2.75.7\nEmbedded backends: \n   DSP to C\n"
+	if version, ok := server.ParseCompilerVersion(output); !ok || version != "2.75.7" {
+		t.Errorf("Expected version 2.75.7, got %q", version)
+	}
+	if _, ok := server.ParseCompilerVersion("usage: faust [options] file"); ok {
+		t.Error("Expected no version in output without one")
+	}
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{{"2.75.7", "2.40.0", 1}, {"2.9.0", "2.40.0", -1}, {"2.40", "2.40.0", 0}, {"3.0.0", "2.99.99", 1}} {
+		if got := server.CompareVersions(c.a, c.b); (got > 0) != (c.expected > 0) || (got < 0) != (c.expected < 0) {
+			t.Errorf("Expected %s compared to %s to be %d, got %d", c.a, c.b, c.expected, got)
+		}
+	}
+
+	// A compiler that is found but too old
+	command := filepath.Join(t.TempDir(), "faust")
+	script := "#!/bin/sh\nif [ \"$1\" = --version ]; then echo 'FAUST Version 2.30.5'; else echo /opt/faust/share/faust; fi\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	info := server.ProbeCompiler(command)
+	if info.Path != command || info.Version != "2.30.5" || info.LibraryDir != "/opt/faust/share/faust" {
+		t.Errorf("Expected the compiler's path, version and library directory, got %+v", info)
+	}
+	if problem := info.Problem(); !strings.Contains(problem, "older than") {
+		t.Errorf("Expected a warning about the old compiler, got %q", problem)
+	}
+	missing := server.ProbeCompiler(filepath.Join(t.TempDir(), "faust"))
+	if missing.Path != "" || !strings.Contains(missing.Problem(), "wasn't found") {
+		t.Errorf("Expected a missing compiler, got %+v and %q", missing, missing.Problem())
+	}
+
+	// The initialize result tells about the compiler of the initialization options
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{
+			RootURI:               transport.DocumentURI(util.Path2URI(t.TempDir())),
+			InitializationOptions: map[string]any{"command": command},
+		},
+	})
+	result, err := server.Initialize(t.Context(), &s, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var initialized struct {
+		Capabilities transport.ServerCapabilities `json:"capabilities"`
+		ServerInfo   struct {
+			Name     string              `json:"name"`
+			Compiler server.CompilerInfo `json:"compiler"`
+		} `json:"serverInfo"`
+	}
+	json.Unmarshal(result, &initialized)
+	if initialized.ServerInfo.Name != "faust-lsp" || initialized.ServerInfo.Compiler.Version != "2.30.5" {
+		t.Errorf("Expected the compiler in the server info, got %+v", initialized.ServerInfo)
+	}
+	if initialized.Capabilities.ExecuteCommandProvider == nil {
+		t.Error("Expected the capabilities along with the server info")
+	}
+}
@@ -1,11 +1,17 @@
 package tests
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
 	"github.com/carn181/faustlsp/server"
 	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 )
 
 func TestFindCompletionReplaceRange(t *testing.T) {
@@ -39,3 +45,160 @@ foo = 1;`,
 		})
 	}
 }
+
+func TestUIPrimitiveCompletionItems(t *testing.T) {
+	r := transport.Range{Start: transport.Position{Line: 1, Character: 4}, End: transport.Position{Line: 1, Character: 7}}
+	find := func(items []transport.CompletionItem, label string) (transport.CompletionItem, bool) {
+		for _, item := range items {
+			if item.Label == label {
+				return item, true
+			}
+		}
+		return transport.CompletionItem{}, false
+	}
+
+	items := server.UIPrimitiveCompletionItems(r, true)
+	slider, ok := find(items, "hslider")
+	if !ok {
+		t.Fatalf("Expected hslider in %v", items)
+	}
+	if slider.TextEdit.NewText != `hslider("${1:label}", ${2:0.5}, ${3:0}, ${4:1}, ${5:0.01})` || slider.TextEdit.Range != r {
+		t.Errorf("Unexpected hslider edit %v", slider.TextEdit)
+	}
+	if *slider.InsertTextFormat != transport.SnippetTextFormat || slider.Detail != "hslider(label, init, min, max, step)" {
+		t.Errorf("Unexpected hslider item %v", slider)
+	}
+	if group, ok := find(items, "vgroup"); !ok || group.TextEdit.NewText != `vgroup("${1:label}", $0)` {
+		t.Errorf("Unexpected vgroup item %v", group)
+	}
+
+	// Without snippet support only the name is inserted
+	items = server.UIPrimitiveCompletionItems(r, false)
+	button, ok := find(items, "button")
+	if !ok || button.TextEdit.NewText != "button" || *button.InsertTextFormat != transport.PlainTextTextFormat {
+		t.Errorf("Unexpected button item without snippet support %v", button)
+	}
+}
+
+func TestSymbolCompletionRanking(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	libPath := filepath.Join(dir, "lib.lib")
+	// Stands for a standard library file
+	externalPath := filepath.Join(dir, "ext.lib")
+	mainPath := filepath.Join(dir, "main.dsp")
+	os.WriteFile(libPath, []byte("alpha = 1;\n"), 0644)
+	os.WriteFile(externalPath, []byte("aaa = 1;\n"), 0644)
+	mainCode := `import("lib.lib");
+import("ext.lib");
+beta = 1;
+process = f(2) with { f(y) = y + yy; yy = 3; };
+`
+	os.WriteFile(mainPath, []byte(mainCode), 0644)
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	store := server.Store{
+		Files:        &files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	workspace := server.Workspace{Root: dir}
+	visited := make(map[util.Path]struct{})
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+	for _, path := range []util.Path{libPath, externalPath, mainPath} {
+		files.OpenFromPath(path)
+		f, _ := files.GetFromPath(path)
+		workspace.ParseFile(f, &store, visited, fileChan)
+	}
+
+	// Completing the y of y + yy
+	pos := transport.Position{Line: 3, Character: 29}
+	symbols := server.GetPossibleSymbols(pos, mainPath, &store, "utf-16")
+	external := func(path util.Path) bool { return path == externalPath }
+	items := server.SortCompletionItems(server.SymbolCompletionItems(symbols, mainPath, transport.Range{}, external))
+	index := make(map[string]int)
+	for i, item := range items {
+		index[item.Label] = i
+	}
+	order := []string{"y", "yy", "beta", "alpha", "aaa"}
+	for i, label := range order {
+		if _, ok := index[label]; !ok {
+			t.Fatalf("Expected %s in completions %v", label, items)
+		}
+		if i > 0 && index[order[i-1]] > index[label] {
+			t.Errorf("Expected %s before %s, got %v", order[i-1], label, items)
+		}
+	}
+
+	// Items locate the definition their documentation is resolved from
+	raw, _ := json.Marshal(items[index["alpha"]].Data)
+	var data struct {
+		ID       string                `json:"id"`
+		URI      transport.DocumentURI `json:"uri"`
+		Position transport.Position    `json:"position"`
+	}
+	json.Unmarshal(raw, &data)
+	if data.ID == "" || data.URI != transport.DocumentURI(util.Path2URI(libPath)) || data.Position != (transport.Position{}) {
+		t.Errorf("Unexpected data of alpha %s", raw)
+	}
+	if items[index["alpha"]].Documentation != nil {
+		t.Errorf("Expected documentation of alpha to be resolved lazily")
+	}
+}
+
+func TestImportPathCompletion(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		typed   string
+		ok      bool
+	}{
+		{`import("fil`, "fil", true},
+		{`process = component( "effects/re`, "effects/re", true},
+		{`library("")`, "", true},
+		{`import("filters.lib");`, "", false},
+		{`label = "fil`, "", false},
+	} {
+		offset := uint(len(c.content))
+		if c.content == `library("")` {
+			offset = uint(len(`library("`))
+		}
+		typed, ok := server.ImportPathAt([]byte(c.content), offset)
+		if typed != c.typed || ok != c.ok {
+			t.Errorf("Expected %q, %v in %s, got %q, %v", c.typed, c.ok, c.content, typed, ok)
+		}
+	}
+
+	dir := t.TempDir()
+	libDir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.dsp")
+	os.MkdirAll(filepath.Join(dir, "effects"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	for _, path := range []string{mainPath, filepath.Join(dir, "filters.lib"), filepath.Join(dir, "notes.txt"), filepath.Join(dir, "effects", "reverb.dsp"), filepath.Join(libDir, "filters.lib"), filepath.Join(libDir, "fds.lib")} {
+		os.WriteFile(path, []byte("process = _;\n"), 0644)
+	}
+
+	labels := func(items []transport.CompletionItem) map[string]string {
+		found := make(map[string]string)
+		for _, item := range items {
+			found[item.Label] = item.Detail
+		}
+		return found
+	}
+	items := labels(server.ImportPathCompletionItems("", mainPath, []util.Path{dir, libDir}, transport.Range{}))
+	// The file's own directory wins over the library directory, and other files, hidden directories and the file itself are left out
+	if len(items) != 3 || items["filters.lib"] != filepath.Join(dir, "filters.lib") || items["fds.lib"] == "" || items["effects/"] == "" {
+		t.Errorf("Expected filters.lib of the file's directory, fds.lib and effects/, got %v", items)
+	}
+	items = labels(server.ImportPathCompletionItems("effects/r", mainPath, []util.Path{dir, libDir}, transport.Range{}))
+	if len(items) != 1 || items["reverb.dsp"] == "" {
+		t.Errorf("Expected the files of effects starting with r, got %v", items)
+	}
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestConfigDiagnostics(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte(""), 0644)
+	os.Mkdir(filepath.Join(root, "libs"), 0755)
+
+	pos := func(line, char uint32) transport.Position { return transport.Position{Line: line, Character: char} }
+	tests := []struct {
+		name     string
+		content  string
+		expected []transport.Range
+	}{
+		{"valid", "{\"process_files\": [\"main.dsp\"], \"include\": [\"libs\"]}", nil},
+		{"syntax error", "{\n  \"command\": \"faust\",\n  \"include\": [}\n", []transport.Range{{Start: pos(2, 14), End: pos(2, 15)}}},
+		{"wrong type", "{\n  \"compiler_diagnostics\": \"yes\"\n}", []transport.Range{{Start: pos(1, 2), End: pos(1, 24)}}},
+		{"unknown key", "{\"comand\": \"faust\"}", []transport.Range{{Start: pos(0, 1), End: pos(0, 9)}}},
+		{
+			"missing files",
+			"{\n  \"process_files\": [\"main.dsp\", \"gone.dsp\"],\n  \"include\": [\"libs\", \"notes.txt\", \"/nowhere\"]\n}",
+			[]transport.Range{
+				{Start: pos(1, 32), End: pos(1, 42)},
+				{Start: pos(2, 22), End: pos(2, 33)},
+				{Start: pos(2, 35), End: pos(2, 45)},
+			},
+		},
+	}
+	for _, test := range tests {
+		diagnostics := server.ConfigDiagnostics([]byte(test.content), root, "utf-16")
+		if len(diagnostics) != len(test.expected) {
+			t.Errorf("%s: expected %d diagnostics, got %v", test.name, len(test.expected), diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.Range != test.expected[i] {
+				t.Errorf("%s: expected diagnostic %q at %v, got %v", test.name, d.Message, test.expected[i], d.Range)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestParseTreeSExpression(t *testing.T) {
+	parser.Init()
+	code := []byte("a = 1;\nprocess = a : _;\n")
+
+	result, err := server.ParseTreeSExpression(parseRoot(t, code), code, nil, string(transport.UTF16))
+	if err != nil {
+		t.Fatalf("ParseTreeSExpression() error: %s", err)
+	}
+	want := "(program (definition variable: (identifier) value: (int)) (definition variable: (identifier) value: (sequential left: (identifier) right: (wire))))"
+	if result.Tree != want {
+		t.Errorf("ParseTreeSExpression() = %s, want %s", result.Tree, want)
+	}
+
+	// Smallest node containing "a : _"
+	r := transport.Range{Start: transport.Position{Line: 1, Character: 10}, End: transport.Position{Line: 1, Character: 15}}
+	result, err = server.ParseTreeSExpression(parseRoot(t, code), code, &r, string(transport.UTF16))
+	if err != nil {
+		t.Fatalf("ParseTreeSExpression() error: %s", err)
+	}
+	if result.Tree != "(sequential left: (identifier) right: (wire))" || result.Range != r {
+		t.Errorf("ParseTreeSExpression() of range = %s at %v", result.Tree, result.Range)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	logging.Init()
+
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "gain.lib")
+	path := filepath.Join(dir, "main.dsp")
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+	os.WriteFile(path, []byte("g = library(\"gain.lib\");\nf(x) = x + 1;\nprocess = f : g.gain\n"), 0644)
+
+	r, err := server.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(r.Tree, "(program (definition variable: (identifier) value: (library") {
+		t.Errorf("Expected the S-expression of the tree, got %s", r.Tree)
+	}
+
+	// Symbols are nested in the definitions scoping them, and libraries are resolved from the file's directory
+	if len(r.Symbols) != 3 {
+		t.Fatalf("Expected 3 symbols, got %v", r.Symbols)
+	}
+	if sym := r.Symbols[0]; sym.Kind != "Library" || sym.Name != "g" || sym.File != lib {
+		t.Errorf("Expected library g of %s, got %v", lib, sym)
+	}
+	f := r.Symbols[1]
+	if f.Kind != "Function" || f.Line != 2 || f.Column != 1 || len(f.Children) != 1 || f.Children[0].Name != "x" {
+		t.Errorf("Expected function f with argument x, got %v", f)
+	}
+
+	// The missing semicolon at the end of the file is a syntax error
+	if len(r.Diagnostics) != 1 || r.Diagnostics[0].Line != 3 || r.Diagnostics[0].Severity != "error" {
+		t.Errorf("Expected a syntax error on line 3, got %v", r.Diagnostics)
+	}
+	if text := r.Text(); !strings.Contains(text, "Function f 2:1\n  Identifier x 2:3\n") {
+		t.Errorf("Expected the symbols in the text, got\n%s", text)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestCoalesceEvents(t *testing.T) {
+	event := func(name string, op fsnotify.Op) fsnotify.Event {
+		return fsnotify.Event{Name: name, Op: op}
+	}
+	cases := []struct {
+		name   string
+		events []fsnotify.Event
+		want   []fsnotify.Event
+	}{
+		{
+			"writes of a file are merged",
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Write), event("/b.dsp", fsnotify.Write), event("/a.dsp", fsnotify.Write)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Write), event("/b.dsp", fsnotify.Write)},
+		},
+		{
+			"created files are written",
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Create), event("/a.dsp", fsnotify.Write)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Create|fsnotify.Write)},
+		},
+		{
+			"files created then removed are dropped",
+			[]fsnotify.Event{event("/a.dsp~", fsnotify.Create), event("/a.dsp~", fsnotify.Write), event("/a.dsp~", fsnotify.Remove), event("/a.dsp", fsnotify.Write)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Write)},
+		},
+		{
+			"changes of removed files only leave their removal",
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Write), event("/a.dsp", fsnotify.Remove)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Remove)},
+		},
+		{
+			"files replaced are written",
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Rename), event("/a.dsp", fsnotify.Create)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Create|fsnotify.Write)},
+		},
+		{
+			"temporary files renamed over saved ones are dropped",
+			[]fsnotify.Event{event("/a.tmp", fsnotify.Create), event("/a.tmp", fsnotify.Write), event("/a.tmp", fsnotify.Rename), {Name: "/a.dsp", Op: fsnotify.Create, RenamedFrom: "/a.tmp"}},
+			[]fsnotify.Event{{Name: "/a.dsp", Op: fsnotify.Create, RenamedFrom: "/a.tmp"}},
+		},
+		{
+			"files created again after being dropped are kept",
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Create), event("/a.dsp", fsnotify.Remove), event("/a.dsp", fsnotify.Create)},
+			[]fsnotify.Event{event("/a.dsp", fsnotify.Create)},
+		},
+	}
+	for _, c := range cases {
+		if got := server.CoalesceEvents(c.events); !slices.Equal(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
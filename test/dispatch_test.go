@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/server"
+)
+
+// Whether wait returns within a short time
+func returns(wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestDocumentQueues(t *testing.T) {
+	var q server.DocumentQueues
+
+	waitFirst, appliedFirst := q.Change("/a.dsp")
+	if !returns(waitFirst) {
+		t.Fatal("Expected the first change to be applied right away")
+	}
+	waitSecond, appliedSecond := q.Change("/a.dsp")
+	pendingA := q.Pending("/a.dsp")
+	pendingAll := q.Pending("")
+	pendingB := q.Pending("/b.dsp")
+
+	// Requests about other documents don't wait for the changes
+	if !returns(func() { pendingB(t.Context()) }) {
+		t.Error("Expected a request about another document not to wait")
+	}
+	if returns(waitSecond) {
+		t.Fatal("Expected the second change to wait for the first")
+	}
+
+	appliedFirst()
+	if !returns(waitSecond) {
+		t.Fatal("Expected the second change to be applied once the first is")
+	}
+	// Requests wait for the changes received before them
+	if returns(func() { pendingA(t.Context()) }) || returns(func() { pendingAll(t.Context()) }) {
+		t.Fatal("Expected requests to wait for the second change")
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := pendingA(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled request to stop waiting, got %v", err)
+	}
+
+	appliedSecond()
+	if !returns(func() { pendingA(t.Context()) }) || !returns(func() { pendingAll(t.Context()) }) {
+		t.Error("Expected requests to be handled once the changes before them are applied")
+	}
+	// Changes received after a request don't hold it back
+	_, appliedThird := q.Change("/a.dsp")
+	defer appliedThird()
+	if !returns(func() { pendingA(t.Context()) }) {
+		t.Error("Expected a request not to wait for later changes")
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestDocumentSymbolRanges(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := []byte(`f(x) = g with {
+  g = x;
+  h(y) = y;
+};
+p = case { (0) => 1; (n) => n; };
+q = a
+  : b
+  , c;
+r = s letrec { 's = 1; where t = 2; };
+doubleprecision v = 1.0;
+`)
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	symbols := parser.DocumentSymbols(tree, code)
+
+	rng := func(sl, sc, el, ec uint32) transport.Range {
+		return transport.Range{
+			Start: transport.Position{Line: sl, Character: sc},
+			End:   transport.Position{Line: el, Character: ec},
+		}
+	}
+	want := []struct {
+		name           string
+		rng, selection transport.Range
+	}{
+		{"f", rng(0, 0, 3, 2), rng(0, 0, 0, 1)},
+		{"p", rng(4, 0, 4, 33), rng(4, 0, 4, 1)},
+		{"q", rng(5, 0, 7, 6), rng(5, 0, 5, 1)},
+		{"r", rng(8, 0, 8, 38), rng(8, 0, 8, 1)},
+		{"v", rng(9, 0, 9, 24), rng(9, 16, 9, 17)},
+	}
+	if len(symbols) != len(want) {
+		t.Fatalf("Expected %d symbols, got %v", len(want), symbols)
+	}
+	for i, w := range want {
+		sym := symbols[i]
+		if sym.Name != w.name || sym.Range != w.rng || sym.SelectionRange != w.selection {
+			t.Errorf("Expected %s with range %v and selection range %v, got %s with %v and %v", w.name, w.rng, w.selection, sym.Name, sym.Range, sym.SelectionRange)
+		}
+	}
+
+	// Definitions in a with block are children of the definition using it
+	children := symbols[0].Children
+	if len(children) != 2 || children[0].Name != "g" || children[0].Range != rng(1, 2, 1, 8) || children[1].Name != "h" || children[1].SelectionRange != rng(2, 2, 2, 3) {
+		t.Errorf("Unexpected children of f: %v", children)
+	}
+}
+
+func TestUISymbols(t *testing.T) {
+	parser.Init()
+
+	code := []byte(`synth = hgroup("Synth", vgroup("[0]Osc", hslider("freq[style:knob]", 440, 20, 2000, 1) : os.osc) * button("gate"));
+meter = vbargraph("level", 0, 1);
+process = synth : meter;
+`)
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	symbols := server.AddUISymbols(parser.DocumentSymbols(tree, code), server.UISymbols(tree.RootNode(), code))
+	server.SortDocumentSymbols(symbols)
+
+	if len(symbols) != 3 || symbols[0].Name != "synth" || symbols[1].Name != "meter" {
+		t.Fatalf("Unexpected symbols %v", symbols)
+	}
+	synth := symbols[0].Children
+	if len(synth) != 1 || synth[0].Name != "Synth" || synth[0].Detail != "hgroup" || synth[0].Kind != transport.Namespace {
+		t.Fatalf("Expected the Synth hgroup under synth, got %v", synth)
+	}
+	if synth[0].SelectionRange != (transport.Range{Start: transport.Position{Line: 0, Character: 15}, End: transport.Position{Line: 0, Character: 22}}) {
+		t.Errorf("Expected the label to be selected, got %v", synth[0].SelectionRange)
+	}
+	group := synth[0].Children
+	if len(group) != 2 || group[0].Name != "Osc" || group[0].Detail != "vgroup" || group[1].Name != "gate" || group[1].Kind != transport.Boolean {
+		t.Fatalf("Expected the Osc vgroup and the gate button in Synth, got %v", group)
+	}
+	osc := group[0].Children
+	if len(osc) != 1 || osc[0].Name != "freq" || osc[0].Detail != "hslider" || osc[0].Kind != transport.Number {
+		t.Errorf("Expected the freq hslider in Osc, got %v", osc)
+	}
+	meter := symbols[1].Children
+	if len(meter) != 1 || meter[0].Name != "level" || meter[0].Detail != "vbargraph" || meter[0].Kind != transport.Event {
+		t.Errorf("Expected the level bargraph under meter, got %v", meter)
+	}
+}
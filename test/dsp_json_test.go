@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+const mixerJSON = `{
+	"name": "mixer", "filename": "main.dsp", "version": "2.75.7", "inputs": 1, "outputs": 1,
+	"meta": [{ "name": "mixer" }],
+	"ui": [{ "type": "hgroup", "label": "Mixer", "items": [
+		{ "type": "hslider", "label": "gain", "address": "/Mixer/gain", "meta": [{ "unit": "dB" }], "init": 0, "min": -10, "max": 10, "step": 0.1 },
+		{ "type": "button", "label": "mute", "address": "/Mixer/mute" }
+	]}]
+}`
+
+func TestUIItems(t *testing.T) {
+	parser.Init()
+	var info server.DSPInfo
+	if err := json.Unmarshal([]byte(mixerJSON), &info); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte(`process = *(hslider("h:Mixer/gain[unit:dB]", 0, -10, 10, 0.1)) * (1 - button("mute"));` + "\n")
+
+	primitive, label, ok := server.UIElementAt(parseRoot(t, content), content, uint(strings.Index(string(content), "gain")))
+	if !ok || primitive != "hslider" || label != `"h:Mixer/gain[unit:dB]"` {
+		t.Fatalf("Expected the hslider at the label, got %q %q %v", primitive, label, ok)
+	}
+	if _, _, ok := server.UIElementAt(parseRoot(t, content), content, uint(strings.Index(string(content), "-10"))); ok {
+		t.Error("Expected no UI element for the range of a widget")
+	}
+
+	items := server.UIItems(info.UI, primitive, label)
+	if len(items) != 1 || items[0].Address != "/Mixer/gain" {
+		t.Fatalf("Expected the gain slider, got %+v", items)
+	}
+	docs := server.UIItemsMarkdown(items)
+	for _, expected := range []string{"`/Mixer/gain`", "Range -10 to 10, step 0.1, initially 0", "unit: dB"} {
+		if !strings.Contains(docs, expected) {
+			t.Errorf("Expected %q in %q", expected, docs)
+		}
+	}
+	if items := server.UIItems(info.UI, "vslider", label); len(items) != 0 {
+		t.Errorf("Expected widgets of other primitives not to match, got %+v", items)
+	}
+}
+
+func TestDSPInfoCommand(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	// The compiler writes the JSON of the file it compiles in the output directory
+	jsonFile := filepath.Join(t.TempDir(), "mixer.json")
+	os.WriteFile(jsonFile, []byte(mixerJSON), 0644)
+	command := filepath.Join(t.TempDir(), "faust")
+	script := "#!/bin/sh\nfile=$1\nwhile [ $# -gt 0 ]; do if [ \"$1\" = -O ]; then out=$2; fi; shift; done\n" +
+		"[ -n \"$out\" ] && cp " + jsonFile + " \"$out/$(basename \"$file\").json\"\nexit 0\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(root, "main.dsp")
+	content := `process = *(hslider("h:Mixer/gain[unit:dB]", 0, -10, 10, 0.1));` + "\n"
+	os.WriteFile(main, []byte(content), 0644)
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(`{"command": "`+command+`", "process_files": ["main.dsp"]}`), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	uri := transport.DocumentURI(util.Path2URI(main))
+	argument, _ := json.Marshal(uri)
+	result, err := server.DSPInfoCommand(t.Context(), &s, []json.RawMessage{argument})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info server.DSPInfo
+	if err := json.Unmarshal(result, &info); err != nil || info.Name != "mixer" || info.Inputs != 1 {
+		t.Errorf("Expected the JSON the compiler wrote, got %s, %v", result, err)
+	}
+
+	hover, _ := json.Marshal(transport.HoverParams{TextDocumentPositionParams: transport.TextDocumentPositionParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: uri},
+		Position:     transport.Position{Line: 0, Character: uint32(strings.Index(content, "hslider"))},
+	}})
+	result, err = server.Hover(t.Context(), &s, hover)
+	if err != nil || !strings.Contains(string(result), "/Mixer/gain") {
+		t.Errorf("Expected the hover of the slider to show its address, got %s, %v", result, err)
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestDuplicateDefinition(t *testing.T) {
+	parser.Init()
+
+	code := `voice(f) = os.osc(f) * 0.1; // sine
+voice2 = voice(220);
+process = voice(440) + v with {
+    v = 1;
+};
+`
+	apply := func(edit transport.TextEdit) string {
+		start, _ := server.PositionToOffset(edit.Range.Start, code, "utf-16")
+		end, _ := server.PositionToOffset(edit.Range.End, code, "utf-16")
+		return code[:start] + edit.NewText + code[end:]
+	}
+
+	// voice2 is taken, so the copy is numbered with the next number
+	edit, nameRange, ok := server.DuplicateDefinitionEdit(parseRoot(t, []byte(code)), []byte(code), transport.Position{Line: 0, Character: 14}, "utf-16", "")
+	if !ok {
+		t.Fatalf("expected an edit")
+	}
+	expected := `voice(f) = os.osc(f) * 0.1; // sine
+voice3(f) = os.osc(f) * 0.1;
+voice2 = voice(220);
+process = voice(440) + v with {
+    v = 1;
+};
+`
+	if result := apply(edit); result != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+	}
+	if nameRange != (transport.Range{Start: transport.Position{Line: 1, Character: 0}, End: transport.Position{Line: 1, Character: 6}}) {
+		t.Errorf("unexpected name range: %v", nameRange)
+	}
+
+	// Numbered names get the next number, and with block definitions keep their indentation
+	edit, nameRange, ok = server.DuplicateDefinitionEdit(parseRoot(t, []byte(code)), []byte(code), transport.Position{Line: 3, Character: 4}, "utf-16", "gain")
+	if !ok {
+		t.Fatalf("expected an edit")
+	}
+	expected = `voice(f) = os.osc(f) * 0.1; // sine
+voice2 = voice(220);
+process = voice(440) + v with {
+    v = 1;
+    gain = 1;
+};
+`
+	if result := apply(edit); result != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+	}
+	if nameRange != (transport.Range{Start: transport.Position{Line: 4, Character: 4}, End: transport.Position{Line: 4, Character: 8}}) {
+		t.Errorf("unexpected name range: %v", nameRange)
+	}
+
+	edit, _, _ = server.DuplicateDefinitionEdit(parseRoot(t, []byte(code)), []byte(code), transport.Position{Line: 1, Character: 0}, "utf-16", "")
+	if edit.NewText != "\nvoice3 = voice(220);" {
+		t.Errorf("unexpected copy: %q", edit.NewText)
+	}
+
+	if _, _, ok := server.DuplicateDefinitionEdit(parseRoot(t, []byte(code)), []byte(code), transport.Position{Line: 5, Character: 1}, "utf-16", ""); ok {
+		t.Errorf("expected no edit outside definitions")
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Applies the edits of a code action to code
+func applyCodeAction(t *testing.T, code string, action transport.CodeAction, uri transport.DocumentURI) string {
+	edits := slices.Clone(action.Edit.Changes[uri])
+	// Apply from the end so earlier offsets stay valid
+	slices.SortFunc(edits, func(a, b transport.TextEdit) int {
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return int(a.Range.Start.Line) - int(b.Range.Start.Line)
+		}
+		return int(a.Range.Start.Character) - int(b.Range.Start.Character)
+	})
+	for i := len(edits) - 1; i >= 0; i-- {
+		start, err1 := server.PositionToOffset(edits[i].Range.Start, code, string(transport.UTF16))
+		end, err2 := server.PositionToOffset(edits[i].Range.End, code, string(transport.UTF16))
+		if err1 != nil || err2 != nil {
+			t.Fatalf("Invalid edit range %v", edits[i].Range)
+		}
+		code = code[:start] + edits[i].NewText + code[end:]
+	}
+	return code
+}
+
+func TestExtractActions(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	uri := transport.DocumentURI("file:///test.dsp")
+	sel := func(line, start, end uint32) transport.Range {
+		return transport.Range{
+			Start: transport.Position{Line: line, Character: start},
+			End:   transport.Position{Line: line, Character: end},
+		}
+	}
+
+	tests := []struct {
+		name string
+		code string
+		r    transport.Range
+		want []string
+	}{
+		{
+			name: "Expression without local names",
+			code: "extracted = 1;\nprocess = _ : *(0.5 + 0.25) : _;\n",
+			r:    sel(1, 15, 27),
+			want: []string{
+				"extracted = 1;\nextracted1 = 0.5 + 0.25;\nprocess = _ : *extracted1 : _;\n",
+				"extracted = 1;\nprocess = _ : *extracted1 : _ with { extracted1 = 0.5 + 0.25; };\n",
+			},
+		},
+		{
+			name: "Expression using function arguments",
+			code: "f(g) = g * 2 with { h = 1; };\n",
+			r:    sel(0, 7, 12),
+			want: []string{
+				"f(g) = extracted with { h = 1; extracted = g * 2; };\n",
+			},
+		},
+		{
+			name: "Selection around whitespace",
+			code: "process = os.osc(440) , no.noise;\n",
+			r:    sel(0, 9, 22),
+			want: []string{
+				"extracted = os.osc(440);\nprocess = extracted , no.noise;\n",
+				"process = extracted , no.noise with { extracted = os.osc(440); };\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := server.ExtractActions(parseRoot(t, []byte(tt.code)), []byte(tt.code), uri, tt.r, string(transport.UTF16))
+			if len(actions) != len(tt.want) {
+				t.Fatalf("Expected %d actions, got %v", len(tt.want), actions)
+			}
+			for i, want := range tt.want {
+				if got := applyCodeAction(t, tt.code, actions[i], uri); got != want {
+					t.Errorf("Applying %q gave\n%s\nwant\n%s", actions[i].Title, got, want)
+				}
+			}
+		})
+	}
+
+	// Selections that aren't whole expressions can't be extracted
+	code := "process = fi.lowpass(1, 1000) : _;\n"
+	for _, r := range []transport.Range{sel(0, 10, 15), sel(0, 13, 20), sel(0, 0, 7)} {
+		if actions := server.ExtractActions(parseRoot(t, []byte(code)), []byte(code), uri, r, string(transport.UTF16)); len(actions) != 0 {
+			t.Errorf("Expected no actions for %v, got %v", r, actions)
+		}
+	}
+}
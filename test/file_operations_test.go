@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestRenameImportEdits(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	root := filepath.FromSlash("/project")
+	files := map[string]util.Path{
+		"osc.dsp":        filepath.Join(root, "osc.dsp"),
+		"libs/fx.lib":    filepath.Join(root, "libs", "fx.lib"),
+		"libs/other.lib": filepath.Join(root, "libs", "other.lib"),
+		"stdfaust.lib":   filepath.FromSlash("/usr/share/faust/stdfaust.lib"),
+	}
+	resolve := func(path string) (util.Path, util.Path) {
+		if resolved, ok := files[path]; ok {
+			return resolved, root
+		}
+		return "", ""
+	}
+	code := "import(\"stdfaust.lib\");\nimport(\"libs/fx.lib\");\nosc = component(\"osc.dsp\");\nother = library(\"libs/other.lib\");\n"
+
+	// Renamed files
+	renames := map[util.Path]util.Path{
+		filepath.Join(root, "osc.dsp"):        filepath.Join(root, "voices", "saw.dsp"),
+		filepath.Join(root, "libs", "fx.lib"): filepath.Join(root, "libs", "effects.lib"),
+	}
+	edits := server.RenameImportEdits(parseRoot(t, []byte(code)), []byte(code), resolve, renames)
+	if len(edits) != 2 {
+		t.Fatalf("RenameImportEdits() = %v, want edits of the import and the component", edits)
+	}
+	if edits[0].NewText != `"libs/effects.lib"` || edits[0].Range.Start.Line != 1 || edits[0].Range.Start.Character != 7 {
+		t.Errorf("Unexpected import edit %v", edits[0])
+	}
+	if edits[1].NewText != `"voices/saw.dsp"` || edits[1].Range.Start.Line != 2 {
+		t.Errorf("Unexpected component edit %v", edits[1])
+	}
+
+	// Files in renamed folders
+	renames = map[util.Path]util.Path{filepath.Join(root, "libs"): filepath.Join(root, "lib")}
+	edits = server.RenameImportEdits(parseRoot(t, []byte(code)), []byte(code), resolve, renames)
+	if len(edits) != 2 || edits[0].NewText != `"lib/fx.lib"` || edits[1].NewText != `"lib/other.lib"` {
+		t.Errorf("RenameImportEdits() = %v, want both files of libs moved to lib", edits)
+	}
+}
+
+func TestDeletedImports(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	root := t.TempDir()
+	include := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "libs"), 0755)
+	os.WriteFile(filepath.Join(root, "libs", "fx.lib"), []byte("fx = _;"), 0644)
+	os.WriteFile(filepath.Join(root, "osc.dsp"), []byte("process = _;"), 0644)
+	// Found in the workspace first, so deleting the include directory's copy breaks nothing
+	os.WriteFile(filepath.Join(include, "osc.dsp"), []byte("process = _;"), 0644)
+
+	code := []byte("import(\"libs/fx.lib\");\nosc = component(\"osc.dsp\");\n")
+	searchPath := []util.Path{root, include}
+	imported := func(deleted ...util.Path) []string {
+		paths := []string{}
+		for _, imp := range server.DeletedImports(parseRoot(t, code), code, searchPath, deleted) {
+			paths = append(paths, imp.Path)
+		}
+		return paths
+	}
+
+	if got := imported(filepath.Join(root, "libs", "fx.lib")); !slices.Equal(got, []string{"libs/fx.lib"}) {
+		t.Errorf("DeletedImports(fx.lib) = %v", got)
+	}
+	if got := imported(filepath.Join(root, "libs")); !slices.Equal(got, []string{"libs/fx.lib"}) {
+		t.Errorf("DeletedImports(libs) = %v", got)
+	}
+	if got := imported(filepath.Join(include, "osc.dsp")); len(got) != 0 {
+		t.Errorf("DeletedImports() of a shadowed file = %v, want none", got)
+	}
+
+	// Once deleted, files are still recognized
+	os.Remove(filepath.Join(root, "osc.dsp"))
+	if got := imported(filepath.Join(root, "osc.dsp")); !slices.Equal(got, []string{"osc.dsp"}) {
+		t.Errorf("DeletedImports(osc.dsp) after deletion = %v", got)
+	}
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestWorkspaceFolderRoots(t *testing.T) {
+	a := filepath.Join(t.TempDir(), "a")
+	b := filepath.Join(t.TempDir(), "b")
+	uri := func(path util.Path) transport.DocumentURI { return transport.DocumentURI(util.Path2URI(path)) }
+
+	roots := server.WorkspaceFolderRoots(uri(a), []transport.WorkspaceFolder{
+		{URI: transport.URI(uri(b)), Name: "b"},
+		{URI: transport.URI(uri(a)), Name: "a"},
+		{URI: transport.URI(uri(b)), Name: "b again"},
+	})
+	if !slices.Equal(roots, []util.Path{b, a}) {
+		t.Errorf("Expected the folders' roots in order, got %v", roots)
+	}
+	// Clients without workspace folders only send a root
+	if roots := server.WorkspaceFolderRoots(uri(a), nil); !slices.Equal(roots, []util.Path{a}) {
+		t.Errorf("Expected the root URI, got %v", roots)
+	}
+	if roots := server.WorkspaceFolderRoots("", nil); len(roots) != 0 {
+		t.Errorf("Expected no roots, got %v", roots)
+	}
+}
+
+func TestOwningWorkspace(t *testing.T) {
+	root := t.TempDir()
+	outer := &server.Workspace{Root: root}
+	nested := &server.Workspace{Root: filepath.Join(root, "plugins", "reverb")}
+	other := &server.Workspace{Root: filepath.Join(root, "other")}
+	workspaces := []*server.Workspace{outer, nested, other}
+
+	tests := []struct {
+		path  util.Path
+		owner *server.Workspace
+	}{
+		{filepath.Join(root, "main.dsp"), outer},
+		{filepath.Join(root, "plugins", "reverb", "reverb.dsp"), nested},
+		{filepath.Join(root, "plugins", "reverb2.dsp"), outer},
+		{filepath.Join(root, "other", "lib", "a.lib"), other},
+		{filepath.Join(filepath.Dir(root), "elsewhere.dsp"), nil},
+	}
+	for _, tt := range tests {
+		if owner := server.OwningWorkspace(workspaces, tt.path); owner != tt.owner {
+			t.Errorf("OwningWorkspace(%s) = %v, expected %v", tt.path, owner, tt.owner)
+		}
+	}
+}
+
+func TestChangeWorkspaceFolders(t *testing.T) {
+	logging.Init()
+	a := filepath.Join(t.TempDir(), "a")
+	b := filepath.Join(t.TempDir(), "b")
+	c := filepath.Join(t.TempDir(), "c")
+	for _, root := range []util.Path{a, b, c} {
+		os.MkdirAll(root, 0755)
+		os.WriteFile(filepath.Join(root, filepath.Base(root)+".dsp"), []byte("process = _;\n"), 0644)
+	}
+	uri := func(path util.Path) transport.DocumentURI { return transport.DocumentURI(util.Path2URI(path)) }
+	folder := func(root util.Path) transport.WorkspaceFolder {
+		return transport.WorkspaceFolder{URI: transport.URI(uri(root)), Name: filepath.Base(root)}
+	}
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: uri(a)},
+		WorkspaceFoldersInitializeParams: transport.WorkspaceFoldersInitializeParams{
+			WorkspaceFolders: []transport.WorkspaceFolder{folder(a), folder(b)},
+		},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	indexed := func(root util.Path) bool {
+		_, ok := s.Files.GetFromPath(filepath.Join(root, filepath.Base(root)+".dsp"))
+		return ok
+	}
+	if !indexed(a) || !indexed(b) {
+		t.Fatal("Expected the files of both folders to be indexed")
+	}
+
+	// Removed folders leave the index and the temporary directory
+	params, _ = json.Marshal(transport.DidChangeWorkspaceFoldersParams{
+		Event: transport.WorkspaceFoldersChangeEvent{Removed: []transport.WorkspaceFolder{folder(b)}},
+	})
+	server.DidChangeWorkspaceFolders(t.Context(), &s, params)
+	if indexed(b) {
+		t.Error("Expected the file of the removed folder to leave the index")
+	}
+	if _, err := os.Stat(s.Workspace.TempDirPath(b)); !os.IsNotExist(err) {
+		t.Errorf("Expected the replica of the removed folder to be deleted, got %v", err)
+	}
+
+	// Removing the first folder makes the next one the first
+	params, _ = json.Marshal(transport.DidChangeWorkspaceFoldersParams{
+		Event: transport.WorkspaceFoldersChangeEvent{
+			Added:   []transport.WorkspaceFolder{folder(c)},
+			Removed: []transport.WorkspaceFolder{folder(a)},
+		},
+	})
+	server.DidChangeWorkspaceFolders(t.Context(), &s, params)
+	if s.Workspace.Root != c || indexed(a) || !indexed(c) {
+		t.Errorf("Expected c to replace a as the first folder, got root %s", s.Workspace.Root)
+	}
+	if !slices.Contains(s.Workspace.Files, filepath.Join(c, "c.dsp")) {
+		t.Errorf("Expected c.dsp in the files of the first folder, got %v", s.Workspace.Files)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.dsp")
+	lib := filepath.Join(dir, "gain.lib")
+	code := "import(\"gain.lib\");\nprocess = gain;\n"
+	os.WriteFile(main, []byte(code), 0644)
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+
+	// Imports of files opened without a workspace are resolved from their directory
+	if root := (&server.Workspace{}).ImportRoot(main); root != dir {
+		t.Errorf("ImportRoot(%s) = %s, expected %s", main, root, dir)
+	}
+	if root := (&server.Workspace{Root: "/project"}).ImportRoot(main); root != "/project" {
+		t.Errorf("ImportRoot(%s) = %s, expected the workspace root", main, root)
+	}
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	uri := transport.DocumentURI(util.Path2URI(main))
+	params, _ = json.Marshal(transport.DidOpenTextDocumentParams{
+		TextDocument: transport.TextDocumentItem{URI: uri, LanguageID: "faust", Text: code},
+	})
+	if err := server.TextDocumentOpen(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ = json.Marshal(transport.DefinitionParams{
+		TextDocumentPositionParams: transport.TextDocumentPositionParams{
+			TextDocument: transport.TextDocumentIdentifier{URI: uri},
+			Position:     transport.Position{Line: 1, Character: 11},
+		},
+	})
+	var location transport.Location
+	for range 50 {
+		result, err := server.GetDefinition(t.Context(), &s, params)
+		if err == nil && json.Unmarshal(result, &location) == nil && location.URI != "" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if location.URI != transport.DocumentURI(util.Path2URI(lib)) {
+		t.Errorf("Expected gain to be defined in %s, got %v", lib, location)
+	}
+}
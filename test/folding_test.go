@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestFoldingRanges(t *testing.T) {
+	parser.Init()
+
+	code := []byte(`import("stdfaust.lib"); // standard
+import("a.lib");
+/* block
+comment */
+// line
+// comments
+env = environment {
+  a = 1;
+  b = 2;
+};
+single = 1;
+process = a : b with {
+  a = 2;
+  b = 3;
+};
+`)
+	type fold struct {
+		start, end uint32
+		kind       transport.FoldingRangeKind
+	}
+	want := map[fold]struct{}{
+		{0, 1, transport.Imports}:  {},
+		{2, 3, transport.Comment}:  {},
+		{4, 5, transport.Comment}:  {},
+		{6, 9, transport.Region}:   {},
+		{11, 14, transport.Region}: {},
+	}
+
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	got, err := parser.FoldingRanges(t.Context(), tree, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range got {
+		f := fold{*r.StartLine, *r.EndLine, transport.FoldingRangeKind(r.Kind)}
+		if _, ok := want[f]; !ok {
+			t.Errorf("FoldingRanges() has unexpected fold %v", f)
+		}
+		delete(want, f)
+	}
+	for f := range want {
+		t.Errorf("FoldingRanges() is missing fold %v", f)
+	}
+}
+
+func TestCanceledFoldingRanges(t *testing.T) {
+	parser.Init()
+
+	code := []byte("env = environment {\n  a = 1;\n};\n")
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if folds, err := parser.FoldingRanges(ctx, tree, code); !errors.Is(err, context.Canceled) || folds != nil {
+		t.Errorf("expected canceled folding ranges, got %v, %v", folds, err)
+	}
+}
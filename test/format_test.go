@@ -1,12 +1,201 @@
 package tests
 
 import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
 	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 )
 
 func TestFormat(t *testing.T) {
+	parser.Init()
 	out, err := server.Format([]byte("process=a with {f=2;};"), "    ")
 	t.Log(string(out), err)
 }
+
+func TestBuiltinFormat(t *testing.T) {
+	parser.Init()
+
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "With block indentation",
+			code: "process=a with {f=2;g(x)=x';};",
+			want: "process = a with {\n    f    = 2;\n    g(x) = x';\n};\n",
+		},
+		{
+			name: "Composition operator spacing",
+			code: "process=_,_<:_,_,_,_:>_~_:-x;",
+			want: "process = _, _ <: _, _, _, _ :> _ ~ _ : -x;\n",
+		},
+		{
+			name: "Alignment of definition groups",
+			code: "a=1;\nlong(x)=x;\n\nb = 2;",
+			want: "a       = 1;\nlong(x) = x;\n\nb = 2;\n",
+		},
+		{
+			name: "Comments are preserved",
+			code: "// header\nimport(\"stdfaust.lib\"); // trailing\n/* block */\nprocess=os.osc(440);",
+			want: "// header\nimport(\"stdfaust.lib\"); // trailing\n/* block */\nprocess = os.osc(440);\n",
+		},
+		{
+			name: "Multi-line expressions keep their line breaks",
+			code: "process = a :\nb\n   : c;",
+			want: "process = a :\n    b\n    : c;\n",
+		},
+		{
+			name: "Pattern rules",
+			code: "f = case{(0)=>1;(x)=>hslider(\"a\",0,0,1,0.1);};",
+			want: "f = case {\n    (0) => 1;\n    (x) => hslider(\"a\", 0, 0, 1, 0.1);\n};\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.Format([]byte(tt.code), "    ")
+			if err != nil {
+				t.Fatalf("Format() error: %s", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+
+			again, err := parser.Format(got, "    ")
+			if err != nil || string(again) != string(got) {
+				t.Errorf("Format() is not idempotent: %q, then %q", got, again)
+			}
+		})
+	}
+
+	if _, err := parser.Format([]byte("process = ;"), "    "); err == nil {
+		t.Errorf("Format() should refuse to format code with syntax errors")
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	parser.Init()
+
+	code := "a=1;\nb=a+1;\nc = ;\nprocess=b:_;"
+
+	// Only the statement in range is formatted
+	start := uint(len("a=1;\n"))
+	got, replaceStart, replaceEnd, err := parser.FormatRange([]byte(code), "    ", start, start)
+	if err != nil {
+		t.Fatalf("FormatRange() error: %s", err)
+	}
+	if string(got) != "b = a + 1;" || code[replaceStart:replaceEnd] != "b=a+1;" {
+		t.Errorf("FormatRange() = %q replacing %q", got, code[replaceStart:replaceEnd])
+	}
+
+	// Syntax errors outside the range don't matter
+	start = uint(len(code) - 1)
+	got, _, _, err = parser.FormatRange([]byte(code), "    ", start, start)
+	if err != nil || string(got) != "process = b : _;" {
+		t.Errorf("FormatRange() = %q, %v", got, err)
+	}
+
+	start = uint(len("a=1;\nb=a+1;\nc"))
+	if _, _, _, err := parser.FormatRange([]byte(code), "    ", start, start); err == nil {
+		t.Errorf("FormatRange() should refuse to format statements with syntax errors")
+	}
+}
+
+func TestRangeAndOnTypeFormatting(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	path := filepath.Join(root, "main.dsp")
+	os.WriteFile(path, []byte("a=1;\nb=a+1;\nprocess=b;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	uri := transport.DocumentURI(util.Path2URI(path))
+	edited := func(result json.RawMessage, err error) []transport.TextEdit {
+		t.Helper()
+		var edits []transport.TextEdit
+		if err != nil || json.Unmarshal(result, &edits) != nil {
+			t.Fatalf("Expected edits, got %s, %v", result, err)
+		}
+		return edits
+	}
+
+	// Ranges starting at a statement don't reach the end of the one before
+	line := transport.Position{Line: 1, Character: 0}
+	rangeParams, _ := json.Marshal(transport.DocumentRangeFormattingParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: uri},
+		Range:        transport.Range{Start: line, End: line},
+		Options:      transport.FormattingOptions{TabSize: 4, InsertSpaces: true},
+	})
+	edits := edited(server.RangeFormatting(t.Context(), &s, rangeParams))
+	if len(edits) != 1 || edits[0].NewText != "b = a + 1;" || edits[0].Range.Start != line {
+		t.Errorf("Expected only the statement of the range to be formatted, got %v", edits)
+	}
+
+	// The statement ended by the typed character is formatted, the position being right after it
+	typed := transport.Position{Line: 1, Character: uint32(len("b=a+1;"))}
+	onTypeParams, _ := json.Marshal(transport.DocumentOnTypeFormattingParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: uri},
+		Position:     typed,
+		Ch:           ";",
+		Options:      transport.FormattingOptions{TabSize: 4, InsertSpaces: true},
+	})
+	edits = edited(server.OnTypeFormatting(t.Context(), &s, onTypeParams))
+	if len(edits) != 1 || edits[0].NewText != "b = a + 1;" {
+		t.Errorf("Expected the typed statement to be formatted, got %v", edits)
+	}
+}
+
+func TestFormattingReplacesWholeNonASCIIFile(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	path := filepath.Join(root, "main.dsp")
+	os.WriteFile(path, []byte("// réglage du gain\nprocess=hslider(\"gain é\",0,0,1,0.1);"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	formatParams, _ := json.Marshal(transport.DocumentFormattingParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: transport.DocumentURI(util.Path2URI(path))},
+		Options:      transport.FormattingOptions{TabSize: 4, InsertSpaces: true},
+	})
+	result, err := server.Formatting(t.Context(), &s, formatParams)
+	var edits []transport.TextEdit
+	if err != nil || json.Unmarshal(result, &edits) != nil || len(edits) != 1 {
+		t.Fatalf("Expected one edit, got %s, %v", result, err)
+	}
+	// The last line is 37 bytes but 36 UTF-16 code units long
+	end := transport.Position{Line: 1, Character: 36}
+	if edits[0].Range.End != end {
+		t.Errorf("Expected the edit to end at the end of the file %v, got %v", end, edits[0].Range.End)
+	}
+}
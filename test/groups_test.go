@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestWrapInGroup(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	uri := transport.DocumentURI("file:///test.dsp")
+	code := "gain = hslider(\"gain\", 0.5, 0, 1, 0.01);\nprocess = _ * gain : _ * button(\"mute\");\n"
+	sel := func(line, start, end uint32) transport.Range {
+		return transport.Range{
+			Start: transport.Position{Line: line, Character: start},
+			End:   transport.Position{Line: line, Character: end},
+		}
+	}
+
+	actions := server.GroupActions(parseRoot(t, []byte(code)), []byte(code), uri, sel(0, 7, 39), string(transport.UTF16))
+	if len(actions) != 3 || actions[0].Command == nil || actions[2].Title != "Wrap in tgroup" {
+		t.Fatalf("Expected hgroup, vgroup and tgroup actions, got %v", actions)
+	}
+
+	edit, ok := server.WrapInGroupEdit(parseRoot(t, []byte(code)), []byte(code), sel(0, 7, 39), string(transport.UTF16), "vgroup", `Main "out"`)
+	if !ok || edit.NewText != `vgroup("Main \"out\"", hslider("gain", 0.5, 0, 1, 0.01))` || edit.Range != sel(0, 7, 39) {
+		t.Errorf("Unexpected edit %v", edit)
+	}
+	edit, ok = server.WrapInGroupEdit(parseRoot(t, []byte(code)), []byte(code), sel(1, 21, 39), string(transport.UTF16), "hgroup", "")
+	if !ok || edit.NewText != `hgroup("group", _ * button("mute"))` {
+		t.Errorf("Unexpected edit %v", edit)
+	}
+
+	// Expressions without UI elements and invalid groups
+	if actions := server.GroupActions(parseRoot(t, []byte(code)), []byte(code), uri, sel(1, 10, 18), string(transport.UTF16)); len(actions) != 0 {
+		t.Errorf("Expected no actions for expression without UI elements, got %v", actions)
+	}
+	if _, ok := server.WrapInGroupEdit(parseRoot(t, []byte(code)), []byte(code), sel(0, 7, 39), string(transport.UTF16), "xgroup", ""); ok {
+		t.Errorf("Expected xgroup to be refused")
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := server.NewIgnoreRules([]string{
+		"# comment",
+		"build/",
+		"*.bak",
+		"/generated",
+		"docs/**/*.dsp",
+		"!docs/keep/example.dsp",
+	})
+	tests := []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"build", true, true},
+		{"build", false, false},
+		{"build/main.cpp", false, true},
+		{"src/build/out.dsp", false, true},
+		{"a.dsp.bak", false, true},
+		{"libs/old.bak", false, true},
+		{"generated/a.dsp", false, true},
+		{"src/generated/a.dsp", false, false},
+		{"docs/a/b/c.dsp", false, true},
+		{"docs/keep/example.dsp", false, false},
+		{"main.dsp", false, false},
+	}
+	for _, tt := range tests {
+		if ignored := rules.Ignored(tt.rel, tt.isDir); ignored != tt.ignored {
+			t.Errorf("Ignored(%s, %t) = %t, expected %t", tt.rel, tt.isDir, ignored, tt.ignored)
+		}
+	}
+}
+
+func TestReportIgnoredFiles(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	files := map[string]string{
+		"main.dsp":                "process = _;\n",
+		"node_modules/pkg/a.lib":  "a = 1;\n",
+		".git/hooks/b.dsp":        "process = _;\n",
+		"old/c.dsp":               "process = _;\n",
+		"vendor/d.lib":            "d = 1;\n",
+		"diagrams/main-svg/e.lib": "e = 1;\n",
+		".gitignore":              "old/\n",
+		".faustcfg.json":          `{"exclude": ["vendor/"], "compiler_diagnostics": false, "process_files": ["main.dsp"]}`,
+		"libs/gain.lib":           "gain = *(0.5);\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	// Defaults, .gitignore and the exclude patterns of the config all leave files out
+	r, err := server.ProjectReport(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, file := range r.Files {
+		paths = append(paths, file.Path)
+	}
+	if want := []string{"libs/gain.lib", "main.dsp"}; !slices.Equal(paths, want) {
+		t.Errorf("Expected the files of the report to be %v, got %v", want, paths)
+	}
+}
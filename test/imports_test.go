@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestAbsoluteImports(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	root := t.TempDir()
+	include := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "libs"), 0755)
+	local := filepath.Join(root, "libs", "local.lib")
+	shared := filepath.Join(include, "shared.lib")
+	os.WriteFile(local, []byte("a = 1;"), 0644)
+	os.WriteFile(shared, []byte("b = 2;"), 0644)
+
+	workspace := server.Workspace{Root: root}
+	workspace.Config.IncludeDir = []string{include}
+
+	// Absolute paths and include directories are resolved
+	if path, _ := workspace.ResolveFilePath(local, root); path != local {
+		t.Errorf("ResolveFilePath(%s) = %s", local, path)
+	}
+	if path, dir := workspace.ResolveFilePath("shared.lib", root); path != shared || dir != include {
+		t.Errorf("ResolveFilePath(shared.lib) = %s, %s", path, dir)
+	}
+
+	code := fmt.Sprintf("import(%q);\nimport(\"stdfaust.lib\");\ns = library(%q);\n", local, shared)
+	diagnostics := server.ImportPathDiagnostics(parseRoot(t, []byte(code)), []byte(code))
+	if len(diagnostics) != 2 || diagnostics[0].Range.Start.Line != 0 || diagnostics[1].Range.Start.Line != 2 {
+		t.Fatalf("ImportPathDiagnostics() = %v, want warnings for both absolute imports", diagnostics)
+	}
+
+	main := filepath.Join(root, "synths", "main.dsp")
+	if got, _ := workspace.PortableImportPath(main, local); got != "libs/local.lib" {
+		t.Errorf("PortableImportPath(%s) = %s, want libs/local.lib", local, got)
+	}
+	if got, _ := workspace.PortableImportPath(main, shared); got != "shared.lib" {
+		t.Errorf("PortableImportPath(%s) = %s, want shared.lib", shared, got)
+	}
+	// Files outside the directories imports are looked up in are imported relative to the importing file
+	outside := filepath.Join(filepath.Dir(root), "other", "outside.lib")
+	if got, _ := workspace.PortableImportPath(main, outside); got != "../../other/outside.lib" {
+		t.Errorf("PortableImportPath(%s) = %s, want ../../other/outside.lib", outside, got)
+	}
+
+	if workspace.IsExternalFile(local) || !workspace.IsExternalFile(shared) {
+		t.Errorf("IsExternalFile() should only be true for files outside %s", root)
+	}
+}
+
+func TestMissingImportActions(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	uri := transport.DocumentURI("file:///test.dsp")
+	code := "declare name \"test\";\nprocess = fi.lowpass(1, 1000) : ma.SR;\n"
+	line := transport.Range{Start: transport.Position{Line: 1}, End: transport.Position{Line: 1, Character: 38}}
+
+	actions := server.MissingImportActions(parseRoot(t, []byte(code)), []byte(code), uri, line, nil)
+	titles := []string{}
+	for _, a := range actions {
+		titles = append(titles, a.Title)
+	}
+	want := []string{`Define fi = library("filters.lib")`, `Define ma = library("maths.lib")`, `Import "stdfaust.lib"`}
+	if !slices.Equal(titles, want) {
+		t.Fatalf("MissingImportActions() titles = %v, want %v", titles, want)
+	}
+	edit := actions[2].Edit.Changes[uri][0]
+	if edit.NewText != "import(\"stdfaust.lib\");\n" || edit.Range.Start != (transport.Position{}) || !actions[2].IsPreferred {
+		t.Errorf("Unexpected import edit %v", edit)
+	}
+
+	// Diagnostics from the compiler are fixed too, and new imports go after existing ones
+	code = "import(\"a.lib\");\nprocess = os.osc(440);\n"
+	diagnostic := transport.Diagnostic{Message: "undefined symbol : os", Source: "faust"}
+	actions = server.MissingImportActions(parseRoot(t, []byte(code)), []byte(code), uri, transport.Range{}, []transport.Diagnostic{diagnostic})
+	if len(actions) != 2 || len(actions[1].Diagnostics) != 1 {
+		t.Fatalf("Expected fixes for the undefined os prefix, got %v", actions)
+	}
+	if start := actions[1].Edit.Changes[uri][0].Range.Start; start != (transport.Position{Line: 1}) {
+		t.Errorf("Expected import to be inserted at line 1, got %v", start)
+	}
+
+	// Nothing to fix once the library is imported or the prefix is defined
+	for _, code := range []string{
+		"import(\"stdfaust.lib\");\nprocess = fi.lowpass(1, 1000);\n",
+		"fi = library(\"filters.lib\");\nprocess = fi.lowpass(1, 1000);\n",
+	} {
+		if actions := server.MissingImportActions(parseRoot(t, []byte(code)), []byte(code), uri, line, nil); len(actions) != 0 {
+			t.Errorf("Expected no fixes for %q, got %v", code, actions)
+		}
+	}
+}
+
+func TestComponentAndLibraryExpressions(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := []byte("osc = component(\"osc.dsp\")[freq = 440;];\nprocess = library(\"filters.lib\").lowpass(3, 1000);\n")
+	paths := []string{}
+	for _, imported := range server.ImportedFiles(parseRoot(t, code), code) {
+		paths = append(paths, imported.Path)
+	}
+	if !slices.Equal(paths, []string{"osc.dsp", "filters.lib"}) {
+		t.Errorf("ImportedFiles() = %v, want the component and the library", paths)
+	}
+
+	for _, tt := range []struct {
+		offset uint
+		want   string
+	}{
+		{18, "osc.dsp"},
+		{63, "filters.lib"},
+		{6, ""},
+		{77, ""},
+	} {
+		imported, ok := server.ImportAt(parseRoot(t, code), code, tt.offset)
+		if imported.Path != tt.want || ok != (tt.want != "") {
+			t.Errorf("ImportAt(%d) = %q, %v, want %q", tt.offset, imported.Path, ok, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{`process = library("filters.lib").`, "filters.lib"},
+		{`process = library ( "filters.lib" ) .`, "filters.lib"},
+		{`process = fi.`, ""},
+		{`process = library("filters.lib")`, ""},
+	} {
+		library, ok := server.LibraryAccessAt([]byte(tt.text), uint(len(tt.text)))
+		if library != tt.want || ok != (tt.want != "") {
+			t.Errorf("LibraryAccessAt(%q) = %q, %v, want %q", tt.text, library, ok, tt.want)
+		}
+	}
+}
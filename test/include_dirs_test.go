@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestMissingImportFile(t *testing.T) {
+	for message, expected := range map[string]string{
+		"ERROR : unable to open file filters2.lib\n": "filters2.lib",
+		`cannot open file "dsp/osc.lib"`:             "dsp/osc.lib",
+		"ERROR : undefined symbol : foo":             "",
+	} {
+		file, ok := server.MissingImportFile(message)
+		if file != expected || ok != (expected != "") {
+			t.Errorf("MissingImportFile(%q) = %q, %v, expected %q", message, file, ok, expected)
+		}
+	}
+}
+
+func TestInferIncludeDir(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "project")
+	files := []util.Path{
+		filepath.Join(root, "main.dsp"),
+		filepath.Join(root, "vendor", "libs", "osc.lib"),
+		filepath.Join(root, "libs", "osc.lib"),
+		filepath.Join(root, "third", "dsp", "fx.lib"),
+	}
+	tests := []struct {
+		importPath string
+		dir        util.Path
+	}{
+		{"osc.lib", "libs"},
+		{"dsp/fx.lib", "third"},
+		{"fx.lib", filepath.Join("third", "dsp")},
+		// Files at the root are already found
+		{"main.dsp", ""},
+		{"missing.lib", ""},
+	}
+	for _, tt := range tests {
+		dir, ok := server.InferIncludeDir(files, root, tt.importPath)
+		if dir != tt.dir || ok != (tt.dir != "") {
+			t.Errorf("InferIncludeDir(%q) = %q, %v, expected %q", tt.importPath, dir, ok, tt.dir)
+		}
+	}
+}
+
+func TestAddIncludeDir(t *testing.T) {
+	content, err := server.AddIncludeDir([]byte(`{"process_name": "main", "include": ["libs"]}`), filepath.Join("third", "dsp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		ProcessName string   `json:"process_name"`
+		Include     []string `json:"include"`
+	}
+	json.Unmarshal(content, &config)
+	if config.ProcessName != "main" || !slices.Equal(config.Include, []string{"libs", "third/dsp"}) {
+		t.Errorf("Unexpected config %s", content)
+	}
+
+	// Directories already included aren't added again
+	again, _ := server.AddIncludeDir(content, "libs")
+	if string(again) != string(content) {
+		t.Errorf("Expected config to be unchanged, got %s", again)
+	}
+
+	created, _ := server.AddIncludeDir(nil, "libs")
+	if string(created) != "{\n  \"include\": [\n    \"libs\"\n  ]\n}\n" {
+		t.Errorf("Unexpected new config %q", created)
+	}
+	if _, err := server.AddIncludeDir([]byte("{"), "libs"); err == nil {
+		t.Errorf("Expected invalid config to be an error")
+	}
+}
@@ -2,10 +2,14 @@ package tests
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/server"
 	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 )
 
 func TestGetLines(t *testing.T) {
@@ -510,3 +514,73 @@ func TestApplyIncrementalChange(t *testing.T) {
 		})
 	}
 }
+
+func TestLongLines(t *testing.T) {
+	if got := server.LongestLine([]byte("ab\nabcd\r\nabc")); got != 5 {
+		t.Errorf("Expected the longest line to have 5 bytes, got %d", got)
+	}
+	if got := server.LongestLine([]byte{}); got != 0 {
+		t.Errorf("Expected no line length for empty content, got %d", got)
+	}
+
+	// Positions in a multi-megabyte single line are still mapped exactly
+	line := strings.Repeat("a, ", 1<<20) + "😀b"
+	offset := uint(len(line) - 1)
+	pos, err := server.OffsetToPosition(offset, "x\n"+line, "utf-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := transport.Position{Line: 1, Character: uint32(3<<20) + 2}
+	if pos != want {
+		t.Errorf("Expected %v, got %v", want, pos)
+	}
+	back, err := server.PositionToOffset(pos, "x\n"+line, "utf-16")
+	if err != nil || back != offset+2 {
+		t.Errorf("Expected offset %d back, got %d (%v)", offset+2, back, err)
+	}
+}
+
+func TestLineIndexEdits(t *testing.T) {
+	logging.Init()
+	content := "process = _;\nfoo = 1;\n\nbar = 2;"
+	edits := []struct {
+		start, end uint
+		text       string
+	}{
+		{0, 0, "// 😀\n"},
+		{5, 14, ""},
+		{3, 3, "a\nb\n\nc"},
+		{uint(len(content)) - 2, uint(len(content)), "\n"},
+		{0, 10, "x"},
+	}
+	lines := server.NewLineIndex(content)
+	for _, e := range edits {
+		start, end := min(e.start, uint(len(content))), min(e.end, uint(len(content)))
+		lines = lines.Edited(start, end, e.text)
+		content = content[:start] + e.text + content[end:]
+		if want := server.NewLineIndex(content); !slices.Equal(lines, want) {
+			t.Fatalf("Expected line index %v after replacing %d-%d with %q, got %v", want, start, end, e.text, lines)
+		}
+	}
+
+	// Files keep their line index along with incremental changes
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := "/tmp/faustlsp-lines.dsp"
+	files.Add(util.FromPath(path), []byte("a = 1;\nb = 2;\n"))
+	files.ModifyIncremental(path, transport.Range{Start: transport.Position{Line: 1, Character: 0}, End: transport.Position{Line: 1, Character: 1}}, "😀\nc")
+	f, _ := files.GetFromPath(path)
+	if string(f.Content) != "a = 1;\n😀\nc = 2;\n" {
+		t.Fatalf("Unexpected content %q", f.Content)
+	}
+	for offset := uint(0); offset <= uint(len(f.Content)); offset++ {
+		want, _ := server.OffsetToPosition(offset, string(f.Content), "utf-16")
+		if got, _ := f.OffsetToPosition(offset, "utf-16"); got != want {
+			t.Errorf("Expected offset %d at %v, got %v", offset, want, got)
+		}
+		wantOffset, _ := server.PositionToOffset(want, string(f.Content), "utf-16")
+		if got, _ := f.PositionToOffset(want, "utf-16"); got != wantOffset {
+			t.Errorf("Expected %v at offset %d, got %d", want, wantOffset, got)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestIndexFiles(t *testing.T) {
+	paths := []util.Path{}
+	for i := range 20 {
+		paths = append(paths, fmt.Sprintf("/project/%d.lib", i))
+	}
+
+	var running, mostRunning atomic.Int64
+	var mu sync.Mutex
+	analyzed := []util.Path{}
+	counts := []int{}
+	server.IndexFiles(paths, 3, func(path util.Path) {
+		n := running.Add(1)
+		for {
+			most := mostRunning.Load()
+			if n <= most || mostRunning.CompareAndSwap(most, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		mu.Lock()
+		analyzed = append(analyzed, path)
+		mu.Unlock()
+	}, func(path util.Path, done int) {
+		mu.Lock()
+		counts = append(counts, done)
+		mu.Unlock()
+	})
+
+	slices.Sort(analyzed)
+	expected := slices.Clone(paths)
+	slices.Sort(expected)
+	if !slices.Equal(analyzed, expected) {
+		t.Errorf("Expected every file to be analyzed once, got %v", analyzed)
+	}
+	if mostRunning.Load() > 3 {
+		t.Errorf("Expected at most 3 files analyzed at once, got %d", mostRunning.Load())
+	}
+	slices.Sort(counts)
+	if len(counts) != len(paths) || counts[0] != 1 || counts[len(counts)-1] != len(paths) {
+		t.Errorf("Unexpected progress counts %v", counts)
+	}
+
+	// Nothing to index
+	server.IndexFiles(nil, 3, func(util.Path) { t.Errorf("Expected nothing to analyze") }, func(util.Path, int) {})
+}
+
+func TestFilesContentLimit(t *testing.T) {
+	logging.Init()
+	dir := t.TempDir()
+	paths := []util.Path{}
+	for i := range 3 {
+		path := filepath.Join(dir, fmt.Sprintf("%d.lib", i))
+		os.WriteFile(path, []byte(fmt.Sprintf("a%d = 1;\n", i)), 0644)
+		paths = append(paths, path)
+	}
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	files.SetContentLimit(16)
+	for _, path := range paths {
+		files.OpenFromPath(path)
+	}
+	// Each file has 8 bytes, so only the two last ones fit
+	if resident := files.ResidentContent(); resident != 16 {
+		t.Errorf("Expected 16 bytes of content in memory, got %d", resident)
+	}
+
+	// Dropped contents are read again from disk when the file is gotten, dropping the least recently used one instead
+	f, ok := files.GetFromPath(paths[0])
+	if !ok || string(f.Content) != "a0 = 1;\n" {
+		t.Fatalf("Expected the content of %s to be read again, got %v", paths[0], f)
+	}
+	if resident := files.ResidentContent(); resident != 16 {
+		t.Errorf("Expected 16 bytes of content in memory after reading one again, got %d", resident)
+	}
+
+	// Files open in the editor aren't counted and keep their content
+	files.MarkOpen(paths[1], true)
+	files.ModifyFull(paths[1], "unsaved = 2;\n")
+	// Writes on disk don't replace the buffer of the editor, and only change closed files with new content
+	if files.ModifyFromDisk(paths[1], []byte("saved = 2;\n")) {
+		t.Error("Expected the open file to keep its buffer over the content on disk")
+	}
+	if !files.ModifyFromDisk(paths[2], []byte("a2 = 3;\n")) || files.ModifyFromDisk(paths[2], []byte("a2 = 3;\n")) {
+		t.Error("Expected only the first write of new content to change the closed file")
+	}
+	files.SetContentLimit(1)
+	if f, _ := files.GetFromPath(paths[1]); string(f.Content) != "unsaved = 2;\n" {
+		t.Errorf("Expected the open file to keep its unsaved content, got %q", f.Content)
+	}
+	if resident := files.ResidentContent(); resident > 8 {
+		t.Errorf("Expected at most the file gotten last in memory, got %d bytes", resident)
+	}
+}
+
+func TestIndexOnlyFaustFiles(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("notes\n"), 0644)
+	os.WriteFile(filepath.Join(root, "rain.wav"), []byte("RIFF"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	content, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, content); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	if _, ok := s.Files.GetFromPath(filepath.Join(root, "main.dsp")); !ok {
+		t.Error("Expected main.dsp to be in the store")
+	}
+	// Other files aren't read, the compiler finding those it needs, like soundfiles, in the workspace
+	if _, ok := s.Files.GetFromPath(filepath.Join(root, "notes.txt")); ok {
+		t.Error("Expected notes.txt not to be read into the store")
+	}
+}
+
+func TestInitialDiagnostics(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	for i := range 20 {
+		os.WriteFile(filepath.Join(root, fmt.Sprintf("%d.lib", i)), []byte(fmt.Sprintf("a%d = 1;\n", i)), 0644)
+	}
+	broken := filepath.Join(root, "broken.dsp")
+	os.WriteFile(broken, []byte("process = +(;\n"), 0644)
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(`{"compiler_diagnostics": false}`), 0644)
+
+	serverConn, clientConn := net.Pipe()
+	var s server.Server
+	s.InitConn(serverConn)
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	go s.Run(t.Context())
+	var tr transport.Transport
+	tr.InitConn(transport.Client, clientConn)
+	defer tr.Close()
+
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	request(t, &tr, 1, "initialize", params)
+	tr.WriteNotif("initialized", []byte("{}"))
+
+	// Files read when initializing are diagnosed as they are indexed
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := tr.Read()
+		if err != nil {
+			t.Fatalf("Expected the syntax error of broken.dsp to be published, got %v", err)
+		}
+		var notification transport.NotificationMessage
+		json.Unmarshal(msg, &notification)
+		if notification.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var diagnostics transport.PublishDiagnosticsParams
+		json.Unmarshal(notification.Params, &diagnostics)
+		if diagnostics.URI == transport.DocumentURI(util.Path2URI(broken)) && len(diagnostics.Diagnostics) > 0 {
+			break
+		}
+	}
+	clientConn.SetReadDeadline(time.Time{})
+	request(t, &tr, 2, "shutdown", nil)
+}
+
+func TestRestartIndex(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	content, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, content); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	// Files and exclude patterns added while the server wasn't looking
+	for _, dir := range []string{"node_modules", "vendor"} {
+		os.MkdirAll(filepath.Join(root, dir), 0755)
+		os.WriteFile(filepath.Join(root, dir, "a.lib"), []byte("a = 1;\n"), 0644)
+	}
+	os.WriteFile(filepath.Join(root, "new.lib"), []byte("b = 2;\n"), 0644)
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(`{"exclude": ["vendor/"]}`), 0644)
+	if _, err := server.RestartIndexCommand(t.Context(), &s, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Files.GetFromPath(filepath.Join(root, "new.lib")); !ok {
+		t.Error("Expected new.lib to be indexed")
+	}
+	// Ignored directories are left out, with the exclude patterns of the config the index was restarted with
+	for _, dir := range []string{"node_modules", "vendor"} {
+		if _, ok := s.Files.GetFromPath(filepath.Join(root, dir, "a.lib")); ok {
+			t.Errorf("Expected %s to be left out of the index", dir)
+		}
+	}
+}
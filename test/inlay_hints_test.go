@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+func TestParameterInlayHints(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := "f(x, y, z) = x + y + z;\nprocess = fi.resonlp(1000, q, 0.5) + f(1)(y, 3) + g(2);\n"
+	parameters := func(callee *tree_sitter.Node) []string {
+		switch callee.Utf8Text([]byte(code)) {
+		case "fi.resonlp":
+			return []string{"fc", "q", "gain"}
+		case "f":
+			return []string{"x", "y", "z"}
+		}
+		return nil
+	}
+	all := transport.Range{End: transport.Position{Line: 2}}
+	hints := server.ParameterInlayHints(parseRoot(t, []byte(code)), []byte(code), all, parameters)
+
+	want := []struct {
+		character uint32
+		label     string
+	}{
+		{21, "fc:"},
+		{30, "gain:"},
+		{39, "x:"},
+		{45, "z:"},
+	}
+	if len(hints) != len(want) {
+		t.Fatalf("ParameterInlayHints() = %+v, want %d hints", hints, len(want))
+	}
+	for i, w := range want {
+		h := hints[i]
+		if h.Position != (transport.Position{Line: 1, Character: w.character}) || h.Label[0].Value != w.label || h.Kind != transport.Parameter {
+			t.Errorf("Hint %d = %+v, want %s at character %d", i, h, w.label, w.character)
+		}
+	}
+
+	// Hints outside the requested range are left out
+	line := transport.Range{Start: transport.Position{Line: 1, Character: 35}, End: transport.Position{Line: 1, Character: 60}}
+	if hints := server.ParameterInlayHints(parseRoot(t, []byte(code)), []byte(code), line, parameters); len(hints) != 2 {
+		t.Errorf("ParameterInlayHints() in range = %+v, want the hints of f", hints)
+	}
+}
+
+func TestArityInlayHints(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := "process = _,_ <: _,_,_,_ :> _ : fi.lowpass(1, 1000);\n"
+	hints := server.ArityInlayHints(parseRoot(t, []byte(code)), []byte(code), transport.Range{End: transport.Position{Line: 1}}, "single")
+	labels := []string{}
+	for _, h := range hints {
+		labels = append(labels, h.Label[0].Value)
+	}
+	// Chains are parsed to the right, so operands next to the operators are compared, even when the arity of the rest of the chain is unknown
+	if !slices.Equal(labels, []string{"2→4", "4→1"}) || hints[0].Position.Character != 16 || hints[1].Position.Character != 27 {
+		t.Errorf("ArityInlayHints() = %+v, want 2→4 after <: and 4→1 after :>", hints)
+	}
+}
+
+func TestInlayHintsConfig(t *testing.T) {
+	var config server.FaustProjectConfig
+	json.Unmarshal([]byte(`{"inlay_hints": {"arity": false}}`), &config)
+	if !config.InlayHints.Parameters || config.InlayHints.Arity || !config.InlayHints.Banks {
+		t.Errorf("InlayHints = %+v, want only arity hints disabled", config.InlayHints)
+	}
+	json.Unmarshal([]byte(`{}`), &config)
+	if !config.InlayHints.Parameters || !config.InlayHints.Arity || !config.InlayHints.Banks {
+		t.Errorf("InlayHints = %+v, want all hints by default", config.InlayHints)
+	}
+}
@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestLibraryCheckWrapper(t *testing.T) {
+	parser.Init()
+	content := []byte(`declare name "gains";
+gain = hslider("gain", 0.5, 0, 1, 0.01);
+stereo = gain, gain;
+amp(g) = *(g);
+label = "volume";
+env = environment { x = 1; };
+gain = 1;
+`)
+	wrapper := server.LibraryCheckWrapper("/project/gains.lib", content)
+	lines := strings.Split(strings.TrimSpace(wrapper), "\n")
+	if len(lines) != 2 || lines[0] != `import("/project/gains.lib");` {
+		t.Fatalf("Expected the wrapper to import the library, got %q", wrapper)
+	}
+	// Functions, strings and environments aren't diagrams the wrapper can check
+	if expected := "faustlsp_library_check = gain, stereo;"; lines[1] != expected {
+		t.Errorf("Expected %q, got %q", expected, lines[1])
+	}
+
+	if wrapper := server.LibraryCheckWrapper("/project/empty.lib", []byte("f(x) = x;\n")); !strings.HasSuffix(wrapper, "faustlsp_library_check = 0;\n") {
+		t.Errorf("Expected a library without definitions to only be imported, got %q", wrapper)
+	}
+}
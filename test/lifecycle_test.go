@@ -3,10 +3,16 @@ package tests
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/server"
 	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
 
 	"testing"
 	"time"
@@ -18,7 +24,9 @@ func TestExitWithoutError(t *testing.T) {
 	var s server.Server
 
 	runserver := func() error {
-		s.Init(transport.Socket)
+		if err := s.Init(transport.Socket); err != nil {
+			return err
+		}
 		err := s.Run(context.Background())
 		s.Transport.Close()
 		return err
@@ -28,7 +36,7 @@ func TestExitWithoutError(t *testing.T) {
 
 	go func() {
 		var tr transport.Transport
-		tr.Init(transport.Client, transport.Socket)
+		tr.InitConn(transport.Client, dialServer(t))
 		msg, _ := json.Marshal(transport.ParamInitialize{
 			XInitializeParams: transport.XInitializeParams{
 				RootPath: "",
@@ -48,6 +56,21 @@ func TestExitWithoutError(t *testing.T) {
 	}
 }
 
+// Connects to a server listening on the default port, waiting for it to listen
+func dialServer(t *testing.T) net.Conn {
+	address := net.JoinHostPort("localhost", strconv.Itoa(transport.DefaultPort))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Error(err)
+			return nil
+		}
+	}
+}
+
 func TestExitWithError(t *testing.T) {
 	logging.Init()
 	logging.Logger.Info("Starting")
@@ -55,8 +78,11 @@ func TestExitWithError(t *testing.T) {
 	var s server.Server
 	ctx, cancel := context.WithCancel(context.Background())
 	runserver := func() error {
-		s.Init(transport.Socket)
+		if err := s.Init(transport.Socket); err != nil {
+			return err
+		}
 		err := s.Run(ctx)
+		s.Transport.Close()
 		return err
 	}
 
@@ -64,7 +90,7 @@ func TestExitWithError(t *testing.T) {
 
 	go func() {
 		var tr transport.Transport
-		tr.Init(transport.Client, transport.Socket)
+		tr.InitConn(transport.Client, dialServer(t))
 		msg, _ := json.Marshal(transport.ParamInitialize{
 			XInitializeParams: transport.XInitializeParams{
 				RootPath: "",
@@ -83,3 +109,167 @@ func TestExitWithError(t *testing.T) {
 		t.Errorf("Exit should not have been graceful")
 	}
 }
+
+func TestInitializeAfterShutdown(t *testing.T) {
+	var s server.Server
+	s.Status = server.Shutdown
+
+	if err := s.ValidateMethod("initialize"); err != nil {
+		t.Errorf("Expected initialize to be allowed after shutdown, got %s", err)
+	}
+	if err := s.ValidateMethod("exit"); err != nil {
+		t.Errorf("Expected exit to be allowed after shutdown, got %s", err)
+	}
+	if err := s.ValidateMethod("textDocument/hover"); err == nil {
+		t.Errorf("Expected textDocument/hover to be refused after shutdown")
+	}
+}
+
+func TestServeSequentialClients(t *testing.T) {
+	logging.Init()
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, ln) }()
+
+	params, _ := json.Marshal(transport.ParamInitialize{})
+	// Each client starts from a server that wasn't initialized, whether the previous one exited or just disconnected
+	for i, exit := range []bool{true, false, true} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tr transport.Transport
+		tr.InitConn(transport.Client, conn)
+		tr.WriteRequest(1, "initialize", params)
+		msg, err := tr.Read()
+		var resp transport.ResponseMessage
+		json.Unmarshal(msg, &resp)
+		if err != nil || resp.Error != nil || len(resp.Result) == 0 {
+			t.Fatalf("Client %d: expected initialize result, got %s, %v", i, msg, err)
+		}
+		if exit {
+			tr.WriteRequest(2, "shutdown", nil)
+			tr.Read()
+			tr.WriteNotif("exit", nil)
+		}
+		tr.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected serving to end without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected serving to end once canceled")
+	}
+}
+
+// Sends a request to the server and returns its response, skipping the messages the server sends before it
+func request(t *testing.T, tr *transport.Transport, id int, method string, params json.RawMessage) transport.ResponseMessage {
+	t.Helper()
+	if err := tr.WriteRequest(id, method, params); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := tr.Read()
+		if err != nil {
+			t.Fatalf("Expected a response to %s, got %v", method, err)
+		}
+		var resp transport.ResponseMessage
+		json.Unmarshal(msg, &resp)
+		if transport.IsResponse(msg) && resp.ID == float64(id) {
+			return resp
+		}
+	}
+}
+
+func TestShutdownRemovesOverlay(t *testing.T) {
+	logging.Init()
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	os.MkdirAll(filepath.Join(tmp, "faustlsp"), 0755)
+	overlays := func() []string {
+		dirs, _ := filepath.Glob(filepath.Join(tmp, "faustlsp", "faustlsp-*"))
+		return dirs
+	}
+
+	serverConn, clientConn := net.Pipe()
+	var s server.Server
+	s.InitConn(serverConn)
+	if len(overlays()) != 1 {
+		t.Fatalf("Expected the server to create its overlay, got %v", overlays())
+	}
+	ended := make(chan error, 1)
+	go func() { ended <- s.Run(t.Context()) }()
+
+	var tr transport.Transport
+	tr.InitConn(transport.Client, clientConn)
+	params, _ := json.Marshal(transport.ParamInitialize{})
+	// Clients can initialize again after shutting down, with a new overlay
+	for i := range 2 {
+		request(t, &tr, 3*i+1, "initialize", params)
+		if dirs := overlays(); len(dirs) != 1 {
+			t.Fatalf("Expected one overlay once initialized, got %v", dirs)
+		}
+		tr.WriteNotif("initialized", []byte("{}"))
+		if resp := request(t, &tr, 3*i+2, "shutdown", nil); resp.Error != nil {
+			t.Fatalf("Expected shutdown to succeed, got %v", resp.Error)
+		}
+		if dirs := overlays(); len(dirs) != 0 {
+			t.Fatalf("Expected the overlay to be removed before answering shutdown, got %v", dirs)
+		}
+	}
+
+	tr.WriteNotif("exit", nil)
+	tr.Flush()
+	go func() {
+		for {
+			if _, err := tr.Read(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-ended:
+		if err != nil {
+			t.Errorf("Expected exit after shutdown to be graceful, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the server to end after exit")
+	}
+	tr.Close()
+}
+
+func TestInitializeOtherRoot(t *testing.T) {
+	logging.Init()
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+
+	// A client initializing again without shutting down replaces the workspace tracked so far
+	for _, name := range []string{"a", "b"} {
+		root := filepath.Join(t.TempDir(), name)
+		os.MkdirAll(root, 0755)
+		os.WriteFile(filepath.Join(root, name+".dsp"), []byte("process = _;\n"), 0644)
+		params, _ := json.Marshal(transport.ParamInitialize{
+			XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+		})
+		if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+			t.Fatal(err)
+		}
+		server.Initialized(t.Context(), &s, []byte("{}"))
+		if s.Workspace.Root != root {
+			t.Errorf("Expected the workspace root to be %s, got %s", root, s.Workspace.Root)
+		}
+		if _, ok := s.Files.GetFromPath(filepath.Join(root, name+".dsp")); !ok {
+			t.Errorf("Expected %s.dsp to be indexed", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestLinkedParameterRanges(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	code := `gain = 0.5;
+amp(gain, x) = x * gain + g with { g(gain) = gain; };
+process = amp(1, 2);
+`
+	path := filepath.Join(dir, "main.dsp")
+	os.WriteFile(path, []byte(code), 0644)
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	store := server.Store{
+		Files:        &files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	workspace := server.Workspace{Root: dir}
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+	files.OpenFromPath(path)
+	f, _ := files.GetFromPath(path)
+	workspace.ParseFile(f, &store, make(map[util.Path]struct{}), fileChan)
+
+	// The parameter in the head and its use in the body, not the shadowing parameter of g or the top-level definition
+	offset := uint(strings.Index(code, "amp(gain") + len("amp("))
+	ranges := server.LinkedParameterRanges(parseRoot(t, []byte(code)), []byte(code), f.Scope, &store, path, offset)
+	want := []transport.Range{
+		{Start: transport.Position{Line: 1, Character: 4}, End: transport.Position{Line: 1, Character: 8}},
+		{Start: transport.Position{Line: 1, Character: 19}, End: transport.Position{Line: 1, Character: 23}},
+	}
+	if len(ranges) != len(want) || ranges[0] != want[0] || ranges[1] != want[1] {
+		t.Fatalf("LinkedParameterRanges() = %v, want %v", ranges, want)
+	}
+
+	// Uses in the body link back to the parameter
+	offset = uint(strings.Index(code, "* gain") + 2)
+	if ranges := server.LinkedParameterRanges(parseRoot(t, []byte(code)), []byte(code), f.Scope, &store, path, offset); len(ranges) != 2 || ranges[0] != want[0] {
+		t.Errorf("LinkedParameterRanges() from the body = %v, want %v", ranges, want)
+	}
+
+	// Top-level definitions aren't parameters
+	if ranges := server.LinkedParameterRanges(parseRoot(t, []byte(code)), []byte(code), f.Scope, &store, path, 1); len(ranges) != 0 {
+		t.Errorf("LinkedParameterRanges() of a definition = %v, want none", ranges)
+	}
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestDocumentLinks(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := `declare name "test";
+declare url "see https://faust.grame.fr for docs";
+import("stdfaust.lib");
+import("missing.lib");
+fi = library("filters.lib");
+process = component("osc.dsp");
+`
+	libs := map[string]util.Path{
+		"stdfaust.lib": "/usr/share/faust/stdfaust.lib",
+		"filters.lib":  "/usr/share/faust/filters.lib",
+		"osc.dsp":      "/home/user/osc.dsp",
+	}
+	resolve := func(path string) util.Path { return libs[path] }
+	links := server.DocumentLinks(parseRoot(t, []byte(code)), []byte(code), string(transport.UTF16), resolve)
+
+	want := []struct {
+		line, start, end uint32
+		target           string
+	}{
+		{1, 17, 39, "https://faust.grame.fr"},
+		{2, 8, 20, util.Path2URI("/usr/share/faust/stdfaust.lib")},
+		{4, 14, 25, util.Path2URI("/usr/share/faust/filters.lib")},
+		{5, 21, 28, util.Path2URI("/home/user/osc.dsp")},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("Expected %d links, got %v", len(want), links)
+	}
+	for i, w := range want {
+		l := links[i]
+		if l.Range.Start.Line != w.line || l.Range.Start.Character != w.start || l.Range.End.Character != w.end {
+			t.Errorf("Link %d has range %v, expected line %d [%d, %d)", i, l.Range, w.line, w.start, w.end)
+		}
+		if l.Target == nil || string(*l.Target) != w.target {
+			t.Errorf("Link %d has target %v, expected %s", i, l.Target, w.target)
+		}
+	}
+}
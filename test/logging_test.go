@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestLoggingOutputAndLevel(t *testing.T) {
+	defer logging.Init()
+	defer logging.SetLevel("info")
+
+	path := filepath.Join(t.TempDir(), "faustlsp.log")
+	if err := logging.SetOutput(path); err != nil {
+		t.Fatal(err)
+	}
+	if logging.Path() != path {
+		t.Errorf("Expected the log path to be %s, got %s", path, logging.Path())
+	}
+	if err := logging.SetLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	logging.Logger.Info("Hidden record")
+	logging.Logger.Warn("Shown record", "path", "/a.dsp")
+
+	content, _ := os.ReadFile(path)
+	if strings.Contains(string(content), "Hidden record") || !strings.Contains(string(content), `"msg":"Shown record","path":"/a.dsp"`) {
+		t.Errorf("Expected only records of at least the level, with their attributes, got %s", content)
+	}
+
+	if err := logging.SetLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be refused")
+	}
+	if err := logging.SetOutput(logging.Discard); err != nil || logging.Path() != "" {
+		t.Errorf("Expected discarding logs to leave no log path, got %q, %v", logging.Path(), err)
+	}
+	if err := logging.SetOutput(filepath.Join(t.TempDir(), "missing", "faustlsp.log")); err == nil {
+		t.Error("Expected logging to a file that can't be created to fail")
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	defer logging.Init()
+	maxSize, retention := logging.MaxSize, logging.Retention
+	defer func() { logging.MaxSize, logging.Retention = maxSize, retention }()
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := filepath.Join(os.TempDir(), "faustlsp")
+	os.MkdirAll(dir, 0750)
+	// Log file of another server
+	other := filepath.Join(dir, "log-1-2026-01-01-00-00-00.json")
+	os.WriteFile(other, nil, 0644)
+
+	logging.MaxSize, logging.Retention = 512, 3
+	if err := logging.SetOutput(""); err != nil {
+		t.Fatal(err)
+	}
+	first := logging.Path()
+	for range 20 {
+		logging.Logger.Info("Filling the log file", "padding", strings.Repeat("x", 100))
+	}
+	if logging.Path() == first {
+		t.Fatal("Expected a new log file once the first grew beyond the maximum size")
+	}
+	logs, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("log-%d-*.json", os.Getpid())))
+	if len(logs) != 3 {
+		t.Errorf("Expected only the 3 newest log files to be kept, got %v", logs)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected the log file of another server to be kept, got %v", err)
+	}
+	for _, log := range logs {
+		if info, _ := os.Stat(log); info.Size() > 512 {
+			t.Errorf("Expected %s to be rotated before growing beyond the maximum size, got %d bytes", log, info.Size())
+		}
+	}
+
+	logging.MaxSize = 0
+	if err := logging.SetOutput(""); err != nil {
+		t.Fatal(err)
+	}
+	unlimited := logging.Path()
+	for range 20 {
+		logging.Logger.Info("Filling the log file", "padding", strings.Repeat("x", 100))
+	}
+	if logging.Path() != unlimited {
+		t.Error("Expected a maximum size of 0 to keep writing to the same log file")
+	}
+
+	var s server.Server
+	result, err := server.OpenLogCommand(t.Context(), &s, nil)
+	var opened server.OpenLogResult
+	json.Unmarshal(result, &opened)
+	if err != nil || opened.Path != logging.Path() {
+		t.Errorf("Expected faustlsp.openLog to return the current log file %s, got %s, %v", logging.Path(), result, err)
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestMetadataHover(t *testing.T) {
+	parser.Init()
+	content := []byte(`declare name "Echo";
+declare author "Jane";
+declare echo license "MIT";
+import("delays.lib");
+de = library("missing.lib");
+process = _;
+`)
+	libraries := map[util.Path][]byte{
+		"/libs/delays.lib": []byte("declare name \"Faust Delay Library\";\ndeclare version \"1.2\";\ndelay(n) = @(n);\n"),
+	}
+	resolve := func(importPath string) util.Path {
+		if importPath == "delays.lib" {
+			return "/libs/delays.lib"
+		}
+		return ""
+	}
+	read := func(path util.Path) ([]byte, bool) {
+		content, ok := libraries[path]
+		return content, ok
+	}
+	hover := func(text string) (string, bool) {
+		return server.MetadataHover(parseRoot(t, content), content, uint(strings.Index(string(content), text)), resolve, read)
+	}
+
+	if docs, ok := hover("author"); !ok || docs != "**name**: Echo  \n**author**: Jane" {
+		t.Errorf("Expected the metadata of the file, got %q", docs)
+	}
+	if docs, ok := hover("license"); !ok || docs != "`echo`  \n**license**: MIT" {
+		t.Errorf("Expected the metadata of the function, got %q", docs)
+	}
+	docs, ok := hover("delays.lib")
+	if !ok || !strings.Contains(docs, "/libs/delays.lib") || !strings.Contains(docs, "**name**: Faust Delay Library  \n**version**: 1.2") {
+		t.Errorf("Expected the path and metadata of the imported library, got %q", docs)
+	}
+	if _, ok := hover("missing.lib"); ok {
+		t.Error("Expected no hover for a library that can't be found")
+	}
+	if _, ok := hover("process"); ok {
+		t.Error("Expected no metadata hover outside declare statements and imports")
+	}
+}
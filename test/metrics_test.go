@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestStatusMetrics(t *testing.T) {
+	logging.Init()
+	serverConn, clientConn := net.Pipe()
+	var s server.Server
+	s.InitConn(serverConn)
+	go s.Run(t.Context())
+	var tr transport.Transport
+	tr.InitConn(transport.Client, clientConn)
+	defer tr.Close()
+
+	params, _ := json.Marshal(transport.ParamInitialize{})
+	request(t, &tr, 1, "initialize", params)
+	tr.WriteNotif("initialized", []byte("{}"))
+	request(t, &tr, 2, "faustlsp/status", nil)
+	resp := request(t, &tr, 3, "faustlsp/status", nil)
+
+	var status server.Status
+	json.Unmarshal(resp.Result, &status)
+	for _, method := range []string{"initialize", "faustlsp/status"} {
+		if status.Metrics.Methods[method].Count == 0 {
+			t.Errorf("Expected the %s messages handled to be counted, got %+v", method, status.Metrics.Methods)
+		}
+	}
+	if initialize := status.Metrics.Methods["initialize"]; initialize.MaxMs < initialize.MeanMs || initialize.MeanMs <= 0 {
+		t.Errorf("Expected the time initialize took to be measured, got %+v", initialize)
+	}
+	if status.Metrics.UptimeSeconds <= 0 {
+		t.Errorf("Expected the uptime of the server, got %v", status.Metrics.UptimeSeconds)
+	}
+	request(t, &tr, 4, "shutdown", nil)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestNestedConfigs(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	deeper := filepath.Join(sub, "deeper")
+	os.MkdirAll(filepath.Join(sub, "libs"), 0755)
+	os.MkdirAll(deeper, 0755)
+	files := map[string]string{
+		".faustcfg.json":            `{"compiler_diagnostics": false, "process_files": ["main.dsp"], "formatter": "faustfmt"}`,
+		"main.dsp":                  "process = _;\n",
+		"sub/.faustcfg.json":        `{"process_files": ["synth.dsp"], "include": ["libs"], "textual_references": true}`,
+		"sub/synth.dsp":             "process = _;\n",
+		"sub/other.dsp":             "process = _;\n",
+		"sub/deeper/.faustcfg.json": `{"formatter": "builtin"}`,
+		"sub/deeper/fx.dsp":         "process = _;\n",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	w := &s.Workspace
+
+	if c := w.ConfigFor(filepath.Join(root, "main.dsp")); c.Formatter != server.FaustfmtFormatter || c.TextualReferences {
+		t.Errorf("Expected the root config for files outside subprojects, got %q and %v", c.Formatter, c.TextualReferences)
+	}
+	c := w.ConfigFor(filepath.Join(sub, "synth.dsp"))
+	if !c.TextualReferences || !slices.Equal(c.IncludeDir, []util.Path{filepath.Join(sub, "libs")}) {
+		t.Errorf("Expected the keys of the subproject's config, got %v and %v", c.TextualReferences, c.IncludeDir)
+	}
+	if c.Formatter != server.FaustfmtFormatter || c.CompilerDiagnostics {
+		t.Errorf("Expected the other keys of the root config, got %q and %v", c.Formatter, c.CompilerDiagnostics)
+	}
+	if c := w.ConfigFor(filepath.Join(deeper, "fx.dsp")); c.Formatter != server.BuiltinFormatter || !c.TextualReferences {
+		t.Errorf("Expected the configs of both directories above, the innermost last, got %q and %v", c.Formatter, c.TextualReferences)
+	}
+
+	// Process files are those listed by their own config
+	expected := []util.Path{filepath.Join(root, "main.dsp"), filepath.Join(sub, "synth.dsp")}
+	if got := w.ProcessFiles(); !slices.Equal(got, expected) {
+		t.Errorf("Expected process files %v, got %v", expected, got)
+	}
+
+	// Changing a nested config file applies to the files beneath it, once the workspace is watched
+	time.Sleep(100 * time.Millisecond)
+	os.WriteFile(filepath.Join(sub, ".faustcfg.json"), []byte(`{"process_files": ["synth.dsp", "other.dsp"]}`), 0644)
+	changed := false
+	for range 50 {
+		if !w.ConfigFor(filepath.Join(sub, "synth.dsp")).TextualReferences {
+			changed = true
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !changed {
+		t.Fatal("Expected the changed config of the subproject")
+	}
+	expected = append(expected, filepath.Join(sub, "other.dsp"))
+	if got := w.ProcessFiles(); !slices.Equal(got, expected) {
+		t.Errorf("Expected process files %v, got %v", expected, got)
+	}
+}
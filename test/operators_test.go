@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestOperatorHover(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	tests := []struct {
+		name     string
+		code     string
+		operator string
+		want     []string
+	}{
+		{
+			name:     "Split with known arities",
+			code:     "process = _ <: _,_;",
+			operator: "<:",
+			want:     []string{"Split composition", "`A` has 1 input(s), 1 output(s)", "`B` has 2 input(s), 2 output(s)", "Result has 1 input(s), 2 output(s)"},
+		},
+		{
+			name:     "Merge resolving local definitions",
+			code:     "stereo = _,_;\nprocess = stereo :> _;",
+			operator: ":>",
+			want:     []string{"Merge composition", "`A` has 2 input(s), 2 output(s)", "Result has 2 input(s), 1 output(s)"},
+		},
+		{
+			name:     "Invalid sequential composition",
+			code:     "process = _,_ : +(1), sin(2), 3;",
+			operator: ":",
+			want:     []string{"Sequential composition", "**Arities don't satisfy the rule above**"},
+		},
+		{
+			name:     "Recursive composition",
+			code:     "process = + ~ _;",
+			operator: "~",
+			want:     []string{"Recursive composition", "Result has 1 input(s), 1 output(s)"},
+		},
+		{
+			name:     "Unknown arity of library function",
+			code:     "process = os.osc(440) : _;",
+			operator: ":",
+			want:     []string{"Sequential composition", "`B` has 1 input(s), 1 output(s)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := uint(strings.Index(tt.code, tt.operator))
+			got, ok := server.OperatorHover(parseRoot(t, []byte(tt.code)), []byte(tt.code), offset, server.SinglePrecision)
+			if !ok {
+				t.Fatalf("OperatorHover() found no operator at %d", offset)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("OperatorHover() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+
+	if _, ok := server.OperatorHover(parseRoot(t, []byte("process = foo;")), []byte("process = foo;"), 10, server.SinglePrecision); ok {
+		t.Errorf("OperatorHover() returned docs for an identifier")
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestSortDiagnostics(t *testing.T) {
+	at := func(line, char uint32) transport.Range {
+		return transport.Range{Start: transport.Position{Line: line, Character: char}, End: transport.Position{Line: line, Character: char + 1}}
+	}
+	diagnostics := func() []transport.Diagnostic {
+		return []transport.Diagnostic{
+			{Range: at(2, 0), Message: "c"},
+			{Range: at(0, 4), Message: "b"},
+			{Range: at(0, 4), Message: "a"},
+			{Range: at(0, 1), Message: "d"},
+		}
+	}
+
+	first := diagnostics()
+	server.SortDiagnostics(first)
+	messages := []string{}
+	for _, d := range first {
+		messages = append(messages, d.Message)
+	}
+	if !slices.Equal(messages, []string{"d", "a", "b", "c"}) {
+		t.Errorf("SortDiagnostics() order = %v", messages)
+	}
+
+	// Same diagnostics in another order give the same response
+	second := diagnostics()
+	slices.Reverse(second)
+	server.SortDiagnostics(second)
+	a, _ := json.Marshal(first)
+	b, _ := json.Marshal(second)
+	if string(a) != string(b) {
+		t.Errorf("SortDiagnostics() isn't deterministic:\n%s\n%s", a, b)
+	}
+	if first[0].Data == nil || string(*first[0].Data) == string(*first[1].Data) {
+		t.Errorf("SortDiagnostics() should give each diagnostic a distinct ID")
+	}
+}
+
+func TestSortCompletionItems(t *testing.T) {
+	items := server.SortCompletionItems([]transport.CompletionItem{
+		{Label: "osc"}, {Label: "gain"}, {Label: "osc"}, {Label: "ba"},
+	})
+	labels := []string{}
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if !slices.Equal(labels, []string{"ba", "gain", "osc"}) {
+		t.Errorf("SortCompletionItems() = %v", labels)
+	}
+	if items[0].SortText >= items[1].SortText || items[1].SortText >= items[2].SortText {
+		t.Errorf("SortCompletionItems() sort texts don't keep the order: %q %q %q", items[0].SortText, items[1].SortText, items[2].SortText)
+	}
+}
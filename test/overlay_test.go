@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestOverlay(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	lib := filepath.Join(root, "gain.lib")
+	saved := "import(\"gain.lib\");\nprocess = gain;\n"
+	os.WriteFile(main, []byte(saved), 0644)
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	// The workspace isn't copied anywhere
+	if _, err := os.Stat(s.Workspace.TempDirPath(lib)); !os.IsNotExist(err) {
+		t.Errorf("Expected saved files to stay out of the overlay, got %v", err)
+	}
+
+	uri := transport.DocumentURI(util.Path2URI(main))
+	params, _ = json.Marshal(transport.DidOpenTextDocumentParams{
+		TextDocument: transport.TextDocumentItem{URI: uri, LanguageID: "faust", Text: saved},
+	})
+	server.TextDocumentOpen(t.Context(), &s, params)
+	change := func(text string) {
+		params, _ := json.Marshal(transport.DidChangeTextDocumentParams{
+			TextDocument:   transport.VersionedTextDocumentIdentifier{TextDocumentIdentifier: transport.TextDocumentIdentifier{URI: uri}},
+			ContentChanges: []transport.TextDocumentContentChangeEvent{{Text: text}},
+		})
+		server.TextDocumentChangeFull(t.Context(), &s, params)
+	}
+	dirty := func(want bool) bool {
+		for range 50 {
+			if slices.Contains(s.Workspace.DirtyFiles(), main) == want {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if !dirty(false) {
+		t.Error("Expected a document opened with its saved content not to be in the overlay")
+	}
+
+	// Unsaved buffers are written to the overlay
+	unsaved := "import(\"gain.lib\");\nprocess = gain : gain;\n"
+	change(unsaved)
+	if !dirty(true) {
+		t.Fatalf("Expected the changed document in the overlay, got %v", s.Workspace.DirtyFiles())
+	}
+	if content, err := os.ReadFile(s.Workspace.TempDirPath(main)); err != nil || string(content) != unsaved {
+		t.Errorf("Expected the unsaved buffer in the overlay, got %q, %v", content, err)
+	}
+
+	// Changing the document back to its saved content removes it from the overlay
+	change(saved)
+	if !dirty(false) {
+		t.Errorf("Expected the document to leave the overlay, got %v", s.Workspace.DirtyFiles())
+	}
+	if _, err := os.Stat(s.Workspace.TempDirPath(main)); !os.IsNotExist(err) {
+		t.Errorf("Expected the overlay copy to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+func TestPoolAccounting(t *testing.T) {
+	parser.Init()
+
+	var pool parser.Pool
+	code := []byte("process = _;")
+	trees := []*parser.Tree{pool.Parse(code), pool.Parse(code), pool.Parse(code)}
+	if pool.Live() != 3 {
+		t.Fatalf("expected 3 live trees, got %d", pool.Live())
+	}
+	trees[0].Close()
+	trees[0].Close()
+	if pool.Live() != 2 {
+		t.Errorf("expected closing twice to count once, got %d live trees", pool.Live())
+	}
+	for _, tree := range trees {
+		tree.Close()
+	}
+	if pool.Live() != 0 {
+		t.Errorf("expected no live trees, got %d", pool.Live())
+	}
+}
+
+func TestPoolCopies(t *testing.T) {
+	parser.Init()
+
+	var pool parser.Pool
+	code := []byte("process = _;")
+	tree := pool.Parse(code)
+	copied := tree.Copy()
+	if pool.Live() != 2 {
+		t.Fatalf("expected the copy to be counted, got %d live trees", pool.Live())
+	}
+	tree.Close()
+	if root := copied.RootNode(); root.Utf8Text(code) != string(code) {
+		t.Errorf("expected the copy to outlive the original, got %q", root.Utf8Text(code))
+	}
+	copied.Close()
+	if pool.Live() != 0 {
+		t.Errorf("expected no live trees, got %d", pool.Live())
+	}
+}
+
+func TestFileTreesAreCopies(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := filepath.Join(t.TempDir(), "main.dsp")
+	files.Add(util.FromPath(path), []byte("process = _;\n"))
+	f, _ := files.GetFromPath(path)
+
+	tree, content := f.Tree()
+	files.ModifyIncremental(path, transport.Range{Start: transport.Position{Line: 0, Character: 11}, End: transport.Position{Line: 0, Character: 11}}, " : _")
+	if got := tree.RootNode().Utf8Text(content); got != "process = _;\n" {
+		t.Errorf("expected the borrowed tree to keep the content it was taken with, got %q", got)
+	}
+	if files.LiveTrees() != 2 {
+		t.Errorf("expected the file's tree and the borrowed copy to be live, got %d", files.LiveTrees())
+	}
+	tree.Close()
+	files.RemoveFromPath(path)
+	if files.LiveTrees() != 0 {
+		t.Errorf("expected no live trees once the copy is closed and the file removed, got %d", files.LiveTrees())
+	}
+}
+
+// Root of the syntax tree of content, closed once the test is done
+func parseRoot(t *testing.T, content []byte) *tree_sitter.Node {
+	tree := parser.ParseTree(content)
+	t.Cleanup(tree.Close)
+	return tree.RootNode()
+}
+
+func TestRemovedFilesCloseTrees(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := filepath.Join(t.TempDir(), "main.dsp")
+	files.Add(util.FromPath(path), []byte("process = _;\n"))
+	f, _ := files.GetFromPath(path)
+
+	f.SemanticTokens(t.Context(), "utf-16")
+	files.ModifyIncremental(path, transport.Range{Start: transport.Position{Line: 0, Character: 11}, End: transport.Position{Line: 0, Character: 11}}, " : _")
+	f.SemanticTokens(t.Context(), "utf-16")
+	if files.LiveTrees() != 1 {
+		t.Fatalf("expected the file to keep one tree after reparsing, got %d", files.LiveTrees())
+	}
+	files.RemoveFromPath(path)
+	if files.LiveTrees() != 0 {
+		t.Errorf("expected removing the file to close its tree, got %d live trees", files.LiveTrees())
+	}
+}
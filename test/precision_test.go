@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestPrecisionDiagnostics(t *testing.T) {
+	parser.Init()
+
+	code := `a = 3.14159265358979;
+doubleprecision b = 3.14159265358979;
+c = 0.000012345;
+singleprecision doubleprecision d = 2.718281828459045;
+`
+	warning, hint := transport.SeverityWarning, transport.SeverityHint
+	tests := []struct {
+		name       string
+		precision  string
+		want       []string
+		severities []transport.DiagnosticSeverity
+	}{
+		{"single", server.SinglePrecision, []string{"3.14159265358979", "2.718281828459045"}, []transport.DiagnosticSeverity{warning, warning}},
+		{"double", server.DoublePrecision, []string{"2.718281828459045"}, []transport.DiagnosticSeverity{warning}},
+		// The default precision only gives hints, outside definitions restricted to precisions
+		{"default", "", []string{"3.14159265358979", "2.718281828459045"}, []transport.DiagnosticSeverity{hint, warning}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := server.PrecisionDiagnostics(parseRoot(t, []byte(code)), []byte(code), tt.precision)
+			if len(diagnostics) != len(tt.want) {
+				t.Fatalf("PrecisionDiagnostics() = %v, want warnings for %v", diagnostics, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(diagnostics[i].Message, want) || diagnostics[i].Severity != tt.severities[i] {
+					t.Errorf("PrecisionDiagnostics()[%d] = %q of severity %d, want it to mention %q with severity %d",
+						i, diagnostics[i].Message, diagnostics[i].Severity, want, tt.severities[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPrecisionVariantArity(t *testing.T) {
+	parser.Init()
+
+	code := "singleprecision bank = _;\ndoubleprecision bank = _,_,_,_;\nN = 4;\nprocess = bank : par(i, N, _);"
+	offset := uint(strings.LastIndex(code, ":"))
+
+	for precision, want := range map[string]string{
+		server.SinglePrecision: "**Arities don't satisfy the rule above**",
+		server.DoublePrecision: "- Result has 4 input(s), 4 output(s)",
+	} {
+		got, ok := server.OperatorHover(parseRoot(t, []byte(code)), []byte(code), offset, precision)
+		if !ok || !strings.Contains(got, "`B` has 4 input(s), 4 output(s)") || !strings.Contains(got, want) {
+			t.Errorf("OperatorHover() in %s precision = %q, want it to contain %q", precision, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestDefinitionPreview(t *testing.T) {
+	parser.Init()
+
+	code := []byte(`process = voice with {
+  voice = osc * env
+  with {
+    env = en.adsr(a, d, s, r) letrec { 'x = x + 1; };
+    osc = os.osc(440);
+  };
+  gain = 0.5;
+};
+`)
+	tree := parser.ParseTree(code)
+	defer tree.Close()
+	definition := tree.RootNode().NamedChild(0)
+
+	tests := []struct {
+		depth int
+		want  string
+	}{
+		{0, "process = voice with { ... };"},
+		{1, `process = voice with {
+  voice = osc * env
+  with { ... };
+  gain = 0.5;
+};`},
+		{2, `process = voice with {
+  voice = osc * env
+  with {
+    env = en.adsr(a, d, s, r) letrec { ... };
+    osc = os.osc(440);
+  };
+  gain = 0.5;
+};`},
+		{-1, string(code[:len(code)-1])},
+	}
+	for _, test := range tests {
+		if got := server.DefinitionPreview(code, definition, test.depth); got != test.want {
+			t.Errorf("Depth %d: expected\n%s\ngot\n%s", test.depth, test.want, got)
+		}
+	}
+
+	// Lines of nested definitions lose the indentation of the definition
+	inner := definition.NamedDescendantForByteRange(26, 26)
+	for inner != nil && inner.Kind() != "definition" {
+		inner = inner.Parent()
+	}
+	if inner == nil {
+		t.Fatal("Couldn't find the definition of voice")
+	}
+	want := "voice = osc * env\nwith { ... };"
+	if got := server.DefinitionPreview(code, inner, 0); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestPrimitiveVersionDiagnostics(t *testing.T) {
+	parser.Init()
+	content := []byte("process = _ <: lowest, route(1, 1, (1, 1)) : ondemand(_);\n")
+	messages := func(diagnostics []transport.Diagnostic) []string {
+		found := []string{}
+		for _, d := range diagnostics {
+			found = append(found, d.Message)
+		}
+		return found
+	}
+
+	diagnostics := server.PrimitiveVersionDiagnostics(parseRoot(t, content), content, "2.30.0")
+	if len(diagnostics) != 2 {
+		t.Fatalf("Expected lowest and ondemand to be unknown to 2.30.0, got %v", messages(diagnostics))
+	}
+	if !strings.HasPrefix(diagnostics[0].Message, "lowest needs Faust 2.37.0") || !strings.HasPrefix(diagnostics[1].Message, "ondemand needs Faust 2.69.0") {
+		t.Errorf("Unexpected messages %v", messages(diagnostics))
+	}
+	if diagnostics := server.PrimitiveVersionDiagnostics(parseRoot(t, content), content, "2.75.7"); len(diagnostics) != 0 {
+		t.Errorf("Expected 2.75.7 to know every primitive, got %v", messages(diagnostics))
+	}
+	if diagnostics := server.PrimitiveVersionDiagnostics(parseRoot(t, content), content, ""); len(diagnostics) != 0 {
+		t.Errorf("Expected nothing reported for an unknown version, got %v", messages(diagnostics))
+	}
+
+	// Files can define names that later became primitives
+	shadowed := []byte("ondemand(x) = x;\nprocess = ondemand(_);\n")
+	if diagnostics := server.PrimitiveVersionDiagnostics(parseRoot(t, shadowed), shadowed, "2.30.0"); len(diagnostics) != 0 {
+		t.Errorf("Expected a defined ondemand not to be reported, got %v", messages(diagnostics))
+	}
+}
+
+func TestPrimitiveCompletionAndHover(t *testing.T) {
+	parser.Init()
+	labels := func(version string) map[string]bool {
+		found := map[string]bool{}
+		for _, item := range server.PrimitiveCompletionItems(transport.Range{}, true, version) {
+			found[item.Label] = true
+		}
+		return found
+	}
+	if old := labels("2.30.0"); !old["route"] || old["lowest"] || old["ondemand"] {
+		t.Errorf("Expected 2.30.0 to complete route but not lowest or ondemand, got %v", old)
+	}
+	if all := labels(""); !all["lowest"] || !all["ondemand"] || all["widget modulation"] {
+		t.Errorf("Expected an unknown version to complete every primitive but syntax, got %v", all)
+	}
+
+	content := []byte("process = lowest;\n")
+	offset := uint(strings.Index(string(content), "lowest") + 2)
+	docs, ok := server.PrimitiveHover(parseRoot(t, content), content, offset, "2.30.0")
+	if !ok || !strings.Contains(docs, "Since Faust 2.37.0") || !strings.Contains(docs, "doesn't know it") {
+		t.Errorf("Expected hover telling lowest is unknown to 2.30.0, got %q", docs)
+	}
+	if docs, ok := server.PrimitiveHover(parseRoot(t, content), content, offset, "2.75.7"); !ok || strings.Contains(docs, "doesn't know it") {
+		t.Errorf("Expected hover without a warning for 2.75.7, got %q", docs)
+	}
+}
@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestProblemLimiter(t *testing.T) {
+	var l server.ProblemLimiter
+	now := time.Now()
+
+	if !l.Allow("Couldn't start watching the workspace", now) {
+		t.Fatal("Expected the first problem to be told")
+	}
+	if l.Allow("Couldn't start watching the workspace", now.Add(10*time.Second)) {
+		t.Error("Expected the same problem not to be told again right away")
+	}
+	if !l.Allow("Couldn't start watching the workspace", now.Add(2*time.Minute)) {
+		t.Error("Expected the same problem to be told again after a while")
+	}
+
+	// Many problems at once are cut short
+	later := now.Add(time.Hour)
+	told := 0
+	for i := range 20 {
+		if l.Allow(fmt.Sprintf("Problem %d", i), later) {
+			told++
+		}
+	}
+	if told != 5 {
+		t.Errorf("Expected 5 of 20 problems at once to be told, got %d", told)
+	}
+	if !l.Allow("Problem 19", later.Add(2*time.Minute)) {
+		t.Error("Expected problems to be told again once the burst is over")
+	}
+}
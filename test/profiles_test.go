@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestProfiles(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	os.WriteFile(filepath.Join(root, "synth.dsp"), []byte("process = _;\n"), 0644)
+	config := `{
+  "process_files": ["main.dsp"],
+  "compiler_args": ["-vec"],
+  "profiles": {
+    "release": { "compiler_args": ["-vec", "-vs", "64"], "process_files": ["main.dsp", "synth.dsp"] },
+    "wasm": { "command": "faust-wasm" }
+  }
+}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{
+			RootURI:               transport.DocumentURI(util.Path2URI(root)),
+			InitializationOptions: map[string]any{"profile": "wasm"},
+		},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	main := filepath.Join(root, "main.dsp")
+
+	// The profile of the settings
+	if c := s.Workspace.ConfigFor(main); c.Command != "faust-wasm" || !slices.Equal(c.CompilerArgs, []string{"-vec"}) {
+		t.Errorf("Expected the command of the wasm profile with the config's arguments, got %q and %v", c.Command, c.CompilerArgs)
+	}
+
+	// A profile selected with the command replaces it
+	selectProfile := func(name string) error {
+		args, _ := json.Marshal(server.SelectProfileArgs{Name: name})
+		_, err := server.SelectProfileCommand(t.Context(), &s, []json.RawMessage{args})
+		return err
+	}
+	if err := selectProfile("release"); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Workspace.ConfigFor(main); c.Command != "faust" || !slices.Equal(c.CompilerArgs, []string{"-vec", "-vs", "64"}) {
+		t.Errorf("Expected the arguments of the release profile with the default command, got %q and %v", c.Command, c.CompilerArgs)
+	}
+	expected := []util.Path{main, filepath.Join(root, "synth.dsp")}
+	if got := s.Workspace.ProcessFiles(); !slices.Equal(got, expected) {
+		t.Errorf("Expected the process files of the release profile %v, got %v", expected, got)
+	}
+	result, _ := server.StatusCommand(t.Context(), &s, nil)
+	var status server.Status
+	json.Unmarshal(result, &status)
+	if status.Profile != "release" {
+		t.Errorf("Expected the status to show the release profile, got %q", status.Profile)
+	}
+
+	if err := selectProfile("missing"); err == nil {
+		t.Error("Expected an error selecting a profile the config doesn't have")
+	}
+
+	// Selecting no profile goes back to the one of the settings
+	if err := selectProfile(""); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Workspace.ConfigFor(main); c.Command != "faust-wasm" || !slices.Equal(s.Workspace.ProcessFiles(), []util.Path{main}) {
+		t.Errorf("Expected the wasm profile again, got %q and %v", c.Command, s.Workspace.ProcessFiles())
+	}
+}
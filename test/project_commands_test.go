@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestImportGraph(t *testing.T) {
+	parser.Init()
+
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	lib := filepath.Join(root, "lib.lib")
+	std := filepath.Join(root, "std", "stdfaust.lib")
+	contents := map[util.Path][]byte{
+		main: []byte(`import("stdfaust.lib");
+l = library("lib.lib");
+import("lib.lib");
+import("missing.lib");
+process = l.gain;
+`),
+		lib: []byte(`gain = *(0.5);`),
+	}
+	resolve := func(importPath string) util.Path {
+		switch importPath {
+		case "stdfaust.lib":
+			return std
+		case "lib.lib":
+			return lib
+		}
+		return ""
+	}
+
+	graph := server.ImportGraphOf(contents, resolve)
+	uri := func(path util.Path) transport.DocumentURI { return transport.DocumentURI(util.Path2URI(path)) }
+	nodes := []transport.DocumentURI{uri(lib), uri(main), uri(std)}
+	if len(graph.Nodes) != len(nodes) {
+		t.Fatalf("expected nodes %v, got %v", nodes, graph.Nodes)
+	}
+	for i := range nodes {
+		if graph.Nodes[i] != nodes[i] {
+			t.Errorf("expected node %s, got %s", nodes[i], graph.Nodes[i])
+		}
+	}
+	// Importing lib.lib twice gives a single edge, missing files none
+	edges := []server.ImportEdge{{From: uri(main), To: uri(lib)}, {From: uri(main), To: uri(std)}}
+	if len(graph.Edges) != len(edges) {
+		t.Fatalf("expected edges %v, got %v", edges, graph.Edges)
+	}
+	for i := range edges {
+		if graph.Edges[i] != edges[i] {
+			t.Errorf("expected edge %v, got %v", edges[i], graph.Edges[i])
+		}
+	}
+}
+
+func TestSvgDir(t *testing.T) {
+	cache := filepath.Join("/home", "user", ".cache")
+	root := filepath.Join("/home", "user", "project")
+	dir := server.SvgDir(cache, root, filepath.Join(root, "synths", "organ.dsp"), "process")
+	if filepath.Base(dir) != "process" || filepath.Base(filepath.Dir(dir)) != "organ-svg" || filepath.Base(filepath.Dir(filepath.Dir(dir))) != "synths" {
+		t.Errorf("expected diagrams in synths/organ-svg/process, got %s", dir)
+	}
+	if !strings.HasPrefix(dir, filepath.Join(cache, "faustlsp", "svg")) {
+		t.Errorf("expected diagrams in the cache directory, got %s", dir)
+	}
+	if again := server.SvgDir(cache, root, filepath.Join(root, "synths", "organ.dsp"), "process"); again != dir {
+		t.Errorf("expected a stable directory, got %s then %s", dir, again)
+	}
+	// Workspaces with the same file names don't share diagrams
+	other := server.SvgDir(cache, filepath.Join("/home", "user", "other"), filepath.Join("/home", "user", "other", "synths", "organ.dsp"), "process")
+	if other == dir {
+		t.Errorf("expected different directories for different workspaces, got %s", other)
+	}
+}
+
+func TestDiagramMarkdown(t *testing.T) {
+	markdown := server.DiagramMarkdown("file:///cache/organ-svg/process/process.svg")
+	if !strings.Contains(markdown, "![Block diagram](file:///cache/organ-svg/process/process.svg)") || !strings.Contains(markdown, "[Open block diagram](file:///cache/organ-svg/process/process.svg)") {
+		t.Errorf("expected image and link to the diagram, got %s", markdown)
+	}
+}
+
+func TestDiagramHover(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	root := t.TempDir()
+	// The compiler takes a while to write the diagrams of the file it compiles in the output directory
+	command := filepath.Join(t.TempDir(), "faust")
+	script := "#!/bin/sh\nfile=$1\nwhile [ $# -gt 0 ]; do if [ \"$1\" = -O ]; then out=$2; fi; shift; done\n[ -n \"$out\" ] || exit 0\nsleep 1\n" +
+		"dir=\"$out/$(basename \"$file\" .dsp)-svg\"\nmkdir -p \"$dir\" && echo '<svg/>' > \"$dir/process.svg\"\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(root, "main.dsp")
+	// Hovers of process, a keyword, don't resolve it, unlike those of other process names
+	os.WriteFile(main, []byte("main = _;\n"), 0644)
+	config := `{"command": "` + command + `", "process_name": "main", "compiler_diagnostics": false}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	hover, _ := json.Marshal(transport.HoverParams{TextDocumentPositionParams: transport.TextDocumentPositionParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: transport.DocumentURI(util.Path2URI(main))},
+		Position:     transport.Position{Line: 0, Character: 2},
+	}})
+	// Hovers don't wait for the diagram, showing it once it was generated in the background
+	hovered := false
+	for range 50 {
+		start := time.Now()
+		result, err := server.Hover(t.Context(), &s, hover)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("Expected the hover not to wait for the compiler, took %v", elapsed)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(result), "Block diagram") {
+			if !hovered {
+				t.Errorf("Expected the first hover not to show the diagram, got %s", result)
+			}
+			return
+		}
+		// The file may not be indexed yet
+		hovered = hovered || string(result) != "null"
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("Expected the diagram once it was generated")
+}
+
+func TestCodeOutputPath(t *testing.T) {
+	root := filepath.Join("/home", "user", "project")
+	path := filepath.Join(root, "synths", "organ.dsp")
+	if output := server.CodeOutputPath(root, "", path, "cpp"); output != filepath.Join(root, "synths", "organ.cpp") {
+		t.Errorf("expected code next to the source, got %s", output)
+	}
+	if output := server.CodeOutputPath(root, "build", path, "rust"); output != filepath.Join(root, "build", "synths", "organ.rs") {
+		t.Errorf("expected code in the build directory, got %s", output)
+	}
+	if output := server.CodeOutputPath(root, "/tmp/out", path, "wasm"); output != filepath.Join("/tmp", "out", "synths", "organ.wasm") {
+		t.Errorf("expected code in the absolute build directory, got %s", output)
+	}
+}
+
+func TestImportGraphDOT(t *testing.T) {
+	parser.Init()
+
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	lib := filepath.Join(root, "libs", "lib.lib")
+	std := filepath.Join(t.TempDir(), "stdfaust.lib")
+	contents := map[util.Path][]byte{
+		main: []byte(`import("stdfaust.lib");
+l = library("libs/lib.lib");
+process = l.gain with { k = library("libs/lib.lib"); };
+`),
+	}
+	resolve := func(importPath string) util.Path {
+		if importPath == "stdfaust.lib" {
+			return std
+		}
+		return lib
+	}
+
+	graph := server.ImportGraphOf(contents, resolve)
+	if len(graph.Bindings) != 2 || graph.Bindings[0].Definition != "k" || graph.Bindings[1].Definition != "l" {
+		t.Fatalf("expected the bindings k and l, got %+v", graph.Bindings)
+	}
+
+	dot := graph.DOT(root)
+	mainURI, libURI, stdURI := util.Path2URI(main), util.Path2URI(lib), util.Path2URI(std)
+	for _, expected := range []string{
+		"digraph imports {",
+		`"` + mainURI + `" [label="main.dsp"];`,
+		`"` + libURI + `" [label="libs/lib.lib"];`,
+		`"` + stdURI + `" [label="stdfaust.lib", style=dashed];`,
+		`"` + mainURI + `" -> "` + libURI + `" [label="k, l"];`,
+		`"` + mainURI + `" -> "` + stdURI + `";`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected DOT to contain %s, got\n%s", expected, dot)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+func TestRecoverFromHandlerPanic(t *testing.T) {
+	logging.Init()
+	serverConn, clientConn := net.Pipe()
+	var s server.Server
+	s.InitConn(serverConn)
+	go s.Run(t.Context())
+	var tr transport.Transport
+	tr.InitConn(transport.Client, clientConn)
+	defer tr.Close()
+
+	params, _ := json.Marshal(transport.ParamInitialize{})
+	request(t, &tr, 1, "initialize", params)
+	tr.WriteNotif("initialized", []byte("{}"))
+
+	// Hovering a document the server doesn't know of is a bug of the handler
+	hover, _ := json.Marshal(transport.HoverParams{TextDocumentPositionParams: transport.TextDocumentPositionParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: "file:///missing.dsp"},
+	}})
+	tr.WriteRequest(2, "textDocument/hover", hover)
+	shown := ""
+	for {
+		msg, err := tr.Read()
+		if err != nil {
+			t.Fatalf("Expected an answer to the hover, got %v", err)
+		}
+		var notification transport.NotificationMessage
+		json.Unmarshal(msg, &notification)
+		if notification.Method == "window/showMessage" {
+			var params transport.ShowMessageParams
+			json.Unmarshal(notification.Params, &params)
+			shown = params.Message
+		}
+		var resp transport.ResponseMessage
+		json.Unmarshal(msg, &resp)
+		if transport.IsResponse(msg) && resp.ID == float64(2) {
+			if resp.Error == nil || resp.Error.Code != int(transport.InternalError) {
+				t.Fatalf("Expected an internal error, got %s", msg)
+			}
+			break
+		}
+	}
+	if !strings.Contains(shown, "textDocument/hover") || !strings.Contains(shown, logging.Path()) {
+		t.Errorf("Expected the user to be asked to report the crash with the log, got %q", shown)
+	}
+
+	// The server keeps running
+	if resp := request(t, &tr, 3, "shutdown", nil); resp.Error != nil {
+		t.Errorf("Expected the server to keep handling requests, got %v", resp.Error)
+	}
+}
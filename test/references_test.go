@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestReferences(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	libCode := `gain = 0.5;
+env = environment { inner = gain + 1; };
+`
+	mainCode := `l = library("lib.lib");
+// apply the gain, not again
+process = l.gain * hslider("gain", 0, 0, 1, 0.1) : l.env.inner;
+`
+	libPath := filepath.Join(dir, "lib.lib")
+	mainPath := filepath.Join(dir, "main.dsp")
+	os.WriteFile(libPath, []byte(libCode), 0644)
+	os.WriteFile(mainPath, []byte(mainCode), 0644)
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	store := server.Store{
+		Files:        &files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	workspace := server.Workspace{Root: dir}
+
+	visited := make(map[util.Path]struct{})
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+
+	files.OpenFromPath(libPath)
+	files.OpenFromPath(mainPath)
+	lib, _ := files.GetFromPath(libPath)
+	main, _ := files.GetFromPath(mainPath)
+	workspace.ParseFile(lib, &store, visited, fileChan)
+	workspace.ParseFile(main, &store, visited, fileChan)
+
+	target, err := server.ResolveSymbol("l.gain", main.Scope, &store)
+	if err != nil {
+		t.Fatalf("ResolveSymbol(l.gain) error: %s", err)
+	}
+	rng := func(line, start, end uint32) transport.Range {
+		return transport.Range{
+			Start: transport.Position{Line: line, Character: start},
+			End:   transport.Position{Line: line, Character: end},
+		}
+	}
+
+	libRefs := server.SymbolReferences(parseRoot(t, lib.Content), lib.Content, lib.Scope, &store, target, true)
+	if want := []transport.Range{rng(0, 0, 4), rng(1, 28, 32)}; !slices.Equal(libRefs, want) {
+		t.Errorf("References in library = %v, want %v", libRefs, want)
+	}
+	libRefs = server.SymbolReferences(parseRoot(t, lib.Content), lib.Content, lib.Scope, &store, target, false)
+	if want := []transport.Range{rng(1, 28, 32)}; !slices.Equal(libRefs, want) {
+		t.Errorf("References in library without declaration = %v, want %v", libRefs, want)
+	}
+	mainRefs := server.SymbolReferences(parseRoot(t, main.Content), main.Content, main.Scope, &store, target, true)
+	if want := []transport.Range{rng(2, 12, 16)}; !slices.Equal(mainRefs, want) {
+		t.Errorf("References in main = %v, want %v", mainRefs, want)
+	}
+
+	// Whole words in comments and UI labels
+	matches := server.TextualMatches(parseRoot(t, main.Content), main.Content, "gain")
+	if want := []transport.Range{rng(1, 13, 17), rng(2, 28, 32)}; !slices.Equal(matches, want) {
+		t.Errorf("TextualMatches() = %v, want %v", matches, want)
+	}
+}
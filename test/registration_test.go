@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+)
+
+// Reads messages until a request of method, returning its params
+func readRequest(t *testing.T, conn net.Conn, tr *transport.Transport, method string) json.RawMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := tr.Read()
+		if err != nil {
+			t.Fatalf("Expected a %s request, got %v", method, err)
+		}
+		var req transport.RequestMessage
+		json.Unmarshal(msg, &req)
+		if req.Method == method && req.ID != nil {
+			return req.Params
+		}
+	}
+}
+
+func TestDynamicRegistration(t *testing.T) {
+	logging.Init()
+	serverConn, clientConn := net.Pipe()
+	var s server.Server
+	s.InitConn(serverConn)
+	go s.Run(t.Context())
+	var tr transport.Transport
+	tr.InitConn(transport.Client, clientConn)
+	defer tr.Close()
+
+	var capabilities transport.ClientCapabilities
+	capabilities.TextDocument.SemanticTokens.DynamicRegistration = true
+	capabilities.TextDocument.Formatting = &transport.DocumentFormattingClientCapabilities{DynamicRegistration: true}
+	params, _ := json.Marshal(transport.ParamInitialize{XInitializeParams: transport.XInitializeParams{Capabilities: capabilities}})
+	resp := request(t, &tr, 1, "initialize", params)
+
+	// Providers the client registers dynamically are left out of the result, others stay
+	var result struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	json.Unmarshal(resp.Result, &result)
+	for _, provider := range []string{"semanticTokensProvider", "documentFormattingProvider"} {
+		if _, ok := result.Capabilities[provider]; ok {
+			t.Errorf("Expected %s to be registered dynamically rather than in the result", provider)
+		}
+	}
+	if _, ok := result.Capabilities["documentRangeFormattingProvider"]; !ok {
+		t.Error("Expected documentRangeFormattingProvider to stay in the result")
+	}
+
+	tr.WriteNotif("initialized", []byte("{}"))
+	var registrations transport.RegistrationParams
+	json.Unmarshal(readRequest(t, clientConn, &tr, "client/registerCapability"), &registrations)
+	methods := []string{}
+	for _, registration := range registrations.Registrations {
+		methods = append(methods, registration.Method)
+	}
+	slices.Sort(methods)
+	if !slices.Equal(methods, []string{"textDocument/formatting", "textDocument/semanticTokens"}) {
+		t.Errorf("Expected formatting and semantic tokens to be registered, got %v", methods)
+	}
+
+	// Shutting down unregisters them, so that initializing again can register them again
+	tr.WriteRequest(2, "shutdown", nil)
+	var unregistrations transport.UnregistrationParams
+	json.Unmarshal(readRequest(t, clientConn, &tr, "client/unregisterCapability"), &unregistrations)
+	if len(unregistrations.Unregisterations) != len(registrations.Registrations) {
+		t.Errorf("Expected the registrations to be unregistered, got %+v", unregistrations)
+	}
+}
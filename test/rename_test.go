@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestRenameReferences(t *testing.T) {
+	at := func(line uint32) transport.Range {
+		return transport.Range{Start: transport.Position{Line: line, Character: 0}, End: transport.Position{Line: line, Character: 4}}
+	}
+	main := transport.DocumentURI("file:///project/main.dsp")
+	lib := transport.DocumentURI("file:///project/lib.lib")
+	external := transport.DocumentURI("file:///usr/share/faust/ext.lib")
+	references := []transport.Location{
+		{URI: main, Range: at(0)},
+		{URI: main, Range: at(2)},
+		{URI: main, Range: at(2)},
+		{URI: lib, Range: at(1)},
+		{URI: external, Range: at(3)},
+	}
+	readOnly := func(path util.Path) bool { return strings.HasPrefix(path, "/usr") }
+
+	rename := server.RenameReferences(references, "level", readOnly)
+	if rename.Edits != 3 || rename.Skipped != 1 || len(rename.Edit.Changes) != 2 {
+		t.Fatalf("expected 3 edits in 2 files and 1 skipped reference, got %+v", rename)
+	}
+	if edits := rename.Edit.Changes[main]; len(edits) != 2 || edits[0].NewText != "level" || edits[1].Range != at(2) {
+		t.Errorf("unexpected edits of main.dsp: %v", edits)
+	}
+
+	messageType, message := server.RenameSummary("gain", "level", rename, transport.ApplyWorkspaceEditResult{Applied: true})
+	if messageType != transport.Warning || message != "Renamed gain to level: 3 edits in 2 files, 1 reference in read-only files left unchanged" {
+		t.Errorf("unexpected summary: %d %s", messageType, message)
+	}
+
+	messageType, message = server.RenameSummary("gain", "level", rename, transport.ApplyWorkspaceEditResult{Applied: false, FailureReason: "file changed", FailedChange: 1})
+	if messageType != transport.Error || message != "Couldn't rename gain to level: file changed (change 2 of 2 failed)" {
+		t.Errorf("unexpected summary: %d %s", messageType, message)
+	}
+
+	rename = server.RenameReferences(references[:1], "level", readOnly)
+	if _, message := server.RenameSummary("gain", "level", rename, transport.ApplyWorkspaceEditResult{Applied: true}); message != "Renamed gain to level: 1 edit in 1 file" {
+		t.Errorf("unexpected summary: %s", message)
+	}
+}
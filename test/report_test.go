@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestBuildReport(t *testing.T) {
+	parser.Init()
+
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	broken := filepath.Join(root, "broken.dsp")
+	lib := filepath.Join(root, "lib.lib")
+	contents := map[util.Path][]byte{
+		main: []byte(`import("lib.lib");
+unused = 1;
+process = gain;
+`),
+		broken: []byte(`process = (;`),
+		lib:    []byte(`gain = *(0.5);`),
+	}
+	resolve := func(importPath string) util.Path {
+		if importPath == "lib.lib" {
+			return lib
+		}
+		return ""
+	}
+	compiled := []util.Path{}
+	compile := func(path util.Path) transport.Diagnostic {
+		compiled = append(compiled, path)
+		return transport.Diagnostic{Message: "ERROR : undefined symbol : gain\n"}
+	}
+	config := server.FaustProjectConfig{
+		ProcessName:       "process",
+		ProcessFiles:      []util.Path{"main.dsp", broken, "missing.dsp"},
+		UnusedDiagnostics: true,
+	}
+
+	report := server.BuildReport(root, contents, config, resolve, compile)
+
+	if len(compiled) != 1 || compiled[0] != main {
+		t.Errorf("expected only main.dsp to be compiled, compiled %v", compiled)
+	}
+	if report.Summary.Files != 3 || report.Summary.Unused != 1 {
+		t.Errorf("unexpected summary %+v", report.Summary)
+	}
+	// The syntax error and the 3 failed compilations
+	if report.Summary.Errors != 4 {
+		t.Errorf("expected 4 errors, got %+v", report.Summary)
+	}
+	if len(report.Files) != 3 || report.Files[0].Path != "broken.dsp" || report.Files[2].Path != "main.dsp" {
+		t.Fatalf("unexpected files %+v", report.Files)
+	}
+	unused := report.Files[2].Diagnostics
+	if len(unused) != 1 || unused[0].Line != 2 || unused[0].Column != 1 || unused[0].Code != "unused-definition" {
+		t.Errorf("unexpected diagnostics of main.dsp %+v", unused)
+	}
+	if len(report.Compilations) != 3 {
+		t.Fatalf("expected 3 compilations, got %+v", report.Compilations)
+	}
+	if c := report.Compilations[0]; c.Path != "main.dsp" || c.Error != "ERROR : undefined symbol : gain" {
+		t.Errorf("unexpected compilation %+v", c)
+	}
+	if c := report.Compilations[1]; c.Path != "broken.dsp" || !strings.Contains(c.Error, "syntax errors") {
+		t.Errorf("unexpected compilation %+v", c)
+	}
+	if c := report.Compilations[2]; c.Path != "missing.dsp" || c.Error != "file not found" {
+		t.Errorf("unexpected compilation %+v", c)
+	}
+	if len(report.Dependencies.Edges) != 1 {
+		t.Errorf("expected 1 import, got %+v", report.Dependencies.Edges)
+	}
+
+	markdown := report.Markdown()
+	for _, expected := range []string{
+		"3 files, 4 errors, 0 warnings, 1 unused definitions",
+		"| main.dsp | 2 | hint |",
+		"| missing.dsp | 0 ms | file not found |",
+		"- main.dsp imports lib.lib",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("expected markdown to contain %q, got\n%s", expected, markdown)
+		}
+	}
+
+	annotations := report.GitHubAnnotations()
+	for _, expected := range []string{
+		"::notice file=main.dsp,line=2,col=1,title=unused-definition::",
+		"::error file=broken.dsp,line=1,col=",
+		"title=syntax-error::",
+		"::error file=main.dsp,line=1,col=1,title=compile-error::ERROR : undefined symbol : gain\n",
+	} {
+		if !strings.Contains(annotations, expected) {
+			t.Errorf("expected annotations to contain %q, got\n%s", expected, annotations)
+		}
+	}
+
+	content, err := report.SARIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sarif struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(content, &sarif); err != nil {
+		t.Fatal(err)
+	}
+	if sarif.Version != "2.1.0" || len(sarif.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log %s", content)
+	}
+	// The syntax error, the unused definition and the 3 failed compilations
+	if results := sarif.Runs[0].Results; len(results) != 5 {
+		t.Errorf("expected 5 results, got %s", content)
+	} else if r := results[1]; r.RuleID != "unused-definition" || r.Level != "note" || r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "main.dsp" || r.Locations[0].PhysicalLocation.Region.StartLine != 2 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
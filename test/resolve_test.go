@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestResolveSymbolAliases(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	libCode := `env = environment { inner = 1; };
+short = env;
+lp = env.inner;
+own = lp;
+`
+	mainCode := `l = library("lib.lib");
+process = l.short.inner, l.lp;
+`
+	libPath := filepath.Join(dir, "lib.lib")
+	mainPath := filepath.Join(dir, "main.dsp")
+	os.WriteFile(libPath, []byte(libCode), 0644)
+	os.WriteFile(mainPath, []byte(mainCode), 0644)
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	store := server.Store{
+		Files:        &files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	workspace := server.Workspace{Root: dir}
+
+	visited := make(map[util.Path]struct{})
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+
+	files.OpenFromPath(libPath)
+	files.OpenFromPath(mainPath)
+	lib, _ := files.GetFromPath(libPath)
+	main, _ := files.GetFromPath(mainPath)
+	workspace.ParseFile(lib, &store, visited, fileChan)
+	workspace.ParseFile(main, &store, visited, fileChan)
+
+	innerRange := transport.Range{
+		Start: transport.Position{Line: 0, Character: 20},
+		End:   transport.Position{Line: 0, Character: 29},
+	}
+	envRange := transport.Range{
+		Start: transport.Position{Line: 0, Character: 0},
+		End:   transport.Position{Line: 0, Character: 3},
+	}
+	ownRange := transport.Range{
+		Start: transport.Position{Line: 3, Character: 0},
+		End:   transport.Position{Line: 3, Character: 8},
+	}
+
+	tests := []struct {
+		name  string
+		ident string
+		want  transport.Range
+	}{
+		{name: "Alias of environment in prefix", ident: "l.short.inner", want: innerRange},
+		{name: "Alias of environment", ident: "l.short", want: envRange},
+		{name: "Alias of qualified definition", ident: "l.lp", want: innerRange},
+		// Definitions that are another unqualified identifier are definitions of their own
+		{name: "Alias of definition", ident: "l.own", want: ownRange},
+		{name: "Environment in library", ident: "l.env.inner", want: innerRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sym, err := server.ResolveSymbol(tt.ident, main.Scope, &store)
+			if err != nil {
+				t.Fatalf("ResolveSymbol(%s) error: %s", tt.ident, err)
+			}
+			if sym.Loc.File != libPath || sym.Loc.Range != tt.want {
+				t.Errorf("ResolveSymbol(%s) = %v, want %s %v", tt.ident, sym.Loc, libPath, tt.want)
+			}
+		})
+	}
+
+	if _, err := server.ResolveSymbol("l.missing", main.Scope, &store); err == nil {
+		t.Errorf("ResolveSymbol(l.missing) should have failed")
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestSemanticTokens(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := `import("stdfaust.lib");
+gain(x) = x * 0.5;
+process = fi.lowpass(2, 440) : gain;
+`
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := filepath.Join(t.TempDir(), "main.dsp")
+	files.Add(util.FromPath(path), []byte(code))
+	f, _ := files.GetFromPath(path)
+
+	tokens, err := f.SemanticTokens(t.Context(), "utf-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{
+		0, 7, 14, 5, 0, // "stdfaust.lib"
+		1, 0, 4, 1, 1, // gain
+		0, 5, 1, 2, 1, // x
+		0, 5, 1, 3, 0, // x
+		0, 4, 3, 6, 0, // 0.5
+		1, 0, 7, 3, 1, // process
+		0, 10, 2, 0, 0, // fi
+		0, 3, 7, 1, 0, // lowpass
+		0, 8, 1, 6, 0, // 2
+		0, 3, 3, 6, 0, // 440
+		0, 7, 4, 3, 0, // gain
+	}
+	if !slices.Equal(tokens.Data, expected) {
+		t.Fatalf("expected tokens %v, got %v", expected, tokens.Data)
+	}
+}
+
+func TestSemanticTokensDelta(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := `gain = 0.5;
+// a comment
+process = _ * gain;
+`
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := filepath.Join(t.TempDir(), "main.dsp")
+	files.Add(util.FromPath(path), []byte(code))
+	f, _ := files.GetFromPath(path)
+
+	previous, _ := f.SemanticTokens(t.Context(), "utf-16")
+	changes := []struct {
+		r    transport.Range
+		text string
+	}{
+		// gain = 0.5; -> gain(x) = 0.5 * x;
+		{transport.Range{Start: transport.Position{Line: 0, Character: 4}, End: transport.Position{Line: 0, Character: 4}}, "(x)"},
+		{transport.Range{Start: transport.Position{Line: 0, Character: 13}, End: transport.Position{Line: 0, Character: 13}}, " * x"},
+		// New definition in the middle of the file
+		{transport.Range{Start: transport.Position{Line: 1, Character: 0}, End: transport.Position{Line: 1, Character: 0}}, "freq = 440;\n"},
+	}
+	for _, change := range changes {
+		files.ModifyIncremental(path, change.r, change.text)
+
+		delta, ok, _ := f.SemanticTokensDelta(t.Context(), previous.ResultID, "utf-16")
+		if !ok {
+			t.Fatalf("expected delta from result %s", previous.ResultID)
+		}
+		if len(delta.Edits) != 1 || int(delta.Edits[0].DeleteCount) >= len(previous.Data) {
+			t.Errorf("expected one edit of the modified tokens, got %v", delta.Edits)
+		}
+		data := slices.Clone(previous.Data)
+		for _, edit := range delta.Edits {
+			data = slices.Replace(data, int(edit.Start), int(edit.Start+edit.DeleteCount), edit.Data...)
+		}
+
+		// Tokens computed from scratch for the same content
+		var fresh server.Files
+		fresh.Init(t.Context(), transport.UTF16)
+		fresh.Add(util.FromPath(path), f.Content)
+		freshFile, _ := fresh.GetFromPath(path)
+		freshTokens, _ := freshFile.SemanticTokens(t.Context(), "utf-16")
+		expected := freshTokens.Data
+		if !slices.Equal(data, expected) {
+			t.Fatalf("after inserting %q, expected tokens %v, got %v", change.text, expected, data)
+		}
+		previous = transport.SemanticTokens{ResultID: delta.ResultID, Data: data}
+	}
+
+	if _, ok, _ := f.SemanticTokensDelta(t.Context(), "stale", "utf-16"); ok {
+		t.Errorf("expected no delta from an unknown result")
+	}
+}
+
+func TestCanceledSemanticTokens(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	path := filepath.Join(t.TempDir(), "main.lib")
+	files.Add(util.FromPath(path), []byte("gain(x) = x * 0.5;\nprocess = gain;\n"))
+	f, _ := files.GetFromPath(path)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := f.SemanticTokens(ctx, "utf-16"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled tokens, got %v", err)
+	}
+	// Canceling leaves nothing half computed behind
+	tokens, err := f.SemanticTokens(t.Context(), "utf-16")
+	if err != nil || len(tokens.Data) != 5*6 {
+		t.Errorf("expected the 6 tokens of the file, got %v, %v", tokens.Data, err)
+	}
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestClientSettings(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+	config := filepath.Join(root, ".faustcfg.json")
+	os.WriteFile(config, []byte(`{"unused_diagnostics": false}`), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	changeSettings := func(settings string) {
+		server.DidChangeConfiguration(t.Context(), &s, []byte(`{"settings": {"faustlsp": `+settings+`}}`))
+	}
+	configured := func(ok func(server.FaustProjectConfig) bool) bool {
+		for range 50 {
+			if ok(s.Workspace.ConfigFor(root)) {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	// Settings apply on top of the defaults, except for keys set in .faustcfg.json
+	changeSettings(`{"command": "/opt/faust/bin/faust", "compiler_diagnostics": false, "unused_diagnostics": true, "formatter": "faustfmt"}`)
+	if !configured(func(c server.FaustProjectConfig) bool { return c.Command == "/opt/faust/bin/faust" }) {
+		t.Fatalf("Expected the compiler of the client settings, got %q", s.Workspace.ConfigFor(root).Command)
+	}
+	if c := s.Workspace.ConfigFor(root); c.CompilerDiagnostics || c.Formatter != server.FaustfmtFormatter {
+		t.Errorf("Expected compiler diagnostics off and faustfmt from the client settings, got %v and %q", c.CompilerDiagnostics, c.Formatter)
+	}
+	if s.Workspace.ConfigFor(root).UnusedDiagnostics {
+		t.Error("Expected the project config to take precedence over the client settings")
+	}
+
+	// Settings that are no longer given go back to the defaults
+	changeSettings(`{"formatter": "faustfmt"}`)
+	if !configured(func(c server.FaustProjectConfig) bool { return c.Command == "faust" }) {
+		t.Fatalf("Expected the default compiler, got %q", s.Workspace.ConfigFor(root).Command)
+	}
+	if c := s.Workspace.ConfigFor(root); !c.CompilerDiagnostics || c.Formatter != server.FaustfmtFormatter {
+		t.Errorf("Expected compiler diagnostics back on and faustfmt kept, got %v and %q", c.CompilerDiagnostics, c.Formatter)
+	}
+
+	// Keys set by the config file once it changes take precedence over the settings again
+	os.WriteFile(config, []byte(`{"formatter": "builtin"}`), 0644)
+	if !configured(func(c server.FaustProjectConfig) bool {
+		return c.UnusedDiagnostics && c.Formatter == server.BuiltinFormatter
+	}) {
+		t.Errorf("Expected the formatter of the changed config file, got %q", s.Workspace.ConfigFor(root).Formatter)
+	}
+}
+
+func TestInitializationOptions(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	t.Cleanup(func() { logging.Level.Set(slog.LevelInfo) })
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "main.dsp"), []byte("process = _;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{
+			RootURI: transport.DocumentURI(util.Path2URI(root)),
+			InitializationOptions: map[string]any{"faustlsp": map[string]any{
+				"command":            "/opt/faust/bin/faust",
+				"include":            []string{"libs"},
+				"textual_references": true,
+				"log_level":          "debug",
+			}},
+		},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	c := s.Workspace.ConfigFor(root)
+	if c.Command != "/opt/faust/bin/faust" || !slices.Equal(c.IncludeDir, []util.Path{"libs"}) || !c.TextualReferences {
+		t.Errorf("Expected the config of the initialization options, got %q, %v and %v", c.Command, c.IncludeDir, c.TextualReferences)
+	}
+	if logging.Level.Level() != slog.LevelDebug {
+		t.Errorf("Expected debug logs, got %v", logging.Level.Level())
+	}
+
+	// Settings given later replace those of the initialization options they have
+	server.DidChangeConfiguration(t.Context(), &s, []byte(`{"settings": {"faustlsp": {"command": "faust-2.80"}}}`))
+	for range 50 {
+		if s.Workspace.ConfigFor(root).Command == "faust-2.80" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if c := s.Workspace.ConfigFor(root); c.Command != "faust-2.80" || !slices.Equal(c.IncludeDir, []util.Path{"libs"}) {
+		t.Errorf("Expected the new compiler with the include directories of the initialization options, got %q and %v", c.Command, c.IncludeDir)
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestArityCache(t *testing.T) {
+	cache := server.NewArityCache(2)
+	compiles := 0
+	compile := func(arity server.Arity, ok bool) func() (server.Arity, bool) {
+		return func() (server.Arity, bool) {
+			compiles++
+			return arity, ok
+		}
+	}
+
+	lowpass := server.NewSnippetKey(`import("stdfaust.lib");`, "fi.lowpass(2, 440)", "single")
+	for range 3 {
+		arity, ok := cache.Arity(lowpass, compile(server.Arity{Inputs: 1, Outputs: 1}, true))
+		if !ok || arity != (server.Arity{Inputs: 1, Outputs: 1}) {
+			t.Fatalf("unexpected arity %v", arity)
+		}
+	}
+	if compiles != 1 {
+		t.Errorf("expected the snippet to be compiled once, got %d compiles", compiles)
+	}
+
+	// Failures are cached too
+	broken := server.NewSnippetKey(`import("stdfaust.lib");`, "fi.unknown", "single")
+	cache.Arity(broken, compile(server.Arity{}, false))
+	if _, ok := cache.Arity(broken, compile(server.Arity{}, false)); ok || compiles != 2 {
+		t.Errorf("expected the failed compile to be cached, got %d compiles", compiles)
+	}
+
+	// Other statements or precisions are different snippets
+	if server.NewSnippetKey("", "fi.lowpass(2, 440)", "single") == lowpass || server.NewSnippetKey(`import("stdfaust.lib");`, "fi.lowpass(2, 440)", "double") == lowpass {
+		t.Errorf("expected keys to depend on statements and precision")
+	}
+
+	// Least recently used snippets are evicted past the cache's size
+	cache.Arity(lowpass, compile(server.Arity{}, false))
+	cache.Arity(server.NewSnippetKey("", "_,_", "single"), compile(server.Arity{Inputs: 2, Outputs: 2}, true))
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 cached snippets, got %d", cache.Len())
+	}
+	compiles = 0
+	cache.Arity(lowpass, compile(server.Arity{}, false))
+	cache.Arity(broken, compile(server.Arity{}, false))
+	if compiles != 1 {
+		t.Errorf("expected only the evicted snippet to be compiled again, got %d compiles", compiles)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestParseSnippets(t *testing.T) {
+	content := `{
+  "Stereo gain": {
+    "prefix": ["stgain", "gain2"],
+    "body": ["stgain(g) = *(g), *(g);", "$0"],
+    "description": "Stereo gain"
+  },
+  "osc": {
+    "body": "os.osc(${1:440})"
+  }
+}`
+	snippets, err := server.ParseSnippets([]byte(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []server.Snippet{
+		{Label: "Stereo gain", Prefix: "gain2", Body: "stgain(g) = *(g), *(g);\n$0", Description: "Stereo gain"},
+		{Label: "osc", Prefix: "osc", Body: "os.osc(${1:440})"},
+		{Label: "Stereo gain", Prefix: "stgain", Body: "stgain(g) = *(g), *(g);\n$0", Description: "Stereo gain"},
+	}
+	if len(snippets) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, snippets)
+	}
+	for i := range expected {
+		if snippets[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], snippets[i])
+		}
+	}
+
+	if _, err := server.ParseSnippets([]byte(`{"a": {"body": 1}}`)); err == nil {
+		t.Errorf("expected error for invalid body")
+	}
+}
+
+func TestSnippetCompletionItems(t *testing.T) {
+	r := transport.Range{Start: transport.Position{Line: 2, Character: 0}, End: transport.Position{Line: 2, Character: 3}}
+	items := server.SnippetCompletionItems([]server.Snippet{{Label: "osc", Prefix: "osc", Body: "os.osc(${1:440})", Description: "Sine"}}, r)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %v", items)
+	}
+	item := items[0]
+	if item.Label != "osc" || item.Kind != transport.SnippetCompletion || item.TextEdit.NewText != "os.osc(${1:440})" || item.TextEdit.Range != r {
+		t.Errorf("unexpected item: %v", item)
+	}
+	if item.InsertTextFormat == nil || *item.InsertTextFormat != transport.SnippetTextFormat {
+		t.Errorf("expected snippet format, got %v", item.InsertTextFormat)
+	}
+	if item.LabelDetails == nil || item.LabelDetails.Description != "Sine" {
+		t.Errorf("expected description in label details, got %v", item.LabelDetails)
+	}
+}
+
+func TestBuiltinAndConfigSnippets(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	os.WriteFile(main, []byte("process = _;\n\n"), 0644)
+	config := `{"compiler_diagnostics": false, "snippets": {"Team gain": {"prefix": "tgain", "body": "*(${1:0.5})"}}}`
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(config), 0644)
+	if diagnostics := server.ConfigDiagnostics([]byte(config), root, "utf-16"); len(diagnostics) != 0 {
+		t.Errorf("Expected snippets to be a known configuration key, got %v", diagnostics)
+	}
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	var capabilities transport.ClientCapabilities
+	capabilities.TextDocument.Completion.CompletionItem.SnippetSupport = true
+	params, _ := json.Marshal(transport.ParamInitialize{XInitializeParams: transport.XInitializeParams{
+		RootURI:      transport.DocumentURI(util.Path2URI(root)),
+		Capabilities: capabilities,
+	}})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	params, _ = json.Marshal(transport.CompletionParams{TextDocumentPositionParams: transport.TextDocumentPositionParams{
+		TextDocument: transport.TextDocumentIdentifier{URI: transport.DocumentURI(util.Path2URI(main))},
+		Position:     transport.Position{Line: 1, Character: 0},
+	}})
+	result, err := server.Completion(t.Context(), &s, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []transport.CompletionItem
+	json.Unmarshal(result, &items)
+	snippets := map[string]string{}
+	for _, item := range items {
+		if item.Kind == transport.SnippetCompletion {
+			snippets[item.Label] = item.Detail
+		}
+	}
+	for _, prefix := range []string{"tgain", "stereo", "oscui", "case", "letrec", "sliding"} {
+		if _, ok := snippets[prefix]; !ok {
+			t.Errorf("Expected the %s snippet to be completed, got %v", prefix, snippets)
+		}
+	}
+}
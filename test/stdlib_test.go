@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestLocateLibraryDir(t *testing.T) {
+	root := t.TempDir()
+	libraryDir := func(name string) util.Path {
+		dir := filepath.Join(root, name)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "stdfaust.lib"), []byte(`os = library("oscillators.lib");`), 0644)
+		os.WriteFile(filepath.Join(dir, "oscillators.lib"), []byte(`osc(f) = f;`), 0644)
+		return dir
+	}
+	vendored := libraryDir("faustlibraries")
+	compiler := libraryDir("compiler")
+	installed := libraryDir("installed")
+	empty := filepath.Join(root, "empty")
+	os.MkdirAll(empty, 0755)
+	compilerDir := func(dir util.Path) func() util.Path {
+		return func() util.Path { return dir }
+	}
+
+	tests := []struct {
+		name       string
+		configured util.Path
+		compiler   util.Path
+		expected   util.Path
+	}{
+		{"configured relative to root", "faustlibraries", compiler, vendored},
+		{"compiler", "", compiler, compiler},
+		{"invalid configured directory", "empty", compiler, compiler},
+		{"install directory without compiler", "", "", installed},
+		{"compiler directory without libraries", "", empty, installed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := server.LocateLibraryDir(tt.configured, root, compilerDir(tt.compiler), []util.Path{empty, installed})
+			if dir != tt.expected {
+				t.Errorf("LocateLibraryDir() = %s, expected %s", dir, tt.expected)
+			}
+		})
+	}
+	if dir := server.LocateLibraryDir("", root, compilerDir(""), []util.Path{empty}); dir != "" {
+		t.Errorf("Expected no library directory, got %s", dir)
+	}
+
+	files := server.LibraryFiles(installed)
+	expected := []util.Path{filepath.Join(installed, "oscillators.lib"), filepath.Join(installed, "stdfaust.lib")}
+	if !slices.Equal(files, expected) {
+		t.Errorf("LibraryFiles() = %v, expected %v", files, expected)
+	}
+}
+
+func TestImportSearchPath(t *testing.T) {
+	root := t.TempDir()
+	envDir := t.TempDir()
+	libraryDir := t.TempDir()
+	os.WriteFile(filepath.Join(libraryDir, "stdfaust.lib"), []byte(""), 0644)
+	os.WriteFile(filepath.Join(libraryDir, "shared.lib"), []byte(""), 0644)
+	os.WriteFile(filepath.Join(envDir, "shared.lib"), []byte(""), 0644)
+	t.Setenv("FAUST_LIB_PATH", envDir)
+
+	workspace := server.Workspace{Root: root}
+	workspace.Config.IncludeDir = []util.Path{"libs"}
+	workspace.Config.LibraryDir = libraryDir
+
+	expected := []util.Path{root, filepath.Join(root, "libs"), envDir, libraryDir}
+	if dirs := workspace.ImportSearchPath(root); !slices.Equal(dirs, expected) {
+		t.Errorf("ImportSearchPath() = %v, expected %v", dirs, expected)
+	}
+	// FAUST_LIB_PATH comes before the library directory, like for the compiler
+	if path, dir := workspace.ResolveFilePath("shared.lib", root); path != filepath.Join(envDir, "shared.lib") || dir != envDir {
+		t.Errorf("ResolveFilePath() = %s, %s, expected the file in FAUST_LIB_PATH", path, dir)
+	}
+	if path, _ := workspace.ResolveFilePath("stdfaust.lib", root); path != filepath.Join(libraryDir, "stdfaust.lib") {
+		t.Errorf("ResolveFilePath() = %s, expected the file in the library directory", path)
+	}
+}
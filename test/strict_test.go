@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+)
+
+func TestStrictConfig(t *testing.T) {
+	var config server.FaustProjectConfig
+	if err := json.Unmarshal([]byte(`{}`), &config); err != nil || config.Strict.Enabled {
+		t.Errorf("expected strict mode to be off by default, got %+v", config.Strict)
+	}
+	if err := json.Unmarshal([]byte(`{"strict": true}`), &config); err != nil || !config.Strict.Enabled || config.Strict.MaxLines != 1000 || len(config.Strict.RequiredDeclares) != 3 {
+		t.Errorf("expected strict mode with defaults, got %+v", config.Strict)
+	}
+	if err := json.Unmarshal([]byte(`{"strict": {"max_nesting": 2, "required_declares": ["license"]}}`), &config); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !config.Strict.Enabled || config.Strict.MaxNesting != 2 || config.Strict.MaxLines != 1000 || len(config.Strict.RequiredDeclares) != 1 {
+		t.Errorf("expected overridden defaults, got %+v", config.Strict)
+	}
+}
+
+func TestStrictDiagnostics(t *testing.T) {
+	parser.Init()
+
+	var config server.FaustProjectConfig
+	json.Unmarshal([]byte(`{"strict": {"max_nesting": 2, "max_lines": 6}}`), &config)
+
+	code := `declare name "lib";
+declare author "someone";
+Gain(X) = *(X);
+f = par(i, 2, _ with { g = case { (x) => x; }; });
+h = \(Y).(Y + 1);
+`
+	diagnostics := server.StrictDiagnostics(parseRoot(t, []byte(code)), []byte(code), config.Strict, true)
+	codes := map[string][]string{}
+	for _, d := range diagnostics {
+		code := d.Code.(string)
+		codes[code] = append(codes[code], d.Message)
+	}
+	if msgs := codes["missing-declare"]; len(msgs) != 1 || !strings.Contains(msgs[0], "license") {
+		t.Errorf("expected missing license, got %v", msgs)
+	}
+	if msgs := codes["naming-convention"]; len(msgs) != 3 {
+		t.Errorf("expected Gain, X and Y to break naming conventions, got %v", msgs)
+	}
+	if msgs := codes["nesting-too-deep"]; len(msgs) != 1 {
+		t.Errorf("expected the case nested in the with block of par to be too deep, got %v", msgs)
+	}
+	if len(codes["file-too-long"]) != 0 {
+		t.Errorf("expected file to be short enough, got %v", codes["file-too-long"])
+	}
+
+	// Only libraries need declares, and longer files get a warning
+	long := code + strings.Repeat("\n", 5)
+	diagnostics = server.StrictDiagnostics(parseRoot(t, []byte(long)), []byte(long), config.Strict, false)
+	for _, d := range diagnostics {
+		if d.Code == "missing-declare" {
+			t.Errorf("unexpected declare warning for a .dsp file: %v", d)
+		}
+	}
+	found := false
+	for _, d := range diagnostics {
+		if d.Code == "file-too-long" && d.Range.Start.Line == 6 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected file length warning at line 6, got %v", diagnostics)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestText(t *testing.T) {
+	content := "import(\"stdfaust.lib\");\n// 😀 é\nprocess = _;\n"
+	text := server.NewText([]byte(content))
+	inserts := []string{"", "x", "\n", "é", "😀", "gain = 0.5;\n", "a\nb\n"}
+	r := rand.New(rand.NewSource(1))
+	for i := range 500 {
+		// Edits start at rune boundaries, as positions of the editor do
+		boundaries := []uint{}
+		for offset := range content {
+			boundaries = append(boundaries, uint(offset))
+		}
+		boundaries = append(boundaries, uint(len(content)))
+		start := boundaries[r.Intn(len(boundaries))]
+		end := boundaries[r.Intn(len(boundaries))]
+		start, end = min(start, end), max(start, end)
+		insert := inserts[r.Intn(len(inserts))]
+
+		text.Replace(start, end, insert)
+		content = content[:start] + insert + content[end:]
+		if got := string(text.Bytes()); got != content {
+			t.Fatalf("Edit %d: expected %q after replacing %d-%d with %q, got %q", i, content, start, end, insert, got)
+		}
+		if text.Len() != uint(len(content)) {
+			t.Fatalf("Edit %d: expected length %d, got %d", i, len(content), text.Len())
+		}
+		if from, to := uint(r.Intn(len(content)+1)), uint(r.Intn(len(content)+1)); from <= to {
+			if got := string(text.Slice(from, to)); got != content[from:to] {
+				t.Fatalf("Edit %d: expected slice %q, got %q", i, content[from:to], got)
+			}
+		}
+
+		lines := server.NewLineIndex(content)
+		for _, offset := range boundaries[:min(len(boundaries), 20)] {
+			offset = min(offset, uint(len(content)))
+			want, _ := server.OffsetToPosition(offset, content, "utf-16")
+			if got, _ := text.OffsetToPosition(lines, offset, "utf-16"); got != want {
+				t.Fatalf("Edit %d: expected offset %d at %v, got %v", i, offset, want, got)
+			}
+			wantOffset, _ := server.PositionToOffset(want, content, "utf-16")
+			if got, _ := text.PositionToOffset(lines, want, "utf-16"); got != wantOffset {
+				t.Fatalf("Edit %d: expected %v at offset %d, got %d", i, want, wantOffset, got)
+			}
+		}
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	code := "gain = 0.5;\n// a comment\nprocess = _ * gain;\n"
+	changes := []transport.TextDocumentContentChangeEvent{
+		{Range: &transport.Range{Start: transport.Position{Line: 2, Character: 18}, End: transport.Position{Line: 2, Character: 18}}, Text: " * 2"},
+		{Range: &transport.Range{Start: transport.Position{Line: 1, Character: 0}, End: transport.Position{Line: 2, Character: 0}}, Text: ""},
+		{Range: &transport.Range{Start: transport.Position{Line: 0, Character: 0}, End: transport.Position{Line: 0, Character: 0}}, Text: "freq = 440;\n"},
+		{Range: &transport.Range{Start: transport.Position{Line: 1, Character: 7}, End: transport.Position{Line: 1, Character: 10}}, Text: "😀"},
+	}
+	path := filepath.Join(t.TempDir(), "main.dsp")
+
+	// Changes applied one by one
+	var oneByOne server.Files
+	oneByOne.Init(t.Context(), transport.UTF16)
+	oneByOne.Add(util.FromPath(path), []byte(code))
+	for _, change := range changes {
+		oneByOne.ModifyIncremental(path, *change.Range, change.Text)
+	}
+	expected, _ := oneByOne.GetFromPath(path)
+
+	// The same changes applied as one notification, after the syntax tree was made
+	var files server.Files
+	files.Init(t.Context(), transport.UTF16)
+	files.Add(util.FromPath(path), []byte(code))
+	f, _ := files.GetFromPath(path)
+	f.SemanticTokens(t.Context(), "utf-16")
+	files.ApplyChanges(path, 0, changes)
+	if string(f.Content) != string(expected.Content) {
+		t.Fatalf("Expected %q, got %q", expected.Content, f.Content)
+	}
+	if f.Hash != expected.Hash {
+		t.Error("Expected the hash of the changed content")
+	}
+
+	tokens, _ := f.SemanticTokens(t.Context(), "utf-16")
+	var fresh server.Files
+	fresh.Init(t.Context(), transport.UTF16)
+	fresh.Add(util.FromPath(path), f.Content)
+	freshFile, _ := fresh.GetFromPath(path)
+	freshTokens, _ := freshFile.SemanticTokens(t.Context(), "utf-16")
+	if !slices.Equal(tokens.Data, freshTokens.Data) {
+		t.Errorf("Expected tokens %v of the reparsed tree, got %v", freshTokens.Data, tokens.Data)
+	}
+
+	// Changes without a range replace the whole content
+	files.ApplyChanges(path, 0, []transport.TextDocumentContentChangeEvent{
+		{Text: "process = _;\n"},
+		{Range: &transport.Range{Start: transport.Position{Line: 0, Character: 11}, End: transport.Position{Line: 0, Character: 11}}, Text: " : _"},
+	})
+	if string(f.Content) != "process = _ : _;\n" {
+		t.Errorf("Expected the full change then the incremental one to be applied, got %q", f.Content)
+	}
+}
@@ -1,21 +1,34 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/carn181/faustlsp/logging"
 	"github.com/carn181/faustlsp/transport"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestSocket(test *testing.T) {
-	expectedMsg := []byte("Content-Length: 4\r\n\r\nHey!")
+	expectedMsg := []byte("Hey!")
 	client := func() {
+		conn := dialServer(test)
+		if conn == nil {
+			return
+		}
 		var t transport.Transport
-		t.Init(transport.Client, transport.Socket)
+		t.InitConn(transport.Client, conn)
 
 		err := t.Write([]byte("Hey!"))
 		if err != nil {
-			test.Fatal(err)
+			test.Error(err)
 		}
 
 		t.Close()
@@ -23,25 +36,212 @@ func TestSocket(test *testing.T) {
 
 	server := func() {
 		var t transport.Transport
+		defer t.Close()
 
-		t.Init(transport.Server, transport.Socket)
+		if err := t.Init(transport.Server, transport.Socket); err != nil {
+			test.Error(err)
+			return
+		}
 
 		msg, err := t.Read()
 		if err != nil {
-			fmt.Println(err)
+			test.Error(err)
+			return
+		}
+
+		if !bytes.Equal(msg, expectedMsg) {
+			test.Errorf("Got different message: %s\n", string(msg))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server()
+		close(done)
+	}()
+	client()
+	<-done
+}
+
+func TestIsResponse(test *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{`{"jsonrpc":"2.0","id":"faustlsp-progress-1","result":null}`, true},
+		{`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"unknown"}}`, true},
+		{`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{}}`, false},
+		{`{"jsonrpc":"2.0","method":"initialized","params":{}}`, false},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		if got := transport.IsResponse([]byte(tt.msg)); got != tt.want {
+			test.Errorf("IsResponse(%s) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+// Writer counting the writes made to it
+type countingWriter struct {
+	bytes.Buffer
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (w *countingWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestNotificationBatching(test *testing.T) {
+	logging.Init()
+	var w countingWriter
+	t := transport.Transport{Writer: &w}
+	for i := 0; i < 100; i++ {
+		if err := t.WriteNotif("textDocument/publishDiagnostics", []byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
 			test.Fatal(err)
 		}
+	}
+	if err := t.WriteResponse(1, []byte("null"), nil); err != nil {
+		test.Fatal(err)
+	}
+	// Notifications are only written on their own if the flush interval passed while queuing them
+	if writes := w.count(); writes > 10 {
+		test.Errorf("expected notifications to be written together, got %d writes", writes)
+	}
+
+	// Messages are written in order, each with its header
+	var want bytes.Buffer
+	frame := func(msg string) {
+		fmt.Fprintf(&want, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	for i := 0; i < 100; i++ {
+		frame(fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"n":%d}}`, i))
+	}
+	frame(`{"jsonrpc":"2.0","id":1,"result":null}`)
+	if !bytes.Equal(w.Bytes(), want.Bytes()) {
+		test.Errorf("expected\n%s\ngot\n%s", want.String(), w.String())
+	}
+
+	// Notifications not followed by other messages are written after the flush interval
+	writes := w.count()
+	t.WriteNotif("$/progress", []byte("{}"))
+	for deadline := time.Now().Add(time.Second); w.count() == writes; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			test.Fatal("expected the notification to be written after the flush interval")
+		}
+	}
+}
 
-		bytes.Equal(msg, expectedMsg)
+func TestReadFraming(test *testing.T) {
+	stream := "Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}" +
+		"\r\ncontent-length:7\n\n[1,2,3]" +
+		"Content-Length: 12\r\n\r\n{\"a\":\"\r\n\r\n\"}" +
+		"Content-Length: 4\r\n\r\nnull"
+	var t transport.Transport
+	// Messages come in as many reads as there are bytes
+	t.Reader = bufio.NewReader(iotest.OneByteReader(strings.NewReader(stream)))
+	t.MaxMessageSize = 10
+	want := []string{"{}", "[1,2,3]", "", "null"}
+	for i, w := range want {
+		msg, err := t.Read()
+		var tooLarge *transport.MessageTooLargeError
+		if w == "" {
+			if !errors.As(err, &tooLarge) || tooLarge.Size != 12 {
+				test.Fatalf("message %d: expected a too large error, got %q, %v", i, msg, err)
+			}
+			continue
+		}
+		if err != nil || string(msg) != w {
+			test.Fatalf("message %d: expected %q, got %q, %v", i, w, msg, err)
+		}
+	}
+	if _, err := t.Read(); !errors.Is(err, io.EOF) || !t.Closed {
+		test.Errorf("expected the end of the stream to close the transport, got %v", err)
+	}
 
-		if !bytes.Equal(msg, expectedMsg) {
-			test.Fatalf("Got different message: %s\n", string(msg))
+	invalid := []string{
+		"Content-Length: 10\r\n\r\n{}",
+		"Content-Type: text/plain\r\n\r\n{}",
+		"Content-Length: two\r\n\r\n{}",
+		"Content-Length 2\r\n\r\n{}",
+	}
+	for _, stream := range invalid {
+		t := transport.Transport{Reader: bufio.NewReader(strings.NewReader(stream))}
+		if msg, err := t.Read(); err == nil {
+			test.Errorf("expected an error reading %q, got %q", stream, msg)
 		}
+	}
+}
 
-		t.Close()
+func TestConcurrentWrites(test *testing.T) {
+	logging.Init()
+	var w countingWriter
+	t := transport.Transport{Writer: &w}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			t.WriteResponse(i, []byte(`"result"`), nil)
+		}()
+		go func() {
+			defer wg.Done()
+			t.WriteNotif("$/progress", []byte(fmt.Sprintf(`{"n":%d}`, i)))
+		}()
 	}
+	wg.Wait()
+	t.Flush()
 
-	go func() { server() }()
-	client()
+	// Messages aren't interleaved, each one reads back whole
+	r := transport.Transport{Reader: bufio.NewReader(bytes.NewReader(w.Bytes()))}
+	for i := 0; i < 100; i++ {
+		msg, err := r.Read()
+		if err != nil || !json.Valid(msg) {
+			test.Fatalf("message %d: expected JSON, got %q, %v", i, msg, err)
+		}
+	}
+}
 
+func TestHoldUntilInitialized(test *testing.T) {
+	logging.Init()
+	var w countingWriter
+	t := transport.Transport{Writer: &w}
+	t.Hold()
+	t.WriteNotif("textDocument/publishDiagnostics", []byte(`{"n":1}`))
+	t.WriteRequest("faustlsp-1", "client/registerCapability", []byte("{}"))
+	t.WriteNotif("window/logMessage", []byte(`{"n":2}`))
+	t.WriteResponse(1, []byte("null"), nil)
+
+	var want bytes.Buffer
+	frame := func(msg string) {
+		fmt.Fprintf(&want, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	// Messages a server can send while initializing and responses are written
+	frame(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"n":2}}`)
+	frame(`{"jsonrpc":"2.0","id":1,"result":null}`)
+	if !bytes.Equal(w.Bytes(), want.Bytes()) {
+		test.Errorf("expected\n%s\ngot\n%s", want.String(), w.String())
+	}
+
+	// Others are written in order once released
+	if err := t.Release(); err != nil {
+		test.Fatal(err)
+	}
+	frame(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"n":1}}`)
+	frame(`{"jsonrpc":"2.0","id":"faustlsp-1","method":"client/registerCapability","params":{}}`)
+	t.WriteNotif("textDocument/publishDiagnostics", []byte(`{"n":3}`))
+	t.Flush()
+	frame(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"n":3}}`)
+	if !bytes.Equal(w.Bytes(), want.Bytes()) {
+		test.Errorf("expected\n%s\ngot\n%s", want.String(), w.String())
+	}
 }
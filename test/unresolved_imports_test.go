@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestUnresolvedImports(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	files := map[string]util.Path{
+		"stdfaust.lib":   "/usr/share/faust/stdfaust.lib",
+		"filters.lib":    "/usr/share/faust/filters.lib",
+		"voices/osc.dsp": "/project/voices/osc.dsp",
+	}
+	resolve := func(path string) util.Path { return files[path] }
+	candidates := []string{"voices/osc.dsp", "stdfaust.lib", "filters.lib"}
+
+	code := "import(\"stdfaust.lib\");\nimport(\"filter.lib\");\nosc = component(\"voice/osc.dsp\");\nfx = library(\"effects.lib\");\n"
+	diagnostics := server.UnresolvedImportDiagnostics(parseRoot(t, []byte(code)), []byte(code), resolve, candidates)
+	if len(diagnostics) != 3 {
+		t.Fatalf("UnresolvedImportDiagnostics() = %v, want errors for the 3 missing files", diagnostics)
+	}
+	for i, want := range []struct {
+		line       uint32
+		suggestion string
+	}{
+		{1, "filters.lib"},
+		{2, "voices/osc.dsp"},
+		{3, ""},
+	} {
+		d := diagnostics[i]
+		if d.Range.Start.Line != want.line || d.Severity != transport.DiagnosticSeverity(transport.Error) {
+			t.Errorf("Diagnostic %d = %v, want an error on line %d", i, d, want.line)
+		}
+		if suggested := strings.Contains(d.Message, "Did you mean"); suggested != (want.suggestion != "") || !strings.Contains(d.Message, want.suggestion) {
+			t.Errorf("Diagnostic %d message %q, want suggestion %q", i, d.Message, want.suggestion)
+		}
+	}
+
+	uri := transport.DocumentURI("file:///project/main.dsp")
+	line := transport.Range{Start: transport.Position{Line: 1}, End: transport.Position{Line: 1, Character: 20}}
+	actions := server.UnresolvedImportActions(parseRoot(t, []byte(code)), []byte(code), uri, line, diagnostics, candidates)
+	if len(actions) != 1 || actions[0].Title != `Import "filters.lib" instead` {
+		t.Fatalf("UnresolvedImportActions() = %v, want a fix importing filters.lib", actions)
+	}
+	if edit := actions[0].Edit.Changes[uri][0]; edit.NewText != `"filters.lib"` || edit.Range != diagnostics[0].Range {
+		t.Errorf("Unexpected fix %v", edit)
+	}
+
+	// Far off paths aren't suggested
+	if suggestion, ok := server.ClosestImportPath("reverbs.lib", []string{"maths.lib", "voices/osc.dsp"}); ok {
+		t.Errorf("ClosestImportPath(reverbs.lib) = %s, want no suggestion", suggestion)
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestUntitledDocument(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	lib := filepath.Join(root, "gain.lib")
+	os.WriteFile(lib, []byte("gain = *(0.5);\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	// The content of documents that were never saved is the one the editor sends
+	uri := transport.DocumentURI("untitled:Untitled-1")
+	code := "import(\"gain.lib\");\nprocess = gain;\n"
+	params, _ = json.Marshal(transport.DidOpenTextDocumentParams{
+		TextDocument: transport.TextDocumentItem{URI: uri, LanguageID: "faust", Text: code},
+	})
+	if err := server.TextDocumentOpen(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := util.URI2path(string(uri))
+	if !server.IsDSPFile(path) {
+		t.Errorf("Expected %s to be edited as a .dsp file", path)
+	}
+	if d := s.Files.TSDiagnostics(path); d.URI != uri || len(d.Diagnostics) != 0 {
+		t.Errorf("Expected no syntax errors published for %s, got %v", uri, d)
+	}
+
+	// Symbols are analyzed as for files, resolving imports from the workspace
+	params, _ = json.Marshal(transport.DefinitionParams{
+		TextDocumentPositionParams: transport.TextDocumentPositionParams{
+			TextDocument: transport.TextDocumentIdentifier{URI: uri},
+			Position:     transport.Position{Line: 1, Character: 11},
+		},
+	})
+	var location transport.Location
+	for range 50 {
+		result, err := server.GetDefinition(t.Context(), &s, params)
+		if err == nil && json.Unmarshal(result, &location) == nil && location.URI != "" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if location.URI != transport.DocumentURI(util.Path2URI(lib)) {
+		t.Errorf("Expected gain to be defined in %s, got %v", lib, location)
+	}
+
+	// The compiler is given a synthesized .dsp file with the document's content
+	temp := s.Workspace.TempDirPath(path)
+	if filepath.Ext(temp) != ".dsp" {
+		t.Errorf("Expected a .dsp temporary file, got %s", temp)
+	}
+	if content, err := os.ReadFile(temp); err != nil || string(content) != code {
+		t.Errorf("Expected the document's content in %s, got %q, %v", temp, content, err)
+	}
+
+	params, _ = json.Marshal(transport.DidCloseTextDocumentParams{TextDocument: transport.TextDocumentIdentifier{URI: uri}})
+	server.TextDocumentClose(t.Context(), &s, params)
+	for range 50 {
+		if _, ok := s.Files.GetFromPath(path); !ok {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, ok := s.Files.GetFromPath(path); ok {
+		t.Error("Expected the closed document to leave the store")
+	}
+	if _, err := os.Stat(temp); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed, got %v", err)
+	}
+}
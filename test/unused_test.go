@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestUnusedDefinitionDiagnostics(t *testing.T) {
+	parser.Init()
+
+	lib := `helper = 2;
+`
+	code := `import("stdfaust.lib");
+gain = 0.5;
+unused(x) = x * 2;
+process = _ * gain * helper;
+`
+	used := server.UsedNames(parseRoot(t, []byte(lib)), []byte(lib))
+	for name := range server.UsedNames(parseRoot(t, []byte(code)), []byte(code)) {
+		used[name] = struct{}{}
+	}
+	if _, ok := used["unused"]; ok {
+		t.Fatalf("declarations shouldn't count as used: %v", used)
+	}
+
+	diagnostics := server.UnusedDefinitionDiagnostics(parseRoot(t, []byte(code)), []byte(code), used, "process")
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	d := diagnostics[0]
+	expected := transport.Range{Start: transport.Position{Line: 2, Character: 0}, End: transport.Position{Line: 2, Character: 6}}
+	if d.Range != expected {
+		t.Errorf("expected range %v, got %v", expected, d.Range)
+	}
+	if !slices.Contains(d.Tags, transport.Unnecessary) {
+		t.Errorf("expected diagnostic to be tagged as unnecessary, got %v", d.Tags)
+	}
+}
+
+func TestShadowDiagnostics(t *testing.T) {
+	parser.Init()
+
+	code := `gain = 0.5;
+f(x) = y * x with { x = 2; y = gain with { gain = 1; }; };
+g = z with { z = 3; };
+`
+	diagnostics := server.ShadowDiagnostics(parseRoot(t, []byte(code)), []byte(code))
+	for _, d := range diagnostics {
+		if d.Severity != transport.SeverityWarning {
+			t.Errorf("expected warning, got %v", d.Severity)
+		}
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+	}
+	if diagnostics[0].Range.Start.Character != 20 || diagnostics[1].Range.Start.Character != 43 {
+		t.Errorf("unexpected ranges: %v %v", diagnostics[0].Range, diagnostics[1].Range)
+	}
+	if diagnostics[1].Message != "gain shadows gain defined at line 1" {
+		t.Errorf("unexpected message: %s", diagnostics[1].Message)
+	}
+}
+
+func TestUnusedDefinitionsOfImports(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	main := filepath.Join(root, "main.dsp")
+	a := filepath.Join(root, "a.dsp")
+	os.WriteFile(main, []byte("import(\"a.dsp\");\nprocess = f;\n"), 0644)
+	os.WriteFile(a, []byte("f = 1;\ng = 2;\nprocess = _;\n"), 0644)
+	os.WriteFile(filepath.Join(root, ".faustcfg.json"), []byte(`{"compiler_diagnostics": false}`), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	var output lockedBuffer
+	s.Transport.Writer = &output
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	for range 20 {
+		time.Sleep(300 * time.Millisecond)
+		if output.take() == "" {
+			break
+		}
+	}
+
+	// a.dsp is diagnosed again once main.dsp uses g rather than f
+	s.Files.ModifyFull(main, "import(\"a.dsp\");\nprocess = g;\n")
+	s.Workspace.DiagnoseFile(main, &s)
+	time.Sleep(100 * time.Millisecond)
+	published := output.take()
+	if !strings.Contains(published, util.Path2URI(a)) || !strings.Contains(published, "f is never used") {
+		t.Errorf("Expected f of a.dsp to be reported as unused, got %s", published)
+	}
+	if strings.Contains(published, "g is never used") {
+		t.Errorf("Expected g of a.dsp to be used, got %s", published)
+	}
+
+	// Nothing else is diagnosed again while the names main.dsp uses stay the same
+	s.Files.ModifyFull(main, "import(\"a.dsp\");\nprocess = g : _;\n")
+	s.Workspace.DiagnoseFile(main, &s)
+	time.Sleep(100 * time.Millisecond)
+	if published := output.take(); strings.Contains(published, util.Path2URI(a)) {
+		t.Errorf("Expected a.dsp not to be diagnosed again, got %s", published)
+	}
+}
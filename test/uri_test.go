@@ -48,3 +48,19 @@ func TestIsWindowsPath(t *testing.T) {
 		fmt.Printf(" Is Windows: %t\n", util.IsWindowsDrivePath(path))
 	}
 }
+
+func TestVirtualPaths(t *testing.T) {
+	// Documents that aren't files get paths that convert back to their URI
+	for _, uri := range []string{"untitled:Untitled-1", "untitled:Untitled%201"} {
+		path, err := util.URI2path(uri)
+		if err != nil || !util.IsVirtualPath(path) {
+			t.Errorf("URI2path(%s) = %s, %v, expected a virtual path", uri, path, err)
+		}
+		if back := util.Path2URI(path); back != uri {
+			t.Errorf("Path2URI(%s) = %s, expected %s", path, back, uri)
+		}
+	}
+	if path, _ := util.URI2path("file:///home/user/a.dsp"); util.IsVirtualPath(path) {
+		t.Errorf("Expected %s not to be virtual", path)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestDocumentVersions(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+	path := filepath.Join(root, "main.dsp")
+	os.WriteFile(path, []byte("process = _;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	uri := transport.DocumentURI(util.Path2URI(path))
+	params, _ = json.Marshal(transport.DidOpenTextDocumentParams{
+		TextDocument: transport.TextDocumentItem{URI: uri, LanguageID: "faust", Version: 1, Text: "process = _;\n"},
+	})
+	server.TextDocumentOpen(t.Context(), &s, params)
+	change := func(version int32, changes ...transport.TextDocumentContentChangeEvent) {
+		params, _ := json.Marshal(transport.DidChangeTextDocumentParams{
+			TextDocument: transport.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: transport.TextDocumentIdentifier{URI: uri}, Version: version,
+			},
+			ContentChanges: changes,
+		})
+		server.TextDocumentChangeIncremental(t.Context(), &s, params)
+	}
+	insert := func(line, char uint32, text string) transport.TextDocumentContentChangeEvent {
+		pos := transport.Position{Line: line, Character: char}
+		return transport.TextDocumentContentChangeEvent{Range: &transport.Range{Start: pos, End: pos}, Text: text}
+	}
+	f, _ := s.Files.GetFromPath(path)
+
+	// Several changes of a notification are applied in order, as one version
+	change(2, insert(0, 11, " : _"), insert(0, 0, "gain = 1;\n"))
+	if string(f.Content) != "gain = 1;\nprocess = _ : _;\n" || f.Version != 2 {
+		t.Fatalf("Expected the changes of version 2 to be applied, got %q at version %d", f.Content, f.Version)
+	}
+
+	// Changes that aren't newer than the document are rejected
+	change(2, insert(0, 0, "// repeated\n"))
+	change(1, insert(0, 0, "// older\n"))
+	if string(f.Content) != "gain = 1;\nprocess = _ : _;\n" || f.Version != 2 {
+		t.Errorf("Expected out of order changes to be rejected, got %q at version %d", f.Content, f.Version)
+	}
+
+	change(5, insert(1, 0, "// newer\n"))
+	if string(f.Content) != "gain = 1;\n// newer\nprocess = _ : _;\n" || f.Version != 5 {
+		t.Errorf("Expected the changes of version 5 to be applied, got %q at version %d", f.Content, f.Version)
+	}
+
+	// Diagnostics are published for the version they were computed on
+	if d := s.Files.TSDiagnostics(path); d.Version != 5 {
+		t.Errorf("Expected diagnostics of version 5, got %d", d.Version)
+	}
+	s.Files.CloseFromPath(path)
+	if d := s.Files.TSDiagnostics(path); d.Version != 0 {
+		t.Errorf("Expected diagnostics of the closed document without a version, got %d", d.Version)
+	}
+}
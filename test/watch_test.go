@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestWatchNestedDirectories(t *testing.T) {
+	logging.Init()
+	parser.Init()
+	root := t.TempDir()
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	params, _ := json.Marshal(transport.ParamInitialize{
+		XInitializeParams: transport.XInitializeParams{RootURI: transport.DocumentURI(util.Path2URI(root))},
+	})
+	if _, err := server.Initialize(t.Context(), &s, params); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+	// The watcher is started in the background
+	time.Sleep(100 * time.Millisecond)
+
+	inStore := func(path util.Path, want bool) bool {
+		for range 50 {
+			if _, ok := s.Files.GetFromPath(path); ok == want {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	// Trees created at once have files in directories that weren't watched yet
+	deep := filepath.Join(root, "a", "b", "c", "deep.dsp")
+	os.MkdirAll(filepath.Dir(deep), 0755)
+	os.WriteFile(deep, []byte("process = _;\n"), 0644)
+	if !inStore(deep, true) {
+		t.Fatal("Expected the file of the created tree in the store")
+	}
+	// Its directories are watched for later changes
+	later := filepath.Join(root, "a", "b", "later.lib")
+	os.WriteFile(later, []byte("gain = *(0.5);\n"), 0644)
+	if !inStore(later, true) {
+		t.Error("Expected the file created in the tree afterwards in the store")
+	}
+
+	// Trees moved into the workspace come with their files
+	outside := t.TempDir()
+	moved := filepath.Join(outside, "moved")
+	os.MkdirAll(filepath.Join(moved, "sub"), 0755)
+	os.WriteFile(filepath.Join(moved, "sub", "moved.dsp"), []byte("process = _;\n"), 0644)
+	os.Rename(moved, filepath.Join(root, "moved"))
+	if !inStore(filepath.Join(root, "moved", "sub", "moved.dsp"), true) {
+		t.Error("Expected the file of the tree moved into the workspace in the store")
+	}
+
+	// Trees moved out of the workspace or removed take their files with them
+	os.Rename(filepath.Join(root, "moved"), moved)
+	if !inStore(filepath.Join(root, "moved", "sub", "moved.dsp"), false) {
+		t.Error("Expected the file of the tree moved out of the workspace to leave the store")
+	}
+	os.RemoveAll(filepath.Join(root, "a"))
+	if !inStore(deep, false) || !inStore(later, false) {
+		t.Error("Expected the files of the removed tree to leave the store")
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestClientWatchedFiles(t *testing.T) {
+	logging.Init()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.dsp"), []byte("process = _;\n"), 0644)
+
+	var s server.Server
+	s.Init(transport.Stdin)
+	s.Transport.Writer = io.Discard
+	t.Cleanup(func() { os.RemoveAll(s.Workspace.TempDirPath("")) })
+	var params transport.ParamInitialize
+	params.RootURI = transport.DocumentURI(util.Path2URI(root))
+	params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = true
+	content, _ := json.Marshal(params)
+	if _, err := server.Initialize(t.Context(), &s, content); err != nil {
+		t.Fatal(err)
+	}
+	server.Initialized(t.Context(), &s, []byte("{}"))
+
+	// Files created in new directories are indexed from the client's events alone
+	path := filepath.Join(root, "effects", "b.dsp")
+	code := "process = *(0.5);\n"
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, []byte(code), 0644)
+	changes := func(path util.Path, change transport.FileChangeType) []byte {
+		content, _ := json.Marshal(transport.DidChangeWatchedFilesParams{
+			Changes: []transport.FileEvent{{URI: transport.DocumentURI(util.Path2URI(path)), Type: change}},
+		})
+		return content
+	}
+	// Events are handled in batches, so the store is polled until it has the change
+	inStore := func(want bool) bool {
+		for range 50 {
+			if _, ok := s.Files.GetFromPath(path); ok == want {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if err := server.DidChangeWatchedFiles(t.Context(), &s, changes(path, transport.Created)); err != nil {
+		t.Fatal(err)
+	}
+	inStore(true)
+	if f, ok := s.Files.GetFromPath(path); !ok || string(f.Content) != code {
+		t.Errorf("Expected the created file in the store, got %v", f)
+	}
+	if _, err := os.Stat(s.Workspace.TempDirPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the saved file not to be copied to the overlay, got %v", err)
+	}
+
+	os.Remove(path)
+	server.DidChangeWatchedFiles(t.Context(), &s, changes(path, transport.Deleted))
+	if !inStore(false) {
+		t.Error("Expected the deleted file to leave the store")
+	}
+}
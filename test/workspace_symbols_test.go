@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carn181/faustlsp/logging"
+	"github.com/carn181/faustlsp/parser"
+	"github.com/carn181/faustlsp/server"
+	"github.com/carn181/faustlsp/transport"
+	"github.com/carn181/faustlsp/util"
+)
+
+func TestMatchesQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"lowpass", "", true},
+		{"lowpass", "lp", true},
+		{"lowpass", "LPS", true},
+		{"lowpass", "pl", false},
+		{"résonance", "rsn", true},
+		{"gain", "gains", false},
+	}
+	for _, tt := range tests {
+		if got := server.MatchesQuery(tt.name, tt.query); got != tt.want {
+			t.Errorf("MatchesQuery(%q, %q) = %v, expected %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestWorkspaceSymbolResolve(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filters", "lp.dsp")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	code := "fi = library(\"filters.lib\");\nlowpass(x) = x;\ngain = hslider(\"é\", 0, 0, 1, 0.1);\nprocess = lowpass;\n"
+	os.WriteFile(path, []byte(code), 0644)
+
+	var s server.Server
+	s.Files.Init(t.Context(), transport.UTF16)
+	s.Store = server.Store{
+		Files:        &s.Files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	workspace := server.Workspace{Root: dir}
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+	s.Files.OpenFromPath(path)
+	f, _ := s.Files.GetFromPath(path)
+	workspace.ParseFile(f, &s.Store, make(map[util.Path]struct{}), fileChan)
+
+	symbols := server.FileWorkspaceSymbols(path, dir, f.Scope, "lp", false)
+	if len(symbols) != 1 || symbols[0].Name != "lowpass" || symbols[0].Kind != transport.Function || symbols[0].ContainerName != "filters/lp.dsp" {
+		t.Fatalf("Expected lowpass in filters/lp.dsp, got %+v", symbols)
+	}
+	if symbols[0].Location.Range != nil || symbols[0].Data == nil {
+		t.Fatalf("Expected range of lowpass to be left to resolve, got %+v", symbols[0])
+	}
+	if symbols := server.FileWorkspaceSymbols(path, dir, f.Scope, "", true); len(symbols) != 4 || symbols[0].Location.Range == nil {
+		t.Errorf("Expected every top-level definition with its range, got %+v", symbols)
+	}
+
+	// Ranges are converted to the client's encoding, where é is one character
+	symbols = server.FileWorkspaceSymbols(path, dir, f.Scope, "gn", false)
+	if len(symbols) != 1 {
+		t.Fatalf("Expected gain, got %+v", symbols)
+	}
+	params, _ := json.Marshal(symbols[0])
+	raw, err := server.WorkspaceSymbolResolve(t.Context(), &s, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resolved struct {
+		Location transport.Location `json:"location"`
+		Data     any                `json:"data"`
+	}
+	json.Unmarshal(raw, &resolved)
+	want := transport.Range{Start: transport.Position{Line: 2}, End: transport.Position{Line: 2, Character: 33}}
+	if resolved.Location.Range != want || resolved.Data != nil {
+		t.Errorf("Expected gain resolved at %v, got %s", want, raw)
+	}
+}
+
+func TestCanceledWorkspaceSymbols(t *testing.T) {
+	logging.Init()
+	parser.Init()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.dsp")
+	os.WriteFile(path, []byte("lowpass(x) = x;\nprocess = lowpass;\n"), 0644)
+
+	var s server.Server
+	s.Files.Init(t.Context(), transport.UTF16)
+	s.Store = server.Store{
+		Files:        &s.Files,
+		Dependencies: server.NewDependencyGraph(),
+		Cache:        make(map[[sha256.Size]byte]*server.Scope),
+	}
+	s.Workspace = server.Workspace{Root: dir, Files: server.WorkspaceFiles{path}}
+	fileChan := make(chan string)
+	go func() {
+		for range fileChan {
+		}
+	}()
+	defer close(fileChan)
+	s.Files.OpenFromPath(path)
+	f, _ := s.Files.GetFromPath(path)
+	s.Workspace.ParseFile(f, &s.Store, make(map[util.Path]struct{}), fileChan)
+
+	params, _ := json.Marshal(transport.WorkspaceSymbolParams{Query: "lp"})
+	raw, err := server.WorkspaceSymbols(t.Context(), &s, params)
+	if err != nil || !strings.Contains(string(raw), "lowpass") {
+		t.Fatalf("Expected lowpass, got %s, %v", raw, err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if raw, err := server.WorkspaceSymbols(ctx, &s, params); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected canceled workspace symbols, got %s, %v", raw, err)
+	}
+}
@@ -4740,7 +4740,7 @@ type ServerCapabilities struct {
 	// The server provides color provider support.
 	ColorProvider *Or_ServerCapabilities_colorProvider `json:"colorProvider,omitempty"`
 	// The server provides workspace symbol support.
-	WorkspaceSymbolProvider *Or_ServerCapabilities_workspaceSymbolProvider `json:"workspaceSymbolProvider,omitempty"`
+	WorkspaceSymbolProvider any `json:"workspaceSymbolProvider,omitempty"`
 	// The server provides document formatting.
 	DocumentFormattingProvider *Or_ServerCapabilities_documentFormattingProvider `json:"documentFormattingProvider,omitempty"`
 	// The server provides document range formatting.
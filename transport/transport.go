@@ -5,10 +5,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/carn181/faustlsp/logging"
 )
@@ -32,14 +37,52 @@ const (
 type Transport struct {
 	Type    TransportType   // client or server
 	Method  TransportMethod // type of stream
-	Scanner *bufio.Scanner  // reader (scanner)
-	conn    net.Conn        // connection to close for client
+	Reader  *bufio.Reader   // reader
+	conn    net.Conn        // connection to close
 	ln      net.Listener    // listener to close for server
+	Address string          // address of the socket, on DefaultPort of localhost if empty
 	Writer  io.Writer       // writer
 	Closed  bool
+
+	// Largest content of a message read, DefaultMaxMessageSize if 0
+	MaxMessageSize int
+
+	// Notifications waiting to be written together, so that bursts of them (like diagnostics of a whole workspace) don't cost a write each
+	mu      sync.Mutex
+	pending bytes.Buffer
+	flush   *time.Timer
+	// Whether notifications and requests are held until Release, along with the held ones
+	holding bool
+	held    bytes.Buffer
+}
+
+// Port of sockets without an address
+const DefaultPort = 5007
+
+// Largest content of a message read by default
+const DefaultMaxMessageSize = 10 * 1024 * 1024 // 10 MB
+
+// MessageTooLargeError is the error of reading a message whose content is larger than the transport's limit.
+// The message is skipped, so that the next one can be read.
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes is larger than the limit of %d bytes", e.Size, e.Limit)
 }
 
-func (t *Transport) Init(ttype TransportType, method TransportMethod) {
+// Time notifications wait for others to be written with
+const flushInterval = 5 * time.Millisecond
+
+// Size of waiting notifications from which they are written right away.
+// Writing blocks while the client is slow to read, so that past this size notifying waits for the client instead of buffering more.
+const maxBatchSize = 64 * 1024
+
+// Init sets up t to communicate through method. Sockets listen for a client and accept it for servers, and connect to the server for clients.
+// Returns the error of listening or connecting, leaving t unusable.
+func (t *Transport) Init(ttype TransportType, method TransportMethod) error {
 	t.Method = method
 	t.Type = ttype
 	var r io.Reader
@@ -51,69 +94,214 @@ func (t *Transport) Init(ttype TransportType, method TransportMethod) {
 		t.Writer = os.Stdout
 
 	// Communicate with client through tcp socket
-	// Default port at 5007
-	// TODO: take port from cmd arguments
 	case Socket:
+		address := t.Address
+		if address == "" {
+			address = net.JoinHostPort("localhost", strconv.Itoa(DefaultPort))
+		}
 		var conn net.Conn
 		var err error
 		switch t.Type {
 		case Server:
-			t.ln, err = net.Listen("tcp", ":5007")
+			t.ln, err = net.Listen("tcp", address)
 			if err != nil {
 				logging.Logger.Error("Connection error", "error", err)
+				return err
 			}
 			conn, err = t.ln.Accept()
 			if err != nil {
 				logging.Logger.Error("Connection error", "error", err)
+				t.ln.Close()
+				return err
 			}
 		case Client:
-			var err error
-			conn, err = net.Dial("tcp", "localhost:5007")
-			t.conn = conn
+			conn, err = net.Dial("tcp", address)
 			if err != nil {
 				logging.Logger.Error("Connection error", "error", err)
+				return err
 			}
 		}
+		t.conn = conn
 		r = conn
 		t.Writer = conn
 	}
+	t.Reader = bufio.NewReader(r)
+	return nil
+}
 
-	// TODO: Find dynamic buffer for handling large files
-	const maxBufferSize = 1024 * 1024 * 10 // 10 MB
-	buf := make([]byte, maxBufferSize)
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(buf, maxBufferSize)
-	scanner.Split(split)
-	t.Scanner = scanner
+// InitConn sets up t to communicate through conn, a connection accepted or dialled elsewhere, which closing t closes
+func (t *Transport) InitConn(ttype TransportType, conn net.Conn) {
+	t.Method = Socket
+	t.Type = ttype
+	t.conn = conn
+	t.Writer = conn
+	t.Reader = bufio.NewReader(conn)
 }
 
-// Reads one JSON RPC message from the stream
+// Reads the content of one JSON RPC message from the stream, waiting for all of it however it is split into reads.
+// Reading past the end of the stream marks t as closed. A message larger than the limit is skipped with a MessageTooLargeError.
 func (t *Transport) Read() ([]byte, error) {
-	hasError := !t.Scanner.Scan()
-	if hasError {
-		if t.Scanner.Err() == nil {
+	length, err := readHeader(t.Reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 			t.Closed = true
 		}
+		return nil, err
 	}
 
-	rawMessage := t.Scanner.Bytes()
-	err := t.Scanner.Err()
-	if err != nil {
-		return rawMessage, err
+	limit := t.MaxMessageSize
+	if limit == 0 {
+		limit = DefaultMaxMessageSize
+	}
+	if length > limit {
+		if _, err := io.CopyN(io.Discard, t.Reader, int64(length)); err != nil {
+			t.Closed = true
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, &MessageTooLargeError{Size: length, Limit: limit}
 	}
 
-	_, content, _ := bytes.Cut(rawMessage, []byte{'\r', '\n', '\r', '\n'})
+	content := make([]byte, length)
+	if _, err := io.ReadFull(t.Reader, content); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Closed = true
+		}
+		return nil, err
+	}
 	return content, nil
 }
 
-// Writes JSON RPC message
+// Reads the header of a message up to the empty line ending it, returning its Content-Length.
+// Lines can end with \r\n or \n, blank lines between messages are skipped, header names are case insensitive,
+// and headers other than Content-Length, like Content-Type, are ignored.
+// Returns io.EOF if the stream ends before the message starts.
+func readHeader(r *bufio.Reader) (int, error) {
+	length := -1
+	started := false
+	for {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return 0, errors.New("invalid header: line too long")
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && (started || len(line) > 0) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		field := strings.TrimRight(string(line), "\r\n")
+		if field == "" {
+			if !started {
+				continue
+			}
+			if length < 0 {
+				return 0, errors.New("invalid header: missing Content-Length")
+			}
+			return length, nil
+		}
+		started = true
+
+		name, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return 0, errors.New("invalid header: " + field)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				return 0, errors.New("invalid Content Length: " + strings.TrimSpace(value))
+			}
+		}
+	}
+}
+
+// Writes JSON RPC message, after the notifications waiting to be written
 func (t *Transport) Write(msg []byte) error {
-	header := []byte("Content-Length: " + strconv.Itoa(len(msg)) + "\r\n\r\n")
-	_, err := t.Writer.Write(append(header, msg...))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	frame(&t.pending, msg)
+	return t.writePending()
+}
+
+// Queues a JSON RPC message to be written with the ones following it within the flush interval
+func (t *Transport) queue(msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	frame(&t.pending, msg)
+	if t.pending.Len() >= maxBatchSize {
+		return t.writePending()
+	}
+	if t.flush == nil {
+		t.flush = time.AfterFunc(flushInterval, func() {
+			if err := t.Flush(); err != nil {
+				logging.Logger.Error("Couldn't write notifications", "error", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Writes the notifications waiting to be written
+func (t *Transport) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writePending()
+}
+
+func (t *Transport) writePending() error {
+	if t.flush != nil {
+		t.flush.Stop()
+		t.flush = nil
+	}
+	if t.pending.Len() == 0 {
+		return nil
+	}
+	_, err := t.Writer.Write(t.pending.Bytes())
+	t.pending.Reset()
 	return err
 }
 
-// Writes JSON RPC Notif Message
+// Methods a server can send while initializing, which aren't held
+var initializingMethods = []string{"window/showMessage", "window/logMessage", "telemetry/event", "window/showMessageRequest"}
+
+// Hold makes notifications and requests wait until Release, other than those a server can send while initializing, as servers
+// can't send them until the client is initialized. Responses are written as usual.
+func (t *Transport) Hold() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.holding = true
+}
+
+// Release writes the notifications and requests held since Hold in the order they were sent, and stops holding them
+func (t *Transport) Release() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.holding = false
+	if t.held.Len() == 0 {
+		return nil
+	}
+	t.pending.Write(t.held.Bytes())
+	t.held.Reset()
+	return t.writePending()
+}
+
+// Holds the message msg of method if it has to wait for Release, returning whether it does
+func (t *Transport) hold(method string, msg []byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.holding || slices.Contains(initializingMethods, method) {
+		return false
+	}
+	frame(&t.held, msg)
+	return true
+}
+
+func frame(b *bytes.Buffer, msg []byte) {
+	b.WriteString("Content-Length: " + strconv.Itoa(len(msg)) + "\r\n\r\n")
+	b.Write(msg)
+}
+
+// Writes JSON RPC Notif Message, batched with the notifications following it
 func (t *Transport) WriteNotif(method string, params json.RawMessage) error {
 	msg, err := json.Marshal(
 		NotificationMessage{
@@ -125,8 +313,10 @@ func (t *Transport) WriteNotif(method string, params json.RawMessage) error {
 		return err
 	}
 
-	err = t.Write(msg)
-	return err
+	if t.hold(method, msg) {
+		return nil
+	}
+	return t.queue(msg)
 }
 
 // Writes JSON RPC Request Message
@@ -142,7 +332,11 @@ func (t *Transport) WriteRequest(id any, method string, params json.RawMessage)
 		return err
 	}
 
-	logging.Logger.Info("Writing " + string(msg))
+	if t.hold(method, msg) {
+		logging.Logger.Debug("Holding request", "method", method, "id", id)
+		return nil
+	}
+	logging.Logger.Debug("Writing request", "method", method, "id", id)
 	err = t.Write(msg)
 	return err
 }
@@ -160,49 +354,40 @@ func (t *Transport) WriteResponse(id any, response json.RawMessage, responseErro
 		return err
 	}
 
-	logging.Logger.Info("Writing " + string(msg))
+	logging.Logger.Debug("Writing response", "id", id, "error", responseError)
 	err = t.Write(msg)
 	return err
 }
 
 func (t *Transport) Close() {
+	if err := t.Flush(); err != nil {
+		logging.Logger.Error("Couldn't write notifications", "error", err)
+	}
 	if t.Method == Socket {
-		if t.Type == Client {
+		if t.conn != nil {
 			t.conn.Close()
-		} else {
+		}
+		if t.ln != nil {
 			t.ln.Close()
 		}
 	}
 }
 
-// Split function for scanner to parse a JSON RPC message
-func split(data []byte, _ bool) (advance int, token []byte, err error) {
-	header, content, found := bytes.Cut(data, []byte{'\r', '\n', '\r', '\n'})
-	if !found {
-		return 0, nil, nil
-	}
-
-	// Content-Length: <number>
-	if len(header) < len("Content-Length: ") {
-		return 0, nil, errors.New("invalid Header: " + string(header))
-	}
-	contentLengthBytes := header[len("Content-Length: "):]
-	contentLength, err := strconv.Atoi(string(contentLengthBytes))
-	if err != nil {
-		return 0, nil, errors.New("invalid Content Length")
-	}
-
-	if len(content) < contentLength {
-		return 0, nil, nil
-	}
-
-	totalLength := len(header) + 4 + contentLength
-	return totalLength, data[:totalLength], nil
-}
-
 func GetMethod(content []byte) (string, error) {
 	var msg RPCMessage
 
 	err := json.Unmarshal(content, &msg)
 	return msg.Method, err
 }
+
+// Whether content is a response to a request, which has an ID but no method
+func IsResponse(content []byte) bool {
+	var msg struct {
+		ID     any    `json:"id"`
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return false
+	}
+	return msg.ID != nil && msg.Method == ""
+}
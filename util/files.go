@@ -1,8 +1,9 @@
 package util
 
 import (
-	"fmt"
 	"os"
+
+	"github.com/carn181/faustlsp/logging"
 )
 
 
@@ -12,7 +13,7 @@ func IsValidPath(path Path) bool{
 		if os.IsNotExist(err){
 			return false
 		}
-		fmt.Println(err)
+		logging.Logger.Warn("Couldn't stat path", "path", path, "error", err)
 		return false
 	} else {
 		return true
@@ -25,21 +25,44 @@ func FromURI(uri string) (Handle, error) {
 	return Handle{uri, path}, err
 }
 
+// Directory documents that aren't files, like untitled: ones that aren't saved yet, get virtual paths in.
+// Nothing is ever read from or written to it, it only keeps their paths apart from those of files.
+var VirtualDir = filepath.Join(string(filepath.Separator), "faustlsp-virtual")
+
+// IsVirtualPath tells if path is the virtual path of a document that isn't a file
+func IsVirtualPath(path Path) bool {
+	return strings.HasPrefix(path, VirtualDir+string(filepath.Separator))
+}
+
 // Converting functions
 
 func URI2path(uri string) (string, error) {
-	url, err := url.Parse(uri)
+	parsed, err := url.Parse(uri)
 	if err != nil {
 		return "", err
 	}
-	//	url.Path
-	if IsWindowsDriveURIPath(url.Path) {
-		url.Path = strings.ToUpper(string(url.Path[1])) + url.Path[2:]
+	// Documents of other schemes, like untitled:Untitled-1, are given the path VirtualDir/untitled/Untitled-1.
+	// Single letters are the drives of Windows paths rather than schemes.
+	if len(parsed.Scheme) > 1 && parsed.Scheme != "file" {
+		name := parsed.Path
+		if parsed.Opaque != "" {
+			if name, err = url.PathUnescape(parsed.Opaque); err != nil {
+				return "", err
+			}
+		}
+		return filepath.Join(VirtualDir, parsed.Scheme, filepath.FromSlash(name)), nil
 	}
-	return filepath.FromSlash(url.Path), nil
+	if IsWindowsDriveURIPath(parsed.Path) {
+		parsed.Path = strings.ToUpper(string(parsed.Path[1])) + parsed.Path[2:]
+	}
+	return filepath.FromSlash(parsed.Path), nil
 }
 
 func Path2URI(path string) URI {
+	if IsVirtualPath(path) {
+		scheme, name, _ := strings.Cut(filepath.ToSlash(path[len(VirtualDir)+1:]), "/")
+		return scheme + ":" + (&url.URL{Path: name}).EscapedPath()
+	}
 	scheme := "file://"
 	if runtime.GOOS == "windows" {
 		path = "/" + strings.Replace(path, "\\", "/", -1)